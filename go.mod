@@ -3,6 +3,7 @@ module github.com/dolthub/vitess
 go 1.19
 
 require (
+	github.com/klauspost/compress v1.17.4
 	github.com/stretchr/testify v1.4.0
 	golang.org/x/tools v0.1.9
 	google.golang.org/grpc v1.24.0
@@ -0,0 +1,172 @@
+/*
+Copyright 2019 The Vitess Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mysql
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"net"
+
+	"github.com/dolthub/vitess/go/vt/proto/vtrpc"
+	"github.com/dolthub/vitess/go/vt/vterrors"
+)
+
+// This file contains the server side of the caching_sha2_password
+// authentication method. See
+// https://dev.mysql.com/doc/dev/mysql-server/latest/page_caching_sha2_authentication_exchanges.html
+//
+// The client sends a SHA256 scramble of its password, either in its
+// handshake response if the server advertised caching_sha2_password
+// (see ListenerConfig.DefaultAuthMethod), or after an auth switch
+// request. The server checks it against the hash of the password it
+// cached after a previous successful authentication of the user: this
+// is the "fast" authentication.
+//
+// If the hash is not cached, the server asks for the "full"
+// authentication: the client sends its password in clear text over TLS
+// or a Unix socket, or else encrypted with the RSA public key of the
+// server, which it asks for first if it was not given it.
+
+const (
+	// cachingSha2RequestPublicKey is sent by the client to ask for
+	// the public key of the server.
+	cachingSha2RequestPublicKey = 0x02
+)
+
+// CachingSha2AuthServer is implemented by the AuthServers supporting
+// the caching_sha2_password method: AuthMethod returns
+// CachingSha2Password for their users. The exchanges with the client
+// are handled by the Listener, Negotiate is not called.
+type CachingSha2AuthServer interface {
+	AuthServer
+
+	// ValidateCachingSha2Hash does the "fast" authentication: it
+	// validates the scramble sent by the client (see
+	// ScrambleCachingSha2Password) against the password hash cached
+	// for user (see CachingSha2PasswordHash). It returns false, and no
+	// error, if no hash is cached for user: the "full" authentication
+	// is then done with ValidateCachingSha2Password.
+	ValidateCachingSha2Hash(salt []byte, user string, scramble []byte, remoteAddr net.Addr) (Getter, bool, error)
+
+	// ValidateCachingSha2Password does the "full" authentication, with
+	// the password sent by the client. If the password is valid, its
+	// hash should be cached for the next authentications of user.
+	ValidateCachingSha2Password(user string, password []byte, remoteAddr net.Addr) (Getter, error)
+}
+
+// negotiateCachingSha2 authenticates user with caching_sha2_password.
+// authMethod and authResponse are what the client sent in its
+// handshake response.
+func (l *Listener) negotiateCachingSha2(c *Conn, user, authMethod string, salt, authResponse []byte) (Getter, error) {
+	authServer, ok := l.cfg.AuthServer.(CachingSha2AuthServer)
+	if !ok {
+		return nil, vterrors.Errorf(vtrpc.Code_INTERNAL, "the AuthServer does not support %v", CachingSha2Password)
+	}
+	remoteAddr := c.Conn.RemoteAddr()
+
+	scramble := authResponse
+	if authMethod != CachingSha2Password {
+		// The client answered for another method, switch.
+		data := make([]byte, len(salt)+1)
+		copy(data, salt)
+		if err := c.writeAuthSwitchRequest(CachingSha2Password, data); err != nil {
+			return nil, err
+		}
+		var err error
+		scramble, err = c.readPacket()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// An empty scramble is an empty password.
+	if len(scramble) == 0 {
+		return authServer.ValidateCachingSha2Password(user, nil, remoteAddr)
+	}
+
+	// Fast authentication.
+	userData, ok, err := authServer.ValidateCachingSha2Hash(salt, user, scramble, remoteAddr)
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		if err := c.writeAuthMoreData([]byte{CachingSha2FastAuth}); err != nil {
+			return nil, err
+		}
+		return userData, nil
+	}
+
+	// Full authentication.
+	if err := c.writeAuthMoreData([]byte{CachingSha2FullAuth}); err != nil {
+		return nil, err
+	}
+	password, err := l.readCachingSha2Password(c, salt)
+	if err != nil {
+		return nil, err
+	}
+	return authServer.ValidateCachingSha2Password(user, password, remoteAddr)
+}
+
+// readCachingSha2Password reads the password of the "full"
+// authentication.
+func (l *Listener) readCachingSha2Password(c *Conn, salt []byte) ([]byte, error) {
+	data, err := c.readPacket()
+	if err != nil {
+		return nil, err
+	}
+
+	// Over a secure transport, the password is in clear text.
+	_, unixSocket := c.Conn.RemoteAddr().(*net.UnixAddr)
+	if c.Capabilities&CapabilityClientSSL != 0 || unixSocket {
+		if len(data) == 0 || data[len(data)-1] != 0 {
+			return nil, vterrors.Errorf(vtrpc.Code_INTERNAL, "received invalid response packet, datalen=%v", len(data))
+		}
+		return data[:len(data)-1], nil
+	}
+
+	// Else it's encrypted with our public key. The client asks for it
+	// first, unless it already has it.
+	if l.cfg.RSAKey == nil {
+		return nil, NewSQLError(ERAccessDeniedError, SSAccessDeniedError, "Access denied: %v requires a secure connection", CachingSha2Password)
+	}
+	if len(data) == 1 && data[0] == cachingSha2RequestPublicKey {
+		publicKey, err := x509.MarshalPKIXPublicKey(&l.cfg.RSAKey.PublicKey)
+		if err != nil {
+			return nil, err
+		}
+		if err := c.writeAuthMoreData(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicKey})); err != nil {
+			return nil, err
+		}
+		data, err = c.readPacket()
+		if err != nil {
+			return nil, err
+		}
+	}
+	password, err := DecryptPasswordWithPrivateKey(salt, data, l.cfg.RSAKey)
+	if err != nil {
+		return nil, NewSQLError(ERAccessDeniedError, SSAccessDeniedError, "Access denied: cannot decrypt password: %v", err)
+	}
+	return password, nil
+}
+
+// writeAuthMoreData writes an AuthMoreData packet with data.
+func (c *Conn) writeAuthMoreData(data []byte) error {
+	packet := c.startEphemeralPacket(1 + len(data))
+	packet[0] = AuthMoreDataPacket
+	copy(packet[1:], data)
+	return c.writeEphemeralPacket()
+}
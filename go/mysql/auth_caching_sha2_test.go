@@ -0,0 +1,380 @@
+/*
+Copyright 2019 The Vitess Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mysql
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"io/ioutil"
+	"net"
+	"os"
+	"path"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/dolthub/vitess/go/vt/tlstest"
+	"github.com/dolthub/vitess/go/vt/vttls"
+)
+
+// countingCachingSha2AuthServer counts the fast and full
+// caching_sha2_password authentications.
+type countingCachingSha2AuthServer struct {
+	*AuthServerStatic
+	fast int64
+	full int64
+}
+
+func (a *countingCachingSha2AuthServer) ValidateCachingSha2Hash(salt []byte, user string, scramble []byte, remoteAddr net.Addr) (Getter, bool, error) {
+	userData, ok, err := a.AuthServerStatic.ValidateCachingSha2Hash(salt, user, scramble, remoteAddr)
+	if ok {
+		atomic.AddInt64(&a.fast, 1)
+	}
+	return userData, ok, err
+}
+
+func (a *countingCachingSha2AuthServer) ValidateCachingSha2Password(user string, password []byte, remoteAddr net.Addr) (Getter, error) {
+	atomic.AddInt64(&a.full, 1)
+	return a.AuthServerStatic.ValidateCachingSha2Password(user, password, remoteAddr)
+}
+
+// counts returns the number of fast and full authentications.
+func (a *countingCachingSha2AuthServer) counts() (int64, int64) {
+	return atomic.LoadInt64(&a.fast), atomic.LoadInt64(&a.full)
+}
+
+func newCachingSha2AuthServer() *countingCachingSha2AuthServer {
+	authServer := NewAuthServerStatic("", "", 0)
+	authServer.Method = CachingSha2Password
+	authServer.entries["user1"] = []*AuthServerStaticEntry{{
+		Password: "password1",
+		UserData: "userData1",
+	}}
+	authServer.entries["user2"] = []*AuthServerStaticEntry{{
+		MysqlNativePassword: "*" + strings.ToUpper(hex.EncodeToString(mysqlNativePasswordHash([]byte("password2")))),
+	}}
+	authServer.entries["empty"] = []*AuthServerStaticEntry{{}}
+	return &countingCachingSha2AuthServer{AuthServerStatic: authServer}
+}
+
+// connectCachingSha2 connects as user, and checks the connection
+// works.
+func connectCachingSha2(t *testing.T, params ConnParams, user, password string) error {
+	params.Uname = user
+	params.Pass = password
+	conn, err := Connect(context.Background(), &params)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if _, err := conn.ExecuteFetch("ssl echo", 10, true); err != nil {
+		t.Errorf("ExecuteFetch failed: %v", err)
+	}
+	return nil
+}
+
+func TestCachingSha2PasswordTLS(t *testing.T) {
+	th := &testHandler{}
+	authServer := newCachingSha2AuthServer()
+
+	root, err := ioutil.TempDir("", "TestCachingSha2PasswordTLS")
+	if err != nil {
+		t.Fatalf("TempDir failed: %v", err)
+	}
+	defer os.RemoveAll(root)
+	tlstest.CreateCA(root)
+	tlstest.CreateSignedCert(root, tlstest.CA, "01", "server", "server.example.com")
+	serverConfig, err := vttls.ServerConfig(
+		path.Join(root, "server-cert.pem"),
+		path.Join(root, "server-key.pem"),
+		"",
+		"",
+		"",
+		tls.VersionTLS12)
+	if err != nil {
+		t.Fatalf("TLSServerConfig failed: %v", err)
+	}
+
+	l, err := NewListenerWithOptions(
+		WithAddress("tcp", ":0"),
+		WithAuthServer(authServer),
+		WithHandler(th),
+		WithTLS(serverConfig, false),
+		WithDefaultAuthMethod(CachingSha2Password),
+	)
+	if err != nil {
+		t.Fatalf("NewListener failed: %v", err)
+	}
+	defer l.Close()
+	go l.Accept()
+
+	host, port := getHostPort(t, l.Addr())
+	params := ConnParams{
+		Host:       host,
+		Port:       port,
+		Flags:      CapabilityClientSSL,
+		SslCa:      path.Join(root, "ca-cert.pem"),
+		ServerName: "server.example.com",
+	}
+
+	// The first connection misses the cache, the password is sent
+	// over TLS. The next ones use the cache.
+	for i, want := range [][2]int64{{0, 1}, {1, 1}, {2, 1}} {
+		if err := connectCachingSha2(t, params, "user1", "password1"); err != nil {
+			t.Fatalf("Connect %v failed: %v", i, err)
+		}
+		if fast, full := authServer.counts(); fast != want[0] || full != want[1] {
+			t.Errorf("connection %v: got %v fast and %v full authentications, want %v", i, fast, full, want)
+		}
+		if got := th.LastConn().UserData.Get().Username; got != "userData1" {
+			t.Errorf("got user data %q, want userData1", got)
+		}
+	}
+
+	// A wrong password is not in the cache, it is checked again.
+	if err := connectCachingSha2(t, params, "user1", "bad"); err == nil || !strings.Contains(err.Error(), "Access denied for user 'user1'") {
+		t.Errorf("Connect with a bad password returned %v, want access denied", err)
+	}
+	if fast, full := authServer.counts(); fast != 2 || full != 2 {
+		t.Errorf("got %v fast and %v full authentications, want 2 and 2", fast, full)
+	}
+
+	// Entries with a mysql_native_password hash, and empty passwords.
+	if err := connectCachingSha2(t, params, "user2", "password2"); err != nil {
+		t.Errorf("Connect with a mysql_native_password hash failed: %v", err)
+	}
+	if err := connectCachingSha2(t, params, "empty", ""); err != nil {
+		t.Errorf("Connect with an empty password failed: %v", err)
+	}
+}
+
+func TestCachingSha2PasswordSwitch(t *testing.T) {
+	th := &testHandler{}
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("GenerateKey failed: %v", err)
+	}
+
+	for _, tcase := range []struct {
+		name string
+		key  *rsa.PrivateKey
+	}{
+		{name: "RSA key", key: key},
+		{name: "no RSA key"},
+	} {
+		t.Run(tcase.name, func(t *testing.T) {
+			// The clients start with mysql_native_password, the
+			// server switches them to caching_sha2_password.
+			authServer := newCachingSha2AuthServer()
+			l, err := NewListenerWithOptions(
+				WithAddress("tcp", ":0"),
+				WithAuthServer(authServer),
+				WithHandler(th),
+				WithRSAKey(tcase.key),
+			)
+			if err != nil {
+				t.Fatalf("NewListener failed: %v", err)
+			}
+			defer l.Close()
+			go l.Accept()
+
+			host, port := getHostPort(t, l.Addr())
+			params := ConnParams{
+				Host: host,
+				Port: port,
+			}
+
+			err = connectCachingSha2(t, params, "user2", "password2")
+			if tcase.key == nil {
+				// The password can't be sent securely.
+				if err == nil || !strings.Contains(err.Error(), "requires a secure connection") {
+					t.Fatalf("Connect returned %v, want an error", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Connect failed: %v", err)
+			}
+			_, full := authServer.counts()
+			if full != 1 {
+				t.Errorf("got %v full authentications, want 1", full)
+			}
+
+			// Now the password hash is cached.
+			if err := connectCachingSha2(t, params, "user2", "password2"); err != nil {
+				t.Fatalf("Connect failed: %v", err)
+			}
+			if fast, full := authServer.counts(); fast != 1 || full != 1 {
+				t.Errorf("got %v fast and %v full authentications, want 1 and 1", fast, full)
+			}
+		})
+	}
+}
+
+func TestCachingSha2Scramble(t *testing.T) {
+	salt, err := NewSalt()
+	if err != nil {
+		t.Fatalf("NewSalt failed: %v", err)
+	}
+	hash := CachingSha2PasswordHash([]byte("password"))
+	if !isPassScrambleCachingSha2Password(ScrambleCachingSha2Password(salt, []byte("password")), salt, hash) {
+		t.Errorf("the scramble of the password does not match its hash")
+	}
+	if isPassScrambleCachingSha2Password(ScrambleCachingSha2Password(salt, []byte("other")), salt, hash) {
+		t.Errorf("the scramble of another password matches the hash")
+	}
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("GenerateKey failed: %v", err)
+	}
+	enc, err := EncryptPasswordWithPublicKey(salt, []byte("password"), &key.PublicKey)
+	if err != nil {
+		t.Fatalf("EncryptPasswordWithPublicKey failed: %v", err)
+	}
+	password, err := DecryptPasswordWithPrivateKey(salt, enc, key)
+	if err != nil || string(password) != "password" {
+		t.Errorf("DecryptPasswordWithPrivateKey returned %q, %v", password, err)
+	}
+}
+
+func TestScrambleCachingSha2PasswordVectors(t *testing.T) {
+	salt := []byte{10, 47, 74, 111, 75, 73, 34, 48, 88, 76, 114, 74, 37, 13, 3, 80, 82, 2, 23, 21}
+	for _, tcase := range []struct {
+		password string
+		want     string
+	}{
+		{password: "secret", want: "f490e76f66d9d86665ce54d98c78d0acfe2fb0b08b423da807144873d30b312c"},
+		{password: "secret2", want: "abc3934a012cf342e876071c8ee202de51785b430258a7a0138bc79c4d800bc6"},
+		{password: "", want: ""},
+	} {
+		if got := hex.EncodeToString(ScrambleCachingSha2Password(salt, []byte(tcase.password))); got != tcase.want {
+			t.Errorf("ScrambleCachingSha2Password(%q) = %v, want %v", tcase.password, got, tcase.want)
+		}
+	}
+}
+
+func TestCachingSha2PasswordPinnedKey(t *testing.T) {
+	th := &testHandler{}
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("GenerateKey failed: %v", err)
+	}
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("GenerateKey failed: %v", err)
+	}
+
+	root, err := ioutil.TempDir("", "TestCachingSha2PasswordPinnedKey")
+	if err != nil {
+		t.Fatalf("TempDir failed: %v", err)
+	}
+	defer os.RemoveAll(root)
+	writeKey := func(name string, key *rsa.PrivateKey) string {
+		der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+		if err != nil {
+			t.Fatalf("MarshalPKIXPublicKey failed: %v", err)
+		}
+		file := path.Join(root, name)
+		if err := ioutil.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0600); err != nil {
+			t.Fatalf("WriteFile failed: %v", err)
+		}
+		return file
+	}
+
+	for _, tcase := range []struct {
+		name string
+		file string
+		want string
+	}{
+		{name: "server key", file: writeKey("server.pem", key)},
+		{name: "other key", file: writeKey("other.pem", otherKey), want: "cannot decrypt password"},
+		{name: "missing file", file: path.Join(root, "missing.pem"), want: "cannot read server public key"},
+	} {
+		t.Run(tcase.name, func(t *testing.T) {
+			l, err := NewListenerWithOptions(
+				WithAddress("tcp", ":0"),
+				WithAuthServer(newCachingSha2AuthServer()),
+				WithHandler(th),
+				WithDefaultAuthMethod(CachingSha2Password),
+				WithRSAKey(key),
+			)
+			if err != nil {
+				t.Fatalf("NewListener failed: %v", err)
+			}
+			defer l.Close()
+			go l.Accept()
+
+			host, port := getHostPort(t, l.Addr())
+			params := ConnParams{
+				Host:            host,
+				Port:            port,
+				ServerPublicKey: tcase.file,
+			}
+			err = connectCachingSha2(t, params, "user1", "password1")
+			if tcase.want == "" {
+				if err != nil {
+					t.Errorf("Connect failed: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tcase.want) {
+				t.Errorf("Connect returned %v, want %q", err, tcase.want)
+			}
+		})
+	}
+}
+
+func TestCachingSha2PasswordDowngrade(t *testing.T) {
+	th := &testHandler{}
+
+	// The Listener advertises caching_sha2_password, but the user
+	// is set up for mysql_native_password: the server switches the
+	// client to it.
+	authServer := NewAuthServerStatic("", "", 0)
+	authServer.entries["user1"] = []*AuthServerStaticEntry{{
+		Password: "password1",
+	}}
+	l, err := NewListenerWithOptions(
+		WithAddress("tcp", ":0"),
+		WithAuthServer(authServer),
+		WithHandler(th),
+		WithDefaultAuthMethod(CachingSha2Password),
+	)
+	if err != nil {
+		t.Fatalf("NewListener failed: %v", err)
+	}
+	defer l.Close()
+	go l.Accept()
+
+	host, port := getHostPort(t, l.Addr())
+	params := ConnParams{
+		Host: host,
+		Port: port,
+	}
+	if err := connectCachingSha2(t, params, "user1", "password1"); err != nil {
+		t.Fatalf("Connect failed: %v", err)
+	}
+	if err := connectCachingSha2(t, params, "user1", "bad"); err == nil || !strings.Contains(err.Error(), "Access denied for user 'user1'") {
+		t.Errorf("Connect with a bad password returned %v, want access denied", err)
+	}
+}
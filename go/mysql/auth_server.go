@@ -191,6 +191,14 @@ func isPassScrambleMysqlNativePassword(reply, salt []byte, mysqlNativePassword s
 	return bytes.Equal(candidateHash2, hash)
 }
 
+// mysqlNativePasswordHash returns SHA1(SHA1(password)), what the
+// mysql_native_password plugin stores, see AuthServerStaticEntry.
+func mysqlNativePasswordHash(password []byte) []byte {
+	stage1 := sha1.Sum(password)
+	hash := sha1.Sum(stage1[:])
+	return hash[:]
+}
+
 // Constants for the dialog plugin.
 const (
 	mysqlDialogMessage = "Enter password: "
@@ -331,3 +339,56 @@ func EncryptPasswordWithPublicKey(salt []byte, password []byte, pub *rsa.PublicK
 
 	return enc, nil
 }
+
+// DecryptPasswordWithPrivateKey decrypts the password sent by the client with
+// EncryptPasswordWithPublicKey, for the caching_sha2_password plugin "full"
+// authentication.
+func DecryptPasswordWithPrivateKey(salt []byte, enc []byte, priv *rsa.PrivateKey) ([]byte, error) {
+	if len(salt) == 0 {
+		return nil, vterrors.Errorf(vtrpc.Code_INVALID_ARGUMENT, "empty salt")
+	}
+	buffer, err := rsa.DecryptOAEP(sha1.New(), rand.Reader, priv, enc, nil)
+	if err != nil {
+		return nil, err
+	}
+	for i := range buffer {
+		buffer[i] ^= salt[i%len(salt)]
+	}
+
+	// The password is null terminated.
+	if len(buffer) == 0 || buffer[len(buffer)-1] != 0 {
+		return nil, vterrors.Errorf(vtrpc.Code_INVALID_ARGUMENT, "invalid encrypted password")
+	}
+	return buffer[:len(buffer)-1], nil
+}
+
+// CachingSha2PasswordHash returns SHA256(SHA256(password)), what the
+// caching_sha2_password plugin caches to validate the scrambles of
+// the "fast" authentication.
+func CachingSha2PasswordHash(password []byte) []byte {
+	stage1 := sha256.Sum256(password)
+	hash := sha256.Sum256(stage1[:])
+	return hash[:]
+}
+
+func isPassScrambleCachingSha2Password(reply, salt, hash []byte) bool {
+	/*
+		SERVER:  recv(reply)
+				 stage1=xor(reply, sha256(hash, salt))
+				 check(sha256(stage1)==hash)
+	*/
+	if len(reply) != sha256.Size || len(hash) != sha256.Size {
+		return false
+	}
+
+	crypt := sha256.New()
+	crypt.Write(hash)
+	crypt.Write(salt)
+	scramble := crypt.Sum(nil)
+
+	for i := range scramble {
+		scramble[i] ^= reply[i]
+	}
+	candidateHash := sha256.Sum256(scramble)
+	return bytes.Equal(candidateHash[:], hash)
+}
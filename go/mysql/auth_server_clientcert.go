@@ -55,9 +55,10 @@ func (ascc *AuthServerClientCert) Salt() ([]byte, error) {
 	return NewSalt()
 }
 
-// ValidateHash is unimplemented.
+// ValidateHash is only called for clients that cannot switch to the
+// clear text method, they are refused.
 func (ascc *AuthServerClientCert) ValidateHash(salt []byte, user string, authResponse []byte, remoteAddr net.Addr) (Getter, error) {
-	panic("unimplemented")
+	return nil, NewSQLError(ERAccessDeniedError, SSAccessDeniedError, "Access denied for user '%v': client cannot use the %v auth method", user, ascc.Method)
 }
 
 // Negotiate is part of the AuthServer interface.
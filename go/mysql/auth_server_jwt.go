@@ -0,0 +1,380 @@
+/*
+Copyright 2019 The Vitess Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mysql
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dolthub/vitess/go/vt/log"
+)
+
+// AuthServerJWT is an AuthServer that takes the password as a JSON Web
+// Token (JWT), a short lived bearer token issued by an external
+// identity provider. The password is sent in clear text, so the
+// connection should use TLS, see Listener.AllowClearTextWithoutTLS.
+//
+// A token is accepted if:
+// - it is signed with RS256 or ES256, by the key of its "kid" header.
+// - its "aud" claim contains Audience.
+// - its "exp" claim is in the future, and its "nbf" claim, if any,
+// in the past.
+// - its UserClaim claim is the MySQL user name.
+// The groups of the user are in the GroupsClaim claim.
+//
+// Expired tokens are refused with a different message than invalid
+// ones, so clients know to get a new token.
+type AuthServerJWT struct {
+	// Method can be set to:
+	// - MysqlClearPassword
+	// - MysqlDialog
+	// - CachingSha2Password: the token is always checked with the
+	// "full" authentication, as it cannot be checked from a scramble.
+	// It defaults to MysqlClearPassword.
+	Method string
+
+	// Audience is the audience the tokens must be issued for.
+	Audience string
+
+	// UserClaim is the claim with the user name. It defaults to
+	// "sub".
+	UserClaim string
+
+	// GroupsClaim is the claim with the groups of the user, a list of
+	// strings. It defaults to "groups".
+	GroupsClaim string
+
+	// Refresh, if set, is called when a token is signed by an unknown
+	// key, to get the current keys, indexed by key id. It lets keys be
+	// rotated without a restart.
+	Refresh func() (map[string]crypto.PublicKey, error)
+
+	// MinRefreshInterval is the minimum time between two calls to
+	// Refresh, so tokens with made up key ids cannot flood the identity
+	// provider. Tokens signed by an unknown key are refused in between.
+	MinRefreshInterval time.Duration
+
+	// now returns the current time, if set. It is changed by tests.
+	now func() time.Time
+
+	mu   sync.Mutex
+	keys map[string]crypto.PublicKey
+
+	// refreshMu serializes the calls to Refresh: the connections
+	// waiting for it use the keys it got.
+	refreshMu   sync.Mutex
+	lastRefresh time.Time
+}
+
+// DefaultJWTMinRefreshInterval is the MinRefreshInterval of the
+// AuthServerJWT returned by NewAuthServerJWT.
+const DefaultJWTMinRefreshInterval = 30 * time.Second
+
+// NewAuthServerJWT returns an AuthServerJWT accepting the tokens for
+// audience signed with keys, indexed by key id. Supported keys are
+// *rsa.PublicKey and *ecdsa.PublicKey on the P-256 curve.
+func NewAuthServerJWT(audience string, keys map[string]crypto.PublicKey) *AuthServerJWT {
+	return &AuthServerJWT{
+		Method:             MysqlClearPassword,
+		Audience:           audience,
+		UserClaim:          "sub",
+		GroupsClaim:        "groups",
+		MinRefreshInterval: DefaultJWTMinRefreshInterval,
+		keys:               keys,
+	}
+}
+
+// SetKeys replaces the keys the tokens can be signed with.
+func (a *AuthServerJWT) SetKeys(keys map[string]crypto.PublicKey) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.keys = keys
+}
+
+// AuthMethod is part of the AuthServer interface.
+func (a *AuthServerJWT) AuthMethod(user, addr string) (string, error) {
+	if a.Method == "" {
+		return MysqlClearPassword, nil
+	}
+	return a.Method, nil
+}
+
+// Salt is not used for this plugin.
+func (a *AuthServerJWT) Salt() ([]byte, error) {
+	return NewSalt()
+}
+
+// ValidateHash is only called for clients that cannot switch to the
+// clear text method, they are refused: a token cannot be checked from
+// a hash.
+func (a *AuthServerJWT) ValidateHash(salt []byte, user string, authResponse []byte, remoteAddr net.Addr) (Getter, error) {
+	method, _ := a.AuthMethod(user, remoteAddr.String())
+	return nil, NewSQLError(ERAccessDeniedError, SSAccessDeniedError, "Access denied for user '%v': client cannot use the %v auth method", user, method)
+}
+
+// Negotiate is part of the AuthServer interface.
+func (a *AuthServerJWT) Negotiate(c *Conn, user string, remoteAddr net.Addr) (Getter, error) {
+	method, _ := a.AuthMethod(user, remoteAddr.String())
+	token, err := AuthServerNegotiateClearOrDialog(c, method)
+	if err != nil {
+		return nil, err
+	}
+	return a.validate(user, token, remoteAddr)
+}
+
+// ValidateCachingSha2Hash is part of the CachingSha2AuthServer
+// interface. No hash is ever cached: a token cannot be checked from a
+// scramble, so it always asks for the "full" authentication.
+func (a *AuthServerJWT) ValidateCachingSha2Hash(salt []byte, user string, scramble []byte, remoteAddr net.Addr) (Getter, bool, error) {
+	return nil, false, nil
+}
+
+// ValidateCachingSha2Password is part of the CachingSha2AuthServer
+// interface. The password is the token.
+func (a *AuthServerJWT) ValidateCachingSha2Password(user string, password []byte, remoteAddr net.Addr) (Getter, error) {
+	return a.validate(user, string(password), remoteAddr)
+}
+
+// jwtHeader is the header of a JWT.
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+// jwtAudience is the "aud" claim, a string or a list of strings.
+type jwtAudience []string
+
+func (aud *jwtAudience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*aud = jwtAudience{single}
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+	*aud = list
+	return nil
+}
+
+// jwtClaims are the claims of a JWT this plugin looks at.
+type jwtClaims struct {
+	Audience  jwtAudience `json:"aud"`
+	ExpiresAt *int64      `json:"exp"`
+	NotBefore *int64      `json:"nbf"`
+}
+
+// errJWTExpired is returned by checkToken for expired tokens.
+var errJWTExpired = errors.New("token expired")
+
+// validate checks the token of the user, and returns the identity it
+// carries.
+func (a *AuthServerJWT) validate(user, token string, remoteAddr net.Addr) (Getter, error) {
+	groups, err := a.checkToken(user, token)
+	switch {
+	case err == errJWTExpired:
+		return nil, NewSQLError(ERAccessDeniedError, SSAccessDeniedError, "Access denied for user '%v': token expired", user)
+	case err != nil:
+		log.Warningf("Invalid token for user %v from %v: %v", user, remoteAddr, err)
+		return nil, NewSQLError(ERAccessDeniedError, SSAccessDeniedError, "Access denied for user '%v': invalid token", user)
+	}
+	return &StaticUserData{
+		username: user,
+		groups:   groups,
+	}, nil
+}
+
+// checkToken checks the signature and the claims of the token of the
+// user, and returns the groups of the user.
+func (a *AuthServerJWT) checkToken(user, token string) ([]string, error) {
+	payload, err := a.verifySignature(token)
+	if err != nil {
+		return nil, err
+	}
+	var claims jwtClaims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, fmt.Errorf("malformed token claims: %v", err)
+	}
+	var raw map[string]interface{}
+	if err := json.Unmarshal(payload, &raw); err != nil {
+		return nil, fmt.Errorf("malformed token claims: %v", err)
+	}
+
+	now := a.currentTime().Unix()
+	if claims.ExpiresAt == nil {
+		return nil, fmt.Errorf("token has no expiration")
+	}
+	if now >= *claims.ExpiresAt {
+		return nil, errJWTExpired
+	}
+	if claims.NotBefore != nil && now < *claims.NotBefore {
+		return nil, fmt.Errorf("token not valid before %v", time.Unix(*claims.NotBefore, 0))
+	}
+
+	audienceOK := false
+	for _, aud := range claims.Audience {
+		if aud == a.Audience {
+			audienceOK = true
+			break
+		}
+	}
+	if !audienceOK {
+		return nil, fmt.Errorf("token for audience %v instead of %v", claims.Audience, a.Audience)
+	}
+
+	userClaim := a.UserClaim
+	if userClaim == "" {
+		userClaim = "sub"
+	}
+	if name, _ := raw[userClaim].(string); name != user {
+		return nil, fmt.Errorf("token for user %q", raw[userClaim])
+	}
+
+	groupsClaim := a.GroupsClaim
+	if groupsClaim == "" {
+		groupsClaim = "groups"
+	}
+	var groups []string
+	if list, ok := raw[groupsClaim].([]interface{}); ok {
+		for _, group := range list {
+			if group, ok := group.(string); ok {
+				groups = append(groups, group)
+			}
+		}
+	}
+	return groups, nil
+}
+
+// verifySignature checks the signature of the token, and returns its
+// decoded payload.
+func (a *AuthServerJWT) verifySignature(token string) ([]byte, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("malformed token: got %v parts, want 3", len(parts))
+	}
+	headerData, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("malformed token header: %v", err)
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("malformed token payload: %v", err)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("malformed token signature: %v", err)
+	}
+	var header jwtHeader
+	if err := json.Unmarshal(headerData, &header); err != nil {
+		return nil, fmt.Errorf("malformed token header: %v", err)
+	}
+
+	key, err := a.key(header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	switch header.Alg {
+	case "RS256":
+		pub, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return nil, fmt.Errorf("key %v is not a RSA key", header.Kid)
+		}
+		if err := rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], signature); err != nil {
+			return nil, fmt.Errorf("bad signature: %v", err)
+		}
+	case "ES256":
+		pub, ok := key.(*ecdsa.PublicKey)
+		if !ok || pub.Curve.Params().BitSize != 256 {
+			return nil, fmt.Errorf("key %v is not a P-256 ECDSA key", header.Kid)
+		}
+		if len(signature) != 64 {
+			return nil, fmt.Errorf("bad signature length: %v", len(signature))
+		}
+		r := new(big.Int).SetBytes(signature[:32])
+		s := new(big.Int).SetBytes(signature[32:])
+		if !ecdsa.Verify(pub, digest[:], r, s) {
+			return nil, fmt.Errorf("bad signature")
+		}
+	default:
+		return nil, fmt.Errorf("unsupported signing algorithm %q", header.Alg)
+	}
+	return payload, nil
+}
+
+// currentTime returns the current time.
+func (a *AuthServerJWT) currentTime() time.Time {
+	if a.now != nil {
+		return a.now()
+	}
+	return time.Now()
+}
+
+// lookupKey returns the key with the key id, if known.
+func (a *AuthServerJWT) lookupKey(kid string) (crypto.PublicKey, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	key, ok := a.keys[kid]
+	return key, ok
+}
+
+// key returns the key with the key id. If there is none, the keys are
+// refreshed first, at most once per MinRefreshInterval.
+func (a *AuthServerJWT) key(kid string) (crypto.PublicKey, error) {
+	if key, ok := a.lookupKey(kid); ok {
+		return key, nil
+	}
+	if a.Refresh == nil {
+		return nil, fmt.Errorf("unknown key %q", kid)
+	}
+
+	a.refreshMu.Lock()
+	defer a.refreshMu.Unlock()
+
+	// The keys may have been refreshed while we were waiting.
+	if key, ok := a.lookupKey(kid); ok {
+		return key, nil
+	}
+	now := a.currentTime()
+	if !a.lastRefresh.IsZero() && now.Sub(a.lastRefresh) < a.MinRefreshInterval {
+		return nil, fmt.Errorf("unknown key %q, keys refreshed less than %v ago", kid, a.MinRefreshInterval)
+	}
+	a.lastRefresh = now
+
+	keys, err := a.Refresh()
+	if err != nil {
+		return nil, fmt.Errorf("cannot refresh keys for unknown key %q: %v", kid, err)
+	}
+	a.SetKeys(keys)
+	key, ok := keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown key %q", kid)
+	}
+	return key, nil
+}
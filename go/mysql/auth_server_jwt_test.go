@@ -0,0 +1,394 @@
+/*
+Copyright 2019 The Vitess Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mysql
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// signJWT returns a token with the claims, signed with key.
+func signJWT(t *testing.T, kid string, key crypto.Signer, claims map[string]interface{}) string {
+	t.Helper()
+	alg := "RS256"
+	if _, ok := key.(*ecdsa.PrivateKey); ok {
+		alg = "ES256"
+	}
+	header, err := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+
+	var signature []byte
+	switch key := key.(type) {
+	case *rsa.PrivateKey:
+		signature, err = rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		signature = make([]byte, 64)
+		r.FillBytes(signature[:32])
+		s.FillBytes(signature[32:])
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func TestAuthServerJWT(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Unix(1700000000, 0)
+	a := NewAuthServerJWT("vitess", map[string]crypto.PublicKey{
+		"rsa": &rsaKey.PublicKey,
+		"ec":  &ecKey.PublicKey,
+	})
+	a.now = func() time.Time { return now }
+	addr := &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 3306}
+
+	claims := func(changes map[string]interface{}) map[string]interface{} {
+		result := map[string]interface{}{
+			"sub":    "alice",
+			"aud":    "vitess",
+			"exp":    now.Add(time.Minute).Unix(),
+			"groups": []string{"dev", "ops"},
+		}
+		for k, v := range changes {
+			if v == nil {
+				delete(result, k)
+				continue
+			}
+			result[k] = v
+		}
+		return result
+	}
+
+	for _, tcase := range []struct {
+		name    string
+		user    string
+		token   string
+		wantErr string
+	}{{
+		name:  "valid RS256",
+		user:  "alice",
+		token: signJWT(t, "rsa", rsaKey, claims(nil)),
+	}, {
+		name:  "valid ES256",
+		user:  "alice",
+		token: signJWT(t, "ec", ecKey, claims(nil)),
+	}, {
+		name:  "audience list",
+		user:  "alice",
+		token: signJWT(t, "rsa", rsaKey, claims(map[string]interface{}{"aud": []string{"other", "vitess"}})),
+	}, {
+		name:    "expired",
+		user:    "alice",
+		token:   signJWT(t, "rsa", rsaKey, claims(map[string]interface{}{"exp": now.Unix()})),
+		wantErr: "token expired",
+	}, {
+		name:    "no expiration",
+		user:    "alice",
+		token:   signJWT(t, "rsa", rsaKey, claims(map[string]interface{}{"exp": nil})),
+		wantErr: "invalid token",
+	}, {
+		name:    "not valid yet",
+		user:    "alice",
+		token:   signJWT(t, "rsa", rsaKey, claims(map[string]interface{}{"nbf": now.Add(time.Minute).Unix()})),
+		wantErr: "invalid token",
+	}, {
+		name:    "wrong audience",
+		user:    "alice",
+		token:   signJWT(t, "rsa", rsaKey, claims(map[string]interface{}{"aud": "other"})),
+		wantErr: "invalid token",
+	}, {
+		name:    "wrong user",
+		user:    "bob",
+		token:   signJWT(t, "rsa", rsaKey, claims(nil)),
+		wantErr: "invalid token",
+	}, {
+		name:    "wrong key",
+		user:    "alice",
+		token:   signJWT(t, "rsa", otherKey, claims(nil)),
+		wantErr: "invalid token",
+	}, {
+		name:    "unknown key",
+		user:    "alice",
+		token:   signJWT(t, "other", otherKey, claims(nil)),
+		wantErr: "invalid token",
+	}, {
+		name:    "key of another type",
+		user:    "alice",
+		token:   signJWT(t, "ec", rsaKey, claims(nil)),
+		wantErr: "invalid token",
+	}, {
+		name:    "password",
+		user:    "alice",
+		token:   "password1",
+		wantErr: "invalid token",
+	}, {
+		name: "unsigned",
+		user: "alice",
+		token: base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none"}`)) + "." +
+			base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"alice","aud":"vitess","exp":2000000000}`)) + ".",
+		wantErr: "invalid token",
+	}} {
+		t.Run(tcase.name, func(t *testing.T) {
+			userData, err := a.validate(tcase.user, tcase.token, addr)
+			if tcase.wantErr != "" {
+				assertSQLError(t, err, ERAccessDeniedError, SSAccessDeniedError, tcase.wantErr, "")
+				return
+			}
+			if err != nil {
+				t.Fatalf("validate failed: %v", err)
+			}
+			callerID := userData.Get()
+			if callerID.Username != "alice" || strings.Join(callerID.Groups, ",") != "dev,ops" {
+				t.Errorf("got caller id %v, want alice in dev and ops", callerID)
+			}
+		})
+	}
+}
+
+func TestAuthServerJWTKeyRotation(t *testing.T) {
+	oldKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a := NewAuthServerJWT("vitess", map[string]crypto.PublicKey{"old": &oldKey.PublicKey})
+	refreshes := 0
+	a.Refresh = func() (map[string]crypto.PublicKey, error) {
+		refreshes++
+		return map[string]crypto.PublicKey{"new": &newKey.PublicKey}, nil
+	}
+	addr := &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 3306}
+	claims := map[string]interface{}{
+		"sub": "alice",
+		"aud": "vitess",
+		"exp": time.Now().Add(time.Minute).Unix(),
+	}
+
+	if _, err := a.validate("alice", signJWT(t, "old", oldKey, claims), addr); err != nil {
+		t.Fatalf("token signed with the old key refused: %v", err)
+	}
+	if refreshes != 0 {
+		t.Errorf("keys refreshed %v times for a known key, want 0", refreshes)
+	}
+
+	// A token signed with the new key makes the server fetch it.
+	if _, err := a.validate("alice", signJWT(t, "new", newKey, claims), addr); err != nil {
+		t.Fatalf("token signed with the new key refused: %v", err)
+	}
+	if refreshes != 1 {
+		t.Errorf("keys refreshed %v times for a new key, want 1", refreshes)
+	}
+
+	// The old key was rotated out.
+	_, err = a.validate("alice", signJWT(t, "old", oldKey, claims), addr)
+	assertSQLError(t, err, ERAccessDeniedError, SSAccessDeniedError, "invalid token", "")
+}
+
+func TestAuthServerJWTRefreshInterval(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unknownKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	a := NewAuthServerJWT("vitess", nil)
+	a.now = func() time.Time { return now }
+	var refreshes int64
+	a.Refresh = func() (map[string]crypto.PublicKey, error) {
+		atomic.AddInt64(&refreshes, 1)
+		return map[string]crypto.PublicKey{"key": &key.PublicKey}, nil
+	}
+	addr := &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 3306}
+	claims := map[string]interface{}{
+		"sub": "alice",
+		"aud": "vitess",
+		"exp": now.Add(time.Hour).Unix(),
+	}
+
+	// Concurrent tokens signed by a new key only refresh the keys once.
+	token := signJWT(t, "key", key, claims)
+	wg := sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := a.validate("alice", token, addr); err != nil {
+				t.Errorf("token signed with the new key refused: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if got := atomic.LoadInt64(&refreshes); got != 1 {
+		t.Errorf("keys refreshed %v times for a new key, want 1", got)
+	}
+
+	// Tokens with unknown key ids don't refresh the keys again until
+	// MinRefreshInterval passed.
+	for i := 0; i < 5; i++ {
+		_, err = a.validate("alice", signJWT(t, fmt.Sprintf("unknown%v", i), unknownKey, claims), addr)
+		assertSQLError(t, err, ERAccessDeniedError, SSAccessDeniedError, "invalid token", "")
+	}
+	if got := atomic.LoadInt64(&refreshes); got != 1 {
+		t.Errorf("keys refreshed %v times for unknown keys, want 1", got)
+	}
+
+	now = now.Add(a.MinRefreshInterval)
+	_, err = a.validate("alice", signJWT(t, "unknown", unknownKey, claims), addr)
+	assertSQLError(t, err, ERAccessDeniedError, SSAccessDeniedError, "invalid token", "")
+	if got := atomic.LoadInt64(&refreshes); got != 2 {
+		t.Errorf("keys refreshed %v times after MinRefreshInterval, want 2", got)
+	}
+}
+
+func TestAuthServerJWTNegotiate(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := NewAuthServerJWT("vitess", map[string]crypto.PublicKey{"key": &key.PublicKey})
+	token := signJWT(t, "key", key, map[string]interface{}{
+		"sub":    "alice",
+		"aud":    "vitess",
+		"exp":    time.Now().Add(time.Minute).Unix(),
+		"groups": []string{"dev"},
+	})
+
+	listener, sConn, cConn := createSocketPair(t)
+	defer func() {
+		listener.Close()
+		sConn.Close()
+		cConn.Close()
+	}()
+
+	// The client sends the token as a clear text password.
+	var clientErr error
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		data := make([]byte, len(token)+1)
+		writeNullString(data, 0, token)
+		clientErr = cConn.writePacket(data)
+	}()
+
+	userData, err := a.Negotiate(sConn, "alice", sConn.RemoteAddr())
+	wg.Wait()
+	if clientErr != nil {
+		t.Fatalf("writePacket failed: %v", clientErr)
+	}
+	if err != nil {
+		t.Fatalf("Negotiate failed: %v", err)
+	}
+	if callerID := userData.Get(); callerID.Username != "alice" || len(callerID.Groups) != 1 || callerID.Groups[0] != "dev" {
+		t.Errorf("got caller id %v, want alice in dev", callerID)
+	}
+}
+
+func TestAuthServerJWTCachingSha2(t *testing.T) {
+	th := &testHandler{}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	authServer := NewAuthServerJWT("vitess", map[string]crypto.PublicKey{"key": &key.PublicKey})
+	authServer.Method = CachingSha2Password
+	token := signJWT(t, "key", key, map[string]interface{}{
+		"sub": "alice",
+		"aud": "vitess",
+		"exp": time.Now().Add(time.Minute).Unix(),
+	})
+
+	// The token is sent with the "full" authentication, in clear text
+	// over the unix socket.
+	unixSocket, err := ioutil.TempFile("", "mysql_vitess_test.sock")
+	if err != nil {
+		t.Fatalf("Failed to create temp file")
+	}
+	os.Remove(unixSocket.Name())
+
+	l, err := NewListenerWithOptions(
+		WithAddress("unix", unixSocket.Name()),
+		WithAuthServer(authServer),
+		WithHandler(th),
+	)
+	if err != nil {
+		t.Fatalf("NewListener failed: %v", err)
+	}
+	defer l.Close()
+	go l.Accept()
+
+	params := ConnParams{UnixSocket: unixSocket.Name()}
+	for i := 0; i < 2; i++ {
+		if err := connectCachingSha2(t, params, "alice", token); err != nil {
+			t.Fatalf("Connect %v failed: %v", i, err)
+		}
+		if got := th.LastConn().User; got != "alice" {
+			t.Errorf("got user %q, want alice", got)
+		}
+	}
+
+	if err := connectCachingSha2(t, params, "alice", "bad"); err == nil || !strings.Contains(err.Error(), "invalid token") {
+		t.Errorf("Connect with a bad token returned %v, want invalid token", err)
+	}
+}
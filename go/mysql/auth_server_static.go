@@ -19,11 +19,13 @@ package mysql
 import (
 	"bytes"
 	ylog "dreamland/lib/log"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"net"
 	"os"
 	"os/signal"
+	"strings"
 	"sync"
 	"syscall"
 	"time"
@@ -50,6 +52,7 @@ type AuthServerStatic struct {
 	// - MysqlNativePassword
 	// - MysqlClearPassword
 	// - MysqlDialog
+	// - CachingSha2Password
 	// It defaults to MysqlNativePassword.
 	Method string
 
@@ -59,6 +62,11 @@ type AuthServerStatic struct {
 	// entries contains the users, passwords and user data.
 	entries map[string][]*AuthServerStaticEntry
 
+	// cachingSha2Cache has the password hashes of the users
+	// authenticated with CachingSha2Password. It is cleared when the
+	// entries are reloaded.
+	cachingSha2Cache map[string]cachingSha2CacheEntry
+
 	file           string
 	jsonConfig     string
 	reloadInterval time.Duration
@@ -124,6 +132,7 @@ func (a *AuthServerStatic) reload() {
 
 	a.mu.Lock()
 	a.entries = entries
+	a.cachingSha2Cache = nil
 	a.mu.Unlock()
 }
 
@@ -278,6 +287,62 @@ func (a *AuthServerStatic) Negotiate(c *Conn, user string, remoteAddr net.Addr)
 	return &StaticUserData{}, NewSQLError(ERAccessDeniedError, SSAccessDeniedError, "Access denied for user '%v'", user)
 }
 
+// cachingSha2CacheEntry is the password hash of a user, cached after
+// a full caching_sha2_password authentication, and the entry it
+// matched.
+type cachingSha2CacheEntry struct {
+	hash  []byte
+	entry *AuthServerStaticEntry
+}
+
+// ValidateCachingSha2Hash is part of the CachingSha2AuthServer interface.
+// If the scramble does not match the cached hash, the password may have
+// changed, the full authentication is done again.
+func (a *AuthServerStatic) ValidateCachingSha2Hash(salt []byte, user string, scramble []byte, remoteAddr net.Addr) (Getter, bool, error) {
+	a.mu.Lock()
+	cached, ok := a.cachingSha2Cache[user]
+	a.mu.Unlock()
+
+	if !ok || !matchSourceHost(remoteAddr, cached.entry.SourceHost) || !isPassScrambleCachingSha2Password(scramble, salt, cached.hash) {
+		return nil, false, nil
+	}
+	return &StaticUserData{cached.entry.UserData, cached.entry.Groups}, true, nil
+}
+
+// ValidateCachingSha2Password is part of the CachingSha2AuthServer interface.
+// The password is checked against the Password or the MysqlNativePassword
+// of the entries of user.
+func (a *AuthServerStatic) ValidateCachingSha2Password(user string, password []byte, remoteAddr net.Addr) (Getter, error) {
+	a.mu.Lock()
+	entries, ok := a.entries[user]
+	a.mu.Unlock()
+
+	if !ok {
+		return &StaticUserData{}, NewSQLError(ERAccessDeniedError, SSAccessDeniedError, "Access denied for user '%v'", user)
+	}
+	for _, entry := range entries {
+		if !matchSourceHost(remoteAddr, entry.SourceHost) {
+			continue
+		}
+		var valid bool
+		if entry.MysqlNativePassword != "" {
+			valid = strings.EqualFold(strings.TrimPrefix(entry.MysqlNativePassword, "*"), hex.EncodeToString(mysqlNativePasswordHash(password)))
+		} else {
+			valid = entry.Password == string(password)
+		}
+		if valid {
+			a.mu.Lock()
+			if a.cachingSha2Cache == nil {
+				a.cachingSha2Cache = make(map[string]cachingSha2CacheEntry)
+			}
+			a.cachingSha2Cache[user] = cachingSha2CacheEntry{hash: CachingSha2PasswordHash(password), entry: entry}
+			a.mu.Unlock()
+			return &StaticUserData{entry.UserData, entry.Groups}, nil
+		}
+	}
+	return &StaticUserData{}, NewSQLError(ERAccessDeniedError, SSAccessDeniedError, "Access denied for user '%v'", user)
+}
+
 func matchSourceHost(remoteAddr net.Addr, targetSourceHost string) bool {
 	// Legacy support, there was not matcher defined default to true
 	if targetSourceHost == "" {
@@ -0,0 +1,544 @@
+/*
+Copyright 2019 The Vitess Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mysql
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"strconv"
+	"testing"
+
+	"github.com/dolthub/vitess/go/sqltypes"
+
+	querypb "github.com/dolthub/vitess/go/vt/proto/query"
+)
+
+// decodeBinaryRow decodes a binary protocol row the way MySQL clients
+// do, from the MySQL type of each field, and returns the values in
+// their text protocol representation. NULL values are returned as nil.
+// See https://dev.mysql.com/doc/dev/mysql-server/latest/page_protocol_binary_resultset.html
+func decodeBinaryRow(fields []*querypb.Field, data []byte) ([]*string, error) {
+	if len(data) == 0 || data[0] != 0x00 {
+		return nil, fmt.Errorf("invalid binary row header: %v", data)
+	}
+	// The NULL-bitmap starts at bit 2.
+	bitmap := data[1 : 1+(len(fields)+7+2)/8]
+	pos := 1 + len(bitmap)
+	short := func(n int) error {
+		if pos+n > len(data) {
+			return fmt.Errorf("row is truncated at %v: %v", pos, data)
+		}
+		return nil
+	}
+
+	row := make([]*string, len(fields))
+	for i, field := range fields {
+		if bitmap[(i+2)/8]&(1<<uint((i+2)%8)) != 0 {
+			continue
+		}
+		typ, flags := sqltypes.TypeToMySQL(field.Type)
+		unsigned := flags&int64(querypb.MySqlFlag_UNSIGNED_FLAG) != 0
+		var s string
+		switch typ {
+		case 1: // TINY
+			if err := short(1); err != nil {
+				return nil, err
+			}
+			if unsigned {
+				s = strconv.FormatUint(uint64(data[pos]), 10)
+			} else {
+				s = strconv.FormatInt(int64(int8(data[pos])), 10)
+			}
+			pos++
+		case 2, 13: // SHORT, YEAR
+			if err := short(2); err != nil {
+				return nil, err
+			}
+			v, _, _ := readUint16(data, pos)
+			if unsigned || typ == 13 {
+				s = strconv.FormatUint(uint64(v), 10)
+			} else {
+				s = strconv.FormatInt(int64(int16(v)), 10)
+			}
+			pos += 2
+		case 3, 9: // LONG, INT24
+			if err := short(4); err != nil {
+				return nil, err
+			}
+			v, _, _ := readUint32(data, pos)
+			if unsigned {
+				s = strconv.FormatUint(uint64(v), 10)
+			} else {
+				s = strconv.FormatInt(int64(int32(v)), 10)
+			}
+			pos += 4
+		case 8: // LONGLONG
+			if err := short(8); err != nil {
+				return nil, err
+			}
+			v, _, _ := readUint64(data, pos)
+			if unsigned {
+				s = strconv.FormatUint(v, 10)
+			} else {
+				s = strconv.FormatInt(int64(v), 10)
+			}
+			pos += 8
+		case 4: // FLOAT
+			if err := short(4); err != nil {
+				return nil, err
+			}
+			v, _, _ := readUint32(data, pos)
+			s = strconv.FormatFloat(float64(math.Float32frombits(v)), 'g', -1, 32)
+			pos += 4
+		case 5: // DOUBLE
+			if err := short(8); err != nil {
+				return nil, err
+			}
+			v, _, _ := readUint64(data, pos)
+			s = strconv.FormatFloat(math.Float64frombits(v), 'g', -1, 64)
+			pos += 8
+		case 7, 10, 12: // TIMESTAMP, DATE, DATETIME
+			if err := short(1); err != nil {
+				return nil, err
+			}
+			n := int(data[pos])
+			pos++
+			if err := short(n); err != nil {
+				return nil, err
+			}
+			b := data[pos : pos+n]
+			switch n {
+			case 0:
+				s = "0000-00-00"
+			case 4, 7, 11:
+				year, _, _ := readUint16(b, 0)
+				s = fmt.Sprintf("%04d-%02d-%02d", year, b[2], b[3])
+				if n >= 7 {
+					s += fmt.Sprintf(" %02d:%02d:%02d", b[4], b[5], b[6])
+				}
+				if n == 11 {
+					micros, _, _ := readUint32(b, 7)
+					s += fmt.Sprintf(".%06d", micros)
+				}
+			default:
+				return nil, fmt.Errorf("invalid datetime length %v", n)
+			}
+			pos += n
+		case 11: // TIME
+			if err := short(1); err != nil {
+				return nil, err
+			}
+			n := int(data[pos])
+			pos++
+			if err := short(n); err != nil {
+				return nil, err
+			}
+			b := data[pos : pos+n]
+			switch n {
+			case 0:
+				s = "00:00:00"
+			case 8, 12:
+				if b[0] == 1 {
+					s = "-"
+				}
+				days, _, _ := readUint32(b, 1)
+				s += fmt.Sprintf("%02d:%02d:%02d", days*24+uint32(b[5]), b[6], b[7])
+				if n == 12 {
+					micros, _, _ := readUint32(b, 8)
+					s += fmt.Sprintf(".%06d", micros)
+				}
+			default:
+				return nil, fmt.Errorf("invalid time length %v", n)
+			}
+			pos += n
+		default:
+			// Everything else, DECIMAL included, is a string.
+			v, next, ok := readLenEncStringAsBytes(data, pos)
+			if !ok {
+				return nil, fmt.Errorf("cannot read string at %v: %v", pos, data)
+			}
+			s = string(v)
+			pos = next
+		}
+		row[i] = &s
+	}
+	if pos != len(data) {
+		return nil, fmt.Errorf("row has %v extra bytes: %v", len(data)-pos, data)
+	}
+	return row, nil
+}
+
+func TestWriteBinaryRow(t *testing.T) {
+	listener, sConn, cConn := createSocketPair(t)
+	defer func() {
+		listener.Close()
+		sConn.Close()
+		cConn.Close()
+	}()
+
+	tests := []struct {
+		typ querypb.Type
+		in  string
+		// value is the encoded value, after the header and the
+		// NULL-bitmap. It is not checked if nil.
+		value []byte
+		// out is the decoded value, if different from in.
+		out string
+	}{
+		{typ: querypb.Type_INT8, in: "-1", value: []byte{0xff}},
+		{typ: querypb.Type_UINT8, in: "255", value: []byte{0xff}},
+		{typ: querypb.Type_INT16, in: "-2", value: []byte{0xfe, 0xff}},
+		{typ: querypb.Type_UINT16, in: "65535", value: []byte{0xff, 0xff}},
+		{typ: querypb.Type_YEAR, in: "2020", value: []byte{0xe4, 0x07}},
+		{typ: querypb.Type_INT24, in: "-3", value: []byte{0xfd, 0xff, 0xff, 0xff}},
+		{typ: querypb.Type_UINT24, in: "16777215", value: []byte{0xff, 0xff, 0xff, 0x00}},
+		{typ: querypb.Type_INT32, in: "-2147483648", value: []byte{0x00, 0x00, 0x00, 0x80}},
+		{typ: querypb.Type_UINT32, in: "4294967295", value: []byte{0xff, 0xff, 0xff, 0xff}},
+		{typ: querypb.Type_INT64, in: "-9223372036854775808", value: []byte{0, 0, 0, 0, 0, 0, 0, 0x80}},
+		{typ: querypb.Type_UINT64, in: "18446744073709551615", value: []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}},
+		{typ: querypb.Type_FLOAT32, in: "1.5", value: []byte{0x00, 0x00, 0xc0, 0x3f}},
+		{typ: querypb.Type_FLOAT64, in: "-2.25", value: []byte{0, 0, 0, 0, 0, 0, 0x02, 0xc0}},
+		{typ: querypb.Type_DECIMAL, in: "-123.4500", value: []byte{9, '-', '1', '2', '3', '.', '4', '5', '0', '0'}},
+		{typ: querypb.Type_DATE, in: "2020-01-02", value: []byte{4, 0xe4, 0x07, 1, 2}},
+		{typ: querypb.Type_DATETIME, in: "2020-01-02 03:04:05", value: []byte{7, 0xe4, 0x07, 1, 2, 3, 4, 5}},
+		{typ: querypb.Type_DATETIME, in: "2020-01-02 03:04:05.000006", value: []byte{11, 0xe4, 0x07, 1, 2, 3, 4, 5, 6, 0, 0, 0}},
+		{typ: querypb.Type_TIMESTAMP, in: "2020-01-02 03:04:05.5", out: "2020-01-02 03:04:05.500000"},
+		{typ: querypb.Type_TIME, in: "00:00:00", value: []byte{0}},
+		{typ: querypb.Type_TIME, in: "-01:02:03", value: []byte{8, 1, 0, 0, 0, 0, 1, 2, 3}},
+		{typ: querypb.Type_TIME, in: "-25:00:00.000001", value: []byte{12, 1, 1, 0, 0, 0, 1, 0, 0, 1, 0, 0, 0}},
+		{typ: querypb.Type_TIME, in: "838:59:59"},
+		{typ: querypb.Type_VARCHAR, in: "abc", value: []byte{3, 'a', 'b', 'c'}},
+		{typ: querypb.Type_BLOB, in: "", value: []byte{0}},
+		{typ: querypb.Type_BIT, in: "\x01", value: []byte{1, 1}},
+		{typ: querypb.Type_ENUM, in: "a"},
+		{typ: querypb.Type_SET, in: "a,b"},
+		{typ: querypb.Type_JSON, in: `{"a": 1}`},
+	}
+	for _, test := range tests {
+		t.Run(fmt.Sprintf("%v %v", test.typ, test.in), func(t *testing.T) {
+			fields := []*querypb.Field{{Name: "col", Type: test.typ}}
+			if err := sConn.writeBinaryRow(fields, []sqltypes.Value{sqltypes.MakeTrusted(test.typ, []byte(test.in))}); err != nil {
+				t.Fatalf("writeBinaryRow failed: %v", err)
+			}
+			data, err := cConn.ReadPacket()
+			if err != nil {
+				t.Fatalf("ReadPacket failed: %v", err)
+			}
+			if test.value != nil && !bytes.Equal(data[2:], test.value) {
+				t.Errorf("got value %v, want %v", data[2:], test.value)
+			}
+			row, err := decodeBinaryRow(fields, data)
+			if err != nil {
+				t.Fatalf("decodeBinaryRow failed: %v", err)
+			}
+			want := test.out
+			if want == "" {
+				want = test.in
+			}
+			if row[0] == nil || *row[0] != want {
+				t.Errorf("got decoded value %v, want %q", row[0], want)
+			}
+		})
+	}
+}
+
+func TestWriteBinaryRowNulls(t *testing.T) {
+	listener, sConn, cConn := createSocketPair(t)
+	defer func() {
+		listener.Close()
+		sConn.Close()
+		cConn.Close()
+	}()
+
+	// With the 2 bit offset, the 7th column is the first one of the
+	// second byte of the NULL-bitmap.
+	var fields []*querypb.Field
+	var row []sqltypes.Value
+	for i := 0; i < 10; i++ {
+		fields = append(fields, &querypb.Field{Name: fmt.Sprintf("c%v", i), Type: querypb.Type_INT64})
+		if i == 0 || i == 5 || i == 6 || i == 9 {
+			row = append(row, sqltypes.NULL)
+		} else {
+			row = append(row, sqltypes.NewInt64(int64(i)))
+		}
+	}
+	if err := sConn.writeBinaryRow(fields, row); err != nil {
+		t.Fatalf("writeBinaryRow failed: %v", err)
+	}
+	data, err := cConn.ReadPacket()
+	if err != nil {
+		t.Fatalf("ReadPacket failed: %v", err)
+	}
+	if want := []byte{0x00, 0x84, 0x09}; !bytes.Equal(data[:3], want) {
+		t.Errorf("got header and NULL-bitmap %v, want %v", data[:3], want)
+	}
+	got, err := decodeBinaryRow(fields, data)
+	if err != nil {
+		t.Fatalf("decodeBinaryRow failed: %v", err)
+	}
+	for i, val := range row {
+		switch {
+		case val.IsNull() && got[i] != nil:
+			t.Errorf("column %v: got %q, want NULL", i, *got[i])
+		case !val.IsNull() && (got[i] == nil || *got[i] != val.ToString()):
+			t.Errorf("column %v: got %v, want %v", i, got[i], val.ToString())
+		}
+	}
+
+	// A malformed temporal value is an error.
+	err = sConn.writeBinaryRow([]*querypb.Field{{Name: "d", Type: querypb.Type_DATETIME}}, []sqltypes.Value{sqltypes.MakeTrusted(querypb.Type_DATETIME, []byte("2020-01-02 03"))})
+	if err == nil {
+		t.Errorf("writeBinaryRow of a truncated DATETIME succeeded")
+	}
+}
+
+func TestParseBinaryRow(t *testing.T) {
+	// The rows as MySQL sends them: the 0x00 header, the NULL-bitmap
+	// starting at bit 2, and the values.
+	tests := []struct {
+		name     string
+		typ      querypb.Type
+		decimals uint32
+		data     []byte
+		want     string
+	}{
+		{name: "tiny", typ: querypb.Type_INT8, data: []byte{0x80}, want: "-128"},
+		{name: "unsigned tiny", typ: querypb.Type_UINT8, data: []byte{0xff}, want: "255"},
+		{name: "short", typ: querypb.Type_INT16, data: []byte{0x00, 0x80}, want: "-32768"},
+		{name: "unsigned short", typ: querypb.Type_UINT16, data: []byte{0xff, 0xff}, want: "65535"},
+		{name: "year", typ: querypb.Type_YEAR, data: []byte{0xe4, 0x07}, want: "2020"},
+		{name: "zero year", typ: querypb.Type_YEAR, data: []byte{0x00, 0x00}, want: "0000"},
+		{name: "int24", typ: querypb.Type_INT24, data: []byte{0x00, 0x00, 0x80, 0xff}, want: "-8388608"},
+		{name: "unsigned int24", typ: querypb.Type_UINT24, data: []byte{0xff, 0xff, 0xff, 0x00}, want: "16777215"},
+		{name: "long", typ: querypb.Type_INT32, data: []byte{0x00, 0x00, 0x00, 0x80}, want: "-2147483648"},
+		{name: "unsigned long", typ: querypb.Type_UINT32, data: []byte{0xff, 0xff, 0xff, 0xff}, want: "4294967295"},
+		{name: "longlong", typ: querypb.Type_INT64, data: []byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x80}, want: "-9223372036854775808"},
+		{name: "unsigned longlong", typ: querypb.Type_UINT64, data: []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}, want: "18446744073709551615"},
+		{name: "float", typ: querypb.Type_FLOAT32, decimals: notFixedDecimals, data: []byte{0x00, 0x00, 0xc0, 0x3f}, want: "1.5"},
+		{name: "float(5,2)", typ: querypb.Type_FLOAT32, decimals: 2, data: []byte{0x00, 0x00, 0xc0, 0x3f}, want: "1.50"},
+		{name: "float 0.1", typ: querypb.Type_FLOAT32, decimals: notFixedDecimals, data: []byte{0xcd, 0xcc, 0xcc, 0x3d}, want: "0.1"},
+		{name: "double", typ: querypb.Type_FLOAT64, decimals: notFixedDecimals, data: []byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xc0}, want: "-2.25"},
+		{name: "double 0.1", typ: querypb.Type_FLOAT64, decimals: notFixedDecimals, data: []byte{0x9a, 0x99, 0x99, 0x99, 0x99, 0x99, 0xb9, 0x3f}, want: "0.1"},
+		{name: "decimal", typ: querypb.Type_DECIMAL, decimals: 4, data: []byte{0x09, '-', '1', '2', '3', '.', '4', '5', '0', '0'}, want: "-123.4500"},
+		{name: "zero date", typ: querypb.Type_DATE, data: []byte{0x00}, want: "0000-00-00"},
+		{name: "date", typ: querypb.Type_DATE, data: []byte{0x04, 0xe4, 0x07, 0x01, 0x02}, want: "2020-01-02"},
+		{name: "zero datetime", typ: querypb.Type_DATETIME, data: []byte{0x00}, want: "0000-00-00 00:00:00"},
+		{name: "datetime at midnight", typ: querypb.Type_DATETIME, data: []byte{0x04, 0xe4, 0x07, 0x01, 0x02}, want: "2020-01-02 00:00:00"},
+		{name: "datetime", typ: querypb.Type_DATETIME, data: []byte{0x07, 0xe4, 0x07, 0x01, 0x02, 0x03, 0x04, 0x05}, want: "2020-01-02 03:04:05"},
+		{name: "datetime(6)", typ: querypb.Type_DATETIME, decimals: 6, data: []byte{0x0b, 0xe4, 0x07, 0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x00, 0x00, 0x00}, want: "2020-01-02 03:04:05.000006"},
+		{name: "datetime(6) without microseconds", typ: querypb.Type_DATETIME, decimals: 6, data: []byte{0x07, 0xe4, 0x07, 0x01, 0x02, 0x03, 0x04, 0x05}, want: "2020-01-02 03:04:05.000000"},
+		{name: "zero datetime(2)", typ: querypb.Type_DATETIME, decimals: 2, data: []byte{0x00}, want: "0000-00-00 00:00:00.00"},
+		{name: "datetime(3)", typ: querypb.Type_DATETIME, decimals: 3, data: []byte{0x0b, 0xe4, 0x07, 0x01, 0x02, 0x03, 0x04, 0x05, 0xc0, 0xd4, 0x01, 0x00}, want: "2020-01-02 03:04:05.120"},
+		{name: "datetime without decimals", typ: querypb.Type_DATETIME, data: []byte{0x0b, 0xe4, 0x07, 0x01, 0x02, 0x03, 0x04, 0x05, 0xc0, 0xd4, 0x01, 0x00}, want: "2020-01-02 03:04:05.120000"},
+		{name: "timestamp", typ: querypb.Type_TIMESTAMP, data: []byte{0x07, 0xb2, 0x07, 0x01, 0x01, 0x00, 0x00, 0x01}, want: "1970-01-01 00:00:01"},
+		{name: "timestamp(6)", typ: querypb.Type_TIMESTAMP, decimals: 6, data: []byte{0x0b, 0x26, 0x08, 0x01, 0x13, 0x03, 0x0e, 0x07, 0x3f, 0x42, 0x0f, 0x00}, want: "2086-01-19 03:14:07.999999"},
+		{name: "zero time", typ: querypb.Type_TIME, data: []byte{0x00}, want: "00:00:00"},
+		{name: "zero time(3)", typ: querypb.Type_TIME, decimals: 3, data: []byte{0x00}, want: "00:00:00.000"},
+		{name: "time", typ: querypb.Type_TIME, data: []byte{0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0x02, 0x03}, want: "01:02:03"},
+		{name: "negative time", typ: querypb.Type_TIME, data: []byte{0x08, 0x01, 0x00, 0x00, 0x00, 0x00, 0x01, 0x02, 0x03}, want: "-01:02:03"},
+		{name: "max time", typ: querypb.Type_TIME, data: []byte{0x08, 0x00, 0x22, 0x00, 0x00, 0x00, 0x16, 0x3b, 0x3b}, want: "838:59:59"},
+		{name: "min time(6)", typ: querypb.Type_TIME, decimals: 6, data: []byte{0x0c, 0x01, 0x22, 0x00, 0x00, 0x00, 0x16, 0x3b, 0x3a, 0x3f, 0x42, 0x0f, 0x00}, want: "-838:59:58.999999"},
+		{name: "time(1)", typ: querypb.Type_TIME, decimals: 1, data: []byte{0x0c, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0x20, 0xa1, 0x07, 0x00}, want: "00:00:01.5"},
+		{name: "varchar", typ: querypb.Type_VARCHAR, data: []byte{0x03, 'a', 'b', 'c'}, want: "abc"},
+		{name: "empty varchar", typ: querypb.Type_VARCHAR, data: []byte{0x00}, want: ""},
+		{name: "char", typ: querypb.Type_CHAR, data: []byte{0x01, 'x'}, want: "x"},
+		{name: "text", typ: querypb.Type_TEXT, data: []byte{0x02, 'h', 'i'}, want: "hi"},
+		{name: "blob", typ: querypb.Type_BLOB, data: []byte{0x03, 0x00, 0xff, 0x01}, want: "\x00\xff\x01"},
+		{name: "varbinary", typ: querypb.Type_VARBINARY, data: []byte{0x01, 0x80}, want: "\x80"},
+		{name: "binary", typ: querypb.Type_BINARY, data: []byte{0x02, 'a', 0x00}, want: "a\x00"},
+		{name: "bit", typ: querypb.Type_BIT, data: []byte{0x01, 0x05}, want: "\x05"},
+		{name: "enum", typ: querypb.Type_ENUM, data: []byte{0x01, 'a'}, want: "a"},
+		{name: "set", typ: querypb.Type_SET, data: []byte{0x03, 'a', ',', 'b'}, want: "a,b"},
+		{name: "json", typ: querypb.Type_JSON, data: []byte{0x08, '{', '"', 'a', '"', ':', ' ', '1', '}'}, want: `{"a": 1}`},
+		{name: "geometry", typ: querypb.Type_GEOMETRY, data: []byte{0x04, 0x00, 0x00, 0x00, 0x00}, want: "\x00\x00\x00\x00"},
+	}
+	c := &Conn{}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fields := []*querypb.Field{{Name: "col", Type: test.typ, Decimals: test.decimals}}
+			row, err := c.parseBinaryRow(append([]byte{0x00, 0x00}, test.data...), fields)
+			if err != nil {
+				t.Fatalf("parseBinaryRow failed: %v", err)
+			}
+			if row[0].IsNull() || row[0].Type() != test.typ || row[0].ToString() != test.want {
+				t.Errorf("got %v, want %v(%q)", row[0], test.typ, test.want)
+			}
+			// The value can be sent back as is.
+			if _, err := val2MySQL(row[0]); err != nil {
+				t.Errorf("val2MySQL(%v) failed: %v", row[0], err)
+			}
+		})
+	}
+}
+
+func TestParseBinaryRowNulls(t *testing.T) {
+	c := &Conn{}
+	fields := []*querypb.Field{
+		{Name: "id", Type: querypb.Type_INT32},
+		{Name: "n", Type: querypb.Type_NULL_TYPE},
+		{Name: "name", Type: querypb.Type_VARCHAR},
+		{Name: "d", Type: querypb.Type_DATETIME},
+		{Name: "a", Type: querypb.Type_INT8},
+		{Name: "b", Type: querypb.Type_INT8},
+		{Name: "c", Type: querypb.Type_INT8},
+	}
+	// The NULL-bitmap has two bytes: "n", "d" and "c" are NULL, so the
+	// bits 3, 5 and 8 are set.
+	data := []byte{0x00, 0x28, 0x01, 0x0a, 0x00, 0x00, 0x00, 0x02, 'h', 'i', 0x01, 0x02}
+	row, err := c.parseBinaryRow(data, fields)
+	if err != nil {
+		t.Fatalf("parseBinaryRow failed: %v", err)
+	}
+	want := []sqltypes.Value{
+		sqltypes.MakeTrusted(querypb.Type_INT32, []byte("10")),
+		sqltypes.NULL,
+		sqltypes.MakeTrusted(querypb.Type_VARCHAR, []byte("hi")),
+		sqltypes.NULL,
+		sqltypes.MakeTrusted(querypb.Type_INT8, []byte("1")),
+		sqltypes.MakeTrusted(querypb.Type_INT8, []byte("2")),
+		sqltypes.NULL,
+	}
+	for i := range want {
+		if row[i].IsNull() != want[i].IsNull() || row[i].ToString() != want[i].ToString() {
+			t.Errorf("column %v: got %v, want %v", fields[i].Name, row[i], want[i])
+		}
+	}
+}
+
+func TestParseBinaryRowErrors(t *testing.T) {
+	c := &Conn{}
+	for _, test := range []struct {
+		name string
+		typ  querypb.Type
+		data []byte
+	}{
+		{name: "no header", typ: querypb.Type_INT8, data: []byte{}},
+		{name: "bad header", typ: querypb.Type_INT8, data: []byte{0x01, 0x00, 0x01}},
+		{name: "no NULL-bitmap", typ: querypb.Type_INT8, data: []byte{0x00}},
+		{name: "truncated long", typ: querypb.Type_INT32, data: []byte{0x00, 0x00, 0x01, 0x02}},
+		{name: "truncated longlong", typ: querypb.Type_UINT64, data: []byte{0x00, 0x00, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}},
+		{name: "bad datetime length", typ: querypb.Type_DATETIME, data: []byte{0x00, 0x00, 0x05, 0xe4, 0x07, 0x01, 0x02, 0x03}},
+		{name: "truncated datetime", typ: querypb.Type_DATETIME, data: []byte{0x00, 0x00, 0x07, 0xe4, 0x07, 0x01, 0x02}},
+		{name: "bad time length", typ: querypb.Type_TIME, data: []byte{0x00, 0x00, 0x04, 0x00, 0x00, 0x00, 0x00}},
+		{name: "truncated string", typ: querypb.Type_VARCHAR, data: []byte{0x00, 0x00, 0x03, 'a'}},
+		{name: "extra bytes", typ: querypb.Type_INT8, data: []byte{0x00, 0x00, 0x01, 0x02}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := c.parseBinaryRow(test.data, []*querypb.Field{{Name: "col", Type: test.typ}})
+			if err == nil {
+				t.Fatalf("parseBinaryRow(%v) succeeded", test.data)
+			}
+			if sqlErr, ok := err.(*SQLError); !ok || sqlErr.Number() != CRMalformedPacket {
+				t.Errorf("got %v, want CRMalformedPacket", err)
+			}
+		})
+	}
+}
+
+func TestReadQueryResultBinary(t *testing.T) {
+	listener, sConn, cConn := createSocketPair(t)
+	defer func() {
+		listener.Close()
+		sConn.Close()
+		cConn.Close()
+	}()
+
+	result := &sqltypes.Result{
+		Fields: []*querypb.Field{
+			{Name: "id", Type: querypb.Type_UINT64},
+			{Name: "d", Type: querypb.Type_DATETIME, Decimals: 6},
+			{Name: "t", Type: querypb.Type_TIME},
+		},
+		Rows: [][]sqltypes.Value{{
+			sqltypes.NewUint64(math.MaxUint64),
+			sqltypes.MakeTrusted(querypb.Type_DATETIME, []byte("2020-01-02 03:04:05")),
+			sqltypes.MakeTrusted(querypb.Type_TIME, []byte("-25:00:00")),
+		}, {
+			sqltypes.NewUint64(0),
+			sqltypes.NULL,
+			sqltypes.MakeTrusted(querypb.Type_TIME, []byte("00:00:00")),
+		}},
+	}
+	writeResult := func(binary bool) {
+		t.Helper()
+		sConn.sequence = 0
+		if _, err := sConn.ReadPacket(); err != nil {
+			t.Fatalf("ReadPacket failed: %v", err)
+		}
+		if err := sConn.writeFields(result); err != nil {
+			t.Fatalf("writeFields failed: %v", err)
+		}
+		var err error
+		if binary {
+			err = sConn.writeBinaryRows(result)
+		} else {
+			err = sConn.writeRows(result)
+		}
+		if err != nil {
+			t.Fatalf("writing the rows failed: %v", err)
+		}
+		if err := sConn.writeEndResult(false, 0, 0, 0); err != nil {
+			t.Fatalf("writeEndResult failed: %v", err)
+		}
+	}
+
+	// After a COM_STMT_EXECUTE, the rows are binary.
+	prepare := &PrepareData{StatementID: 1}
+	if err := cConn.writeComStmtExecute(prepare, NoCursor, nil); err != nil {
+		t.Fatalf("writeComStmtExecute failed: %v", err)
+	}
+	writeResult(true)
+	got, _, _, err := cConn.ReadQueryResult(10, true)
+	if err != nil {
+		t.Fatalf("ReadQueryResult failed: %v", err)
+	}
+	want := [][]string{
+		{"18446744073709551615", "2020-01-02 03:04:05.000000", "-25:00:00"},
+		{"0", "NULL", "00:00:00"},
+	}
+	checkRows := func(got *sqltypes.Result) {
+		t.Helper()
+		if len(got.Rows) != len(want) {
+			t.Fatalf("got %v rows, want %v", len(got.Rows), len(want))
+		}
+		for i, row := range got.Rows {
+			for j, val := range row {
+				s := val.ToString()
+				if val.IsNull() {
+					s = "NULL"
+				}
+				if s != want[i][j] {
+					t.Errorf("row %v column %v: got %q, want %q", i, j, s, want[i][j])
+				}
+			}
+		}
+	}
+	checkRows(got)
+
+	// After a COM_QUERY, they are text again.
+	if err := cConn.WriteComQuery("select"); err != nil {
+		t.Fatalf("WriteComQuery failed: %v", err)
+	}
+	writeResult(false)
+	got, _, _, err = cConn.ReadQueryResult(10, true)
+	if err != nil {
+		t.Fatalf("ReadQueryResult failed: %v", err)
+	}
+	want[0][1] = "2020-01-02 03:04:05"
+	checkRows(got)
+}
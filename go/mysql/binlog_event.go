@@ -98,6 +98,9 @@ type BinlogEvent interface {
 	// Rand returns the two seed values for a RAND_EVENT.
 	// This is only valid if IsRand() returns true.
 	Rand(BinlogFormat) (uint64, uint64, error)
+	// XID returns the ID of the transaction an XID_EVENT commits.
+	// This is only valid if IsXID() returns true.
+	XID(BinlogFormat) (uint64, error)
 	// PreviousGTIDs returns the Position from the event.
 	// This is only valid if IsPreviousGTIDs() returns true.
 	PreviousGTIDs(BinlogFormat) (Position, error)
@@ -0,0 +1,306 @@
+/*
+Copyright 2019 The Vitess Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mysql
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"io/ioutil"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/dolthub/vitess/go/sqltypes"
+
+	querypb "github.com/dolthub/vitess/go/vt/proto/query"
+)
+
+// readBinlogFile returns the events of a binlog file of testdata/binlog.
+func readBinlogFile(t *testing.T, name string) []BinlogEvent {
+	t.Helper()
+	data, err := ioutil.ReadFile(path.Join("testdata", "binlog", name))
+	if err != nil {
+		t.Fatalf("ReadFile failed: %v", err)
+	}
+	if !bytes.HasPrefix(data, []byte("\xfebin")) {
+		t.Fatalf("%v is not a binlog file", name)
+	}
+	data = data[4:]
+
+	var events []BinlogEvent
+	for len(data) > 0 {
+		if len(data) < 19 {
+			t.Fatalf("%v: truncated event header", name)
+		}
+		length := int(binary.LittleEndian.Uint32(data[9:13]))
+		if length < 19 || length > len(data) {
+			t.Fatalf("%v: bad event length %v", name, length)
+		}
+		events = append(events, NewMysql56BinlogEvent(data[:length]))
+		data = data[length:]
+	}
+	return events
+}
+
+// The binlog files of testdata/binlog have the events of the same
+// transaction, as MySQL 5.7 and 8.0 write them with binlog_format=ROW,
+// binlog_row_image=FULL and binlog_checksum=CRC32. They were assembled
+// byte by byte from the server sources (log_event.cc, decimal2bin and
+// json_binary.cc), not decoded with this package:
+//
+//	CREATE TABLE test.orders (
+//	  id INT NOT NULL PRIMARY KEY,
+//	  amount DECIMAL(10,2) NOT NULL,
+//	  rate DECIMAL(20,6),
+//	  doc JSON,
+//	  note VARCHAR(32)
+//	) DEFAULT CHARSET=utf8mb4;
+//
+//	BEGIN;
+//	INSERT INTO test.orders VALUES
+//	  (1, 1234.56, 98765432109876.543210, '{"id": 1, "ok": true, "tags": ["a", "b"], "price": 9.5}', 'héllo'),
+//	  (2, -0.05, -0.000001, '[1, "x", null, -70000]', NULL);
+//	UPDATE test.orders SET amount = -1234.56, rate = NULL, doc = '"scalar"' WHERE id = 1;
+//	COMMIT;
+//
+// 8.0 adds the commit timestamps to the GTID event, and the optional
+// metadata (signedness and default charset) to the TABLE_MAP event.
+func TestBinlogFiles(t *testing.T) {
+	types := []querypb.Type{
+		querypb.Type_INT32,
+		querypb.Type_DECIMAL,
+		querypb.Type_DECIMAL,
+		querypb.Type_JSON,
+		querypb.Type_VARCHAR,
+	}
+	row1 := []sqltypes.Value{
+		sqltypes.NewInt32(1),
+		sqltypes.MakeTrusted(querypb.Type_DECIMAL, []byte("1234.56")),
+		sqltypes.MakeTrusted(querypb.Type_DECIMAL, []byte("98765432109876.543210")),
+		sqltypes.MakeTrusted(sqltypes.Expression, []byte("JSON_OBJECT('id',1,'ok',true,'tags',JSON_ARRAY('a','b'),'price',9.5E+00)")),
+		sqltypes.MakeTrusted(querypb.Type_VARCHAR, []byte("héllo")),
+	}
+	row2 := []sqltypes.Value{
+		sqltypes.NewInt32(2),
+		sqltypes.MakeTrusted(querypb.Type_DECIMAL, []byte("-0.05")),
+		sqltypes.MakeTrusted(querypb.Type_DECIMAL, []byte("-0.000001")),
+		sqltypes.MakeTrusted(sqltypes.Expression, []byte("JSON_ARRAY(1,'x',null,-70000)")),
+		sqltypes.NULL,
+	}
+	row1After := []sqltypes.Value{
+		sqltypes.NewInt32(1),
+		sqltypes.MakeTrusted(querypb.Type_DECIMAL, []byte("-1234.56")),
+		sqltypes.NULL,
+		sqltypes.MakeTrusted(sqltypes.Expression, []byte(`'"scalar"'`)),
+		sqltypes.MakeTrusted(querypb.Type_VARCHAR, []byte("héllo")),
+	}
+
+	for _, tcase := range []struct {
+		file          string
+		serverVersion string
+		gtid          string
+	}{{
+		file:          "mysql57-bin.000001",
+		serverVersion: "5.7.44-log",
+		gtid:          "3e11fa47-71ca-11e1-a529-0800200c9a66:5",
+	}, {
+		file:          "mysql80-binlog.000001",
+		serverVersion: "8.0.36",
+		gtid:          "8a94f357-aab4-11df-b83f-0800200c9a66:5",
+	}} {
+		t.Run(tcase.serverVersion, func(t *testing.T) {
+			events := readBinlogFile(t, tcase.file)
+			if len(events) != 8 {
+				t.Fatalf("got %v events, want 8", len(events))
+			}
+
+			if !events[0].IsFormatDescription() {
+				t.Fatalf("first event is not a FORMAT_DESCRIPTION_EVENT")
+			}
+			f, err := events[0].Format()
+			if err != nil {
+				t.Fatalf("Format() failed: %v", err)
+			}
+			if f.ServerVersion != tcase.serverVersion || f.ChecksumAlgorithm != BinlogChecksumAlgCRC32 || f.HeaderLength != 19 {
+				t.Fatalf("got format %+v, want server version %v with CRC32 checksums", f, tcase.serverVersion)
+			}
+
+			// Strip and check the checksums.
+			for i, ev := range events[1:] {
+				stripped, checksum, err := ev.StripChecksum(f)
+				if err != nil {
+					t.Fatalf("StripChecksum(%v) failed: %v", i+1, err)
+				}
+				if want := crc32.ChecksumIEEE(stripped.(mysql56BinlogEvent).Bytes()); binary.LittleEndian.Uint32(checksum) != want {
+					t.Errorf("event %v: got checksum %x, want %x", i+1, checksum, want)
+				}
+				events[i+1] = stripped
+			}
+
+			pos, err := events[1].PreviousGTIDs(f)
+			if err != nil || !events[1].IsPreviousGTIDs() {
+				t.Fatalf("PreviousGTIDs() failed: %v", err)
+			}
+			if pos.GTIDSet.String() != "" {
+				t.Errorf("got previous GTIDs %v, want none", pos)
+			}
+
+			if !events[2].IsGTID() {
+				t.Fatalf("event 2 is not a GTID_EVENT")
+			}
+			gtid, _, err := events[2].GTID(f)
+			if err != nil {
+				t.Fatalf("GTID() failed: %v", err)
+			}
+			if gtid.String() != tcase.gtid {
+				t.Errorf("got GTID %v, want %v", gtid, tcase.gtid)
+			}
+
+			q, err := events[3].Query(f)
+			if err != nil {
+				t.Fatalf("Query() failed: %v", err)
+			}
+			if q.Database != "test" || q.SQL != "BEGIN" || q.Charset == nil || q.Charset.Client != 33 {
+				t.Errorf("got query %v, want BEGIN in test with the utf8 client charset", q)
+			}
+
+			tm, err := events[4].TableMap(f)
+			if err != nil {
+				t.Fatalf("TableMap() failed: %v", err)
+			}
+			if tm.Database != "test" || tm.Name != "orders" {
+				t.Errorf("got table %v.%v, want test.orders", tm.Database, tm.Name)
+			}
+			if want := []byte{TypeLong, TypeNewDecimal, TypeNewDecimal, TypeJSON, TypeVarchar}; !bytes.Equal(tm.Types, want) {
+				t.Errorf("got types %v, want %v", tm.Types, want)
+			}
+			if want := []uint16{0, 10<<8 | 2, 20<<8 | 6, 4, 128}; !reflect.DeepEqual(tm.Metadata, want) {
+				t.Errorf("got metadata %v, want %v", tm.Metadata, want)
+			}
+			var canBeNull []string
+			for c := 0; c < tm.CanBeNull.Count(); c++ {
+				if tm.CanBeNull.Bit(c) {
+					canBeNull = append(canBeNull, []string{"id", "amount", "rate", "doc", "note"}[c])
+				}
+			}
+			if got := strings.Join(canBeNull, ","); got != "rate,doc,note" {
+				t.Errorf("got nullable columns %v, want rate,doc,note", got)
+			}
+
+			if !events[5].IsWriteRows() {
+				t.Fatalf("event 5 is not a WRITE_ROWS_EVENT")
+			}
+			inserted, err := events[5].Rows(f, tm)
+			if err != nil {
+				t.Fatalf("Rows() failed: %v", err)
+			}
+			if len(inserted.Rows) != 2 {
+				t.Fatalf("got %v inserted rows, want 2", len(inserted.Rows))
+			}
+			for i, want := range [][]sqltypes.Value{row1, row2} {
+				values, err := inserted.DataValues(tm, i, types)
+				if err != nil {
+					t.Fatalf("DataValues(%v) failed: %v", i, err)
+				}
+				if !reflect.DeepEqual(values, want) {
+					t.Errorf("inserted row %v:\n%v\nwant:\n%v", i, values, want)
+				}
+			}
+
+			if !events[6].IsUpdateRows() {
+				t.Fatalf("event 6 is not an UPDATE_ROWS_EVENT")
+			}
+			updated, err := events[6].Rows(f, tm)
+			if err != nil {
+				t.Fatalf("Rows() failed: %v", err)
+			}
+			if len(updated.Rows) != 1 {
+				t.Fatalf("got %v updated rows, want 1", len(updated.Rows))
+			}
+			before, err := updated.IdentifyValues(tm, 0, types)
+			if err != nil {
+				t.Fatalf("IdentifyValues() failed: %v", err)
+			}
+			if !reflect.DeepEqual(before, row1) {
+				t.Errorf("row before update:\n%v\nwant:\n%v", before, row1)
+			}
+			after, err := updated.DataValues(tm, 0, types)
+			if err != nil {
+				t.Fatalf("DataValues() failed: %v", err)
+			}
+			if !reflect.DeepEqual(after, row1After) {
+				t.Errorf("row after update:\n%v\nwant:\n%v", after, row1After)
+			}
+
+			if !events[7].IsXID() {
+				t.Fatalf("event 7 is not a XID_EVENT")
+			}
+			if xid, err := events[7].XID(f); err != nil || xid != 42 {
+				t.Errorf("XID() = %v, %v, want 42", xid, err)
+			}
+		})
+	}
+}
+
+func TestTableMapEventBadColumnCount(t *testing.T) {
+	f := NewMySQL56BinlogFormat()
+	s := NewFakeBinlogStream()
+
+	tm := &TableMap{
+		Flags:     0x8090,
+		Database:  "my_database",
+		Name:      "my_table",
+		Types:     []byte{TypeLongLong, TypeVarchar},
+		CanBeNull: NewServerBitmap(2),
+		Metadata:  []uint16{0, 384},
+	}
+	event, _, err := NewTableMapEvent(f, s, 0x102030405060, tm).StripChecksum(f)
+	if err != nil {
+		t.Fatalf("StripChecksum failed: %v", err)
+	}
+	raw := event.(mariadbBinlogEvent).Bytes()
+
+	// The column count is after the table id, the flags and the
+	// names: 6 + 2 + 1 + 11 + 1 + 1 + 8 + 1.
+	countPos := int(f.HeaderLength) + 31
+	if got := raw[countPos]; got != 2 {
+		t.Fatalf("got column count %v, want 2", got)
+	}
+	for _, count := range [][]byte{
+		{0xfa},
+		{0xfc, 0xff, 0xff},
+		{0xfe, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x7f},
+		{0xfe, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+	} {
+		data := append([]byte{}, raw[:countPos]...)
+		data = append(data, count...)
+		data = append(data, raw[countPos+1:]...)
+		if _, err := NewMysql56BinlogEvent(data).TableMap(f); err == nil || !strings.Contains(err.Error(), "overflows buffer") {
+			t.Errorf("TableMap() with column count %x returned %v, want an overflow error", count, err)
+		}
+	}
+
+	// The metadata length is checked too.
+	metadataPos := countPos + 1 + 2
+	data := append([]byte{}, raw...)
+	data[metadataPos] = 0xfa
+	if _, err := NewMysql56BinlogEvent(data).TableMap(f); err == nil || !strings.Contains(err.Error(), "overflows buffer") {
+		t.Errorf("TableMap() with a bad metadata length returned %v, want an overflow error", err)
+	}
+}
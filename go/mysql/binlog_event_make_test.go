@@ -20,7 +20,9 @@ import (
 	"reflect"
 	"testing"
 
+	"github.com/dolthub/vitess/go/sqltypes"
 	binlogdatapb "github.com/dolthub/vitess/go/vt/proto/binlogdata"
+	querypb "github.com/dolthub/vitess/go/vt/proto/query"
 )
 
 // TestFormatDescriptionEvent tests both MySQL 5.6 and MariaDB 10.0
@@ -110,6 +112,16 @@ func TestXIDEvent(t *testing.T) {
 	if !event.IsXID() {
 		t.Fatalf("NewXIDEvent().IsXID() is false")
 	}
+
+	event = NewMysql56BinlogEvent(s.Packetize(f, eXIDEvent, 0, []byte{0x08, 0x07, 0x06, 0x05, 0x04, 0x03, 0x02, 0x01}))
+	event, _, err := event.StripChecksum(f)
+	if err != nil {
+		t.Fatalf("StripChecksum failed: %v", err)
+	}
+	xid, err := event.XID(f)
+	if err != nil || xid != 0x0102030405060708 {
+		t.Fatalf("XID() returned %x, %v, was expecting 0102030405060708", xid, err)
+	}
 }
 
 func TestIntVarEvent(t *testing.T) {
@@ -390,3 +402,171 @@ func TestRowsEvent(t *testing.T) {
 		t.Fatalf("NewRowsEvent().Rows() got Rows:\n%v\nexpected:\n%v", gotRows, rows)
 	}
 }
+
+func TestRowsEventManyColumns(t *testing.T) {
+	f := NewMySQL56BinlogFormat()
+	s := NewFakeBinlogStream()
+
+	// More than 250 columns: the column counts take 3 bytes.
+	const columnCount = 300
+	tm := &TableMap{
+		Database:  "my_database",
+		Name:      "my_table",
+		Types:     make([]byte, columnCount),
+		CanBeNull: NewServerBitmap(columnCount),
+		Metadata:  make([]uint16, columnCount),
+	}
+	rows := Rows{
+		DataColumns: NewServerBitmap(columnCount),
+		Rows: []Row{{
+			NullColumns: NewServerBitmap(columnCount),
+			Data:        make([]byte, columnCount),
+		}},
+	}
+	types := make([]querypb.Type, columnCount)
+	for c := 0; c < columnCount; c++ {
+		tm.Types[c] = TypeTiny
+		rows.DataColumns.Set(c, true)
+		rows.Rows[0].Data[c] = byte(c)
+		types[c] = querypb.Type_UINT8
+	}
+
+	event, _, err := NewTableMapEvent(f, s, 1, tm).StripChecksum(f)
+	if err != nil {
+		t.Fatalf("StripChecksum failed: %v", err)
+	}
+	gotTm, err := event.TableMap(f)
+	if err != nil {
+		t.Fatalf("TableMap() returned error: %v", err)
+	}
+	if !reflect.DeepEqual(gotTm, tm) {
+		t.Fatalf("TableMap() got TableMap:\n%v\nexpected:\n%v", gotTm, tm)
+	}
+
+	event, _, err = NewWriteRowsEvent(f, s, 1, rows).StripChecksum(f)
+	if err != nil {
+		t.Fatalf("StripChecksum failed: %v", err)
+	}
+	gotRows, err := event.Rows(f, gotTm)
+	if err != nil {
+		t.Fatalf("Rows() returned error: %v", err)
+	}
+	if !reflect.DeepEqual(gotRows, rows) {
+		t.Fatalf("Rows() got Rows:\n%v\nexpected:\n%v", gotRows, rows)
+	}
+	values, err := gotRows.DataValues(gotTm, 0, types)
+	if err != nil {
+		t.Fatalf("DataValues() returned error: %v", err)
+	}
+	if len(values) != columnCount || values[299].ToString() != "43" {
+		t.Fatalf("DataValues() returned %v values, the last one %v", len(values), values[len(values)-1])
+	}
+}
+
+func TestRowsEventValues(t *testing.T) {
+	f := NewMySQL56BinlogFormat()
+	s := NewFakeBinlogStream()
+
+	tm := &TableMap{
+		Database: "my_database",
+		Name:     "my_table",
+		Types: []byte{
+			TypeLong,
+			TypeLongLong,
+			TypeNewDecimal,
+			TypeJSON,
+			TypeVarchar,
+		},
+		CanBeNull: NewServerBitmap(5),
+		Metadata: []uint16{
+			0,
+			0,
+			14<<8 | 4, // DECIMAL(14,4)
+			4,         // Length of the JSON length.
+			384,       // Length of the varchar field.
+		},
+	}
+	tm.CanBeNull.Set(4, true)
+	types := []querypb.Type{
+		querypb.Type_INT32,
+		querypb.Type_UINT64,
+		querypb.Type_DECIMAL,
+		querypb.Type_JSON,
+		querypb.Type_VARCHAR,
+	}
+
+	// An update of all the columns, setting the varchar to NULL.
+	rows := Rows{
+		IdentifyColumns: NewServerBitmap(5),
+		DataColumns:     NewServerBitmap(5),
+		Rows: []Row{
+			{
+				NullIdentifyColumns: NewServerBitmap(5),
+				NullColumns:         NewServerBitmap(5),
+				Identify: []byte{
+					0xfe, 0xff, 0xff, 0xff, // long -2
+					0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, // unsigned longlong 2^64-1
+					0x81, 0x0D, 0xFB, 0x38, 0xD2, 0x04, 0xD2, // 1234567890.1234
+					0x0f, 0x00, 0x00, 0x00, // JSON length
+					0, 1, 0, 14, 0, 11, 0, 1, 0, 12, 12, 0, 97, 1, 98, // {"a": "b"}
+					0x03, 0x00, 'a', 'b', 'c', // varchar 'abc'
+				},
+				Data: []byte{
+					0x02, 0x00, 0x00, 0x00, // long 2
+					0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, // unsigned longlong 1
+					0x7E, 0xF2, 0x04, 0xC7, 0x2D, 0xFB, 0x2D, // -1234567890.1234
+					0x0f, 0x00, 0x00, 0x00, // JSON length
+					0, 1, 0, 14, 0, 11, 0, 1, 0, 12, 12, 0, 97, 1, 98, // {"a": "b"}
+				},
+			},
+		},
+	}
+	for c := 0; c < 5; c++ {
+		rows.IdentifyColumns.Set(c, true)
+		rows.DataColumns.Set(c, true)
+	}
+	rows.Rows[0].NullColumns.Set(4, true)
+
+	event, _, err := NewUpdateRowsEvent(f, s, 1, rows).StripChecksum(f)
+	if err != nil {
+		t.Fatalf("StripChecksum failed: %v", err)
+	}
+	gotRows, err := event.Rows(f, tm)
+	if err != nil {
+		t.Fatalf("Rows() returned error: %v", err)
+	}
+
+	identifies, err := gotRows.IdentifyValues(tm, 0, types)
+	if err != nil {
+		t.Fatalf("IdentifyValues() returned error: %v", err)
+	}
+	want := []sqltypes.Value{
+		sqltypes.NewInt32(-2),
+		sqltypes.NewUint64(18446744073709551615),
+		sqltypes.MakeTrusted(querypb.Type_DECIMAL, []byte("1234567890.1234")),
+		sqltypes.MakeTrusted(sqltypes.Expression, []byte("JSON_OBJECT('a','b')")),
+		sqltypes.MakeTrusted(querypb.Type_VARCHAR, []byte("abc")),
+	}
+	if !reflect.DeepEqual(identifies, want) {
+		t.Errorf("IdentifyValues() returned:\n%v\nexpected:\n%v", identifies, want)
+	}
+
+	values, err := gotRows.DataValues(tm, 0, types)
+	if err != nil {
+		t.Fatalf("DataValues() returned error: %v", err)
+	}
+	want = []sqltypes.Value{
+		sqltypes.NewInt32(2),
+		sqltypes.NewUint64(1),
+		sqltypes.MakeTrusted(querypb.Type_DECIMAL, []byte("-1234567890.1234")),
+		sqltypes.MakeTrusted(sqltypes.Expression, []byte("JSON_OBJECT('a','b')")),
+		sqltypes.NULL,
+	}
+	if !reflect.DeepEqual(values, want) {
+		t.Errorf("DataValues() returned:\n%v\nexpected:\n%v", values, want)
+	}
+
+	if _, err := gotRows.DataValues(tm, 0, types[:4]); err == nil {
+		t.Errorf("DataValues() with missing types should have failed")
+	}
+}
@@ -69,16 +69,27 @@ func (ev binlogEvent) TableMap(f BinlogFormat) (*TableMap, error) {
 	result.Name = string(data[pos+1 : pos+1+l])
 	pos += 1 + l + 1
 
-	// FIXME(alainjobart) this is varlength encoded.
-	columnCount := int(data[pos])
-	pos++
+	count, pos, ok := readLenEncInt(data, pos)
+	if !ok {
+		return nil, vterrors.Errorf(vtrpc.Code_INTERNAL, "cannot read column count (data=%v)", data)
+	}
+	// There is one type byte per column.
+	if count > uint64(len(data)-pos) {
+		return nil, vterrors.Errorf(vtrpc.Code_INTERNAL, "column count %v overflows buffer (%v bytes left)", count, len(data)-pos)
+	}
+	columnCount := int(count)
 
 	result.Types = data[pos : pos+columnCount]
 	pos += columnCount
 
-	// FIXME(alainjobart) this is a var-len-string.
-	l = int(data[pos])
-	pos++
+	metadataLength, pos, ok := readLenEncInt(data, pos)
+	if !ok {
+		return nil, vterrors.Errorf(vtrpc.Code_INTERNAL, "cannot read metadata length (data=%v)", data)
+	}
+	if metadataLength > uint64(len(data)-pos) {
+		return nil, vterrors.Errorf(vtrpc.Code_INTERNAL, "metadata length %v overflows buffer (%v bytes left)", metadataLength, len(data)-pos)
+	}
+	l = int(metadataLength)
 
 	// Allocate and parse / copy Metadata.
 	result.Metadata = make([]uint16, columnCount)
@@ -95,6 +106,9 @@ func (ev binlogEvent) TableMap(f BinlogFormat) (*TableMap, error) {
 	}
 
 	// A bit array that says if each column can be NULL.
+	if len(data)-pos < (columnCount+7)/8 {
+		return nil, vterrors.Errorf(vtrpc.Code_INTERNAL, "NULL bitmap overflows buffer (%v bytes left for %v columns)", len(data)-pos, columnCount)
+	}
 	result.CanBeNull, _ = newBitmap(data, pos, columnCount)
 
 	return result, nil
@@ -662,6 +676,7 @@ func CellValue(data []byte, pos int, typ byte, metadata uint16, styp querypb.Typ
 				d[i] ^= 0xff
 			}
 		}
+		intStart := txt.Len()
 
 		// first we have the leftover full digits
 		var val uint32
@@ -692,12 +707,21 @@ func CellValue(data []byte, pos int, typ byte, metadata uint16, styp querypb.Typ
 			txt.Write(strconv.AppendUint(nil, uint64(val), 10))
 		}
 
-		// now the full digits, 32 bits each, 9 digits
+		// now the full digits, 32 bits each, 9 digits, zero padded
+		// after the leading ones.
 		for i := 0; i < intg0; i++ {
 			val = binary.BigEndian.Uint32(d[pos : pos+4])
-			fmt.Fprintf(txt, "%9d", val)
+			if txt.Len() > intStart {
+				fmt.Fprintf(txt, "%09d", val)
+			} else if val > 0 {
+				txt.Write(strconv.AppendUint(nil, uint64(val), 10))
+			}
 			pos += 4
 		}
+		if txt.Len() == intStart {
+			// The integer part is 0.
+			txt.WriteByte('0')
+		}
 
 		// now see if we have a fraction
 		if scale == 0 {
@@ -947,9 +971,14 @@ func (ev binlogEvent) Rows(f BinlogFormat, tm *TableMap) (Rows, error) {
 		pos += int(extraDataLength)
 	}
 
-	// FIXME(alainjobart) this is var len encoded.
-	columnCount := int(data[pos])
-	pos++
+	count, pos, ok := readLenEncInt(data, pos)
+	if !ok {
+		return result, vterrors.Errorf(vtrpc.Code_INTERNAL, "cannot read column count (data=%v)", data)
+	}
+	if count != uint64(len(tm.Types)) {
+		return result, vterrors.Errorf(vtrpc.Code_INTERNAL, "column count %v doesn't match the %v columns of the table map", count, len(tm.Types))
+	}
+	columnCount := int(count)
 
 	numIdentifyColumns := 0
 	numDataColumns := 0
@@ -1036,6 +1065,55 @@ func (ev binlogEvent) Rows(f BinlogFormat, tm *TableMap) (Rows, error) {
 	return result, nil
 }
 
+// DataValues returns the values of the data columns of a row, the
+// ones of DataColumns, in the order of the TableMap. NULL columns are
+// NULL values. types are the types of all the columns of the table, as
+// the schema defines them: the binlog doesn't say if an integer is
+// signed, or if a string is binary.
+func (rs *Rows) DataValues(tm *TableMap, rowIndex int, types []querypb.Type) ([]sqltypes.Value, error) {
+	row := rs.Rows[rowIndex]
+	return rowValues(tm, rs.DataColumns, row.NullColumns, row.Data, types)
+}
+
+// IdentifyValues returns the values of the identify columns of a row,
+// the ones of IdentifyColumns. See DataValues.
+func (rs *Rows) IdentifyValues(tm *TableMap, rowIndex int, types []querypb.Type) ([]sqltypes.Value, error) {
+	row := rs.Rows[rowIndex]
+	return rowValues(tm, rs.IdentifyColumns, row.NullIdentifyColumns, row.Identify, types)
+}
+
+// rowValues decodes the values of the columns of a row image.
+func rowValues(tm *TableMap, columns, nullColumns Bitmap, data []byte, types []querypb.Type) ([]sqltypes.Value, error) {
+	if len(types) != columns.Count() {
+		return nil, vterrors.Errorf(vtrpc.Code_INTERNAL, "got %v column types for %v columns", len(types), columns.Count())
+	}
+
+	var result []sqltypes.Value
+	valueIndex := 0
+	pos := 0
+	for c := 0; c < columns.Count(); c++ {
+		if !columns.Bit(c) {
+			continue
+		}
+
+		if nullColumns.Bit(valueIndex) {
+			result = append(result, sqltypes.NULL)
+			valueIndex++
+			continue
+		}
+
+		value, l, err := CellValue(data, pos, tm.Types[c], tm.Metadata[c], types[c])
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, value)
+		pos += l
+		valueIndex++
+	}
+
+	return result, nil
+}
+
 // StringValuesForTests is a helper method to return the string value
 // of all columns in a row in a Row. Only use it in tests, as the
 // returned values cannot be interpreted correctly without the schema.
@@ -468,6 +468,30 @@ func TestCellLengthAndData(t *testing.T) {
 		data:     []byte{0x81, 0x0D, 0xFB, 0x38, 0xD2, 0x00, 0x01},
 		out: sqltypes.MakeTrusted(querypb.Type_DECIMAL,
 			[]byte("1234567890.0001")),
+	}, {
+		typ:      TypeNewDecimal,
+		metadata: 14<<8 | 4,
+		data:     []byte{0x81, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00},
+		out: sqltypes.MakeTrusted(querypb.Type_DECIMAL,
+			[]byte("1000000001.0000")),
+	}, {
+		typ:      TypeNewDecimal,
+		metadata: 14<<8 | 4,
+		data:     []byte{0x80, 0x00, 0x00, 0x00, 0x00, 0x13, 0x88},
+		out: sqltypes.MakeTrusted(querypb.Type_DECIMAL,
+			[]byte("0.5000")),
+	}, {
+		typ:      TypeNewDecimal,
+		metadata: 14<<8 | 4,
+		data:     []byte{0x7F, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFE},
+		out: sqltypes.MakeTrusted(querypb.Type_DECIMAL,
+			[]byte("-0.0001")),
+	}, {
+		typ:      TypeNewDecimal,
+		metadata: 18<<8 | 0,
+		data:     []byte{0x80, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x05},
+		out: sqltypes.MakeTrusted(querypb.Type_DECIMAL,
+			[]byte("5")),
 	}, {
 		typ:      TypeBlob,
 		metadata: 1,
@@ -0,0 +1,80 @@
+/*
+Copyright 2019 The Vitess Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mysql
+
+import (
+	"github.com/dolthub/vitess/go/bucketpool"
+)
+
+// ConnBufferPool recycles the buffers of the ephemeral packets read
+// and written by connections, see startEphemeralPacket and
+// readEphemeralPacket. Buffers are pooled by size class, in powers of
+// two from DefaultConnBufferSize up to a maximum size. Larger packets
+// get buffers of their own, left to the garbage collector, so a few
+// large packets do not pin memory for the life of the pool.
+//
+// A ConnBufferPool can be shared by many connections. A buffer is only
+// given back to the pool once the packet is entirely read or written,
+// including when it is cut in several packets or compressed.
+type ConnBufferPool struct {
+	// pool is nil if buffers are not pooled.
+	pool *bucketpool.Pool
+}
+
+// NewConnBufferPool returns a ConnBufferPool for buffers up to
+// maxPooledSize bytes. It is rounded up to DefaultConnBufferSize, and
+// down to MaxPacketSize. If it is 0 or less, buffers are not pooled.
+func NewConnBufferPool(maxPooledSize int) *ConnBufferPool {
+	if maxPooledSize <= 0 {
+		return &ConnBufferPool{}
+	}
+	if maxPooledSize < DefaultConnBufferSize {
+		maxPooledSize = DefaultConnBufferSize
+	}
+	if maxPooledSize > MaxPacketSize {
+		maxPooledSize = MaxPacketSize
+	}
+	return &ConnBufferPool{
+		pool: bucketpool.New(DefaultConnBufferSize, maxPooledSize),
+	}
+}
+
+// defaultConnBufferPool is used by the connections that were not given
+// a pool.
+var defaultConnBufferPool = NewConnBufferPool(MaxPacketSize)
+
+// get returns a buffer of length bytes. A nil pool is the default one.
+func (p *ConnBufferPool) get(length int) *[]byte {
+	if p == nil {
+		p = defaultConnBufferPool
+	}
+	if p.pool == nil {
+		buf := make([]byte, length)
+		return &buf
+	}
+	return p.pool.Get(length)
+}
+
+// put gives back a buffer returned by get.
+func (p *ConnBufferPool) put(buf *[]byte) {
+	if p == nil {
+		p = defaultConnBufferPool
+	}
+	if p.pool != nil {
+		p.pool.Put(buf)
+	}
+}
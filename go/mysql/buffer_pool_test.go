@@ -0,0 +1,157 @@
+/*
+Copyright 2019 The Vitess Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mysql
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestConnBufferPool(t *testing.T) {
+	for _, tcase := range []struct {
+		maxPooledSize int
+		want          int
+	}{
+		{maxPooledSize: 0, want: 0},
+		{maxPooledSize: -1, want: 0},
+		{maxPooledSize: 10, want: DefaultConnBufferSize},
+		{maxPooledSize: 1 << 20, want: 1 << 20},
+		{maxPooledSize: 2 * MaxPacketSize, want: MaxPacketSize},
+	} {
+		p := NewConnBufferPool(tcase.maxPooledSize)
+		if tcase.want == 0 {
+			if p.pool != nil {
+				t.Errorf("NewConnBufferPool(%v) pools buffers, want no pooling", tcase.maxPooledSize)
+			}
+			continue
+		}
+		// The largest bucket holds buffers of the maximum size, and
+		// larger buffers are allocated to the size asked for.
+		if got := cap(*p.pool.Get(tcase.want)); got != tcase.want {
+			t.Errorf("NewConnBufferPool(%v) largest buffer is %v bytes, want %v", tcase.maxPooledSize, got, tcase.want)
+		}
+		if got := cap(*p.pool.Get(tcase.want + 1)); got != tcase.want+1 {
+			t.Errorf("NewConnBufferPool(%v) returned a %v bytes buffer for %v bytes", tcase.maxPooledSize, got, tcase.want+1)
+		}
+	}
+
+	// Buffers have the length asked for, pooled or not.
+	var defaultPool *ConnBufferPool
+	for _, p := range []*ConnBufferPool{defaultPool, NewConnBufferPool(0), NewConnBufferPool(DefaultConnBufferSize)} {
+		for _, length := range []int{0, 10, DefaultConnBufferSize, DefaultConnBufferSize + 1} {
+			buf := p.get(length)
+			if len(*buf) != length {
+				t.Errorf("get(%v) returned %v bytes", length, len(*buf))
+			}
+			p.put(buf)
+		}
+	}
+}
+
+func TestConnBufferPoolPackets(t *testing.T) {
+	for _, compressed := range []bool{false, true} {
+		t.Run(fmt.Sprintf("compressed %v", compressed), func(t *testing.T) {
+			listener, sConn, cConn := createSocketPair(t)
+			defer func() {
+				listener.Close()
+				sConn.Close()
+				cConn.Close()
+			}()
+			// A pool smaller than some of the packets, shared by both
+			// sides, so recycled buffers are reused right away.
+			pool := NewConnBufferPool(DefaultConnBufferSize)
+			sConn.bufPool = pool
+			cConn.bufPool = pool
+			if compressed {
+				sConn.enableCompression()
+				cConn.enableCompression()
+			}
+
+			for i, length := range []int{10, DefaultConnBufferSize, DefaultConnBufferSize + 1, MaxPacketSize, MaxPacketSize + 10} {
+				want := bytes.Repeat([]byte{byte('a' + i)}, length)
+				wg := sync.WaitGroup{}
+				wg.Add(1)
+				var writeErr error
+				go func() {
+					defer wg.Done()
+					sConn.sequence = 0
+					data := sConn.startEphemeralPacket(length)
+					copy(data, want)
+					writeErr = sConn.writeEphemeralPacket()
+				}()
+				cConn.sequence = 0
+				data, err := cConn.readEphemeralPacket()
+				if err != nil {
+					t.Fatalf("readEphemeralPacket(%v bytes) failed: %v", length, err)
+				}
+				if !bytes.Equal(data, want) {
+					t.Errorf("readEphemeralPacket(%v bytes) returned different data", length)
+				}
+				cConn.recycleReadPacket()
+				wg.Wait()
+				if writeErr != nil {
+					t.Fatalf("writeEphemeralPacket(%v bytes) failed: %v", length, writeErr)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkExecuteFetch shows the allocations saved by pooling the
+// packet buffers, for small queries.
+func BenchmarkExecuteFetch(b *testing.B) {
+	result := manyRowsResult(10)
+	for _, tcase := range []struct {
+		name string
+		pool *ConnBufferPool
+	}{
+		{name: "pool", pool: NewConnBufferPool(MaxPacketSize)},
+		{name: "no pool", pool: NewConnBufferPool(0)},
+	} {
+		b.Run(tcase.name, func(b *testing.B) {
+			listener, sConn, cConn, counter := createCountingSocketPair(b)
+			defer func() {
+				listener.Close()
+				sConn.Close()
+				cConn.Close()
+			}()
+			sConn.bufPool = tcase.pool
+			cConn.bufPool = tcase.pool
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				var serverErr error
+				wg := sync.WaitGroup{}
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					_, serverErr = serveResult(sConn, counter, result)
+				}()
+				if _, err := cConn.ExecuteFetch("select rows", 1000, true); err != nil {
+					b.Fatalf("ExecuteFetch failed: %v", err)
+				}
+				wg.Wait()
+				if serverErr != nil {
+					b.Fatalf("server failed: %v", serverErr)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,224 @@
+/*
+Copyright 2019 The Vitess Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mysql
+
+import (
+	"github.com/dolthub/vitess/go/vt/log"
+	"github.com/dolthub/vitess/go/vt/proto/vtrpc"
+	"github.com/dolthub/vitess/go/vt/vterrors"
+)
+
+// This file contains both ends of COM_CHANGE_USER. See
+// https://dev.mysql.com/doc/dev/mysql-server/latest/page_protocol_com_change_user.html
+//
+// The client authenticates again, as another user or the same one,
+// with the salt of the initial handshake. The exchanges that follow
+// are the same as in the handshake: auth method switch, "fast" or
+// "full" caching_sha2_password authentication...
+//
+// If the authentication succeeds, the session is reset as with
+// COM_RESET_CONNECTION, and the server answers with an OK packet. If it
+// fails, the server answers with an error packet, and the connection
+// keeps its previous user and session, as documented for
+// mysql_change_user(). If the Handler then refuses the new session,
+// the connection is closed: the previous session is gone already.
+
+// changeUserRequest is the content of a COM_CHANGE_USER packet.
+type changeUserRequest struct {
+	user         string
+	authResponse []byte
+	schemaName   string
+	// characterSet is 0 if the client did not send it.
+	characterSet uint8
+	authMethod   string
+	// attributes is nil if the client did not send them.
+	attributes map[string]string
+}
+
+// parseComChangeUser parses a COM_CHANGE_USER packet. It returns
+// copies of the data, the packet can be recycled.
+func parseComChangeUser(data []byte) (*changeUserRequest, error) {
+	req := &changeUserRequest{}
+	pos := 1
+	var ok bool
+	req.user, pos, ok = readNullString(data, pos)
+	if !ok {
+		return nil, vterrors.Errorf(vtrpc.Code_INVALID_ARGUMENT, "parseComChangeUser: can't read user")
+	}
+	authResponseLength, pos, ok := readByte(data, pos)
+	if !ok {
+		return nil, vterrors.Errorf(vtrpc.Code_INVALID_ARGUMENT, "parseComChangeUser: can't read auth response length")
+	}
+	req.authResponse, pos, ok = readBytesCopy(data, pos, int(authResponseLength))
+	if !ok {
+		return nil, vterrors.Errorf(vtrpc.Code_INVALID_ARGUMENT, "parseComChangeUser: can't read auth response")
+	}
+	req.schemaName, pos, ok = readNullString(data, pos)
+	if !ok {
+		return nil, vterrors.Errorf(vtrpc.Code_INVALID_ARGUMENT, "parseComChangeUser: can't read schema name")
+	}
+
+	// The rest is optional.
+	if pos == len(data) {
+		return req, nil
+	}
+	characterSet, pos, ok := readUint16(data, pos)
+	if !ok {
+		return nil, vterrors.Errorf(vtrpc.Code_INVALID_ARGUMENT, "parseComChangeUser: can't read character set")
+	}
+	req.characterSet = uint8(characterSet)
+	if pos == len(data) {
+		return req, nil
+	}
+	req.authMethod, pos, ok = readNullString(data, pos)
+	if !ok {
+		return nil, vterrors.Errorf(vtrpc.Code_INVALID_ARGUMENT, "parseComChangeUser: can't read auth method")
+	}
+	if pos == len(data) {
+		return req, nil
+	}
+	attributes, _, err := parseConnAttrs(data, pos)
+	if err != nil {
+		return nil, vterrors.Wrapf(err, "parseComChangeUser")
+	}
+	req.attributes = attributes
+	return req, nil
+}
+
+// handleComChangeUser handles a COM_CHANGE_USER packet, see the top of
+// this file. It returns an error if the connection should be closed.
+func (c *Conn) handleComChangeUser(handler Handler, data []byte) error {
+	req, err := parseComChangeUser(data)
+	c.recycleReadPacket()
+	if err != nil {
+		log.Errorf("Cannot parse COM_CHANGE_USER from %s: %v", c, err)
+		if werr := c.writeErrorPacket(ERUnknownComError, SSUnknownComError, "%v", err); werr != nil {
+			return werr
+		}
+		return err
+	}
+	if c.listener == nil {
+		return c.writeErrorPacket(ERUnknownComError, SSUnknownComError, "COM_CHANGE_USER is not supported on this connection")
+	}
+	if req.authMethod == "" {
+		req.authMethod = MysqlNativePassword
+	}
+
+	userData, ok := c.listener.authenticate(c, req.user, req.authMethod, c.salt, req.authResponse)
+	if !ok {
+		// The error was sent, the previous session is kept.
+		return nil
+	}
+
+	// The new session starts from a clean state.
+	c.discardCursor()
+	c.closeStatements(handler)
+	c.resetSessionState()
+	previousUser := c.User
+	if previousUser != "" {
+		connCountPerUser.Add(previousUser, -1)
+	}
+	if req.user != "" {
+		connCountPerUser.Add(req.user, 1)
+	}
+	c.User = req.user
+	c.UserData = userData
+	c.schemaName = req.schemaName
+	if req.characterSet != 0 {
+		c.CharacterSet = req.characterSet
+	}
+	if req.attributes != nil {
+		c.Attributes = req.attributes
+	}
+
+	if h, ok := handler.(ChangeUserHandler); ok {
+		if err := h.ComChangeUser(c, previousUser); err != nil {
+			log.Errorf("ComChangeUser failed %s: %v", c, err)
+			c.writeErrorPacketFromError(err)
+			return err
+		}
+	}
+	if err := handler.ComInitDB(c, c.schemaName); err != nil {
+		log.Errorf("ComInitDB failed %s: %v", c, err)
+		c.writeErrorPacketFromError(err)
+		return err
+	}
+	return c.writeOKPacket(0, 0, c.StatusFlags, 0)
+}
+
+// writeComChangeUser writes a COM_CHANGE_USER packet, authenticating
+// params.Uname with the salt of the initial handshake.
+// Returns a SQLError.
+func (c *Conn) writeComChangeUser(params *ConnParams) error {
+	charset, err := parseCharacterSet(params.Charset)
+	if err != nil {
+		return err
+	}
+	authMethod := MysqlNativePassword
+	var authResponse []byte
+	if c.authPluginName == CachingSha2Password {
+		authMethod = CachingSha2Password
+		authResponse = ScrambleCachingSha2Password(c.salt, []byte(params.Pass))
+	} else {
+		authResponse = ScrambleMysqlNativePassword(c.salt, []byte(params.Pass))
+	}
+	withAuthMethod := c.serverCapabilities&CapabilityClientPluginAuth != 0
+
+	length := 1 + // ComChangeUser
+		lenNullString(params.Uname) +
+		1 + len(authResponse) +
+		lenNullString(params.DbName) +
+		2 // character set
+	if withAuthMethod {
+		length += lenNullString(authMethod)
+	}
+
+	// This is a new command, need to reset the sequence.
+	c.resetSequence()
+	data := c.startEphemeralPacket(length)
+	pos := writeByte(data, 0, ComChangeUser)
+	pos = writeNullString(data, pos, params.Uname)
+	pos = writeByte(data, pos, byte(len(authResponse)))
+	pos += copy(data[pos:], authResponse)
+	pos = writeNullString(data, pos, params.DbName)
+	pos = writeUint16(data, pos, uint16(charset))
+	if withAuthMethod {
+		writeNullString(data, pos, authMethod)
+	}
+	if err := c.writeEphemeralPacket(); err != nil {
+		return NewSQLError(CRServerGone, SSUnknownSQLState, "cannot send COM_CHANGE_USER: %v", err)
+	}
+	return nil
+}
+
+// ChangeUser authenticates again as params.Uname, with params.Pass, and
+// switches to params.DbName and params.Charset. The server resets the
+// session, as with ResetSession, so the connection can be reused for
+// another user. If the authentication fails, the connection keeps its
+// previous user and session.
+// Returns a SQLError, or ErrResultPending.
+func (c *Conn) ChangeUser(params *ConnParams) error {
+	if err := c.startCommand(); err != nil {
+		return err
+	}
+	defer c.endCommand()
+
+	if err := c.writeComChangeUser(params); err != nil {
+		return err
+	}
+	return c.handleAuthResponse(params)
+}
@@ -0,0 +1,339 @@
+/*
+Copyright 2019 The Vitess Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mysql
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// changeUserHandler is a testHandler recording the user changes, with
+// the character set of the new session, and the closed statements.
+type changeUserHandler struct {
+	testHandler
+
+	changeMu sync.Mutex
+	changes  []string
+	charsets []uint8
+	closed   []uint32
+}
+
+func (th *changeUserHandler) ComStmtClosed(c *Conn, stmtID uint32) {
+	th.changeMu.Lock()
+	defer th.changeMu.Unlock()
+	th.closed = append(th.closed, stmtID)
+}
+
+func (th *changeUserHandler) ComChangeUser(c *Conn, previousUser string) error {
+	th.changeMu.Lock()
+	defer th.changeMu.Unlock()
+	th.changes = append(th.changes, previousUser+" -> "+c.User)
+	th.charsets = append(th.charsets, c.CharacterSet)
+	return nil
+}
+
+// changeCharsets returns the character sets of the sessions after the
+// user changes so far.
+func (th *changeUserHandler) changeCharsets() []uint8 {
+	th.changeMu.Lock()
+	defer th.changeMu.Unlock()
+	return append([]uint8(nil), th.charsets...)
+}
+
+// state returns the user changes and the closed statements so far.
+func (th *changeUserHandler) state() ([]string, []uint32) {
+	th.changeMu.Lock()
+	defer th.changeMu.Unlock()
+	return append([]string(nil), th.changes...), append([]uint32(nil), th.closed...)
+}
+
+func TestParseComChangeUser(t *testing.T) {
+	minimal := []byte{ComChangeUser}
+	minimal = append(minimal, "user1\x00"...)
+	minimal = append(minimal, 3, 1, 2, 3)
+	minimal = append(minimal, "db1\x00"...)
+	withAuthMethod := append(append([]byte{}, minimal...), CharacterSetUtf8, 0)
+	withAuthMethod = append(withAuthMethod, "caching_sha2_password\x00"...)
+	withAttributes := append(append([]byte{}, withAuthMethod...), 7, 3, 'k', 'e', 'y', 3, 'v', 'a', 'l')
+
+	for _, tcase := range []struct {
+		name string
+		data []byte
+		want *changeUserRequest
+		err  string
+	}{{
+		name: "minimal",
+		data: minimal,
+		want: &changeUserRequest{user: "user1", authResponse: []byte{1, 2, 3}, schemaName: "db1"},
+	}, {
+		name: "auth method",
+		data: withAuthMethod,
+		want: &changeUserRequest{user: "user1", authResponse: []byte{1, 2, 3}, schemaName: "db1", characterSet: CharacterSetUtf8, authMethod: CachingSha2Password},
+	}, {
+		name: "attributes",
+		data: withAttributes,
+		want: &changeUserRequest{user: "user1", authResponse: []byte{1, 2, 3}, schemaName: "db1", characterSet: CharacterSetUtf8, authMethod: CachingSha2Password, attributes: map[string]string{"key": "val"}},
+	}, {
+		name: "truncated auth response",
+		data: minimal[:9],
+		err:  "can't read auth response",
+	}, {
+		name: "no schema name",
+		data: minimal[:len(minimal)-1],
+		err:  "can't read schema name",
+	}, {
+		name: "truncated character set",
+		data: withAuthMethod[:len(minimal)+1],
+		err:  "can't read character set",
+	}} {
+		t.Run(tcase.name, func(t *testing.T) {
+			got, err := parseComChangeUser(tcase.data)
+			if tcase.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tcase.err) {
+					t.Fatalf("parseComChangeUser returned %v, want error %q", err, tcase.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseComChangeUser failed: %v", err)
+			}
+			if !reflect.DeepEqual(got, tcase.want) {
+				t.Errorf("parseComChangeUser returned %+v, want %+v", got, tcase.want)
+			}
+		})
+	}
+}
+
+func TestChangeUser(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("GenerateKey failed: %v", err)
+	}
+
+	for _, tcase := range []struct {
+		name string
+		opts []ListenerOption
+	}{
+		{name: "mysql_native_password"},
+		{name: "caching_sha2_password", opts: []ListenerOption{WithDefaultAuthMethod(CachingSha2Password), WithRSAKey(key)}},
+	} {
+		t.Run(tcase.name, func(t *testing.T) {
+			th := &changeUserHandler{}
+			authServer := newCachingSha2AuthServer()
+			if len(tcase.opts) == 0 {
+				authServer.Method = MysqlNativePassword
+			}
+			l, err := NewListenerWithOptions(append([]ListenerOption{
+				WithAddress("tcp", ":0"),
+				WithAuthServer(authServer),
+				WithHandler(th),
+			}, tcase.opts...)...)
+			if err != nil {
+				t.Fatalf("NewListener failed: %v", err)
+			}
+			defer l.Close()
+			go l.Accept()
+
+			host, port := getHostPort(t, l.Addr())
+			params := &ConnParams{
+				Host:   host,
+				Port:   port,
+				Uname:  "user1",
+				Pass:   "password1",
+				DbName: "db1",
+			}
+			conn, err := Connect(context.Background(), params)
+			if err != nil {
+				t.Fatalf("Connect failed: %v", err)
+			}
+			defer conn.Close()
+			schema := func() string {
+				t.Helper()
+				result, err := conn.ExecuteFetch("schema echo", 10, false)
+				if err != nil {
+					t.Fatalf("ExecuteFetch failed: %v", err)
+				}
+				return result.Rows[0][0].ToString()
+			}
+
+			// Prepare a statement.
+			if err := conn.writeComPrepare("select ?"); err != nil {
+				t.Fatalf("writeComPrepare failed: %v", err)
+			}
+			prepare, err := conn.readComPrepareResponse()
+			if err != nil {
+				t.Fatalf("readComPrepareResponse failed: %v", err)
+			}
+
+			// A failed authentication keeps the session.
+			err = conn.ChangeUser(&ConnParams{Uname: "user2", Pass: "bad", DbName: "db2"})
+			if err == nil || !strings.Contains(err.Error(), "Access denied for user 'user2'") {
+				t.Fatalf("ChangeUser with a bad password returned %v, want access denied", err)
+			}
+			if got := schema(); got != "db1" {
+				t.Errorf("schema after a failed ChangeUser: %q, want db1", got)
+			}
+			if changes, closed := th.state(); len(changes) != 0 || len(closed) != 0 {
+				t.Errorf("failed ChangeUser changed the session: %v, closed %v", changes, closed)
+			}
+			if got := th.LastConn().User; got != "user1" {
+				t.Errorf("user after a failed ChangeUser: %q, want user1", got)
+			}
+
+			// A successful one resets it, and closes the statements.
+			if err := conn.ChangeUser(&ConnParams{Uname: "user2", Pass: "password2", DbName: "db2"}); err != nil {
+				t.Fatalf("ChangeUser failed: %v", err)
+			}
+			if got := schema(); got != "db2" {
+				t.Errorf("schema after ChangeUser: %q, want db2", got)
+			}
+			changes, closed := th.state()
+			if want := []string{"user1 -> user2"}; !reflect.DeepEqual(changes, want) {
+				t.Errorf("user changes: %v, want %v", changes, want)
+			}
+			if want := []uint32{prepare.StatementID}; !reflect.DeepEqual(closed, want) {
+				t.Errorf("closed statements: %v, want %v", closed, want)
+			}
+			if sConn := th.LastConn(); sConn.User != "user2" || len(sConn.PrepareData) != 0 {
+				t.Errorf("server connection after ChangeUser: user %q, %v statements", sConn.User, len(sConn.PrepareData))
+			}
+			if conn.User != "user2" {
+				t.Errorf("client connection user after ChangeUser: %q", conn.User)
+			}
+
+			// And back.
+			if err := conn.ChangeUser(params); err != nil {
+				t.Fatalf("ChangeUser back failed: %v", err)
+			}
+			if got := schema(); got != "db1" {
+				t.Errorf("schema after ChangeUser back: %q, want db1", got)
+			}
+		})
+	}
+}
+
+// TestChangeUserWithoutHooks checks a Handler without the optional
+// ChangeUserHandler and StmtCloseHandler hooks can prepare statements
+// and change users.
+func TestChangeUserWithoutHooks(t *testing.T) {
+	th := &testHandler{}
+	authServer := newCachingSha2AuthServer()
+	authServer.Method = MysqlNativePassword
+	l, err := NewListenerWithOptions(
+		WithAddress("tcp", ":0"),
+		WithAuthServer(authServer),
+		WithHandler(th),
+	)
+	if err != nil {
+		t.Fatalf("NewListener failed: %v", err)
+	}
+	defer l.Close()
+	go l.Accept()
+
+	host, port := getHostPort(t, l.Addr())
+	conn, err := Connect(context.Background(), &ConnParams{
+		Host:  host,
+		Port:  port,
+		Uname: "user1",
+		Pass:  "password1",
+	})
+	if err != nil {
+		t.Fatalf("Connect failed: %v", err)
+	}
+	defer conn.Close()
+
+	// The parameters are counted by the server.
+	ps, err := conn.Prepare("select ?, ?")
+	if err != nil {
+		t.Fatalf("Prepare failed: %v", err)
+	}
+	if got := len(th.LastConn().PrepareData[ps.StatementID()].ParamsType); got != 2 {
+		t.Errorf("got %v parameters, want 2", got)
+	}
+
+	if err := conn.ChangeUser(&ConnParams{Uname: "user2", Pass: "password2", DbName: "db2"}); err != nil {
+		t.Fatalf("ChangeUser failed: %v", err)
+	}
+	if sConn := th.LastConn(); sConn.User != "user2" || len(sConn.PrepareData) != 0 {
+		t.Errorf("server connection after ChangeUser: user %q, %v statements", sConn.User, len(sConn.PrepareData))
+	}
+	if _, err := conn.ExecuteFetch("select rows", 10, false); err != nil {
+		t.Errorf("ExecuteFetch after ChangeUser failed: %v", err)
+	}
+}
+
+// TestChangeUserCharacterSet checks that a COM_CHANGE_USER without a
+// character set restores the one of the handshake.
+func TestChangeUserCharacterSet(t *testing.T) {
+	th := &changeUserHandler{}
+	authServer := newCachingSha2AuthServer()
+	authServer.Method = MysqlNativePassword
+	l, err := NewListenerWithOptions(
+		WithAddress("tcp", ":0"),
+		WithAuthServer(authServer),
+		WithHandler(th),
+	)
+	if err != nil {
+		t.Fatalf("NewListener failed: %v", err)
+	}
+	defer l.Close()
+	go l.Accept()
+
+	host, port := getHostPort(t, l.Addr())
+	params := &ConnParams{
+		Host:    host,
+		Port:    port,
+		Uname:   "user1",
+		Pass:    "password1",
+		Charset: "latin1",
+	}
+	conn, err := Connect(context.Background(), params)
+	if err != nil {
+		t.Fatalf("Connect failed: %v", err)
+	}
+	defer conn.Close()
+
+	// With a character set, the session uses it.
+	if err := conn.ChangeUser(&ConnParams{Uname: "user2", Pass: "password2", Charset: "utf8mb4"}); err != nil {
+		t.Fatalf("ChangeUser failed: %v", err)
+	}
+
+	// Without, it gets the one of the handshake back.
+	authResponse := ScrambleMysqlNativePassword(conn.salt, []byte("password1"))
+	packet := []byte{ComChangeUser}
+	packet = append(packet, "user1\x00"...)
+	packet = append(packet, byte(len(authResponse)))
+	packet = append(packet, authResponse...)
+	packet = append(packet, "\x00"...)
+	if err := writeRawPacketToConn(conn, packet); err != nil {
+		t.Fatalf("writing ComChangeUser failed: %v", err)
+	}
+	data, err := conn.ReadPacket()
+	if err != nil || len(data) == 0 || data[0] != OKPacket {
+		t.Fatalf("expected OK packet after ComChangeUser, got: %v %v", data, err)
+	}
+
+	want := []uint8{CharacterSetMap["utf8mb4"], CharacterSetMap["latin1"]}
+	if got := th.changeCharsets(); !reflect.DeepEqual(got, want) {
+		t.Errorf("character sets after ChangeUser: %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,108 @@
+/*
+Copyright 2019 The Vitess Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mysql
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dolthub/vitess/go/sqltypes"
+	querypb "github.com/dolthub/vitess/go/vt/proto/query"
+	"github.com/dolthub/vitess/go/vt/sqlparser"
+)
+
+// characterSetResultsHandler is a testHandler answering
+// "character_set_results" with the character_set_results the Conn
+// tracked, and whether it is NULL.
+type characterSetResultsHandler struct {
+	testHandler
+}
+
+func (th *characterSetResultsHandler) ComMultiQuery(ctx context.Context, c *Conn, query string, callback func(*sqltypes.Result, bool) error) (string, error) {
+	query, remainder, err := sqlparser.SplitStatement(query)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(remainder), th.ComQuery(ctx, c, strings.TrimSpace(query), callback)
+}
+
+func (th *characterSetResultsHandler) ComQuery(ctx context.Context, c *Conn, query string, callback func(*sqltypes.Result, bool) error) error {
+	if query != "character_set_results" {
+		return th.testHandler.ComQuery(ctx, c, query, callback)
+	}
+	charset, null := c.CharacterSetResults()
+	return callback(&sqltypes.Result{
+		Fields: []*querypb.Field{
+			{Name: "charset", Type: querypb.Type_VARCHAR},
+			{Name: "null", Type: querypb.Type_VARCHAR},
+		},
+		Rows: [][]sqltypes.Value{{
+			sqltypes.NewVarChar(charset),
+			sqltypes.NewVarChar(strconv.FormatBool(null)),
+		}},
+	}, false)
+}
+
+func TestCharacterSetResults(t *testing.T) {
+	conn := connectResultPending(t, &characterSetResultsHandler{}, ConnParams{})
+
+	check := func(query, wantCharset string, wantNull bool) {
+		t.Helper()
+		if query != "" {
+			_, err := conn.ExecuteFetchAll(query, 10)
+			require.NoError(t, err, query)
+		}
+		result, err := conn.ExecuteFetch("character_set_results", 10, false)
+		require.NoError(t, err)
+		assert.Equal(t, wantCharset, result.Rows[0][0].ToString(), query)
+		assert.Equal(t, strconv.FormatBool(wantNull), result.Rows[0][1].ToString(), query)
+	}
+
+	check("", "", false)
+	check("SET character_set_results = NULL", "", true)
+	check("set names latin1", "latin1", false)
+	check("set @@session.character_set_results = utf8mb4", "utf8mb4", false)
+	check("/* comment */ SET @@character_set_results = null", "", true)
+	check("set character_set_results = default", "", false)
+	check("set character set 'UTF8MB3'", "utf8mb3", false)
+	check("set character_set_results = null, autocommit = 1", "", true)
+	check("set names default", "", false)
+	check("set names utf8mb4; set character_set_results = null", "", true)
+
+	// The other variables, and the other scopes, don't change it.
+	check("set character_set_client = latin1", "", true)
+	check("set @character_set_results = 'latin1'", "", true)
+	check("set global character_set_results = 'latin1'", "", true)
+	check("set character_set_results = @charset", "", true)
+
+	// Nor does a failed statement.
+	_, err := conn.ExecuteFetch("error", 10, false)
+	require.Error(t, err)
+	check("", "", true)
+
+	// The session state is reset with the connection.
+	c := &Conn{characterSetResultsNull: true}
+	c.resetSessionState()
+	charset, null := c.CharacterSetResults()
+	assert.Equal(t, "", charset)
+	assert.False(t, null)
+}
@@ -23,6 +23,7 @@ import (
 	"crypto/x509"
 	"encoding/pem"
 	"fmt"
+	"io/ioutil"
 	"net"
 	"strconv"
 	"strings"
@@ -102,6 +103,12 @@ func Connect(ctx context.Context, params *ConnParams) (*Conn, error) {
 
 		// Send the connection back, so the other side can close it.
 		c := newConn(conn)
+		if params.ResultLimits != nil {
+			c.resultLimits = *params.ResultLimits
+		}
+		c.panicOnResultPending = params.PanicOnResultPending
+		c.localInfileAllowList = params.LocalInfileAllowList
+		c.openLocalInfile = params.OpenLocalInfile
 		status <- connectResult{
 			c: c,
 		}
@@ -110,8 +117,12 @@ func Connect(ctx context.Context, params *ConnParams) (*Conn, error) {
 		// canceled, the connection will be closed. That will
 		// make any read or write just return with an error
 		// right away.
+		err = c.clientHandshake(params)
+		if err == nil && params.SemiSyncReplica {
+			err = c.EnableSemiSyncReplica()
+		}
 		status <- connectResult{
-			err: c.clientHandshake(params),
+			err: err,
 		}
 	}()
 
@@ -167,12 +178,82 @@ func Connect(ctx context.Context, params *ConnParams) (*Conn, error) {
 
 // Ping implements mysql ping command.
 func (c *Conn) Ping() error {
+	if err := c.startCommand(); err != nil {
+		return err
+	}
+	defer c.endCommand()
+	return c.writeSimpleCommand(ComPing)
+}
+
+// Reset prepares the connection to be used again, typically when it is
+// put back in a pool. A streaming query still in progress is drained,
+// and the sequence numbers are reset. If draining fails, the error is
+// returned and the connection should not be reused.
+// Returns a SQLError.
+func (c *Conn) Reset() error {
+	if c.IsClosed() {
+		return NewSQLError(CRServerGone, SSUnknownSQLState, "connection is closed")
+	}
+	if err := c.startResultRead(); err != nil {
+		return err
+	}
+	defer c.endCommand()
+	for c.fields != nil {
+		row, err := c.fetchNext()
+		if err != nil {
+			c.fields = nil
+			return err
+		}
+		if row == nil {
+			break
+		}
+	}
+	c.resetSequence()
+	return nil
+}
+
+// ResetSession is like Reset, and then calls ResetConnection, so the
+// server also resets the session state.
+// Returns a SQLError.
+func (c *Conn) ResetSession() error {
+	if err := c.Reset(); err != nil {
+		return err
+	}
+	return c.ResetConnection()
+}
+
+// ResetConnection sends COM_RESET_CONNECTION, and reads the OK packet
+// answering it. The server resets the session state without
+// authenticating again: the current database, the session variables,
+// the transaction, the prepared statements... The PrepareData of the
+// statements prepared by this connection are not valid anymore.
+// Returns a SQLError, or ErrResultPending.
+func (c *Conn) ResetConnection() error {
+	if err := c.startCommand(); err != nil {
+		return err
+	}
+	defer c.endCommand()
+	if err := c.writeComResetConnection(); err != nil {
+		return err
+	}
+	return c.readSimpleCommandResponse()
+}
+
+// writeSimpleCommand sends command, which has no argument, and reads
+// the OK packet answering it.
+func (c *Conn) writeSimpleCommand(command byte) error {
 	// This is a new command, need to reset the sequence.
-	c.sequence = 0
+	c.resetSequence()
 
-	if err := c.writePacket([]byte{ComPing}); err != nil {
+	if err := c.writePacket([]byte{command}); err != nil {
 		return NewSQLError(CRServerGone, SSUnknownSQLState, "%v", err)
 	}
+	return c.readSimpleCommandResponse()
+}
+
+// readSimpleCommandResponse reads the OK packet answering a command
+// without result set.
+func (c *Conn) readSimpleCommandResponse() error {
 	data, err := c.readEphemeralPacket()
 	if err != nil {
 		return NewSQLError(CRServerLost, SSUnknownSQLState, "%v", err)
@@ -225,6 +306,7 @@ func (c *Conn) clientHandshake(params *ConnParams) error {
 	}
 	c.fillFlavor(params)
 	c.salt = salt
+	c.serverCapabilities = capabilities
 
 	// Sanity check.
 	if capabilities&CapabilityClientProtocol41 == 0 {
@@ -237,6 +319,31 @@ func (c *Conn) clientHandshake(params *ConnParams) error {
 	if !params.DisableClientDeprecateEOF {
 		c.Capabilities = capabilities & (CapabilityClientDeprecateEOF)
 	}
+	// We always ask for multi statements, see writeHandshakeResponse41.
+	c.Capabilities |= capabilities & CapabilityClientMultiStatements
+	// Local files are only sent if some are allowed.
+	if len(params.LocalInfileAllowList) > 0 {
+		c.Capabilities |= capabilities & CapabilityClientLocalFiles
+	}
+	// Compression is only used if asked for, and the server supports it.
+	c.Capabilities |= capabilities & (CapabilityClientCompress | CapabilityClientZstdCompressionAlgorithm) & uint32(params.Flags)
+	if c.Capabilities&CapabilityClientZstdCompressionAlgorithm != 0 {
+		c.zstdCompressionLevel = params.ZstdCompressionLevel
+		if c.zstdCompressionLevel < 1 || c.zstdCompressionLevel > maxZstdCompressionLevel {
+			c.zstdCompressionLevel = DefaultZstdCompressionLevel
+		}
+	}
+
+	// Client Session Tracking Capability.
+	if capabilities&CapabilityClientSessionTrack == CapabilityClientSessionTrack {
+		// If the server also supports it, we will have enabled
+		// it so we also add it to our capabilities.
+		c.Capabilities |= CapabilityClientSessionTrack
+	} else if params.Flags&CapabilityClientSessionTrack == CapabilityClientSessionTrack {
+		// If client asked for ClientSessionTrack, but server doesn't support it,
+		// stop right here.
+		return NewSQLError(CRSSLConnectionError, SSUnknownSQLState, "server doesn't support ClientSessionTrack but client asked for it")
+	}
 
 	// Figure out the character set we want.
 	charset, err := parseCharacterSet(params.Charset)
@@ -305,17 +412,6 @@ func (c *Conn) clientHandshake(params *ConnParams) error {
 		scrambledPassword = ScrambleMysqlNativePassword(salt, []byte(params.Pass))
 	}
 
-	// Client Session Tracking Capability.
-	if capabilities&CapabilityClientSessionTrack == CapabilityClientSessionTrack {
-		// If the server also supports it, we will have enabled
-		// it so we also add it to our capabilities.
-		c.Capabilities |= CapabilityClientSessionTrack
-	} else if params.Flags&CapabilityClientSessionTrack == CapabilityClientSessionTrack {
-		// If client asked for ClientSessionTrack, but server doesn't support it,
-		// stop right here.
-		return NewSQLError(CRSSLConnectionError, SSUnknownSQLState, "server doesn't support ClientSessionTrack but client asked for it")
-	}
-
 	// Build and send our handshake response 41.
 	// Note this one will never have SSL flag on.
 	if err := c.writeHandshakeResponse41(capabilities, scrambledPassword, charset, params); err != nil {
@@ -327,6 +423,11 @@ func (c *Conn) clientHandshake(params *ConnParams) error {
 		return err
 	}
 
+	// The packets after the handshake are compressed, if negotiated.
+	if c.Capabilities&(CapabilityClientCompress|CapabilityClientZstdCompressionAlgorithm) != 0 {
+		c.enableCompression()
+	}
+
 	// If the server didn't support DbName in its handshake, set
 	// it now. This is what the 'mysql' client does.
 	if capabilities&CapabilityClientConnectWithDB == 0 && params.DbName != "" {
@@ -374,6 +475,9 @@ func (c *Conn) handleAuthResponse(params *ConnParams) error {
 		}
 	case AuthMoreDataPacket:
 		// Server is requesting more data - maybe un-scrambled password
+		if len(response) < 2 {
+			return NewSQLError(CRServerHandshakeErr, SSUnknownSQLState, "AuthMoreDataPacket is empty")
+		}
 		if err := c.handleAuthMoreDataPacket(response[1], params); err != nil {
 			return err
 		}
@@ -436,9 +540,16 @@ func (c *Conn) handleAuthMoreDataPacket(data byte, params *ConnParams) error {
 				return err
 			}
 		} else {
-			// If we are not using an SSL connection or Unix socket, we have to fetch a public key
-			// from the server to encrypt password
-			pub, err := c.requestPublicKey()
+			// If we are not using an SSL connection or Unix socket, we have to encrypt the
+			// password with the public key of the server: the pinned one, or else fetched
+			// from the server.
+			var pub *rsa.PublicKey
+			var err error
+			if params.ServerPublicKey != "" {
+				pub, err = readPublicKeyFile(params.ServerPublicKey)
+			} else {
+				pub, err = c.requestPublicKey()
+			}
 			if err != nil {
 				return err
 			}
@@ -459,6 +570,21 @@ func (c *Conn) handleAuthMoreDataPacket(data byte, params *ConnParams) error {
 	}
 }
 
+// ServerCapabilities returns all the capability flags the server
+// advertised in its initial handshake, not only the ones negotiated
+// in Capabilities. It is only set on client connections.
+func (c *Conn) ServerCapabilities() uint32 {
+	return c.serverCapabilities
+}
+
+// ServerCharset returns the default character set the server
+// advertised in its initial handshake. See the values in
+// constants.go. It is only set on client connections. The version the
+// server advertised is in ServerVersion.
+func (c *Conn) ServerCharset() uint8 {
+	return c.serverCharset
+}
+
 // parseInitialHandshakePacket parses the initial handshake from the server.
 // It returns a SQLError with the right code.
 func (c *Conn) parseInitialHandshakePacket(data []byte) (uint32, []byte, error) {
@@ -525,6 +651,7 @@ func (c *Conn) parseInitialHandshakePacket(data []byte) (uint32, []byte, error)
 		return 0, nil, NewSQLError(CRMalformedPacket, SSUnknownSQLState, "parseInitialHandshakePacket: packet has no character set")
 	}
 	c.CharacterSet = characterSet
+	c.serverCharset = characterSet
 
 	// Status flags. Ignored.
 	_, pos, ok = readUint16(data, pos)
@@ -609,6 +736,12 @@ func (c *Conn) writeSSLRequest(capabilities uint32, characterSet uint8, params *
 		// If the server supported
 		// CapabilityClientDeprecateEOF, we also support it.
 		c.Capabilities&CapabilityClientDeprecateEOF |
+		// Likewise for session state tracking.
+		c.Capabilities&CapabilityClientSessionTrack |
+		// Ask for compression if it was negotiated.
+		c.Capabilities&(CapabilityClientCompress|CapabilityClientZstdCompressionAlgorithm) |
+		// Ask for local files if some are allowed.
+		c.Capabilities&CapabilityClientLocalFiles |
 		// Pass-through ClientFoundRows flag.
 		CapabilityClientFoundRows&uint32(params.Flags)
 
@@ -658,6 +791,12 @@ func (c *Conn) writeHandshakeResponse41(capabilities uint32, scrambledPassword [
 		// If the server supported
 		// CapabilityClientDeprecateEOF, we also support it.
 		c.Capabilities&CapabilityClientDeprecateEOF |
+		// Likewise for session state tracking.
+		c.Capabilities&CapabilityClientSessionTrack |
+		// Ask for compression if it was negotiated.
+		c.Capabilities&(CapabilityClientCompress|CapabilityClientZstdCompressionAlgorithm) |
+		// Ask for local files if some are allowed.
+		c.Capabilities&CapabilityClientLocalFiles |
 		// Pass-through ClientFoundRows flag.
 		CapabilityClientFoundRows&uint32(params.Flags)
 
@@ -674,6 +813,11 @@ func (c *Conn) writeHandshakeResponse41(capabilities uint32, scrambledPassword [
 			21 + // "mysql_native_password" string.
 			1 // terminating zero.
 
+	// Add the zstd compression level if it was negotiated.
+	if c.Capabilities&CapabilityClientZstdCompressionAlgorithm != 0 {
+		length++
+	}
+
 	// Add the DB name if the server supports it.
 	if params.DbName != "" && (capabilities&CapabilityClientConnectWithDB != 0) {
 		flags |= CapabilityClientConnectWithDB
@@ -686,6 +830,9 @@ func (c *Conn) writeHandshakeResponse41(capabilities uint32, scrambledPassword [
 		length++
 	}
 
+	// SSL was asked for in the SSL request, if it is in use.
+	c.negotiatedCapabilities = flags&capabilities | c.Capabilities&CapabilityClientSSL
+
 	data := c.startEphemeralPacket(length)
 	pos := 0
 
@@ -723,6 +870,11 @@ func (c *Conn) writeHandshakeResponse41(capabilities uint32, scrambledPassword [
 	// Auth plugin name
 	pos = writeNullString(data, pos, c.authPluginName)
 
+	// zstd compression level.
+	if c.Capabilities&CapabilityClientZstdCompressionAlgorithm != 0 {
+		pos = writeByte(data, pos, byte(c.zstdCompressionLevel))
+	}
+
 	// Sanity-check the length.
 	if pos != len(data) {
 		return NewSQLError(CRMalformedPacket, SSUnknownSQLState, "writeHandshakeResponse41: only packed %v bytes, out of %v allocated", pos, len(data))
@@ -769,17 +921,42 @@ func (c *Conn) requestPublicKey() (rsaKey *rsa.PublicKey, err error) {
 		return nil, ParseErrorPacket(response)
 	}
 
-	block, _ := pem.Decode(response[1:])
-	if block == nil {
-		return nil, vterrors.Errorf(vtrpcpb.Code_INTERNAL, "failed to decode response from server: %v", err)
+	pub, err := parsePublicKey(response[1:])
+	if err != nil {
+		return nil, vterrors.Wrapf(err, "invalid public key from server")
 	}
+	return pub, nil
+}
 
-	pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+// readPublicKeyFile reads the RSA public key in the PEM file at path,
+// see ConnParams.ServerPublicKey.
+func readPublicKeyFile(path string) (*rsa.PublicKey, error) {
+	data, err := ioutil.ReadFile(path)
 	if err != nil {
-		return nil, vterrors.Errorf(vtrpcpb.Code_INTERNAL, "failed to parse public key from server: %v", err)
+		return nil, NewSQLError(CRServerHandshakeErr, SSUnknownSQLState, "cannot read server public key: %v", err)
 	}
+	pub, err := parsePublicKey(data)
+	if err != nil {
+		return nil, NewSQLError(CRServerHandshakeErr, SSUnknownSQLState, "invalid server public key in %v: %v", path, err)
+	}
+	return pub, nil
+}
 
-	return pub.(*rsa.PublicKey), nil
+// parsePublicKey parses a PEM encoded RSA public key.
+func parsePublicKey(data []byte) (*rsa.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, vterrors.Errorf(vtrpcpb.Code_INTERNAL, "no PEM data found")
+	}
+	pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, vterrors.Errorf(vtrpcpb.Code_INTERNAL, "failed to parse public key: %v", err)
+	}
+	rsaKey, ok := pub.(*rsa.PublicKey)
+	if !ok {
+		return nil, vterrors.Errorf(vtrpcpb.Code_INTERNAL, "not an RSA public key: %T", pub)
+	}
+	return rsaKey, nil
 }
 
 // writeClearTextPassword writes the clear text password.
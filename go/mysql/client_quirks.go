@@ -0,0 +1,152 @@
+/*
+Copyright 2019 The Vitess Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mysql
+
+import (
+	"strings"
+
+	"github.com/dolthub/vitess/go/vt/log"
+)
+
+// This file contains the support for client quirks: deviations from
+// the protocol the server makes for some clients, to work around their
+// bugs, without changing what the other clients get.
+//
+// The clients are recognized by the connection attributes they send in
+// their handshake, see ClientQuirkRule. The quirks of a connection are
+// decided once, after the handshake, and are logged then.
+
+// ClientQuirks is a set of deviations from the protocol for a client.
+type ClientQuirks uint32
+
+const (
+	// ClientQuirkClassicEOF ends result sets with a classic EOF
+	// packet, even if the client negotiated
+	// CapabilityClientDeprecateEOF, for clients that mishandle the OK
+	// packet with an EOF header. The column definitions are still
+	// sent without the EOF packet that follows them.
+	ClientQuirkClassicEOF ClientQuirks = 1 << iota
+
+	// ClientQuirkPadEmptyInfo adds an empty info string to the OK
+	// packets that have none, for clients that always read it.
+	ClientQuirkPadEmptyInfo
+
+	// ClientQuirkNoSessionTrack does not use session state tracking,
+	// even if the client negotiated CapabilityClientSessionTrack.
+	ClientQuirkNoSessionTrack
+)
+
+// clientQuirkNames are the names of the quirks, for logs.
+var clientQuirkNames = []struct {
+	quirk ClientQuirks
+	name  string
+}{
+	{ClientQuirkClassicEOF, "classic_eof"},
+	{ClientQuirkPadEmptyInfo, "pad_empty_info"},
+	{ClientQuirkNoSessionTrack, "no_session_track"},
+}
+
+// String returns the names of the quirks, separated by commas.
+func (q ClientQuirks) String() string {
+	var names []string
+	for _, n := range clientQuirkNames {
+		if q&n.quirk != 0 {
+			names = append(names, n.name)
+		}
+	}
+	if len(names) == 0 {
+		return "none"
+	}
+	return strings.Join(names, ",")
+}
+
+const (
+	// ConnAttrClientName is the connection attribute with the name
+	// of the client library.
+	ConnAttrClientName = "_client_name"
+
+	// ConnAttrClientVersion is the connection attribute with the
+	// version of the client library.
+	ConnAttrClientVersion = "_client_version"
+)
+
+// ClientQuirkRule gives quirks to the clients matching it. A rule
+// without conditions matches all the clients.
+type ClientQuirkRule struct {
+	// Name identifies the rule in logs.
+	Name string
+
+	// ClientName must be the ConnAttrClientName connection attribute
+	// of the client.
+	ClientName string
+
+	// ClientVersionPrefix, if set, must be a prefix of the
+	// ConnAttrClientVersion connection attribute of the client.
+	ClientVersionPrefix string
+
+	// Attributes, if set, must all be connection attributes of the
+	// client, with the same values. It can recognize clients by
+	// other attributes, like program_name.
+	Attributes map[string]string
+
+	// Quirks are the quirks of the matching clients.
+	Quirks ClientQuirks
+}
+
+// matches returns true if the client with the connection attributes
+// matches the rule.
+func (r *ClientQuirkRule) matches(attrs map[string]string) bool {
+	if r.ClientName != "" && attrs[ConnAttrClientName] != r.ClientName {
+		return false
+	}
+	if r.ClientVersionPrefix != "" {
+		version, ok := attrs[ConnAttrClientVersion]
+		if !ok || !strings.HasPrefix(version, r.ClientVersionPrefix) {
+			return false
+		}
+	}
+	for k, v := range r.Attributes {
+		if value, ok := attrs[k]; !ok || value != v {
+			return false
+		}
+	}
+	return true
+}
+
+// applyClientQuirks sets the quirks of all the rules the client
+// matches, and logs them. It is called once the connection attributes
+// and the capabilities are known.
+func (c *Conn) applyClientQuirks(rules []ClientQuirkRule) {
+	var matched []string
+	for i := range rules {
+		if rules[i].matches(c.Attributes) {
+			c.quirks |= rules[i].Quirks
+			matched = append(matched, rules[i].Name)
+		}
+	}
+	if c.quirks&ClientQuirkNoSessionTrack != 0 {
+		c.Capabilities &^= CapabilityClientSessionTrack
+	}
+	if len(matched) > 0 {
+		log.Infof("Using client quirks %v for connection %v, client %q version %q matched rules %v", c.quirks, c.ConnectionID, c.Attributes[ConnAttrClientName], c.Attributes[ConnAttrClientVersion], strings.Join(matched, ","))
+	}
+}
+
+// ClientQuirks returns the quirks of the connection.
+func (c *Conn) ClientQuirks() ClientQuirks {
+	return c.quirks
+}
@@ -0,0 +1,189 @@
+/*
+Copyright 2019 The Vitess Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mysql
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/dolthub/vitess/go/sqltypes"
+
+	querypb "github.com/dolthub/vitess/go/vt/proto/query"
+)
+
+var testClientQuirkRules = []ClientQuirkRule{{
+	Name:                "old-driver",
+	ClientName:          "old-driver",
+	ClientVersionPrefix: "1.",
+	Quirks:              ClientQuirkClassicEOF | ClientQuirkPadEmptyInfo,
+}, {
+	Name:       "reporting-tool",
+	Attributes: map[string]string{"program_name": "reports"},
+	Quirks:     ClientQuirkNoSessionTrack,
+}}
+
+func TestClientQuirkRules(t *testing.T) {
+	for _, tcase := range []struct {
+		attrs map[string]string
+		want  ClientQuirks
+	}{{
+		attrs: nil,
+		want:  0,
+	}, {
+		attrs: map[string]string{ConnAttrClientName: "new-driver", ConnAttrClientVersion: "1.2.0"},
+		want:  0,
+	}, {
+		attrs: map[string]string{ConnAttrClientName: "old-driver", ConnAttrClientVersion: "1.2.0"},
+		want:  ClientQuirkClassicEOF | ClientQuirkPadEmptyInfo,
+	}, {
+		attrs: map[string]string{ConnAttrClientName: "old-driver", ConnAttrClientVersion: "2.0.0"},
+		want:  0,
+	}, {
+		attrs: map[string]string{ConnAttrClientName: "old-driver"},
+		want:  0,
+	}, {
+		attrs: map[string]string{ConnAttrClientName: "old-driver", ConnAttrClientVersion: "1.0", "program_name": "reports"},
+		want:  ClientQuirkClassicEOF | ClientQuirkPadEmptyInfo | ClientQuirkNoSessionTrack,
+	}} {
+		c := &Conn{
+			Attributes:   tcase.attrs,
+			Capabilities: CapabilityClientDeprecateEOF | CapabilityClientSessionTrack,
+		}
+		c.applyClientQuirks(testClientQuirkRules)
+		if got := c.ClientQuirks(); got != tcase.want {
+			t.Errorf("quirks for %v: got %v, want %v", tcase.attrs, got, tcase.want)
+		}
+		wantSessionTrack := tcase.want&ClientQuirkNoSessionTrack == 0
+		if got := c.Capabilities&CapabilityClientSessionTrack != 0; got != wantSessionTrack {
+			t.Errorf("session tracking for %v: got %v, want %v", tcase.attrs, got, wantSessionTrack)
+		}
+	}
+
+	if got, want := (ClientQuirkClassicEOF | ClientQuirkNoSessionTrack).String(), "classic_eof,no_session_track"; got != want {
+		t.Errorf("got quirk names %q, want %q", got, want)
+	}
+}
+
+// TestClientQuirksTerminator sends the same empty result set to two
+// clients that both negotiated CapabilityClientDeprecateEOF, one of them
+// with quirks, and checks how the result set ends for each.
+func TestClientQuirksTerminator(t *testing.T) {
+	result := &sqltypes.Result{
+		Fields: []*querypb.Field{
+			{Name: "id", Type: querypb.Type_INT32, Charset: CharacterSetBinary},
+		},
+	}
+
+	for _, tcase := range []struct {
+		name  string
+		attrs map[string]string
+		// want is the packet ending the result set.
+		want []byte
+	}{{
+		name:  "regular client",
+		attrs: map[string]string{ConnAttrClientName: "new-driver", ConnAttrClientVersion: "3.1.4"},
+		want:  []byte{EOFPacket, 0, 0, 0x02, 0x00, 0, 0},
+	}, {
+		name:  "quirky client",
+		attrs: map[string]string{ConnAttrClientName: "old-driver", ConnAttrClientVersion: "1.0.7"},
+		want:  []byte{EOFPacket, 0, 0, 0x02, 0x00},
+	}} {
+		t.Run(tcase.name, func(t *testing.T) {
+			listener, sConn, cConn := createSocketPair(t)
+			defer func() {
+				listener.Close()
+				sConn.Close()
+				cConn.Close()
+			}()
+			sConn.Attributes = tcase.attrs
+			sConn.Capabilities = CapabilityClientDeprecateEOF
+			sConn.StatusFlags = ServerStatusAutocommit
+			sConn.applyClientQuirks(testClientQuirkRules)
+
+			var serverErr error
+			wg := sync.WaitGroup{}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if _, serverErr = sConn.ReadPacket(); serverErr == nil {
+					serverErr = writeResult(sConn, result)
+				}
+			}()
+
+			if err := cConn.WriteComQuery("select id from t where false"); err != nil {
+				t.Fatalf("WriteComQuery failed: %v", err)
+			}
+			// Column count, column definition, then the end of the
+			// result set: there is no EOF packet after the column
+			// definitions for either client.
+			var packets [][]byte
+			for i := 0; i < 3; i++ {
+				data, err := cConn.ReadPacket()
+				if err != nil {
+					t.Fatalf("ReadPacket failed: %v", err)
+				}
+				packets = append(packets, data)
+			}
+			wg.Wait()
+			if serverErr != nil {
+				t.Fatalf("server failed: %v", serverErr)
+			}
+
+			if got := string(packets[2]); got != string(tcase.want) {
+				t.Errorf("got end of result set %v, want %v", packets[2], tcase.want)
+			}
+		})
+	}
+}
+
+func TestClientQuirksPadEmptyInfo(t *testing.T) {
+	listener, sConn, cConn := createSocketPair(t)
+	defer func() {
+		listener.Close()
+		sConn.Close()
+		cConn.Close()
+	}()
+
+	for _, quirks := range []ClientQuirks{0, ClientQuirkPadEmptyInfo} {
+		sConn.quirks = quirks
+		sConn.sequence = 0
+		cConn.sequence = 0
+		var serverErr error
+		wg := sync.WaitGroup{}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			serverErr = sConn.writeOKPacket(1, 2, ServerStatusAutocommit, 0)
+		}()
+		data, err := cConn.ReadPacket()
+		if err != nil {
+			t.Fatalf("ReadPacket failed: %v", err)
+		}
+		wg.Wait()
+		if serverErr != nil {
+			t.Fatalf("writeOKPacket failed: %v", serverErr)
+		}
+
+		want := []byte{OKPacket, 1, 2, 0x02, 0x00, 0, 0}
+		if quirks != 0 {
+			want = append(want, 0)
+		}
+		if string(data) != string(want) {
+			t.Errorf("got OK packet %v with quirks %v, want %v", data, quirks, want)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+/*
+Copyright 2019 The Vitess Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mysql
+
+import (
+	"errors"
+	"sync/atomic"
+)
+
+// This file contains the checks preventing the misuse of a client
+// connection: the protocol has one command in flight at a time, and
+// its result has to be read entirely before the next command is sent.
+// Otherwise the packets of both get mixed up, and both results are
+// corrupted.
+//
+// Two states are tracked:
+// - a streaming query is in progress (fields is set), from
+// ExecuteStreamFetch until its last row is read or CloseResult.
+// - a method is using the connection (busy is set). It is taken with
+// an atomic compare-and-swap, so concurrent uses from several
+// goroutines are detected.
+
+// ErrResultPending is returned by the client methods called while a
+// streaming query is still in progress on the connection, or while
+// another goroutine is using it. Nothing is sent to the server, the
+// connection can still be used once the result is read or closed.
+var ErrResultPending = errors.New("commands out of sync: a result is still being read on this connection")
+
+// startCommand marks the connection busy for a new command. It fails
+// with ErrResultPending if a streaming query is in progress, or if the
+// connection is already busy. endCommand must be called once it
+// succeeded.
+func (c *Conn) startCommand() error {
+	if err := c.startResultRead(); err != nil {
+		return err
+	}
+	if c.fields != nil {
+		c.endCommand()
+		return c.resultPending()
+	}
+	return nil
+}
+
+// startResultRead marks the connection busy to read the streaming
+// query in progress. It fails with ErrResultPending if the connection
+// is already busy. endCommand must be called once it succeeded.
+func (c *Conn) startResultRead() error {
+	if !atomic.CompareAndSwapInt32(&c.busy, 0, 1) {
+		return c.resultPending()
+	}
+	return nil
+}
+
+// endCommand marks the connection as not busy anymore.
+func (c *Conn) endCommand() {
+	atomic.StoreInt32(&c.busy, 0)
+}
+
+// resultPending returns ErrResultPending, or panics with it if
+// ConnParams.PanicOnResultPending is set.
+func (c *Conn) resultPending() error {
+	if c.panicOnResultPending {
+		panic(ErrResultPending)
+	}
+	return ErrResultPending
+}
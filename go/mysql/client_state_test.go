@@ -0,0 +1,163 @@
+/*
+Copyright 2019 The Vitess Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mysql
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dolthub/vitess/go/sqltypes"
+)
+
+// blockingHandler is a testHandler blocking the "block" queries until
+// release is closed.
+type blockingHandler struct {
+	testHandler
+
+	started chan struct{}
+	release chan struct{}
+}
+
+func (th *blockingHandler) ComMultiQuery(ctx context.Context, c *Conn, query string, callback func(res *sqltypes.Result, more bool) error) (string, error) {
+	return "", th.ComQuery(ctx, c, strings.TrimSpace(query), callback)
+}
+
+func (th *blockingHandler) ComQuery(ctx context.Context, c *Conn, query string, callback func(res *sqltypes.Result, more bool) error) error {
+	if query == "block" {
+		close(th.started)
+		<-th.release
+		return callback(selectRowsResult, false)
+	}
+	return th.testHandler.ComQuery(ctx, c, query, callback)
+}
+
+// connectResultPending starts a Listener with handler and opts, and
+// connects to it with params.
+func connectResultPending(t *testing.T, handler Handler, params ConnParams, opts ...ListenerOption) *Conn {
+	authServer := NewAuthServerStatic("", "", 0)
+	authServer.entries["user1"] = []*AuthServerStaticEntry{{
+		Password: "password1",
+	}}
+	l, err := NewListenerWithOptions(append([]ListenerOption{
+		WithAddress("tcp", ":0"),
+		WithAuthServer(authServer),
+		WithHandler(handler),
+	}, opts...)...)
+	require.NoError(t, err)
+	t.Cleanup(l.Close)
+	go l.Accept()
+
+	params.Host, params.Port = getHostPort(t, l.Addr())
+	params.Uname = "user1"
+	params.Pass = "password1"
+	conn, err := Connect(context.Background(), &params)
+	require.NoError(t, err)
+	t.Cleanup(conn.Close)
+	return conn
+}
+
+func TestResultPending(t *testing.T) {
+	conn := connectResultPending(t, &testHandler{}, ConnParams{})
+
+	require.NoError(t, conn.ExecuteStreamFetch("select rows"))
+	row, err := conn.FetchNext()
+	require.NoError(t, err)
+	require.NotNil(t, row)
+
+	// Nothing can be sent while the rows are read.
+	_, err = conn.ExecuteFetch("select rows", 10, true)
+	assert.True(t, errors.Is(err, ErrResultPending), "ExecuteFetch returned %v", err)
+	assert.True(t, errors.Is(conn.ExecuteStreamFetch("select rows"), ErrResultPending))
+	assert.True(t, errors.Is(conn.WriteComQuery("select rows"), ErrResultPending))
+	assert.True(t, errors.Is(conn.Ping(), ErrResultPending))
+
+	// The stream is intact.
+	row, err = conn.FetchNext()
+	require.NoError(t, err)
+	assert.Equal(t, "nicer name", row[1].ToString())
+	row, err = conn.FetchNext()
+	require.NoError(t, err)
+	assert.Nil(t, row)
+
+	// CloseResult can be called any number of times.
+	conn.CloseResult()
+	conn.CloseResult()
+
+	result, err := conn.ExecuteFetch("select rows", 10, true)
+	require.NoError(t, err)
+	assert.Equal(t, 2, len(result.Rows))
+
+	// A stream closed early.
+	require.NoError(t, conn.ExecuteStreamFetch("select rows"))
+	conn.CloseResult()
+	conn.CloseResult()
+	require.NoError(t, conn.Ping())
+}
+
+func TestResultPendingPanics(t *testing.T) {
+	conn := connectResultPending(t, &testHandler{}, ConnParams{PanicOnResultPending: true})
+
+	require.NoError(t, conn.ExecuteStreamFetch("select rows"))
+	assert.PanicsWithValue(t, ErrResultPending, func() {
+		conn.ExecuteFetch("select rows", 10, true)
+	})
+	conn.CloseResult()
+	require.NoError(t, conn.Ping())
+}
+
+func TestResultPendingConcurrent(t *testing.T) {
+	th := &blockingHandler{
+		started: make(chan struct{}),
+		release: make(chan struct{}),
+	}
+	conn := connectResultPending(t, th, ConnParams{})
+
+	// The first goroutine waits for its result, the second one can't
+	// send its query meanwhile.
+	var result *sqltypes.Result
+	var firstErr error
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		result, firstErr = conn.ExecuteFetch("block", 10, true)
+	}()
+	<-th.started
+
+	secondDone := make(chan error)
+	go func() {
+		_, err := conn.ExecuteFetch("select rows", 10, true)
+		secondDone <- err
+	}()
+	secondErr := <-secondDone
+	assert.True(t, errors.Is(secondErr, ErrResultPending), "second ExecuteFetch returned %v", secondErr)
+	close(th.release)
+	wg.Wait()
+	require.NoError(t, firstErr)
+	assert.Equal(t, 2, len(result.Rows))
+
+	// The connection is intact.
+	result, err := conn.ExecuteFetch("select rows", 10, true)
+	require.NoError(t, err)
+	assert.Equal(t, 2, len(result.Rows))
+}
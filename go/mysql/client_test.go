@@ -145,6 +145,119 @@ func TestConnectTimeout(t *testing.T) {
 	}
 }
 
+// TestServerHandshakeInfo checks the client remembers what the server
+// advertised in its initial handshake.
+func TestServerHandshakeInfo(t *testing.T) {
+	listener, sConn, cConn := createSocketPair(t)
+	defer func() {
+		listener.Close()
+		sConn.Close()
+		cConn.Close()
+	}()
+
+	const serverVersion = "8.0.33-test"
+	var serverErr error
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if _, serverErr = sConn.writeHandshakeV10(serverVersion, NewAuthServerStatic("", "", 0), false); serverErr != nil {
+			return
+		}
+		if _, serverErr = sConn.readEphemeralPacketDirect(); serverErr != nil {
+			return
+		}
+		sConn.recycleReadPacket()
+		serverErr = sConn.writeOKPacket(0, 0, 0, 0)
+	}()
+
+	err := cConn.clientHandshake(&ConnParams{Uname: "user1"})
+	wg.Wait()
+	require.NoError(t, err)
+	require.NoError(t, serverErr)
+
+	assert.Equal(t, serverVersion, cConn.ServerVersion)
+	assert.Equal(t, uint8(CharacterSetUtf8), cConn.ServerCharset())
+	capabilities := cConn.ServerCapabilities()
+	for _, capability := range []uint32{
+		CapabilityClientProtocol41,
+		CapabilityClientPluginAuth,
+		CapabilityClientDeprecateEOF,
+	} {
+		assert.NotZero(t, capabilities&capability, "capability %x not advertised", capability)
+	}
+	assert.Zero(t, capabilities&CapabilityClientSSL, "SSL advertised without TLS")
+	assert.Zero(t, capabilities&CapabilityClientSessionTrack, "session tracking advertised without AllowSessionTracking")
+
+	// The connection uses what both sides support.
+	negotiated := cConn.NegotiatedCapabilities()
+	assert.Zero(t, negotiated&^capabilities, "capabilities negotiated without being advertised")
+	for _, capability := range []uint32{
+		CapabilityClientProtocol41,
+		CapabilityClientPluginAuth,
+		CapabilityClientMultiStatements,
+		CapabilityClientDeprecateEOF,
+	} {
+		assert.NotZero(t, negotiated&capability, "capability %x not negotiated", capability)
+	}
+	assert.Zero(t, negotiated&CapabilityClientSSL, "SSL negotiated without TLS")
+	assert.True(t, cConn.HasCapability(CapabilityClientDeprecateEOF|CapabilityClientMultiStatements))
+	assert.False(t, cConn.HasCapability(CapabilityClientDeprecateEOF|CapabilityClientSSL))
+	assert.Zero(t, sConn.NegotiatedCapabilities())
+}
+
+func TestClientReset(t *testing.T) {
+	th := &testHandler{}
+	authServer := NewAuthServerStatic("", "", 0)
+	authServer.entries["user1"] = []*AuthServerStaticEntry{{
+		Password: "password1",
+	}}
+	l, err := NewListenerWithOptions(
+		WithAddress("tcp", ":0"),
+		WithAuthServer(authServer),
+		WithHandler(th),
+	)
+	require.NoError(t, err)
+	defer l.Close()
+	go l.Accept()
+
+	host, port := getHostPort(t, l.Addr())
+	conn, err := Connect(context.Background(), &ConnParams{
+		Host:   host,
+		Port:   port,
+		Uname:  "user1",
+		Pass:   "password1",
+		DbName: "my_db",
+	})
+	require.NoError(t, err)
+	defer conn.Close()
+
+	schema := func() string {
+		result, err := conn.ExecuteFetch("schema echo", 10, false)
+		require.NoError(t, err)
+		return result.Rows[0][0].ToString()
+	}
+
+	// A streaming query is left half read, Reset drains it.
+	require.NoError(t, conn.ExecuteStreamFetch("select rows"))
+	row, err := conn.FetchNext()
+	require.NoError(t, err)
+	require.NotNil(t, row)
+	require.NoError(t, conn.Reset())
+	_, err = conn.Fields()
+	assert.Error(t, err, "the streaming query is still in progress")
+	assert.Equal(t, "my_db", schema())
+
+	// ResetSession also resets the state of the session on the
+	// server.
+	require.NoError(t, conn.ExecuteStreamFetch("select rows"))
+	require.NoError(t, conn.ResetSession())
+	assert.Equal(t, "", schema())
+
+	conn.Close()
+	assertSQLError(t, conn.Reset(), CRServerGone, SSUnknownSQLState, "connection is closed", "")
+}
+
 // TestTLSClientDisabled creates a Server with TLS support, then connects
 // with a client with TLS disabled.
 func TestTLSClientDisabled(t *testing.T) {
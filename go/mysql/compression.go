@@ -0,0 +1,326 @@
+/*
+Copyright 2019 The Vitess Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mysql
+
+import (
+	"bytes"
+	"compress/zlib"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+
+	"github.com/dolthub/vitess/go/vt/proto/vtrpc"
+	"github.com/dolthub/vitess/go/vt/vterrors"
+)
+
+// This file contains the support for the compressed protocol, used
+// when CapabilityClientCompress or
+// CapabilityClientZstdCompressionAlgorithm is negotiated. See
+// https://dev.mysql.com/doc/internals/en/compressed-packet-header.html
+//
+// Once the handshake is done, the packets are sent in both directions
+// inside compressed packets, with a 7 bytes header:
+// - 3 bytes: length of the payload.
+// - 1 byte: compressed sequence number.
+// - 3 bytes: length of the payload before compression, or 0 if the
+// payload is not compressed.
+// The payload is zlib deflated, or zstd compressed. It is a stream of
+// packets: a compressed packet can hold several packets, or a part of
+// one.
+//
+// With zstd, the client sends the compression level both sides use in
+// its handshake response, unless the Listener sets its own (see
+// ListenerConfig.ZstdCompressionLevel): the level only matters to the
+// side compressing. zlib is used if both algorithms are negotiated.
+//
+// The compressed sequence number is reset at the start of each
+// command, see resetSequence, and increases with each compressed packet in both
+// directions, like the sequence number of packets does. MySQL does not
+// keep the sequence numbers of the packets inside in line with it, so
+// they are not checked in that mode.
+
+const (
+	// compressedHeaderSize is the size of the header of a compressed
+	// packet.
+	compressedHeaderSize = 7
+
+	// minCompressLength is the size under which payloads are sent
+	// without compression, as MySQL does: it would not make them
+	// smaller.
+	minCompressLength = 50
+
+	// DefaultZstdCompressionLevel is the zstd compression level
+	// clients ask for by default, as MySQL does.
+	DefaultZstdCompressionLevel = 3
+
+	// maxZstdCompressionLevel is the highest zstd compression level.
+	maxZstdCompressionLevel = 22
+)
+
+// enableCompression starts using the compressed protocol for all the
+// packets read and written. The algorithm is the one negotiated in
+// Capabilities, zlib if none is.
+func (c *Conn) enableCompression() {
+	var codec compressionCodec = &zlibCodec{}
+	if c.Capabilities&CapabilityClientCompress == 0 && c.Capabilities&CapabilityClientZstdCompressionAlgorithm != 0 {
+		codec = newZstdCodec(c.zstdCompressionLevel)
+	}
+	c.compressedReader = &compressedReader{c: c, codec: codec}
+	c.compressedWriter = &compressedWriter{c: c, codec: codec}
+}
+
+// compressionCodec compresses and inflates the payloads of compressed
+// packets.
+type compressionCodec interface {
+	// compress writes payload compressed to buf.
+	compress(buf *bytes.Buffer, payload []byte) error
+
+	// decompress returns payload inflated, in inflated if it is
+	// large enough. It fails if the result is not length bytes.
+	decompress(inflated, payload []byte, length int) ([]byte, error)
+}
+
+// zlibCodec is the codec of CapabilityClientCompress.
+type zlibCodec struct {
+	// zw and zr are kept for the next payloads.
+	zw *zlib.Writer
+	zr io.ReadCloser
+}
+
+func (zc *zlibCodec) compress(buf *bytes.Buffer, payload []byte) error {
+	if zc.zw == nil {
+		zc.zw = zlib.NewWriter(buf)
+	} else {
+		zc.zw.Reset(buf)
+	}
+	if _, err := zc.zw.Write(payload); err != nil {
+		return err
+	}
+	return zc.zw.Close()
+}
+
+func (zc *zlibCodec) decompress(inflated, payload []byte, length int) ([]byte, error) {
+	var err error
+	if zc.zr == nil {
+		zc.zr, err = zlib.NewReader(bytes.NewReader(payload))
+	} else {
+		err = zc.zr.(zlib.Resetter).Reset(bytes.NewReader(payload), nil)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if cap(inflated) < length {
+		inflated = make([]byte, length)
+	}
+	inflated = inflated[:length]
+	if _, err := io.ReadFull(zc.zr, inflated); err != nil {
+		return nil, err
+	}
+	return inflated, nil
+}
+
+// zstdCodec is the codec of CapabilityClientZstdCompressionAlgorithm.
+// The encoder and decoder are created when first needed, they hold
+// sizable buffers.
+type zstdCodec struct {
+	level int
+	zw    *zstd.Encoder
+	zr    *zstd.Decoder
+	// compressed is kept to compress the next payloads.
+	compressed []byte
+}
+
+// newZstdCodec returns a zstdCodec compressing at level, or at
+// DefaultZstdCompressionLevel if level is out of range.
+func newZstdCodec(level int) *zstdCodec {
+	if level < 1 || level > maxZstdCompressionLevel {
+		level = DefaultZstdCompressionLevel
+	}
+	return &zstdCodec{level: level}
+}
+
+func (zc *zstdCodec) compress(buf *bytes.Buffer, payload []byte) error {
+	if zc.zw == nil {
+		zw, err := zstd.NewWriter(nil,
+			zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(zc.level)),
+			zstd.WithEncoderConcurrency(1),
+			zstd.WithLowerEncoderMem(true))
+		if err != nil {
+			return err
+		}
+		zc.zw = zw
+	}
+	zc.compressed = zc.zw.EncodeAll(payload, zc.compressed[:0])
+	buf.Write(zc.compressed)
+	return nil
+}
+
+func (zc *zstdCodec) decompress(inflated, payload []byte, length int) ([]byte, error) {
+	if zc.zr == nil {
+		zr, err := zstd.NewReader(nil,
+			zstd.WithDecoderConcurrency(1),
+			zstd.WithDecoderMaxMemory(uint64(MaxPacketSize)))
+		if err != nil {
+			return nil, err
+		}
+		zc.zr = zr
+	}
+	inflated, err := zc.zr.DecodeAll(payload, inflated[:0])
+	if err != nil {
+		return nil, err
+	}
+	if len(inflated) != length {
+		return nil, vterrors.Errorf(vtrpc.Code_INTERNAL, "inflated %v bytes, expected %v", len(inflated), length)
+	}
+	return inflated, nil
+}
+
+// compressedWriter cuts the data written to it in compressed packets,
+// and writes them to the socket. Each call to Write sends the data
+// right away, it should be used behind a buffer.
+type compressedWriter struct {
+	c     *Conn
+	codec compressionCodec
+
+	// buf is kept to compress each payload.
+	buf bytes.Buffer
+}
+
+func (cw *compressedWriter) Write(data []byte) (int, error) {
+	written := 0
+	for written < len(data) {
+		length := len(data) - written
+		if length > MaxPacketSize {
+			length = MaxPacketSize
+		}
+		if err := cw.writeCompressedPacket(data[written : written+length]); err != nil {
+			return written, err
+		}
+		written += length
+	}
+	return written, nil
+}
+
+// writeCompressedPacket sends payload in one compressed packet. It is
+// deflated if that makes it smaller.
+func (cw *compressedWriter) writeCompressedPacket(payload []byte) error {
+	cw.buf.Reset()
+	var header [compressedHeaderSize]byte
+	cw.buf.Write(header[:])
+
+	uncompressedLength := 0
+	if len(payload) >= minCompressLength {
+		if err := cw.codec.compress(&cw.buf, payload); err != nil {
+			return vterrors.Wrapf(err, "compressing packet failed")
+		}
+		if cw.buf.Len()-compressedHeaderSize < len(payload) {
+			uncompressedLength = len(payload)
+		}
+	}
+	if uncompressedLength == 0 {
+		// Not worth compressing, send it as is.
+		cw.buf.Truncate(compressedHeaderSize)
+		cw.buf.Write(payload)
+	}
+
+	frame := cw.buf.Bytes()
+	length := len(frame) - compressedHeaderSize
+	frame[0] = byte(length)
+	frame[1] = byte(length >> 8)
+	frame[2] = byte(length >> 16)
+	frame[3] = cw.c.compressedSequence
+	frame[4] = byte(uncompressedLength)
+	frame[5] = byte(uncompressedLength >> 8)
+	frame[6] = byte(uncompressedLength >> 16)
+	cw.c.compressedSequence++
+
+	if _, err := (fullWriter{cw.c}).Write(frame); err != nil {
+		return err
+	}
+	return nil
+}
+
+// compressedReader reads compressed packets from the socket, and
+// returns their inflated payloads, so the packets in them can be read
+// as usual.
+type compressedReader struct {
+	c     *Conn
+	codec compressionCodec
+
+	// data is what is left to read of the current compressed packet.
+	data []byte
+
+	// payload and inflated are kept to read the next compressed
+	// packets.
+	payload  []byte
+	inflated []byte
+}
+
+func (cr *compressedReader) Read(p []byte) (int, error) {
+	for len(cr.data) == 0 {
+		if err := cr.readCompressedPacket(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, cr.data)
+	cr.data = cr.data[n:]
+	return n, nil
+}
+
+// readCompressedPacket reads the next compressed packet.
+func (cr *compressedReader) readCompressedPacket() error {
+	c := cr.c
+	var r io.Reader = connReader{c}
+	if c.bufferedReader != nil {
+		r = c.bufferedReader
+	}
+
+	var header [compressedHeaderSize]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		// io.EOF is returned as is, see readHeaderFrom.
+		return err
+	}
+	length := int(uint32(header[0]) | uint32(header[1])<<8 | uint32(header[2])<<16)
+	sequence := header[3]
+	uncompressedLength := int(uint32(header[4]) | uint32(header[5])<<8 | uint32(header[6])<<16)
+
+	if sequence != c.compressedSequence {
+		return vterrors.Errorf(vtrpc.Code_INTERNAL, "invalid compressed sequence, expected %v got %v", c.compressedSequence, sequence)
+	}
+	c.compressedSequence++
+
+	if cap(cr.payload) < length {
+		cr.payload = make([]byte, length)
+	}
+	cr.payload = cr.payload[:length]
+	if _, err := io.ReadFull(r, cr.payload); err != nil {
+		return vterrors.Wrapf(err, "io.ReadFull(compressed packet body of length %v) failed", length)
+	}
+
+	if uncompressedLength == 0 {
+		cr.data = cr.payload
+		return nil
+	}
+
+	inflated, err := cr.codec.decompress(cr.inflated, cr.payload, uncompressedLength)
+	if err != nil {
+		return vterrors.Wrapf(err, "inflating compressed packet of length %v failed", uncompressedLength)
+	}
+	cr.inflated = inflated
+	cr.data = cr.inflated
+	return nil
+}
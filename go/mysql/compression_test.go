@@ -0,0 +1,456 @@
+/*
+Copyright 2019 The Vitess Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mysql
+
+import (
+	"bytes"
+	"compress/zlib"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/dolthub/vitess/go/sqltypes"
+
+	querypb "github.com/dolthub/vitess/go/vt/proto/query"
+)
+
+// compressedPacket returns a compressed packet with payload, deflated
+// if deflate is set.
+func compressedPacket(t *testing.T, sequence uint8, payload []byte, deflate bool) []byte {
+	body := payload
+	uncompressedLength := 0
+	if deflate {
+		var buf bytes.Buffer
+		zw := zlib.NewWriter(&buf)
+		if _, err := zw.Write(payload); err != nil {
+			t.Fatalf("zlib Write failed: %v", err)
+		}
+		if err := zw.Close(); err != nil {
+			t.Fatalf("zlib Close failed: %v", err)
+		}
+		body = buf.Bytes()
+		uncompressedLength = len(payload)
+	}
+	header := []byte{
+		byte(len(body)), byte(len(body) >> 8), byte(len(body) >> 16),
+		sequence,
+		byte(uncompressedLength), byte(uncompressedLength >> 8), byte(uncompressedLength >> 16),
+	}
+	return append(header, body...)
+}
+
+// packet returns a packet with payload.
+func packet(sequence uint8, payload []byte) []byte {
+	header := []byte{byte(len(payload)), byte(len(payload) >> 8), byte(len(payload) >> 16), sequence}
+	return append(header, payload...)
+}
+
+// compressedTestResult returns a small result set. It is not shared
+// with the other tests, comparing it changes the state of its fields.
+func compressedTestResult() *sqltypes.Result {
+	return &sqltypes.Result{
+		Fields: []*querypb.Field{
+			{Name: "id", Type: querypb.Type_INT32},
+			{Name: "name", Type: querypb.Type_VARCHAR},
+		},
+		Rows: [][]sqltypes.Value{
+			{sqltypes.NewInt32(10), sqltypes.NewVarChar("nice name")},
+			{sqltypes.NewInt32(20), sqltypes.NewVarChar("nicer name")},
+		},
+		RowsAffected: 2,
+	}
+}
+
+func TestCompressedQueries(t *testing.T) {
+	for _, capability := range []uint32{CapabilityClientCompress, CapabilityClientZstdCompressionAlgorithm} {
+		t.Run(fmt.Sprintf("capability %x", capability), func(t *testing.T) {
+			listener, sConn, cConn := createSocketPair(t)
+			defer func() {
+				listener.Close()
+				sConn.Close()
+				cConn.Close()
+			}()
+			for _, c := range []*Conn{sConn, cConn} {
+				c.SetCapability(capability)
+				c.zstdCompressionLevel = DefaultZstdCompressionLevel
+				c.enableCompression()
+			}
+
+			checkQuery(t, "tiny", sConn, cConn, &sqltypes.Result{})
+			checkQuery(t, "insert", sConn, cConn, &sqltypes.Result{
+				RowsAffected: 0x8010203040506070,
+				InsertID:     0x0102030405060708,
+			})
+			checkQuery(t, "select", sConn, cConn, compressedTestResult())
+
+			// More packets than the sequence numbers can count.
+			many := manyRowsResult(1000)
+			many.RowsAffected = 1000
+			checkQuery(t, "many", sConn, cConn, many)
+			checkQuery(t, "select", sConn, cConn, compressedTestResult())
+
+			// Values of a few megabytes, and larger than a compressed
+			// packet can hold.
+			for _, length := range []int{4 << 20, MaxPacketSize + 2000} {
+				large := strings.Repeat("compressed protocol ", length/20)
+				checkQueryInternal(t, "large", sConn, cConn, &sqltypes.Result{
+					Fields: []*querypb.Field{
+						{Name: "name", Type: querypb.Type_VARCHAR},
+					},
+					Rows: [][]sqltypes.Value{
+						{sqltypes.NewVarChar(large)},
+						{sqltypes.NewVarChar("small")},
+					},
+					RowsAffected: 2,
+				}, true /* wantfields */, true /* allRows */, false /* warnings */)
+			}
+		})
+	}
+}
+
+func TestCompressedPackets(t *testing.T) {
+	listener, sConn, cConn := createSocketPair(t)
+	defer func() {
+		listener.Close()
+		sConn.Close()
+		cConn.Close()
+	}()
+	sConn.enableCompression()
+
+	// A packet sent as is, with an uncompressed length of 0.
+	query := append([]byte{ComQuery}, "select 1"...)
+	if _, err := cConn.Conn.Write(compressedPacket(t, 0, packet(0, query), false)); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	sConn.resetSequence()
+	data, err := sConn.readPacket()
+	if err != nil || !bytes.Equal(data, query) {
+		t.Fatalf("readPacket returned %q, %v, want %q", data, err, query)
+	}
+
+	// A packet cut in two compressed packets, one deflated and the
+	// other not.
+	query = append([]byte{ComQuery}, strings.Repeat("select 1 union all ", 20)...)
+	wire := packet(0, query)
+	wire = append(compressedPacket(t, 0, wire[:100], true), compressedPacket(t, 1, wire[100:], false)...)
+	if _, err := cConn.Conn.Write(wire); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	sConn.resetSequence()
+	data, err = sConn.readPacket()
+	if err != nil || !bytes.Equal(data, query) {
+		t.Fatalf("readPacket returned %q, %v, want %q", data, err, query)
+	}
+
+	// Small packets are sent as is, larger ones deflated. The
+	// compressed sequence numbers follow the ones read.
+	sConn.sequence = 1
+	small := []byte{OKPacket, 0, 0, 2, 0, 0, 0}
+	if err := sConn.writePacket(small); err != nil {
+		t.Fatalf("writePacket failed: %v", err)
+	}
+	want := compressedPacket(t, 2, packet(1, small), false)
+	got := make([]byte, len(want))
+	if _, err := io.ReadFull(cConn.Conn, got); err != nil || !bytes.Equal(got, want) {
+		t.Fatalf("got %v, %v, want %v", got, err, want)
+	}
+	large := bytes.Repeat([]byte("a"), 1000)
+	if err := sConn.writePacket(large); err != nil {
+		t.Fatalf("writePacket failed: %v", err)
+	}
+	var header [compressedHeaderSize]byte
+	if _, err := io.ReadFull(cConn.Conn, header[:]); err != nil {
+		t.Fatalf("ReadFull failed: %v", err)
+	}
+	length := int(header[0]) | int(header[1])<<8 | int(header[2])<<16
+	uncompressedLength := int(header[4]) | int(header[5])<<8 | int(header[6])<<16
+	if header[3] != 3 || uncompressedLength != len(large)+4 || length >= uncompressedLength {
+		t.Fatalf("got compressed header %v, want sequence 3 and a deflated payload of %v bytes", header, len(large)+4)
+	}
+	if _, err := io.ReadFull(cConn.Conn, make([]byte, length)); err != nil {
+		t.Fatalf("ReadFull failed: %v", err)
+	}
+
+	// The compressed sequence number is checked.
+	if _, err := cConn.Conn.Write(compressedPacket(t, 5, packet(0, query), false)); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	sConn.resetSequence()
+	if _, err := sConn.readPacket(); err == nil || !strings.Contains(err.Error(), "invalid compressed sequence, expected 0 got 5") {
+		t.Fatalf("readPacket returned %v, want an invalid compressed sequence error", err)
+	}
+}
+
+func TestCompressedConnection(t *testing.T) {
+	want := compressedTestResult()
+	th := &testHandler{result: want}
+
+	authServer := NewAuthServerStatic("", "", 0)
+	authServer.entries["user1"] = []*AuthServerStaticEntry{{
+		Password: "password1",
+	}}
+
+	for _, tcase := range []struct {
+		name       string
+		allowZlib  bool
+		allowZstd  bool
+		askZlib    bool
+		askZstd    bool
+		negotiated uint32
+	}{
+		{name: "zlib", allowZlib: true, askZlib: true, negotiated: CapabilityClientCompress},
+		{name: "zlib not allowed", allowZstd: true, askZlib: true},
+		{name: "zstd", allowZstd: true, askZstd: true, negotiated: CapabilityClientZstdCompressionAlgorithm},
+		{name: "zstd not allowed", allowZlib: true, askZstd: true},
+		{name: "both", allowZlib: true, allowZstd: true, askZlib: true, askZstd: true, negotiated: CapabilityClientCompress | CapabilityClientZstdCompressionAlgorithm},
+	} {
+		t.Run(tcase.name, func(t *testing.T) {
+			l, err := NewListenerWithOptions(
+				WithAddress("tcp", ":0"),
+				WithAuthServer(authServer),
+				WithHandler(th),
+				WithCompression(tcase.allowZlib),
+				WithZstdCompression(tcase.allowZstd),
+			)
+			if err != nil {
+				t.Fatalf("NewListener failed: %v", err)
+			}
+			defer l.Close()
+			go l.Accept()
+
+			host, port := getHostPort(t, l.Addr())
+			params := &ConnParams{
+				Host:  host,
+				Port:  port,
+				Uname: "user1",
+				Pass:  "password1",
+			}
+			if tcase.askZlib {
+				params.EnableCompression()
+			}
+			if tcase.askZstd {
+				params.EnableZstdCompression(7)
+			}
+			conn, err := Connect(context.Background(), params)
+			if err != nil {
+				t.Fatalf("Connect failed: %v", err)
+			}
+			defer conn.Close()
+			if got := conn.Capabilities & (CapabilityClientCompress | CapabilityClientZstdCompressionAlgorithm); got != tcase.negotiated {
+				t.Errorf("compression negotiated: %x, want %x", got, tcase.negotiated)
+			}
+			if conn.compressedWriter != nil {
+				if _, ok := conn.compressedWriter.codec.(*zstdCodec); ok != (tcase.negotiated == CapabilityClientZstdCompressionAlgorithm) {
+					t.Errorf("zstd used: %v, want %v", ok, !ok)
+				}
+			} else if tcase.negotiated != 0 {
+				t.Errorf("compression is not used")
+			}
+			if sConn := th.LastConn(); sConn.Capabilities&CapabilityClientZstdCompressionAlgorithm != 0 && sConn.zstdCompressionLevel != 7 {
+				t.Errorf("server zstd compression level: %v, want 7", sConn.zstdCompressionLevel)
+			}
+			result, err := conn.ExecuteFetch("select", 10, true)
+			if err != nil {
+				t.Fatalf("ExecuteFetch failed: %v", err)
+			}
+			if !result.Equal(want) {
+				t.Errorf("got %v, want %v", result, want)
+			}
+		})
+	}
+}
+
+// codecHandler is a testHandler recording the compression codec of the
+// server connection for each query, from the server goroutine.
+type codecHandler struct {
+	testHandler
+
+	codecMu sync.Mutex
+	codecs  []compressionCodec
+	zstd    []bool
+}
+
+func (th *codecHandler) ComMultiQuery(ctx context.Context, c *Conn, query string, callback func(*sqltypes.Result, bool) error) (string, error) {
+	return "", th.ComQuery(ctx, c, strings.TrimSpace(query), callback)
+}
+
+func (th *codecHandler) ComQuery(ctx context.Context, c *Conn, query string, callback func(*sqltypes.Result, bool) error) error {
+	th.codecMu.Lock()
+	if c.compressedWriter.codec != c.compressedReader.codec {
+		th.codecs = append(th.codecs, nil)
+	} else {
+		th.codecs = append(th.codecs, c.compressedWriter.codec)
+	}
+	th.zstd = append(th.zstd, c.Capabilities&CapabilityClientZstdCompressionAlgorithm != 0)
+	th.codecMu.Unlock()
+	return th.testHandler.ComQuery(ctx, c, query, callback)
+}
+
+// seen returns the codecs and zstd negotiation the queries saw.
+func (th *codecHandler) seen() ([]compressionCodec, []bool) {
+	th.codecMu.Lock()
+	defer th.codecMu.Unlock()
+	return append([]compressionCodec(nil), th.codecs...), append([]bool(nil), th.zstd...)
+}
+
+func TestCompressedResetConnection(t *testing.T) {
+	want := compressedTestResult()
+	th := &codecHandler{testHandler: testHandler{result: want}}
+
+	authServer := NewAuthServerStatic("", "", 0)
+	authServer.entries["user1"] = []*AuthServerStaticEntry{{
+		Password: "password1",
+	}}
+	l, err := NewListenerWithOptions(
+		WithAddress("tcp", ":0"),
+		WithAuthServer(authServer),
+		WithHandler(th),
+		WithZstdCompression(true),
+		WithZstdCompressionLevel(9),
+	)
+	if err != nil {
+		t.Fatalf("NewListener failed: %v", err)
+	}
+	defer l.Close()
+	go l.Accept()
+
+	host, port := getHostPort(t, l.Addr())
+	params := &ConnParams{
+		Host:  host,
+		Port:  port,
+		Uname: "user1",
+		Pass:  "password1",
+	}
+	params.EnableZstdCompression(DefaultZstdCompressionLevel)
+	conn, err := Connect(context.Background(), params)
+	if err != nil {
+		t.Fatalf("Connect failed: %v", err)
+	}
+	defer conn.Close()
+
+	if clientCodec, ok := conn.compressedWriter.codec.(*zstdCodec); !ok || clientCodec.level != DefaultZstdCompressionLevel {
+		t.Fatalf("client codec: %#v, want zstd with level %v", conn.compressedWriter.codec, DefaultZstdCompressionLevel)
+	}
+
+	for i := 0; i < 2; i++ {
+		result, err := conn.ExecuteFetch("select", 10, true)
+		if err != nil {
+			t.Fatalf("ExecuteFetch failed: %v", err)
+		}
+		if !result.Equal(compressedTestResult()) {
+			t.Errorf("got %v, want %v", result, want)
+		}
+
+		// The connection keeps its codec after COM_RESET_CONNECTION.
+		if err := writeRawPacketToConn(conn, []byte{ComResetConnection}); err != nil {
+			t.Fatalf("writing ComResetConnection failed: %v", err)
+		}
+		data, err := conn.ReadPacket()
+		if err != nil || len(data) == 0 || data[0] != OKPacket {
+			t.Fatalf("expected OK packet after ComResetConnection, got: %v %v", data, err)
+		}
+	}
+
+	// The server compresses with its own level, and keeps its codec
+	// and zstd after COM_RESET_CONNECTION.
+	codecs, zstd := th.seen()
+	if len(codecs) != 2 {
+		t.Fatalf("the handler saw %v queries, want 2", len(codecs))
+	}
+	codec, ok := codecs[0].(*zstdCodec)
+	if !ok || codec.level != 9 {
+		t.Fatalf("server codec: %#v, want zstd with level 9 for reading and writing", codecs[0])
+	}
+	if codecs[1] != codecs[0] {
+		t.Errorf("the server codec changed after ComResetConnection")
+	}
+	if !zstd[0] || !zstd[1] {
+		t.Errorf("zstd negotiated for the queries: %v, want it for both", zstd)
+	}
+}
+
+// BenchmarkCompressedExecuteFetch compares the codecs on a large result
+// set. bytes/op is what the server sends.
+func BenchmarkCompressedExecuteFetch(b *testing.B) {
+	result := &sqltypes.Result{
+		Fields: []*querypb.Field{
+			{Name: "id", Type: querypb.Type_INT64},
+			{Name: "name", Type: querypb.Type_VARCHAR},
+			{Name: "description", Type: querypb.Type_TEXT},
+		},
+	}
+	for i := 0; i < 5000; i++ {
+		result.Rows = append(result.Rows, []sqltypes.Value{
+			sqltypes.NewInt64(int64(i)),
+			sqltypes.NewVarChar(fmt.Sprintf("customer %v", i)),
+			sqltypes.NewVarChar(fmt.Sprintf("account opened on day %v, %v orders, status %v", i%365, i%17, []string{"active", "inactive", "suspended"}[i%3])),
+		})
+	}
+
+	for _, tcase := range []struct {
+		name       string
+		capability uint32
+		level      int
+	}{
+		{name: "none"},
+		{name: "zlib", capability: CapabilityClientCompress},
+		{name: "zstd level 1", capability: CapabilityClientZstdCompressionAlgorithm, level: 1},
+		{name: "zstd level 3", capability: CapabilityClientZstdCompressionAlgorithm, level: 3},
+		{name: "zstd level 9", capability: CapabilityClientZstdCompressionAlgorithm, level: 9},
+	} {
+		b.Run(tcase.name, func(b *testing.B) {
+			listener, sConn, cConn, counter := createCountingSocketPair(b)
+			defer func() {
+				listener.Close()
+				sConn.Close()
+				cConn.Close()
+			}()
+			if tcase.capability != 0 {
+				for _, c := range []*Conn{sConn, cConn} {
+					c.SetCapability(tcase.capability)
+					c.zstdCompressionLevel = tcase.level
+					c.enableCompression()
+				}
+			}
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			before := atomic.LoadInt64(&counter.bytes)
+			for i := 0; i < b.N; i++ {
+				var serverErr error
+				wg := sync.WaitGroup{}
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					_, serverErr = serveResult(sConn, counter, result)
+				}()
+				if _, err := cConn.ExecuteFetch("select rows", 10000, true); err != nil {
+					b.Fatalf("ExecuteFetch failed: %v", err)
+				}
+				wg.Wait()
+				if serverErr != nil {
+					b.Fatalf("server failed: %v", serverErr)
+				}
+			}
+			b.ReportMetric(float64(atomic.LoadInt64(&counter.bytes)-before)/float64(b.N), "bytes/op")
+		})
+	}
+}
@@ -18,6 +18,7 @@ package mysql
 
 import (
 	"bufio"
+	"context"
 	"crypto/tls"
 	"crypto/x509"
 	"errors"
@@ -30,7 +31,6 @@ import (
 	"sync"
 	"time"
 
-	"github.com/dolthub/vitess/go/bucketpool"
 	"github.com/dolthub/vitess/go/sqltypes"
 	"github.com/dolthub/vitess/go/sync2"
 	"github.com/dolthub/vitess/go/vt/log"
@@ -46,6 +46,24 @@ const (
 	DefaultConnBufferSize = 16 * 1024
 )
 
+// connState is the lifecycle state of a Conn.
+type connState int32
+
+const (
+	// connOpen is the state of a Conn until Close is called.
+	connOpen connState = iota
+
+	// connDraining is the state of a Conn being closed. Writes fail,
+	// and the socket is about to be closed.
+	connDraining
+
+	// connClosed is the state of a Conn whose socket is closed.
+	connClosed
+)
+
+// errConnClosed is returned by writes on a closed Conn.
+var errConnClosed = vterrors.New(vtrpc.Code_UNAVAILABLE, "connection is closed")
+
 // Constants for how ephemeral buffers were used for reading / writing.
 const (
 	// ephemeralUnused means the ephemeral buffer is not in use at this
@@ -93,8 +111,9 @@ type Conn struct {
 	// - at accept time for the server.
 	ConnectionID uint32
 
-	// closed is set to true when Close() is called on the connection.
-	closed sync2.AtomicBool
+	// state is the connState of the connection. It only moves
+	// forward, from connOpen to connDraining to connClosed, in Close.
+	state sync2.AtomicInt32
 
 	// Capabilities is the current set of features this connection
 	// is using.  It is the features that are both supported by
@@ -103,6 +122,7 @@ type Conn struct {
 	//
 	// It is only used for CapabilityClientDeprecateEOF
 	// and CapabilityClientFoundRows.
+	// Use SetCapability and ClearCapability to change it.
 	Capabilities uint32
 
 	// CharacterSet is the character set used by the other side of the
@@ -126,11 +146,32 @@ type Conn struct {
 	// through the 'USE' statement, which will bypass this variable.
 	schemaName string
 
+	// characterSetResults and characterSetResultsNull are the
+	// character_set_results session variable, as the client set it.
+	// See CharacterSetResults.
+	characterSetResults     string
+	characterSetResultsNull bool
+
+	// status is the session status variables of a server-side
+	// connection, see Status.
+	status statusCounters
+
 	// ServerVersion is set during Connect with the server
 	// version.  It is not changed afterwards. It is unused for
 	// server-side connections.
 	ServerVersion string
 
+	// serverCapabilities and serverCharset are set during Connect
+	// with the capability flags and character set the server
+	// advertised. They are unused for server-side connections.
+	serverCapabilities uint32
+	serverCharset      uint8
+
+	// negotiatedCapabilities is set during Connect with the
+	// capability flags both the client and the server support. It is
+	// unused for server-side connections.
+	negotiatedCapabilities uint32
+
 	// flavor contains the auto-detected flavor for this client
 	// connection. It is unused for server-side connections.
 	flavor flavor
@@ -142,6 +183,17 @@ type Conn struct {
 	// by Handler methods.
 	StatusFlags uint16
 
+	// initialStatusFlags are the StatusFlags set by
+	// Handler.NewConnection. resetSessionState restores
+	// ServerStatusAutocommit from them.
+	initialStatusFlags uint16
+
+	// handshakeCharacterSet is the CharacterSet the client sent in its
+	// handshake, which resetSessionState restores. It is 0 for the
+	// connections without a handshake, which are reset to
+	// CharacterSetUtf8.
+	handshakeCharacterSet uint8
+
 	// ClientData is a place where an application can store any
 	// connection-related data. Mostly used on the server side, to
 	// avoid maps indexed by ConnectionID for instance.
@@ -153,11 +205,94 @@ type Conn struct {
 	// them at all rather than process ';'s in statement incorrectly.
 	DisableClientMultiStatements bool
 
+	// Attributes are the connection attributes the client sent in its
+	// handshake, if any. It is only set on the server side.
+	Attributes map[string]string
+
+	// QueryAttributes are the query attributes the client sent with
+	// the COM_QUERY or COM_STMT_EXECUTE being executed, if it
+	// negotiated CLIENT_QUERY_ATTRIBUTES. They are only set while the
+	// Handler executes the command, on the server side.
+	QueryAttributes map[string]sqltypes.Value
+
+	// resultEncoding is the encoding of the result sets sent by the
+	// server, see ResultEncoding. base64Columns are the columns of
+	// the current text result set that it base64 encodes.
+	resultEncoding ResultEncoding
+	base64Columns  []bool
+
+	// quirks are the deviations from the protocol the server makes
+	// for this client, see ClientQuirks. It is only set on the
+	// server side.
+	quirks ClientQuirks
+
+	// resultLimits bounds the result sets this client connection
+	// accepts. See ResultLimits.
+	resultLimits ResultLimits
+
+	// localInfileAllowList and openLocalInfile are the files this
+	// client connection sends for LOAD DATA LOCAL INFILE, and how it
+	// opens them. See ConnParams.LocalInfileAllowList.
+	localInfileAllowList []string
+	openLocalInfile      func(name string) (io.ReadCloser, error)
+
+	// streamBudget tracks the ongoing streaming query, see
+	// ExecuteStreamFetch.
+	streamBudget resultBudget
+
+	// binlogStreamStarted is set once the first event of the binlog
+	// stream this client connection reads was read, and binlogSemiSync
+	// if it showed that the events have the semi-sync header. See
+	// ReadRawBinlogEvent. binlogAckRequested is set if the master asked
+	// for the ack of the last event read, see WriteSemiSyncAck.
+	binlogStreamStarted bool
+	binlogSemiSync      bool
+	binlogAckRequested  bool
+
+	// progressAllowed is set on the server side while a ComQuery
+	// has not sent its first result yet, see Progress.
+	progressAllowed bool
+
+	// queryTimeout bounds the time a query can run for on the server
+	// side, see SetQueryTimeout.
+	queryTimeout time.Duration
+
+	// lastProgress is set on the client side when the last OK packet
+	// read carried a progress report, see ExecuteFetchWithProgress.
+	lastProgress *progressReport
+
+	// sessionStateChanges is set on the client side to the session
+	// state changes of the last OK packet read in response to a query,
+	// see sqltypes.Result.SessionStateChanges.
+	sessionStateChanges *sqltypes.SessionStateChanges
+
+	// binaryRows is set on the client side when the last command
+	// sent was a COM_STMT_EXECUTE, so its rows are read in the binary
+	// protocol, see ReadQueryResult.
+	binaryRows bool
+
 	// Packet encoding variables.
 	bufferedReader *bufio.Reader
 	bufferedWriter *bufio.Writer
 	sequence       uint8
 
+	// compressedReader and compressedWriter are set once the
+	// compressed protocol is in use, and compressedSequence is the
+	// sequence number of the compressed packets. See compression.go.
+	compressedReader   *compressedReader
+	compressedWriter   *compressedWriter
+	compressedSequence uint8
+
+	// zstdCompressionLevel is the zstd compression level, if
+	// CapabilityClientZstdCompressionAlgorithm is negotiated.
+	zstdCompressionLevel int
+
+	// writeBufferSize is the size of the buffer used to coalesce the
+	// packets of a response between startWriterBuffering and flush.
+	// Zero means DefaultConnBufferSize, and a negative value disables
+	// coalescing: every packet is then written on its own.
+	writeBufferSize int
+
 	// fields contains the fields definitions for an on-going
 	// streaming query. It is set by ExecuteStreamFetch, and
 	// cleared by the last FetchNext().  It is nil if no streaming
@@ -165,6 +300,11 @@ type Conn struct {
 	// fields, this is set to an empty array (but not nil).
 	fields []*querypb.Field
 
+	// busy is set while a client method uses the connection, and
+	// panicOnResultPending makes misuses panic. See client_state.go.
+	busy                 int32
+	panicOnResultPending bool
+
 	// Keep track of how and of the buffer we allocated for an
 	// ephemeral packet on the read and write sides.
 	// These fields are used by:
@@ -175,6 +315,11 @@ type Conn struct {
 	// It can be allocated from bufPool or heap and should be recycled in the same manner.
 	currentEphemeralBuffer *[]byte
 
+	// bufPool recycles the ephemeral buffers. If nil, the default
+	// pool is used. It is set by newServerConn, and must not change
+	// while an ephemeral buffer is in use.
+	bufPool *ConnBufferPool
+
 	// StatementID is the prepared statement ID.
 	StatementID uint32
 
@@ -223,10 +368,11 @@ type PrepareData struct {
 	ParamsType  []int32
 	ColumnNames []string
 	BindVars    map[string]*querypb.BindVariable
-}
 
-// bufPool is used to allocate and free buffers in an efficient way.
-var bufPool = bucketpool.New(DefaultConnBufferSize, MaxPacketSize)
+	// paramsBound is set on the client side once ParamsType has been
+	// sent to the server.
+	paramsBound bool
+}
 
 // writersPool is used for pooling bufio.Writer objects.
 var writersPool = sync.Pool{New: func() interface{} { return bufio.NewWriterSize(nil, DefaultConnBufferSize) }}
@@ -236,8 +382,8 @@ var writersPool = sync.Pool{New: func() interface{} { return bufio.NewWriterSize
 func newConn(conn net.Conn) *Conn {
 	return &Conn{
 		Conn:           conn,
-		closed:         sync2.NewAtomicBool(false),
 		bufferedReader: bufio.NewReaderSize(conn, DefaultConnBufferSize),
+		resultLimits:   DefaultResultLimits,
 	}
 }
 
@@ -250,20 +396,30 @@ func newServerConn(conn net.Conn, listener *Listener) *Conn {
 	c := &Conn{
 		Conn:        conn,
 		listener:    listener,
-		closed:      sync2.NewAtomicBool(false),
 		PrepareData: make(map[uint32]*PrepareData),
+		bufPool:     listener.cfg.ConnBufferPool,
 	}
-	if listener.connReadBufferSize > 0 {
-		c.bufferedReader = bufio.NewReaderSize(conn, listener.connReadBufferSize)
+	if listener.cfg.ConnReadBufferSize > 0 {
+		c.bufferedReader = bufio.NewReaderSize(connReader{c}, listener.cfg.ConnReadBufferSize)
 	}
+	c.writeBufferSize = listener.cfg.ConnWriteBufferSize
 	return c
 }
 
 // startWriterBuffering starts using buffered writes. This should
-// be terminated by a call to flush.
+// be terminated by a call to flush. The packets written in between
+// are sent in as few writes as the buffer size allows.
 func (c *Conn) startWriterBuffering() {
-	c.bufferedWriter = writersPool.Get().(*bufio.Writer)
-	c.bufferedWriter.Reset(c.Conn)
+	if c.bufferedWriter != nil || c.writeBufferSize < 0 {
+		// Already buffering, or buffering is disabled.
+		return
+	}
+	if c.writeBufferSize == 0 || c.writeBufferSize == DefaultConnBufferSize {
+		c.bufferedWriter = writersPool.Get().(*bufio.Writer)
+		c.bufferedWriter.Reset(c.connWriter())
+		return
+	}
+	c.bufferedWriter = bufio.NewWriterSize(c.connWriter(), c.writeBufferSize)
 }
 
 // flush flushes the written data to the socket.
@@ -274,8 +430,10 @@ func (c *Conn) flush() error {
 	}
 
 	defer func() {
-		c.bufferedWriter.Reset(nil)
-		writersPool.Put(c.bufferedWriter)
+		if c.bufferedWriter.Size() == DefaultConnBufferSize {
+			c.bufferedWriter.Reset(nil)
+			writersPool.Put(c.bufferedWriter)
+		}
 		c.bufferedWriter = nil
 	}()
 
@@ -288,16 +446,72 @@ func (c *Conn) getWriter() io.Writer {
 	if c.bufferedWriter != nil {
 		return c.bufferedWriter
 	}
-	return c.Conn
+	return c.connWriter()
+}
+
+// connWriter returns the writer under the buffering: the compression
+// layer if the compressed protocol is in use, the socket otherwise.
+func (c *Conn) connWriter() io.Writer {
+	if c.compressedWriter != nil {
+		return c.compressedWriter
+	}
+	return fullWriter{c}
+}
+
+// fullWriter writes to the socket of a Conn, and is what all writes
+// go through. It fails once the Conn is being closed.
+//
+// It retries short writes until all the data is written or an error
+// occurs. The io.Writer contract says a short write always comes with
+// an error, but some net.Conn wrappers return them silently. Giving up
+// would leave half a packet on the wire, and the other side waiting
+// for the rest of it.
+type fullWriter struct {
+	c *Conn
+}
+
+func (fw fullWriter) Write(data []byte) (int, error) {
+	written := 0
+	for written < len(data) {
+		if fw.c.IsClosed() {
+			return written, errConnClosed
+		}
+		n, err := fw.c.Conn.Write(data[written:])
+		fw.c.countBytesSent(n)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		if n == 0 {
+			return written, io.ErrShortWrite
+		}
+	}
+	return written, nil
+}
+
+// connReader reads from the socket of a Conn, and is what all reads
+// go through. It counts the bytes in the status variables.
+type connReader struct {
+	c *Conn
 }
 
-// getReader returns reader for connection. It can be *bufio.Reader or net.Conn
-// depending on which buffer size was passed to newServerConn.
+func (cr connReader) Read(p []byte) (int, error) {
+	n, err := cr.c.Conn.Read(p)
+	cr.c.countBytesReceived(n)
+	return n, err
+}
+
+// getReader returns reader for connection. It can be *bufio.Reader or
+// the socket, depending on which buffer size was passed to
+// newServerConn.
 func (c *Conn) getReader() io.Reader {
+	if c.compressedReader != nil {
+		return c.compressedReader
+	}
 	if c.bufferedReader != nil {
 		return c.bufferedReader
 	}
-	return c.Conn
+	return connReader{c}
 }
 
 func (c *Conn) readHeaderFrom(r io.Reader) (int, error) {
@@ -322,7 +536,11 @@ func (c *Conn) readHeaderFrom(r io.Reader) (int, error) {
 	}
 
 	sequence := uint8(header[3])
-	if sequence != c.sequence {
+	if c.compressedReader != nil {
+		// The compressed packets have their own sequence numbers,
+		// this one is not reliable, see compression.go.
+		c.sequence = sequence
+	} else if sequence != c.sequence {
 		return 0, vterrors.Errorf(vtrpc.Code_INTERNAL, "invalid sequence, expected %v got %v", c.sequence, sequence)
 	}
 
@@ -338,7 +556,8 @@ func (c *Conn) readHeaderFrom(r io.Reader) (int, error) {
 // Note if the connection is closed already, an error will be
 // returned, and it may not be io.EOF. If the connection closes while
 // we are stuck waiting for data, an error will also be returned, and
-// it most likely will be io.EOF.
+// it most likely will be io.EOF. recycleReadPacket must not be called
+// after an error.
 func (c *Conn) readEphemeralPacket() ([]byte, error) {
 	if c.currentEphemeralPolicy != ephemeralUnused {
 		panic(vterrors.Errorf(vtrpc.Code_INTERNAL, "readEphemeralPacket: unexpected currentEphemeralPolicy: %v", c.currentEphemeralPolicy))
@@ -360,8 +579,9 @@ func (c *Conn) readEphemeralPacket() ([]byte, error) {
 
 	// Use the bufPool.
 	if length < MaxPacketSize {
-		c.currentEphemeralBuffer = bufPool.Get(length)
+		c.currentEphemeralBuffer = c.bufPool.get(length)
 		if _, err := io.ReadFull(r, *c.currentEphemeralBuffer); err != nil {
+			c.recycleReadPacket()
 			return nil, vterrors.Wrapf(err, "io.ReadFull(packet body of length %v) failed", length)
 		}
 		return *c.currentEphemeralBuffer, nil
@@ -372,11 +592,13 @@ func (c *Conn) readEphemeralPacket() ([]byte, error) {
 	// optimize this code path easily.
 	data := make([]byte, length)
 	if _, err := io.ReadFull(r, data); err != nil {
+		c.recycleReadPacket()
 		return nil, vterrors.Wrapf(err, "io.ReadFull(packet body of length %v) failed", length)
 	}
 	for {
 		next, err := c.readOnePacket()
 		if err != nil {
+			c.recycleReadPacket()
 			return nil, err
 		}
 
@@ -404,7 +626,7 @@ func (c *Conn) readEphemeralPacketDirect() ([]byte, error) {
 		panic(vterrors.Errorf(vtrpc.Code_INTERNAL, "readEphemeralPacketDirect: unexpected currentEphemeralPolicy: %v", c.currentEphemeralPolicy))
 	}
 
-	var r io.Reader = c.Conn
+	var r io.Reader = connReader{c}
 
 	length, err := c.readHeaderFrom(r)
 	if err != nil {
@@ -419,13 +641,15 @@ func (c *Conn) readEphemeralPacketDirect() ([]byte, error) {
 	}
 
 	if length < MaxPacketSize {
-		c.currentEphemeralBuffer = bufPool.Get(length)
+		c.currentEphemeralBuffer = c.bufPool.get(length)
 		if _, err := io.ReadFull(r, *c.currentEphemeralBuffer); err != nil {
+			c.recycleReadPacket()
 			return nil, vterrors.Wrapf(err, "io.ReadFull(packet body of length %v) failed", length)
 		}
 		return *c.currentEphemeralBuffer, nil
 	}
 
+	c.recycleReadPacket()
 	return nil, vterrors.Errorf(vtrpc.Code_INTERNAL, "readEphemeralPacketDirect doesn't support more than one packet")
 }
 
@@ -438,7 +662,7 @@ func (c *Conn) recycleReadPacket() {
 	}
 	if c.currentEphemeralBuffer != nil {
 		// We are using the pool, put the buffer back in.
-		bufPool.Put(c.currentEphemeralBuffer)
+		c.bufPool.put(c.currentEphemeralBuffer)
 		c.currentEphemeralBuffer = nil
 	}
 	c.currentEphemeralPolicy = ephemeralUnused
@@ -512,6 +736,14 @@ func (c *Conn) ReadPacket() ([]byte, error) {
 	return result, err
 }
 
+// resetSequence resets the sequence numbers at the start of a new
+// command. The sequence numbers wrap around in long exchanges, so a 0
+// does not mean a command starts.
+func (c *Conn) resetSequence() {
+	c.sequence = 0
+	c.compressedSequence = 0
+}
+
 // writePacket writes a packet, possibly cutting it into multiple
 // chunks.  Note this is not very efficient, as the client probably
 // has to build the []byte and that makes a memory copy.
@@ -519,6 +751,19 @@ func (c *Conn) ReadPacket() ([]byte, error) {
 //
 // This method returns a generic error, not a SQLError.
 func (c *Conn) writePacket(data []byte) error {
+	if c.bufferedWriter == nil && c.writeBufferSize >= 0 {
+		// A packet written outside of startWriterBuffering is sent
+		// right away, but in one write (or compressed packet), not
+		// its header and body separately: with TCP_NODELAY, each
+		// write is a segment.
+		c.startWriterBuffering()
+		if err := c.writePacket(data); err != nil {
+			c.flush()
+			return err
+		}
+		return c.flush()
+	}
+
 	index := 0
 	length := len(data)
 
@@ -582,7 +827,7 @@ func (c *Conn) startEphemeralPacket(length int) []byte {
 
 	c.currentEphemeralPolicy = ephemeralWrite
 	// get buffer from pool or it'll be allocated if length is too big
-	c.currentEphemeralBuffer = bufPool.Get(length)
+	c.currentEphemeralBuffer = c.bufPool.get(length)
 	return *c.currentEphemeralBuffer
 }
 
@@ -612,7 +857,7 @@ func (c *Conn) recycleWritePacket() {
 		panic(vterrors.Errorf(vtrpc.Code_INTERNAL, "trying to call recycleWritePacket while currentEphemeralPolicy is %d", c.currentEphemeralPolicy))
 	}
 	// Release our reference so the buffer can be gced
-	bufPool.Put(c.currentEphemeralBuffer)
+	c.bufPool.put(c.currentEphemeralBuffer)
 	c.currentEphemeralBuffer = nil
 	c.currentEphemeralPolicy = ephemeralUnused
 }
@@ -623,7 +868,7 @@ func (c *Conn) recycleWritePacket() {
 // Returns SQLError(CRServerGone) if it can't.
 func (c *Conn) writeComQuit() error {
 	// This is a new command, need to reset the sequence.
-	c.sequence = 0
+	c.resetSequence()
 
 	data := c.startEphemeralPacket(1)
 	data[0] = ComQuit
@@ -650,17 +895,65 @@ func (c *Conn) String() string {
 
 // Close closes the connection. It can be called from a different go
 // routine to interrupt the current connection.
+//
+// It is the only way a Conn is torn down, whatever the reason: a kill
+// from another go routine, the server loop ending because the client
+// went away, timed out or misbehaved, or a protocol error. Only the
+// first call closes the socket. Writes fail as soon as it starts, so
+// nothing is written to a connection being closed.
 func (c *Conn) Close() {
-	if c.closed.CompareAndSwap(false, true) {
-		c.Conn.Close()
+	if !c.state.CompareAndSwap(int32(connOpen), int32(connDraining)) {
+		return
 	}
+	c.Conn.Close()
+	c.state.Set(int32(connClosed))
 }
 
 // IsClosed returns true if this connection was ever closed by the
 // Close() method.  Note if the other side closes the connection, but
 // Close() wasn't called, this will return false.
 func (c *Conn) IsClosed() bool {
-	return c.closed.Get()
+	return connState(c.state.Get()) != connOpen
+}
+
+// NegotiatedCapabilities returns the capability flags the client and
+// the server agreed on during the handshake: the ones both support.
+// Capabilities, which HasCapability checks, only keeps the subset the
+// connection acts on. See ServerCapabilities for all the flags the
+// server advertised, and ServerVersion for its version. It is 0 for
+// server-side connections.
+func (c *Conn) NegotiatedCapabilities() uint32 {
+	return c.negotiatedCapabilities
+}
+
+// HasCapability returns true if the connection uses all the
+// capability bits of capability, see the Capability constants.
+func (c *Conn) HasCapability(capability uint32) bool {
+	return c.Capabilities&capability == capability
+}
+
+// SetCapability starts using the capability bits of capability on
+// the connection. The other side must support them too, it is mostly
+// useful for tests and embedders taking over a connection.
+func (c *Conn) SetCapability(capability uint32) {
+	c.Capabilities |= capability
+}
+
+// ClearCapability stops using the capability bits of capability on
+// the connection.
+func (c *Conn) ClearCapability(capability uint32) {
+	c.Capabilities &^= capability
+}
+
+// SetClientFoundRows sets CapabilityClientFoundRows, which makes the
+// affected rows of an UPDATE the rows matched instead of the rows
+// changed.
+func (c *Conn) SetClientFoundRows(foundRows bool) {
+	if foundRows {
+		c.SetCapability(CapabilityClientFoundRows)
+	} else {
+		c.ClearCapability(CapabilityClientFoundRows)
+	}
 }
 
 //
@@ -676,6 +969,9 @@ func (c *Conn) writeOKPacket(affectedRows, lastInsertID uint64, flags uint16, wa
 		lenEncIntSize(lastInsertID) +
 		2 + // flags
 		2 // warnings
+	if c.quirks&ClientQuirkPadEmptyInfo != 0 {
+		length++ // empty info string
+	}
 	data := c.startEphemeralPacket(length)
 	pos := 0
 	pos = writeByte(data, pos, OKPacket)
@@ -683,6 +979,9 @@ func (c *Conn) writeOKPacket(affectedRows, lastInsertID uint64, flags uint16, wa
 	pos = writeLenEncInt(data, pos, lastInsertID)
 	pos = writeUint16(data, pos, flags)
 	pos = writeUint16(data, pos, warnings)
+	if c.quirks&ClientQuirkPadEmptyInfo != 0 {
+		writeByte(data, pos, 0)
+	}
 
 	return c.writeEphemeralPacket()
 }
@@ -695,9 +994,13 @@ func (c *Conn) writeOKPacketWithInfo(affectedRows, lastInsertID uint64, flags ui
 		lenEncIntSize(affectedRows) +
 		lenEncIntSize(lastInsertID) +
 		2 + // flags
-		2 + // warnings
-		1 + // 1 byte before info string
-		lenEOFString(info)
+		2 // warnings
+	if c.Capabilities&CapabilityClientSessionTrack != 0 {
+		length += lenEncStringSize(info)
+	} else {
+		length += 1 + // 1 byte before info string
+			lenEOFString(info)
+	}
 	data := c.startEphemeralPacket(length)
 	pos := 0
 	pos = writeByte(data, pos, OKPacket)
@@ -705,8 +1008,13 @@ func (c *Conn) writeOKPacketWithInfo(affectedRows, lastInsertID uint64, flags ui
 	pos = writeLenEncInt(data, pos, lastInsertID)
 	pos = writeUint16(data, pos, flags)
 	pos = writeUint16(data, pos, warnings)
-	pos = writeByte(data, pos, '#')
-	pos = writeEOFString(data, pos, info)
+	if c.Capabilities&CapabilityClientSessionTrack != 0 {
+		// With session tracking, info is length encoded.
+		pos = writeLenEncString(data, pos, info)
+	} else {
+		pos = writeByte(data, pos, '#')
+		pos = writeEOFString(data, pos, info)
+	}
 
 	return c.writeEphemeralPacket()
 }
@@ -722,13 +1030,19 @@ func (c *Conn) writeOKPacketWithEOFHeader(affectedRows, lastInsertID uint64, fla
 		lenEncIntSize(lastInsertID) +
 		2 + // flags
 		2 // warnings
+	if c.quirks&ClientQuirkPadEmptyInfo != 0 {
+		length++ // empty info string
+	}
 	data := c.startEphemeralPacket(length)
 	pos := 0
 	pos = writeByte(data, pos, EOFPacket)
 	pos = writeLenEncInt(data, pos, affectedRows)
 	pos = writeLenEncInt(data, pos, lastInsertID)
 	pos = writeUint16(data, pos, flags)
-	_ = writeUint16(data, pos, warnings)
+	pos = writeUint16(data, pos, warnings)
+	if c.quirks&ClientQuirkPadEmptyInfo != 0 {
+		writeByte(data, pos, 0)
+	}
 
 	return c.writeEphemeralPacket()
 }
@@ -882,13 +1196,15 @@ func (c *Conn) HandleLoadDataLocalQuery(tmpdir string, tmpfileName string, file
 
 	for len(fileData) != 0 {
 		_, err := f.Write(fileData)
+		c.recycleReadPacket()
 		if err != nil {
 			return err
 		}
 
-		c.recycleReadPacket()
-
 		fileData, err = c.readEphemeralPacket()
+		if err != nil {
+			return err
+		}
 	}
 
 	c.recycleReadPacket()
@@ -914,7 +1230,14 @@ const batchSize = 128
 // handleNextCommand is called in the server loop to process
 // incoming packets.
 func (c *Conn) handleNextCommand(handler Handler) error {
-	c.sequence = 0
+	// The whole response to the previous command must have reached
+	// the client before we wait for its next command.
+	if err := c.flush(); err != nil {
+		log.Errorf("Conn %v: Flush() failed: %v", c.ID(), err)
+		return err
+	}
+
+	c.resetSequence()
 	data, err := c.readEphemeralPacket()
 	if err != nil {
 		// Don't log EOF errors. They cause too much spam.
@@ -930,6 +1253,19 @@ func (c *Conn) handleNextCommand(handler Handler) error {
 		return err
 	}
 
+	// MySQL takes an empty packet for a COM_SLEEP, which clients
+	// can't send.
+	if len(data) == 0 {
+		c.recycleReadPacket()
+		if err := c.writeErrorPacket(ERUnknownComError, SSUnknownComError, "command handling not implemented yet: empty packet"); err != nil {
+			log.Errorf("Error writing error packet to %s: %s", c, err)
+			return err
+		}
+		return nil
+	}
+
+	c.countCommand(data[0])
+
 	switch data[0] {
 	case ComQuit:
 		c.recycleReadPacket()
@@ -961,14 +1297,24 @@ func (c *Conn) handleNextCommand(handler Handler) error {
 		c.startWriterBuffering()
 
 		queryStart := time.Now()
-		query := c.parseComQuery(data)
+		query, attributes, err := c.parseComQuery(data)
 
 		c.recycleReadPacket()
+		if err != nil {
+			if werr := c.writeErrorPacketFromError(err); werr != nil {
+				// If we can't even write the error, we're done.
+				log.Error("Error writing query error to client %v: %v", c.ConnectionID, werr)
+				return werr
+			}
+			return c.flush()
+		}
+		c.QueryAttributes = attributes
+		defer func() {
+			c.QueryAttributes = nil
+		}()
 
 		multiStatements := !c.DisableClientMultiStatements && c.Capabilities&CapabilityClientMultiStatements != 0
 
-		var err error
-
 		for query, err = c.execQuery(query, handler, multiStatements); err == nil && query != ""; {
 			query, err = c.execQuery(query, handler, multiStatements)
 		}
@@ -977,6 +1323,7 @@ func (c *Conn) handleNextCommand(handler Handler) error {
 		}
 
 		timings.Record(queryTimingKey, queryStart)
+		c.countQuestion(queryStart)
 
 		if err := c.flush(); err != nil {
 			log.Errorf("Conn %v: Flush() failed: %v", c.ID(), err)
@@ -993,8 +1340,16 @@ func (c *Conn) handleNextCommand(handler Handler) error {
 			return err
 		}
 
+		// flush is called at the end of this block.
+		c.startWriterBuffering()
+
 		sql := fmt.Sprintf("SELECT * FROM %s LIMIT 0;", formatID(table))
-		err = handler.ComQuery(c, sql, func(qr *sqltypes.Result, more bool) error {
+		ctx, cancel := c.queryContext()
+		defer cancel()
+		err = handler.ComQuery(ctx, c, sql, func(qr *sqltypes.Result, more bool) error {
+			if ctx.Err() != nil {
+				return c.newQueryTimeoutError()
+			}
 			// only send meta data, no rows
 			if len(qr.Fields) == 0 {
 				return NewSQLErrorFromError(errors.New("unexpected: query ended without fields and no error"))
@@ -1009,6 +1364,9 @@ func (c *Conn) handleNextCommand(handler Handler) error {
 			}
 			return nil
 		})
+		if queryTimedOut(ctx, err) {
+			err = c.newQueryTimeoutError()
+		}
 
 		if err != nil {
 			if werr := c.writeErrorPacketFromError(err); werr != nil {
@@ -1021,6 +1379,10 @@ func (c *Conn) handleNextCommand(handler Handler) error {
 			log.Errorf("Error writing result to %s: %v", c, err)
 			return err
 		}
+		if err := c.flush(); err != nil {
+			log.Errorf("Conn %v: Flush() failed: %v", c.ID(), err)
+			return err
+		}
 	case ComPing:
 		c.recycleReadPacket()
 		// Return error if listener was shut down and OK otherwise
@@ -1077,34 +1439,16 @@ func (c *Conn) handleNextCommand(handler Handler) error {
 
 		// Populate PrepareData
 		c.StatementID++
-		prepare := &PrepareData{
-			StatementID: c.StatementID,
-			PrepareStmt: query,
-		}
-
+		var prepare *PrepareData
 		var err error
-		var statement sqlparser.Statement
-		var remainder string
-
-		if !c.DisableClientMultiStatements && c.Capabilities&CapabilityClientMultiStatements != 0 {
-			var ri int
-			statement, ri, err = sqlparser.ParseOne(query)
-			if ri < len(query) {
-				remainder = query[ri:]
-			}
-		} else {
-			statement, err = sqlparser.Parse(query)
+		if h, ok := handler.(StmtPrepareHandler); ok {
+			prepare, err = h.ComStmtPrepare(c, query)
 		}
-		if err != nil {
-			if werr := c.writeErrorPacketFromError(err); werr != nil {
-				// If we can't even write the error, we're done.
-				log.Errorf("Error writing query error to %s: %v", c, werr)
-				return werr
-			}
-			return nil
+		if err == nil && prepare == nil {
+			prepare = &PrepareData{}
+			prepare.ParamsCount, err = c.countPrepareParams(query)
 		}
-		if remainder != "" {
-			err := fmt.Errorf("can not prepare multiple statements")
+		if err != nil {
 			if werr := c.writeErrorPacketFromError(err); werr != nil {
 				// If we can't even write the error, we're done.
 				log.Errorf("Error writing query error to %s: %v", c, werr)
@@ -1112,27 +1456,21 @@ func (c *Conn) handleNextCommand(handler Handler) error {
 			}
 			return nil
 		}
-
-		paramsCount := uint16(0)
-		_ = sqlparser.Walk(func(node sqlparser.SQLNode) (bool, error) {
-			switch node := node.(type) {
-			case *sqlparser.SQLVal:
-				if strings.HasPrefix(string(node.Val), ":v") {
-					paramsCount++
-				}
-			}
-			return true, nil
-		}, statement)
-
-		if paramsCount > 0 {
-			prepare.ParamsCount = paramsCount
-			prepare.ParamsType = make([]int32, paramsCount)
-			prepare.BindVars = make(map[string]*querypb.BindVariable, paramsCount)
+		prepare.StatementID = c.StatementID
+		prepare.PrepareStmt = query
+		if prepare.ParamsCount > 0 {
+			prepare.ParamsType = make([]int32, prepare.ParamsCount)
+			prepare.BindVars = make(map[string]*querypb.BindVariable, prepare.ParamsCount)
 		}
 
 		c.PrepareData[c.StatementID] = prepare
 
-		fld, err := handler.ComPrepare(c, query)
+		ctx, cancel := c.queryContext()
+		defer cancel()
+		fld, err := handler.ComPrepare(ctx, c, query)
+		if queryTimedOut(ctx, err) {
+			err = c.newQueryTimeoutError()
+		}
 		if err != nil {
 			if werr := c.writeErrorPacketFromError(err); werr != nil {
 				// If we can't even write the error, we're done.
@@ -1142,18 +1480,30 @@ func (c *Conn) handleNextCommand(handler Handler) error {
 			return nil
 		}
 
+		// The parameter and column definitions are coalesced.
+		c.startWriterBuffering()
 		if err := c.writePrepare(fld, c.PrepareData[c.StatementID]); err != nil {
 			return err
 		}
+		if err := c.flush(); err != nil {
+			log.Errorf("Conn %v: Flush() failed: %v", c.ID(), err)
+			return err
+		}
 	case ComStmtExecute:
-		// outstanding cursor, error
 		if c.cs != nil {
-			log.Error("Received ComStmtExecute with outstanding cursor")
-			if werr := c.writeErrorPacket(ERUnknownComError, SSUnknownComError, "error handling packet: %v", data); werr != nil {
-				log.Error("Error writing error packet to client: %v", werr)
-				return werr
+			// Executing the statement of the open cursor again
+			// closes it, as MySQL does. The cursor of another
+			// statement is an error, as we only support one.
+			if stmtID, _, _ := readUint32(data, 1); stmtID != c.cs.stmtID {
+				log.Error("Received ComStmtExecute with outstanding cursor")
+				c.recycleReadPacket()
+				if werr := c.writeErrorPacket(ERUnknownComError, SSUnknownComError, "error handling packet: statement %v has an open cursor", c.cs.stmtID); werr != nil {
+					log.Error("Error writing error packet to client: %v", werr)
+					return werr
+				}
+				return nil
 			}
-			return nil
+			c.discardCursor()
 		}
 
 		// flush is called at the end of this block.
@@ -1172,12 +1522,20 @@ func (c *Conn) handleNextCommand(handler Handler) error {
 			return c.flush()
 		}
 
+		defer func() {
+			c.QueryAttributes = nil
+		}()
 		if stmtID != uint32(0) {
 			defer func() {
 				// Allocate a new bindvar map every time since VTGate.Execute() mutates it.
 				prepare := c.PrepareData[stmtID]
 				prepare.BindVars = make(map[string]*querypb.BindVariable, prepare.ParamsCount)
 			}()
+			if h, ok := handler.(StmtExecuteLogHandler); ok {
+				if prepare, ok := c.PrepareData[stmtID]; ok {
+					h.ComStmtExecuteLog(c, prepare.PrepareStmt, prepare.BindVars)
+				}
+			}
 		}
 		queryStart := time.Now()
 
@@ -1186,18 +1544,24 @@ func (c *Conn) handleNextCommand(handler Handler) error {
 		}
 
 		timings.Record(queryTimingKey, queryStart)
+		c.countQuestion(queryStart)
 		if err := c.flush(); err != nil {
 			log.Errorf("Conn %v: Flush() failed: %v", c.ID(), err)
 			return err
 		}
 	case ComStmtSendLongData:
 		stmtID, paramID, chunkData, ok := c.parseComStmtSendLongData(data)
-		c.recycleReadPacket()
 		if !ok {
 			err := fmt.Errorf("error parsing statement send long data from client %v, returning error: %v", c.ConnectionID, data)
+			c.recycleReadPacket()
 			log.Error(err.Error())
 			return err
 		}
+		// chunkData points into the packet, so it is copied before the
+		// packet goes back to the pool.
+		chunk := make([]byte, len(chunkData))
+		copy(chunk, chunkData)
+		c.recycleReadPacket()
 
 		prepare, ok := c.PrepareData[stmtID]
 		if !ok {
@@ -1214,9 +1578,6 @@ func (c *Conn) handleNextCommand(handler Handler) error {
 			return err
 		}
 
-		chunk := make([]byte, len(chunkData))
-		copy(chunk, chunkData)
-
 		key := fmt.Sprintf("v%d", paramID+1)
 		if val, ok := prepare.BindVars[key]; ok {
 			val.Value = append(val.Value, chunk...)
@@ -1227,9 +1588,16 @@ func (c *Conn) handleNextCommand(handler Handler) error {
 		stmtID, ok := c.parseComStmtClose(data)
 		c.recycleReadPacket()
 		if ok {
-			delete(c.PrepareData, stmtID)
+			// MySQL does not reply to COM_STMT_CLOSE, even for
+			// unknown statements.
+			if _, ok := c.PrepareData[stmtID]; ok {
+				if h, ok := handler.(StmtCloseHandler); ok {
+					h.ComStmtClosed(c, stmtID)
+				}
+				delete(c.PrepareData, stmtID)
+			}
+			c.discardStatementCursor(stmtID)
 		}
-		c.discardCursor()
 	case ComStmtReset:
 		stmtID, ok := c.parseComStmtReset(data)
 		c.recycleReadPacket()
@@ -1239,24 +1607,28 @@ func (c *Conn) handleNextCommand(handler Handler) error {
 				log.Error("Error writing error packet to client: %v", err)
 				return err
 			}
+			return nil
 		}
 
 		prepare, ok := c.PrepareData[stmtID]
 		if !ok {
-			log.Error("Commands were executed in an improper order from client %v, packet: %v", c.ConnectionID, data)
-			if werr := c.writeErrorPacket(CRCommandsOutOfSync, SSUnknownComError, "commands were executed in an improper order: %v", data); werr != nil {
-				log.Error("Error writing error packet to client: %v", err)
+			log.Errorf("Got ComStmtReset for unknown statement %v from client %v", stmtID, c.ConnectionID)
+			if werr := c.writeErrorPacket(ERUnknownStmtHandler, SSUnknownSQLState, "Unknown prepared statement handler (%v) given to mysqld_stmt_reset", stmtID); werr != nil {
+				log.Error("Error writing error packet to client: %v", werr)
 				return werr
 			}
+			return nil
 		}
 
+		// Drop the long data sent with ComStmtSendLongData, so the
+		// next chunks start from scratch. The bind variables must be
+		// removed, not set to nil, as ComStmtSendLongData appends to
+		// the existing ones.
 		if prepare.BindVars != nil {
-			for k := range prepare.BindVars {
-				prepare.BindVars[k] = nil
-			}
+			prepare.BindVars = make(map[string]*querypb.BindVariable, prepare.ParamsCount)
 		}
 
-		c.discardCursor()
+		c.discardStatementCursor(stmtID)
 
 		if err := c.writeOKPacket(0, 0, c.StatusFlags, 0); err != nil {
 			log.Error("Error writing ComStmtReset OK packet to client %v: %v", c.ConnectionID, err)
@@ -1334,16 +1706,22 @@ func (c *Conn) handleNextCommand(handler Handler) error {
 			log.Errorf("Conn %v: Flush() failed: %v", c.ID(), err)
 			return err
 		}
+	case ComChangeUser:
+		if err := c.handleComChangeUser(handler, data); err != nil {
+			return err
+		}
+
 	case ComResetConnection:
 		// Clean up and reset the connection
 		c.recycleReadPacket()
 		c.discardCursor()
+		// The prepared statements are all closed.
+		c.closeStatements(handler)
+		c.resetSessionState()
 		handler.ComResetConnection(c)
-		// Reset prepared statements
-		c.PrepareData = make(map[uint32]*PrepareData)
-		err = c.writeOKPacket(0, 0, 0, 0)
-		if err != nil {
-			c.writeErrorPacketFromError(err)
+		if err := c.writeOKPacket(0, 0, c.StatusFlags, 0); err != nil {
+			log.Errorf("Error writing ComResetConnection result to %s: %v", c, err)
+			return err
 		}
 
 	default:
@@ -1383,6 +1761,57 @@ func (c *Conn) discardCursor() {
 	c.cs = nil
 }
 
+// discardStatementCursor discards the cursor, if it is the one of the
+// stmtID prepared statement.
+func (c *Conn) discardStatementCursor(stmtID uint32) {
+	if c.cs != nil && c.cs.stmtID == stmtID {
+		c.discardCursor()
+	}
+}
+
+// countCommand updates the per-command stats for a command received
+// by this server connection.
+func (c *Conn) countCommand(cmd byte) {
+	name := CommandName(cmd)
+	commandCount.Add(name, 1)
+	if c.listener != nil {
+		c.listener.commandCount.Add(name, 1)
+	}
+}
+
+// closeStatements tells the handler, if it is a StmtCloseHandler, that
+// all the prepared statements are closed, before a reset of the
+// session.
+func (c *Conn) closeStatements(handler Handler) {
+	h, ok := handler.(StmtCloseHandler)
+	if !ok {
+		return
+	}
+	for stmtID := range c.PrepareData {
+		h.ComStmtClosed(c, stmtID)
+	}
+}
+
+// resetSessionState restores the session state tracked by the connection
+// to its defaults. It is used when handling COM_RESET_CONNECTION: the schema
+// name and character set are cleared, transient status flags are dropped,
+// ServerStatusAutocommit is set back to its value after Handler.NewConnection
+// and all prepared statements are released. Other flags set by the Handler
+// are kept, and so are the negotiated capabilities and the compression codec
+// in use: they belong to the connection.
+func (c *Conn) resetSessionState() {
+	c.schemaName = ""
+	c.CharacterSet = c.handshakeCharacterSet
+	if c.CharacterSet == 0 {
+		c.CharacterSet = CharacterSetUtf8
+	}
+	c.characterSetResults = ""
+	c.characterSetResultsNull = false
+	c.StatusFlags &^= ServerInTransaction | ServerMoreResultsExists | ServerCursorExists | ServerCursorLastRowSent | ServerStatusAutocommit
+	c.StatusFlags |= c.initialStatusFlags & ServerStatusAutocommit
+	c.PrepareData = make(map[uint32]*PrepareData)
+}
+
 // formatID returns a quoted identifier from the one given. Adapted from ast.go
 func formatID(original string) string {
 	var sb strings.Builder
@@ -1403,8 +1832,25 @@ func (c *Conn) execQuery(query string, handler Handler, multiStatements bool) (s
 	fieldSent := false
 	// sendFinished is set if the response should just be an OK packet.
 	sendFinished := false
+	// pendingOK is the result of a statement without fields in a
+	// multi-statement query. Its OK packet is only written once the
+	// handler returned, when it is known if more statements follow.
+	var pendingOK *sqltypes.Result
+
+	// Progress reports can only be sent before the first result.
+	c.progressAllowed = true
+	defer func() {
+		c.progressAllowed = false
+	}()
+
+	ctx, cancel := c.queryContext()
+	defer cancel()
 
 	resultsCB := func(qr *sqltypes.Result, more bool) error {
+		if ctx.Err() != nil {
+			return c.newQueryTimeoutError()
+		}
+		c.progressAllowed = false
 		flag := c.StatusFlags
 		if more {
 			flag |= ServerMoreResultsExists
@@ -1426,11 +1872,11 @@ func (c *Conn) execQuery(query string, handler Handler, multiStatements bool) (s
 				// We should not send any more packets after this, but make sure
 				// to extract the affected rows and last insert id from the result
 				// struct here since clients expect it.
-				if qr.Info != "" {
-					return c.writeOKPacketWithInfo(qr.RowsAffected, qr.InsertID, flag, handler.WarningCount(c), qr.Info)
-				} else {
-					return c.writeOKPacket(qr.RowsAffected, qr.InsertID, flag, handler.WarningCount(c))
+				if multiStatements && !more {
+					pendingOK = qr
+					return nil
 				}
+				return c.writeQueryOKPacket(qr, flag, handler)
 			}
 			if err := c.writeFields(qr); err != nil {
 				return err
@@ -1444,9 +1890,39 @@ func (c *Conn) execQuery(query string, handler Handler, multiStatements bool) (s
 	var remainder string
 
 	if multiStatements {
-		remainder, err = handler.ComMultiQuery(c, query, resultsCB)
+		remainder, err = handler.ComMultiQuery(ctx, c, query, resultsCB)
 	} else {
-		err = handler.ComQuery(c, query, resultsCB)
+		err = handler.ComQuery(ctx, c, query, resultsCB)
+	}
+	timedOut := queryTimedOut(ctx, err)
+	if timedOut {
+		err = c.newQueryTimeoutError()
+	}
+	if err == nil {
+		// The statement that was run is the query up to the remainder.
+		statement := query
+		if len(remainder) <= len(query) {
+			statement = query[:len(query)-len(remainder)]
+		}
+		c.trackCharacterSetResults(statement)
+	}
+	if strings.TrimSpace(remainder) == "" {
+		remainder = ""
+	}
+	if pendingOK != nil {
+		if err != nil {
+			// The OK packet was not sent, send the error instead.
+			fieldSent = false
+		} else {
+			flag := c.StatusFlags
+			if remainder != "" {
+				flag |= ServerMoreResultsExists
+			}
+			if err := c.writeQueryOKPacket(pendingOK, flag, handler); err != nil {
+				log.Errorf("Error writing result to %s: %v", c, err)
+				return "", err
+			}
+		}
 	}
 
 	// If no field was sent, we expect an error.
@@ -1460,7 +1936,20 @@ func (c *Conn) execQuery(query string, handler Handler, multiStatements bool) (s
 			log.Errorf("Error writing query error to %s: %v", c, werr)
 			return "", werr
 		}
+		// The client stops reading at the error, the rest of a
+		// multi-statement query is not run.
+		return "", nil
 	} else {
+		if timedOut {
+			// The client reads the error in place of the next
+			// row. The rest of a multi-statement query is not
+			// run, like after any error.
+			if werr := c.writeErrorPacketFromError(err); werr != nil {
+				log.Errorf("Error writing query error to %s: %v", c, werr)
+				return "", werr
+			}
+			return "", nil
+		}
 		if err != nil {
 			// We can't send an error in the middle of a stream.
 			// All we can do is abort the send, which will cause a 2013.
@@ -1483,6 +1972,112 @@ func (c *Conn) execQuery(query string, handler Handler, multiStatements bool) (s
 	return remainder, nil
 }
 
+// writeQueryOKPacket writes the OK packet ending a query without a
+// result set, with the affected rows and last insert id of qr, since
+// clients expect them.
+func (c *Conn) writeQueryOKPacket(qr *sqltypes.Result, flags uint16, handler Handler) error {
+	if h, ok := handler.(SessionStateHandler); ok && c.Capabilities&CapabilityClientSessionTrack != 0 {
+		if changes := h.SessionStateChanges(c); !changes.IsEmpty() {
+			return c.writeOKPacketWithSessionState(qr.RowsAffected, qr.InsertID, flags, handler.WarningCount(c), qr.Info, changes)
+		}
+	}
+	if qr.Info != "" {
+		return c.writeOKPacketWithInfo(qr.RowsAffected, qr.InsertID, flags, handler.WarningCount(c), qr.Info)
+	}
+	return c.writeOKPacket(qr.RowsAffected, qr.InsertID, flags, handler.WarningCount(c))
+}
+
+// CharacterSetResults returns the character_set_results session
+// variable, as the client last set it with SET character_set_results,
+// SET NAMES or SET CHARACTER SET: the character set the result strings
+// should be sent in. null is true if the client set it to NULL, to get
+// the strings as they are stored, without any conversion. charset is ""
+// and null false if the client didn't set it, or set it back to its
+// default: the character set of the connection applies.
+func (c *Conn) CharacterSetResults() (charset string, null bool) {
+	return c.characterSetResults, c.characterSetResultsNull
+}
+
+// trackCharacterSetResults updates the character_set_results session
+// variable if statement, which the handler ran successfully, sets it.
+// Only the statements starting with SET are parsed. The values that
+// are not a character set name, NULL or DEFAULT, like user variables,
+// are ignored.
+func (c *Conn) trackCharacterSetResults(statement string) {
+	stripped := strings.TrimSpace(sqlparser.StripLeadingComments(statement))
+	if len(stripped) < 3 || !strings.EqualFold(stripped[:3], "set") {
+		return
+	}
+	stmt, err := sqlparser.Parse(statement)
+	if err != nil {
+		return
+	}
+	set, ok := stmt.(*sqlparser.Set)
+	if !ok {
+		return
+	}
+	for _, expr := range set.Exprs {
+		if expr.Scope != sqlparser.SetScope_None && expr.Scope != sqlparser.SetScope_Session {
+			continue
+		}
+		switch strings.ToLower(expr.Name.Name.String()) {
+		case "character_set_results", "names", "charset":
+		default:
+			continue
+		}
+		switch val := expr.Expr.(type) {
+		case *sqlparser.NullVal:
+			c.characterSetResults, c.characterSetResultsNull = "", true
+		case *sqlparser.Default:
+			c.characterSetResults, c.characterSetResultsNull = "", false
+		case *sqlparser.SQLVal:
+			if val.Type == sqlparser.StrVal {
+				c.characterSetResults, c.characterSetResultsNull = strings.ToLower(string(val.Val)), false
+			}
+		case *sqlparser.ColName:
+			if val.Qualifier.IsEmpty() && !strings.HasPrefix(val.Name.String(), "@") {
+				c.characterSetResults, c.characterSetResultsNull = val.Name.Lowered(), false
+			}
+		}
+	}
+}
+
+// countPrepareParams parses query, which is being prepared, and
+// returns its number of parameters.
+func (c *Conn) countPrepareParams(query string) (uint16, error) {
+	var err error
+	var statement sqlparser.Statement
+	var remainder string
+
+	if !c.DisableClientMultiStatements && c.Capabilities&CapabilityClientMultiStatements != 0 {
+		var ri int
+		statement, ri, err = sqlparser.ParseOne(query)
+		if ri < len(query) {
+			remainder = query[ri:]
+		}
+	} else {
+		statement, err = sqlparser.Parse(query)
+	}
+	if err != nil {
+		return 0, err
+	}
+	if remainder != "" {
+		return 0, fmt.Errorf("can not prepare multiple statements")
+	}
+
+	paramsCount := uint16(0)
+	_ = sqlparser.Walk(func(node sqlparser.SQLNode) (bool, error) {
+		switch node := node.(type) {
+		case *sqlparser.SQLVal:
+			if strings.HasPrefix(string(node.Val), ":v") {
+				paramsCount++
+			}
+		}
+		return true, nil
+	}, statement)
+	return paramsCount, nil
+}
+
 // execPrepareStatement runs the query identified by the statement ID, and writes the expected packets to the connection
 // If the client requests that a cursor be opened, we should only write the fields, and wait for subsequent fetch
 // requests to write the rows from the result set.
@@ -1492,7 +2087,12 @@ func (c *Conn) execPrepareStatement(stmtID uint32, cursorType byte, handler Hand
 		fieldSent := false
 		sendFinished := false // sendFinished is set if the response should just be an OK packet.
 
-		err := handler.ComStmtExecute(c, prepare, func(qr *sqltypes.Result) error {
+		ctx, cancel := c.queryContext()
+		defer cancel()
+		err := handler.ComStmtExecute(ctx, c, prepare, func(qr *sqltypes.Result) error {
+			if ctx.Err() != nil {
+				return c.newQueryTimeoutError()
+			}
 			if sendFinished {
 				// Failsafe: Unreachable if server is well-behaved.
 				return io.EOF
@@ -1511,6 +2111,10 @@ func (c *Conn) execPrepareStatement(stmtID uint32, cursorType byte, handler Hand
 			}
 			return c.writeBinaryRows(qr)
 		})
+		timedOut := queryTimedOut(ctx, err)
+		if timedOut {
+			err = c.newQueryTimeoutError()
+		}
 
 		// If no field was sent, we expect an error.
 		if !fieldSent {
@@ -1524,6 +2128,15 @@ func (c *Conn) execPrepareStatement(stmtID uint32, cursorType byte, handler Hand
 				return werr
 			}
 		} else {
+			if timedOut {
+				// The client reads the error in place of the
+				// next row.
+				if werr := c.writeErrorPacketFromError(err); werr != nil {
+					log.Errorf("Error writing query error to %s: %v", c, werr)
+					return werr
+				}
+				return nil
+			}
 			// We can't send an error in the middle of a stream.
 			// All we can do is abort the send, which will cause a 2013.
 			if err != nil {
@@ -1558,7 +2171,7 @@ func (c *Conn) execPrepareStatement(stmtID uint32, cursorType byte, handler Hand
 				done <- err
 				close(done)
 			}()
-			err = handler.ComStmtExecute(c, prepare, func(qr *sqltypes.Result) error {
+			err = handler.ComStmtExecute(context.Background(), c, prepare, func(qr *sqltypes.Result) error {
 				// block until query results are sent or receive signal to quit
 				var qerr error
 				select {
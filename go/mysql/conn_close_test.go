@@ -0,0 +1,226 @@
+/*
+Copyright 2019 The Vitess Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mysql
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// closeCountingHandler is a testHandler that records its connections,
+// and counts how many times each of them was reported closed.
+type closeCountingHandler struct {
+	testHandler
+
+	closeMu sync.Mutex
+	conns   chan *Conn
+	closed  map[*Conn]int
+}
+
+func newCloseCountingHandler() *closeCountingHandler {
+	return &closeCountingHandler{
+		conns:  make(chan *Conn, 1),
+		closed: make(map[*Conn]int),
+	}
+}
+
+func (th *closeCountingHandler) NewConnection(c *Conn) {
+	th.testHandler.NewConnection(c)
+	th.conns <- c
+}
+
+func (th *closeCountingHandler) ConnectionClosed(c *Conn) {
+	th.closeMu.Lock()
+	defer th.closeMu.Unlock()
+	th.closed[c]++
+}
+
+func (th *closeCountingHandler) closedCount(c *Conn) int {
+	th.closeMu.Lock()
+	defer th.closeMu.Unlock()
+	return th.closed[c]
+}
+
+func TestCloseTwice(t *testing.T) {
+	listener, sConn, cConn := createSocketPair(t)
+	defer func() {
+		listener.Close()
+		cConn.Close()
+	}()
+
+	if sConn.IsClosed() {
+		t.Fatalf("new connection is closed")
+	}
+	sConn.Close()
+	sConn.Close()
+	if !sConn.IsClosed() {
+		t.Errorf("connection is not closed after Close")
+	}
+	if got := connState(sConn.state.Get()); got != connClosed {
+		t.Errorf("got state %v after Close, want connClosed", got)
+	}
+}
+
+func TestWriteAfterClose(t *testing.T) {
+	for _, buffered := range []bool{false, true} {
+		listener, sConn, cConn, counter := createCountingSocketPair(t)
+		if buffered {
+			sConn.startWriterBuffering()
+		}
+		sConn.Close()
+
+		if err := sConn.writeOKPacket(0, 0, 0, 0); err == nil && !buffered {
+			t.Errorf("writeOKPacket after Close should have failed")
+		}
+		if err := sConn.flush(); err == nil && buffered {
+			t.Errorf("flush after Close should have failed")
+		}
+		if writes := atomic.LoadInt64(&counter.writes); writes != 0 {
+			t.Errorf("got %v writes to the socket after Close, want 0", writes)
+		}
+
+		listener.Close()
+		cConn.Close()
+	}
+}
+
+// closeTrigger is one of the ways a server connection can be torn
+// down while it is serving queries.
+type closeTrigger struct {
+	name string
+	fire func(l *Listener, sConn, cConn *Conn)
+}
+
+var closeTriggers = []closeTrigger{{
+	name: "kill",
+	fire: func(l *Listener, sConn, cConn *Conn) {
+		sConn.Close()
+	},
+}, {
+	name: "disconnect",
+	fire: func(l *Listener, sConn, cConn *Conn) {
+		cConn.Close()
+	},
+}, {
+	name: "timeout",
+	fire: func(l *Listener, sConn, cConn *Conn) {
+		sConn.Conn.SetDeadline(time.Now())
+	},
+}, {
+	name: "shutdown",
+	fire: func(l *Listener, sConn, cConn *Conn) {
+		l.Shutdown()
+		l.CloseConnections()
+	},
+}}
+
+// TestConcurrentClose fires each pair of teardown triggers at the same
+// time while queries are running, and checks the handler is told about
+// the connection closing exactly once. It is most useful with -race.
+func TestConcurrentClose(t *testing.T) {
+	for i, first := range closeTriggers {
+		for _, second := range closeTriggers[i:] {
+			first, second := first, second
+			t.Run(first.name+"+"+second.name, func(t *testing.T) {
+				testConcurrentClose(t, first, second)
+			})
+		}
+	}
+}
+
+func testConcurrentClose(t *testing.T, first, second closeTrigger) {
+	th := newCloseCountingHandler()
+	authServer := NewAuthServerStatic("", "", 0)
+	authServer.entries["user1"] = []*AuthServerStaticEntry{{
+		Password: "password1",
+	}}
+	l, err := NewListener("tcp", ":0", authServer, th, 0, 0)
+	if err != nil {
+		t.Fatalf("NewListener failed: %v", err)
+	}
+	defer l.Close()
+	go l.Accept()
+
+	host, port := getHostPort(t, l.Addr())
+	params := &ConnParams{
+		Host:  host,
+		Port:  port,
+		Uname: "user1",
+		Pass:  "password1",
+	}
+	cConn, err := Connect(context.Background(), params)
+	if err != nil {
+		t.Fatalf("Connect failed: %v", err)
+	}
+	defer cConn.Close()
+	sConn := <-th.conns
+
+	// Run queries until the connection goes away.
+	var queries int64
+	workloadDone := make(chan struct{})
+	go func() {
+		defer close(workloadDone)
+		for {
+			if _, err := cConn.ExecuteFetch("select rows", 10000, true); err != nil {
+				return
+			}
+			atomic.AddInt64(&queries, 1)
+		}
+	}()
+	for atomic.LoadInt64(&queries) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	wg := sync.WaitGroup{}
+	start := make(chan struct{})
+	for _, trigger := range []closeTrigger{first, second} {
+		wg.Add(1)
+		go func(trigger closeTrigger) {
+			defer wg.Done()
+			<-start
+			trigger.fire(l, sConn, cConn)
+		}(trigger)
+	}
+	close(start)
+	wg.Wait()
+
+	// The server side may be blocked writing a result the client
+	// stopped reading, closing the client unblocks it.
+	cConn.Close()
+	select {
+	case <-workloadDone:
+	case <-time.After(10 * time.Second):
+		t.Fatalf("queries still running after %v and %v", first.name, second.name)
+	}
+
+	deadline := time.Now().Add(10 * time.Second)
+	for th.closedCount(sConn) == 0 || !sConn.IsClosed() {
+		if time.Now().After(deadline) {
+			t.Fatalf("connection not torn down after %v and %v", first.name, second.name)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	// Give a second notification a chance to show up.
+	time.Sleep(10 * time.Millisecond)
+	if got := th.closedCount(sConn); got != 1 {
+		t.Errorf("ConnectionClosed called %v times, want 1", got)
+	}
+	sConn.Close()
+}
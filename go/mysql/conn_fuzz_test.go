@@ -0,0 +1,222 @@
+/*
+Copyright 2019 The Vitess Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mysql
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dolthub/vitess/go/sqltypes"
+
+	querypb "github.com/dolthub/vitess/go/vt/proto/query"
+)
+
+// fuzzOutputLimit is the most bytes a server connection may write in
+// response to a fuzzed input.
+const fuzzOutputLimit = 1 << 20
+
+// fuzzCapabilities are the client capabilities the first 4 bytes of a
+// fuzzed input can set. They change how the commands are parsed and
+// answered.
+const fuzzCapabilities = CapabilityClientDeprecateEOF |
+	CapabilityClientFoundRows |
+	CapabilityClientSessionTrack |
+	CapabilityClientMultiStatements |
+	CapabilityClientQueryAttributes
+
+var fuzzAddr = &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 3306}
+
+// fuzzNetConn is a net.Conn reading a fuzzed input, and recording what
+// is written up to fuzzOutputLimit.
+type fuzzNetConn struct {
+	in       *bytes.Reader
+	out      bytes.Buffer
+	overflow bool
+}
+
+func (fc *fuzzNetConn) Read(b []byte) (int, error) {
+	return fc.in.Read(b)
+}
+
+func (fc *fuzzNetConn) Write(b []byte) (int, error) {
+	if fc.out.Len()+len(b) > fuzzOutputLimit {
+		fc.overflow = true
+		return 0, errors.New("output limit exceeded")
+	}
+	return fc.out.Write(b)
+}
+
+func (fc *fuzzNetConn) Close() error                       { return nil }
+func (fc *fuzzNetConn) LocalAddr() net.Addr                { return fuzzAddr }
+func (fc *fuzzNetConn) RemoteAddr() net.Addr               { return fuzzAddr }
+func (fc *fuzzNetConn) SetDeadline(t time.Time) error      { return nil }
+func (fc *fuzzNetConn) SetReadDeadline(t time.Time) error  { return nil }
+func (fc *fuzzNetConn) SetWriteDeadline(t time.Time) error { return nil }
+
+// fuzzHandler is a testHandler answering every query and statement
+// with fuzzResult, or with an OK packet for the "insert" ones.
+type fuzzHandler struct {
+	testHandler
+}
+
+var fuzzResult = &sqltypes.Result{
+	Fields: []*querypb.Field{
+		{Name: "id", Type: querypb.Type_INT64},
+		{Name: "name", Type: querypb.Type_VARCHAR},
+	},
+	Rows: [][]sqltypes.Value{
+		{sqltypes.NewInt64(1), sqltypes.NewVarChar("a")},
+		{sqltypes.NewInt64(2), sqltypes.NULL},
+	},
+	RowsAffected: 2,
+}
+
+func (th *fuzzHandler) result(query string) *sqltypes.Result {
+	if strings.HasPrefix(query, "insert") {
+		return &sqltypes.Result{RowsAffected: 1}
+	}
+	return fuzzResult
+}
+
+func (th *fuzzHandler) ComMultiQuery(ctx context.Context, c *Conn, query string, callback func(res *sqltypes.Result, more bool) error) (string, error) {
+	return "", th.ComQuery(ctx, c, query, callback)
+}
+
+func (th *fuzzHandler) ComQuery(ctx context.Context, c *Conn, query string, callback func(res *sqltypes.Result, more bool) error) error {
+	return callback(th.result(query), false)
+}
+
+func (th *fuzzHandler) ComPrepare(ctx context.Context, c *Conn, query string) ([]*querypb.Field, error) {
+	return th.result(query).Fields, nil
+}
+
+func (th *fuzzHandler) ComStmtExecute(ctx context.Context, c *Conn, prepare *PrepareData, callback func(*sqltypes.Result) error) error {
+	return callback(th.result(prepare.PrepareStmt))
+}
+
+// fuzzInput returns a fuzzed input made of capabilities and of one
+// packet per payload.
+func fuzzInput(capabilities uint32, payloads ...[]byte) []byte {
+	data := binary.LittleEndian.AppendUint32(nil, capabilities)
+	for _, payload := range payloads {
+		data = append(data, byte(len(payload)), byte(len(payload)>>8), byte(len(payload)>>16), 0)
+		data = append(data, payload...)
+	}
+	return data
+}
+
+// fuzzSeeds are valid command sequences, as the other tests send them.
+func fuzzSeeds() [][]byte {
+	query := func(q string) []byte { return append([]byte{ComQuery}, q...) }
+	stmt := func(cmd byte, stmtID uint32, rest ...byte) []byte {
+		return append(binary.LittleEndian.AppendUint32([]byte{cmd}, stmtID), rest...)
+	}
+	execute := stmt(ComStmtExecute, 1, NoCursor, 1, 0, 0, 0, 0x00, 0x01, byte(TypeLongLong), 0, 7, 0, 0, 0, 0, 0, 0, 0)
+	executeCursor := stmt(ComStmtExecute, 1, ReadOnly, 1, 0, 0, 0, 0x00, 0x01, byte(TypeVarString), 0, 1, 'a')
+	attributes := append([]byte{ComQuery}, queryAttributesPacket()...)
+	changeUser := append([]byte{ComChangeUser}, "user1\x00\x00db1\x00"...)
+	changeUser = append(changeUser, CharacterSetUtf8, 0)
+	changeUser = append(changeUser, "mysql_native_password\x00"...)
+
+	return [][]byte{
+		fuzzInput(0, query("select 1"), []byte{ComPing}, []byte{ComQuit}),
+		fuzzInput(fuzzCapabilities, query("select 1; insert into t values (1)"), query("insert into t values (2)")),
+		fuzzInput(0, append([]byte{ComInitDB}, "db1"...), append([]byte{ComFieldList}, "t\x00"...)),
+		fuzzInput(0, append([]byte{ComPrepare}, "select ?"...), execute, execute, stmt(ComStmtClose, 1)),
+		fuzzInput(CapabilityClientDeprecateEOF, append([]byte{ComPrepare}, "select ?"...), executeCursor,
+			stmt(ComStmtFetch, 1, 1, 0, 0, 0), executeCursor, stmt(ComStmtFetch, 1, 100, 0, 0, 0)),
+		fuzzInput(0, append([]byte{ComPrepare}, "insert into t values (?)"...),
+			stmt(ComStmtSendLongData, 1, 0, 0, 'a', 'b'), stmt(ComStmtReset, 1), execute),
+		fuzzInput(CapabilityClientQueryAttributes, append(attributes, "select 1"...)),
+		fuzzInput(0, changeUser, []byte{ComResetConnection}, query("select 1")),
+	}
+}
+
+func FuzzServerConn(f *testing.F) {
+	for _, seed := range fuzzSeeds() {
+		f.Add(seed)
+	}
+
+	authServer := NewAuthServerStatic("", "", 0)
+	authServer.entries["user1"] = []*AuthServerStaticEntry{{Password: "password1"}}
+	handler := &fuzzHandler{}
+	l, err := NewListenerWithOptions(
+		WithAddress("tcp", ":0"),
+		WithAuthServer(authServer),
+		WithHandler(handler),
+	)
+	if err != nil {
+		f.Fatalf("NewListener failed: %v", err)
+	}
+	defer l.Close()
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var capabilities uint32
+		if len(data) >= 4 {
+			capabilities = binary.LittleEndian.Uint32(data) & fuzzCapabilities
+			data = data[4:]
+		}
+
+		// The connection is in the state Listener.handle leaves it
+		// in after the handshake.
+		fc := &fuzzNetConn{in: bytes.NewReader(data)}
+		c := newServerConn(fc, l)
+		c.ConnectionID = 1
+		c.User = "user1"
+		c.Capabilities = capabilities
+		handler.NewConnection(c)
+		c.initialStatusFlags = c.StatusFlags
+		for c.handleNextCommand(handler) == nil {
+		}
+		c.flush()
+		c.discardCursor()
+
+		if fc.overflow {
+			t.Fatalf("the connection wrote more than %v bytes", fuzzOutputLimit)
+		}
+		checkFuzzOutput(t, fc.out.Bytes())
+	})
+}
+
+// checkFuzzOutput checks that out is made of whole packets, and that
+// the error packets among them are well-formed.
+func checkFuzzOutput(t *testing.T, out []byte) {
+	t.Helper()
+	for pos := 0; pos < len(out); {
+		if len(out)-pos < 4 {
+			t.Fatalf("truncated packet header at %v: %v", pos, out[pos:])
+		}
+		length := int(uint32(out[pos]) | uint32(out[pos+1])<<8 | uint32(out[pos+2])<<16)
+		pos += 4
+		if len(out)-pos < length {
+			t.Fatalf("truncated packet at %v: %v bytes, want %v", pos, len(out)-pos, length)
+		}
+		payload := out[pos : pos+length]
+		if length > 0 && payload[0] == ErrPacket {
+			if _, ok := ParseErrorPacket(payload).(*SQLError); !ok {
+				t.Fatalf("malformed error packet at %v: %v", pos, payload)
+			}
+		}
+		pos += length
+	}
+}
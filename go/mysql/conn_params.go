@@ -17,6 +17,8 @@ limitations under the License.
 package mysql
 
 import (
+	"io"
+
 	"github.com/dolthub/vitess/go/vt/vttls"
 )
 
@@ -61,6 +63,43 @@ type ConnParams struct {
 	// for informative purposes. It has no programmatic value. Returning this field is
 	// disabled by default.
 	EnableQueryInfo bool
+
+	// ResultLimits caps the work done decoding result sets on this
+	// connection. If nil, DefaultResultLimits is used.
+	ResultLimits *ResultLimits `json:"result_limits,omitempty"`
+
+	// ZstdCompressionLevel is the zstd compression level asked for
+	// with CapabilityClientZstdCompressionAlgorithm, from 1 to 22.
+	// DefaultZstdCompressionLevel is used if it's 0.
+	ZstdCompressionLevel int `json:"zstd_compression_level,omitempty"`
+
+	// ServerPublicKey is the path of a PEM file holding the RSA public
+	// key of the server. The full caching_sha2_password authentication
+	// without TLS encrypts the password with it, instead of asking the
+	// server for its key: this saves a round trip, and pins the key.
+	ServerPublicKey string `json:"server_public_key,omitempty"`
+
+	// LocalInfileAllowList are the files the server may read with
+	// LOAD DATA LOCAL INFILE: a file is sent if its path is one of
+	// them, or is in one of them. The paths are compared once cleaned,
+	// symbolic links are not resolved. CapabilityClientLocalFiles is
+	// only asked for if the list is not empty.
+	LocalInfileAllowList []string `json:"local_infile_allow_list,omitempty"`
+
+	// OpenLocalInfile opens the files of LocalInfileAllowList the
+	// server reads. os.Open is used if it's nil.
+	OpenLocalInfile func(name string) (io.ReadCloser, error) `json:"-"`
+
+	// SemiSyncReplica makes the connection tell the master, once
+	// connected, that it is a semi-sync replica, with
+	// EnableSemiSyncReplica: the binlog events it then streams have
+	// the semi-sync header, and the events whose ack the master asks
+	// for must be acked with WriteSemiSyncAck.
+	SemiSyncReplica bool `json:"semi_sync_replica,omitempty"`
+
+	// PanicOnResultPending makes the client methods panic instead of
+	// returning ErrResultPending, to catch misuses in tests.
+	PanicOnResultPending bool `json:"-"`
 }
 
 // EnableSSL will set the right flag on the parameters.
@@ -86,6 +125,21 @@ func (cp *ConnParams) EnableClientFoundRows() {
 	cp.Flags |= CapabilityClientFoundRows
 }
 
+// EnableCompression sets the flag for CLIENT_COMPRESS. The connection
+// uses the compressed protocol if the server supports it.
+func (cp *ConnParams) EnableCompression() {
+	cp.Flags |= CapabilityClientCompress
+}
+
+// EnableZstdCompression sets the flag for
+// CLIENT_ZSTD_COMPRESSION_ALGORITHM, and the compression level. The
+// connection uses the compressed protocol with zstd if the server
+// supports it.
+func (cp *ConnParams) EnableZstdCompression(level int) {
+	cp.Flags |= CapabilityClientZstdCompressionAlgorithm
+	cp.ZstdCompressionLevel = level
+}
+
 // SslRequired returns whether the connection parameters
 // define that SSL is a requirement. If SslMode is set, it uses
 // that to determine this, if it's not set it falls back to
@@ -19,15 +19,27 @@ package mysql
 import (
 	"bytes"
 	crypto_rand "crypto/rand"
+	"fmt"
 	"math/rand"
 	"net"
 	"reflect"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
+
+	"github.com/dolthub/vitess/go/sqltypes"
+
+	querypb "github.com/dolthub/vitess/go/vt/proto/query"
 )
 
 func createSocketPair(t *testing.T) (net.Listener, *Conn, *Conn) {
+	return createWrappedSocketPair(t, nil)
+}
+
+// createWrappedSocketPair is like createSocketPair, but if wrap is set,
+// both sides of the socket go through it before the Conns are built.
+func createWrappedSocketPair(t testing.TB, wrap func(net.Conn) net.Conn) (net.Listener, *Conn, *Conn) {
 	// Create a listener.
 	listener, err := net.Listen("tcp", ":0")
 	if err != nil {
@@ -64,6 +76,11 @@ func createSocketPair(t *testing.T) (net.Listener, *Conn, *Conn) {
 		t.Fatalf("Accept failed: %v", serverErr)
 	}
 
+	if wrap != nil {
+		clientConn = wrap(clientConn)
+		serverConn = wrap(serverConn)
+	}
+
 	// Create a Conn on both sides.
 	cConn := newConn(clientConn)
 	sConn := newConn(serverConn)
@@ -283,3 +300,249 @@ func TestEOFOrLengthEncodedIntFuzz(t *testing.T) {
 		}
 	}
 }
+
+// countingConn is a net.Conn that counts the calls to Write.
+type countingConn struct {
+	net.Conn
+	writes int64
+	bytes  int64
+}
+
+func (cc *countingConn) Write(data []byte) (int, error) {
+	atomic.AddInt64(&cc.writes, 1)
+	atomic.AddInt64(&cc.bytes, int64(len(data)))
+	return cc.Conn.Write(data)
+}
+
+// createCountingSocketPair is like createSocketPair, but counts the
+// writes of the server side.
+func createCountingSocketPair(t testing.TB) (net.Listener, *Conn, *Conn, *countingConn) {
+	counter := &countingConn{}
+	wrapped := 0
+	listener, sConn, cConn := createWrappedSocketPair(t, func(c net.Conn) net.Conn {
+		// The client side is wrapped first.
+		wrapped++
+		if wrapped == 1 {
+			return c
+		}
+		counter.Conn = c
+		return counter
+	})
+	return listener, sConn, cConn, counter
+}
+
+// manyRowsResult returns a result with count small rows.
+func manyRowsResult(count int) *sqltypes.Result {
+	result := &sqltypes.Result{
+		Fields: []*querypb.Field{
+			{Name: "id", Type: querypb.Type_INT32},
+			{Name: "name", Type: querypb.Type_VARCHAR},
+		},
+	}
+	for i := 0; i < count; i++ {
+		result.Rows = append(result.Rows, []sqltypes.Value{
+			sqltypes.NewInt32(int32(i)),
+			sqltypes.NewVarChar(fmt.Sprintf("row %v", i)),
+		})
+	}
+	return result
+}
+
+// serveResult answers the next query on sConn with result, coalescing
+// the packets like handleNextCommand does, and returns how many writes
+// that took.
+func serveResult(sConn *Conn, counter *countingConn, result *sqltypes.Result) (int64, error) {
+	sConn.resetSequence()
+	if _, err := sConn.ReadPacket(); err != nil {
+		return 0, err
+	}
+	before := atomic.LoadInt64(&counter.writes)
+	sConn.startWriterBuffering()
+	if err := writeResult(sConn, result); err != nil {
+		return 0, err
+	}
+	if err := sConn.flush(); err != nil {
+		return 0, err
+	}
+	return atomic.LoadInt64(&counter.writes) - before, nil
+}
+
+func TestWriteCoalescing(t *testing.T) {
+	result := manyRowsResult(100)
+	for _, tcase := range []struct {
+		bufferSize int
+		minWrites  int64
+		maxWrites  int64
+	}{
+		// The header and the payload of each packet are written
+		// separately: the column count, 2 fields, 1 EOF, 100 rows
+		// and 1 EOF.
+		{bufferSize: -1, minWrites: 2 * 105, maxWrites: 2 * 105},
+		{bufferSize: 0, minWrites: 1, maxWrites: 1},
+		{bufferSize: 256, minWrites: 2, maxWrites: 20},
+	} {
+		t.Run(fmt.Sprintf("buffer size %v", tcase.bufferSize), func(t *testing.T) {
+			listener, sConn, cConn, counter := createCountingSocketPair(t)
+			defer func() {
+				listener.Close()
+				sConn.Close()
+				cConn.Close()
+			}()
+			sConn.writeBufferSize = tcase.bufferSize
+
+			var writes int64
+			var serverErr error
+			wg := sync.WaitGroup{}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				writes, serverErr = serveResult(sConn, counter, result)
+			}()
+			got, err := cConn.ExecuteFetch("select rows", 1000, true)
+			wg.Wait()
+			if serverErr != nil {
+				t.Fatalf("server failed: %v", serverErr)
+			}
+			if err != nil {
+				t.Fatalf("ExecuteFetch failed: %v", err)
+			}
+			if len(got.Rows) != len(result.Rows) {
+				t.Errorf("got %v rows, want %v", len(got.Rows), len(result.Rows))
+			}
+			if writes < tcase.minWrites || writes > tcase.maxWrites {
+				t.Errorf("result took %v writes, want between %v and %v", writes, tcase.minWrites, tcase.maxWrites)
+			}
+		})
+	}
+}
+
+// TestWritePacketCoalescing checks a packet written outside of
+// startWriterBuffering, like the response to an interactive command,
+// is sent right away in one write.
+func TestWritePacketCoalescing(t *testing.T) {
+	for _, tcase := range []struct {
+		bufferSize int
+		writes     int64
+	}{
+		{bufferSize: -1, writes: 2},
+		{bufferSize: 0, writes: 1},
+		{bufferSize: 256, writes: 1},
+	} {
+		t.Run(fmt.Sprintf("buffer size %v", tcase.bufferSize), func(t *testing.T) {
+			listener, sConn, cConn, counter := createCountingSocketPair(t)
+			defer func() {
+				listener.Close()
+				sConn.Close()
+				cConn.Close()
+			}()
+			sConn.writeBufferSize = tcase.bufferSize
+
+			if err := sConn.writeOKPacket(1, 2, 0, 0); err != nil {
+				t.Fatalf("writeOKPacket failed: %v", err)
+			}
+			if writes := atomic.LoadInt64(&counter.writes); writes != tcase.writes {
+				t.Errorf("OK packet took %v writes, want %v", writes, tcase.writes)
+			}
+			data, err := cConn.ReadPacket()
+			if err != nil || data[0] != OKPacket {
+				t.Fatalf("ReadPacket returned %v, %v, want an OK packet", data, err)
+			}
+		})
+	}
+}
+
+// BenchmarkResultWrites reports the writes it takes to send a result
+// of many small rows, with and without coalescing.
+func BenchmarkResultWrites(b *testing.B) {
+	for _, bc := range []struct {
+		rows       int
+		bufferSize int
+	}{
+		{rows: 100, bufferSize: -1},
+		{rows: 100, bufferSize: 0},
+		{rows: 100000, bufferSize: -1},
+		{rows: 100000, bufferSize: 0},
+		{rows: 100000, bufferSize: 64 * 1024},
+	} {
+		result := manyRowsResult(bc.rows)
+		bufferSize := bc.bufferSize
+		b.Run(fmt.Sprintf("%v rows buffer size %v", bc.rows, bufferSize), func(b *testing.B) {
+			listener, sConn, cConn, counter := createCountingSocketPair(b)
+			defer func() {
+				listener.Close()
+				sConn.Close()
+				cConn.Close()
+			}()
+			sConn.writeBufferSize = bufferSize
+
+			var writes int64
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				var n int64
+				var serverErr error
+				wg := sync.WaitGroup{}
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					n, serverErr = serveResult(sConn, counter, result)
+				}()
+				if _, err := cConn.ExecuteFetch("select rows", len(result.Rows), true); err != nil {
+					b.Fatalf("ExecuteFetch failed: %v", err)
+				}
+				wg.Wait()
+				if serverErr != nil {
+					b.Fatalf("server failed: %v", serverErr)
+				}
+				writes += n
+			}
+			b.ReportMetric(float64(writes)/float64(b.N), "writes/op")
+		})
+	}
+}
+
+func TestCapabilities(t *testing.T) {
+	listener, sConn, cConn := createSocketPair(t)
+	defer func() {
+		listener.Close()
+		sConn.Close()
+		cConn.Close()
+	}()
+
+	sConn.Capabilities = CapabilityClientDeprecateEOF
+	sConn.SetClientFoundRows(true)
+	if !sConn.HasCapability(CapabilityClientFoundRows) || !sConn.HasCapability(CapabilityClientDeprecateEOF) {
+		t.Errorf("SetClientFoundRows(true): got capabilities %x", sConn.Capabilities)
+	}
+	sConn.SetClientFoundRows(false)
+	if sConn.HasCapability(CapabilityClientFoundRows) || !sConn.HasCapability(CapabilityClientDeprecateEOF) {
+		t.Errorf("SetClientFoundRows(false): got capabilities %x", sConn.Capabilities)
+	}
+
+	sConn.SetCapability(CapabilityClientMultiStatements | CapabilityClientSessionTrack)
+	if !sConn.HasCapability(CapabilityClientMultiStatements | CapabilityClientSessionTrack) {
+		t.Errorf("SetCapability: got capabilities %x", sConn.Capabilities)
+	}
+	sConn.ClearCapability(CapabilityClientSessionTrack)
+	if sConn.HasCapability(CapabilityClientMultiStatements|CapabilityClientSessionTrack) || !sConn.HasCapability(CapabilityClientMultiStatements) {
+		t.Errorf("ClearCapability: got capabilities %x", sConn.Capabilities)
+	}
+	if want := uint32(CapabilityClientDeprecateEOF | CapabilityClientMultiStatements); sConn.Capabilities != want {
+		t.Errorf("got capabilities %x, want %x", sConn.Capabilities, want)
+	}
+
+	// The capabilities change what is written: the result ends with
+	// an EOF packet, or an OK packet if CapabilityClientDeprecateEOF
+	// is used.
+	for _, deprecateEOF := range []bool{false, true} {
+		if deprecateEOF {
+			sConn.SetCapability(CapabilityClientDeprecateEOF)
+			cConn.SetCapability(CapabilityClientDeprecateEOF)
+		} else {
+			sConn.ClearCapability(CapabilityClientDeprecateEOF)
+			cConn.ClearCapability(CapabilityClientDeprecateEOF)
+		}
+		result := manyRowsResult(3)
+		result.RowsAffected = 3
+		checkQuery(t, fmt.Sprintf("deprecate EOF %v", deprecateEOF), sConn, cConn, result)
+	}
+}
@@ -69,8 +69,10 @@ const (
 	// CLIENT_NO_SCHEMA 1 << 4
 	// Do not permit database.table.column. We do permit it.
 
-	// CLIENT_COMPRESS 1 << 5
-	// We do not support compression. CPU is usually our bottleneck.
+	// CapabilityClientCompress is CLIENT_COMPRESS.
+	// Use the compressed protocol after the handshake. It is only
+	// used if asked for, CPU is usually our bottleneck.
+	CapabilityClientCompress = 1 << 5
 
 	// CLIENT_ODBC 1 << 6
 	// No special behavior since 3.22.
@@ -140,6 +142,16 @@ const (
 	// CapabilityClientDeprecateEOF is CLIENT_DEPRECATE_EOF
 	// Expects an OK (instead of EOF) after the resultset rows of a Text Resultset.
 	CapabilityClientDeprecateEOF = 1 << 24
+
+	// CapabilityClientZstdCompressionAlgorithm is
+	// CLIENT_ZSTD_COMPRESSION_ALGORITHM.
+	// Use the compressed protocol with zstd after the handshake, at
+	// the level the client sends in its handshake response.
+	CapabilityClientZstdCompressionAlgorithm = 1 << 26
+
+	// CapabilityClientQueryAttributes is CLIENT_QUERY_ATTRIBUTES.
+	// COM_QUERY and COM_STMT_EXECUTE can carry query attributes.
+	CapabilityClientQueryAttributes = 1 << 27
 )
 
 // Status flags. They are returned by the server in a few cases.
@@ -161,6 +173,9 @@ const (
 
 	// ServerCursorLastRowSent is SERVER_STATUS_LAST_ROW_SENT
 	ServerCursorLastRowSent = 0x0080
+
+	// ServerSessionStateChanged is SERVER_SESSION_STATE_CHANGED
+	ServerSessionStateChanged = 0x4000
 )
 
 // Cursor Types. They are received on COM_STMT_EXECUTE()
@@ -172,6 +187,12 @@ const (
 	ScrollableCursor
 )
 
+// ParameterCountAvailable is PARAMETER_COUNT_AVAILABLE, a flag sent
+// with the cursor type of COM_STMT_EXECUTE: the parameter count is
+// sent, even if the statement has no parameters. It is only used with
+// CLIENT_QUERY_ATTRIBUTES.
+const ParameterCountAvailable = 0x08
+
 // State Change Information
 const (
 	// one or more system variables changed.
@@ -229,6 +250,9 @@ const (
 	// ComResetConnection is COM_RESET_CONNECTION
 	ComResetConnection = 0x1f
 
+	// ComChangeUser is COM_CHANGE_USER
+	ComChangeUser = 0x11
+
 	// ComBinlogDumpGTID is COM_BINLOG_DUMP_GTID.
 	ComBinlogDumpGTID = 0x1e
 
@@ -247,6 +271,36 @@ const (
 	NullValue = 0xfb
 )
 
+// commandNames maps the packet types a client can send to their
+// MySQL names. It is used to label per-command stats.
+var commandNames = map[byte]string{
+	ComQuit:             "COM_QUIT",
+	ComInitDB:           "COM_INIT_DB",
+	ComQuery:            "COM_QUERY",
+	ComFieldList:        "COM_FIELD_LIST",
+	ComPing:             "COM_PING",
+	ComBinlogDump:       "COM_BINLOG_DUMP",
+	ComPrepare:          "COM_STMT_PREPARE",
+	ComStmtExecute:      "COM_STMT_EXECUTE",
+	ComStmtSendLongData: "COM_STMT_SEND_LONG_DATA",
+	ComStmtClose:        "COM_STMT_CLOSE",
+	ComStmtReset:        "COM_STMT_RESET",
+	ComStmtFetch:        "COM_STMT_FETCH",
+	ComSetOption:        "COM_SET_OPTION",
+	ComResetConnection:  "COM_RESET_CONNECTION",
+	ComChangeUser:       "COM_CHANGE_USER",
+	ComBinlogDumpGTID:   "COM_BINLOG_DUMP_GTID",
+}
+
+// CommandName returns the MySQL name of a command packet type,
+// like COM_QUERY. Unknown types are returned as COM_UNKNOWN.
+func CommandName(cmd byte) string {
+	if name, ok := commandNames[cmd]; ok {
+		return name
+	}
+	return "COM_UNKNOWN"
+}
+
 // Auth packet types
 const (
 	// AuthMoreDataPacket is sent when server requires more data to authenticate
@@ -316,6 +370,16 @@ const (
 
 	// CRMalformedPacket is CR_MALFORMED_PACKET
 	CRMalformedPacket = 2027
+
+	// CRParamsNotBound is CR_PARAMS_NOT_BOUND
+	// Sent when a prepared statement is executed without a value
+	// for each of its parameters.
+	CRParamsNotBound = 2031
+
+	// CRLoadDataLocalInfileRejected is CR_LOAD_DATA_LOCAL_INFILE_REJECTED
+	// Returned when the server asks for a file with LOAD DATA LOCAL
+	// INFILE that the client does not allow.
+	CRLoadDataLocalInfileRejected = 2068
 )
 
 // Error codes return in SQLErrors generated by vitess. These error codes
@@ -323,6 +387,18 @@ const (
 const (
 	// ERVitessMaxRowsExceeded is when a user tries to select more rows than the max rows as enforced by vitess.
 	ERVitessMaxRowsExceeded = 10001
+
+	// ERVitessResultTooManyColumns is when a server announces more columns than ResultLimits.MaxColumns.
+	ERVitessResultTooManyColumns = 10002
+
+	// ERVitessResultTooManyRows is when a server sends more rows than ResultLimits.MaxRows.
+	ERVitessResultTooManyRows = 10003
+
+	// ERVitessResultTooLarge is when a server sends more bytes than ResultLimits.MaxBytes.
+	ERVitessResultTooLarge = 10004
+
+	// ERVitessResultTooManyPackets is when a server sends more packets than ResultLimits.MaxPackets.
+	ERVitessResultTooManyPackets = 10005
 )
 
 // Error codes for server-side errors.
@@ -431,6 +507,7 @@ const (
 
 	// invalid arg
 	ERUnknownComError              = 1047
+	ERMalformedPacket              = 1835
 	ERBadNullError                 = 1048
 	ERBadDb                        = 1049
 	ERBadTable                     = 1051
@@ -506,6 +583,7 @@ const (
 	ERIncorrectGlobalLocalVar      = 1238
 	ERWrongFKDef                   = 1239
 	ERKeyRefDoNotMatchTableRef     = 1240
+	ERUnknownStmtHandler           = 1243
 	ERCyclicReference              = 1245
 	ERIllegalReference             = 1247
 	ERDerivedMustHaveAlias         = 1248
@@ -0,0 +1,202 @@
+/*
+Copyright 2019 The Vitess Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mysql
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"testing"
+
+	"github.com/dolthub/vitess/go/sqltypes"
+
+	querypb "github.com/dolthub/vitess/go/vt/proto/query"
+)
+
+var cursorFields = []*querypb.Field{{Name: "v", Type: querypb.Type_VARCHAR}}
+
+// cursorHandler is a testHandler whose statements return 3 batches of
+// 2 rows, "<execution>-<row>", and which records the executions that
+// were cancelled.
+type cursorHandler struct {
+	testHandler
+
+	mu         sync.Mutex
+	executions int
+	cancelled  []int
+}
+
+func (th *cursorHandler) ComStmtExecute(ctx context.Context, c *Conn, prepare *PrepareData, callback func(*sqltypes.Result) error) error {
+	th.mu.Lock()
+	th.executions++
+	execution := th.executions
+	th.mu.Unlock()
+
+	for batch := 0; batch < 3; batch++ {
+		result := &sqltypes.Result{Fields: cursorFields}
+		for i := 0; i < 2; i++ {
+			result.Rows = append(result.Rows, []sqltypes.Value{sqltypes.NewVarChar(fmt.Sprintf("%d-%d", execution, batch*2+i))})
+		}
+		if err := callback(result); err != nil {
+			th.mu.Lock()
+			th.cancelled = append(th.cancelled, execution)
+			th.mu.Unlock()
+			return err
+		}
+	}
+	return nil
+}
+
+func (th *cursorHandler) cancelledExecutions() []int {
+	th.mu.Lock()
+	defer th.mu.Unlock()
+	return append([]int(nil), th.cancelled...)
+}
+
+func TestCursorReExecute(t *testing.T) {
+	listener, sConn, cConn := createSocketPair(t)
+	defer func() {
+		listener.Close()
+		sConn.Close()
+		cConn.Close()
+	}()
+	sConn.PrepareData = map[uint32]*PrepareData{
+		1: {StatementID: 1, PrepareStmt: "select v from t"},
+		2: {StatementID: 2, PrepareStmt: "select v from u"},
+	}
+	handler := &cursorHandler{}
+
+	// command sends packet, handles it on the server side, and returns
+	// what read reads of the response.
+	command := func(packet []byte, read func() (*sqltypes.Result, error)) (*sqltypes.Result, error) {
+		t.Helper()
+		var result *sqltypes.Result
+		var err error
+		wg := sync.WaitGroup{}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err = writeRawPacketToConn(cConn, packet); err != nil {
+				return
+			}
+			result, err = read()
+		}()
+		if err := sConn.handleNextCommand(handler); err != nil {
+			t.Fatalf("handleNextCommand(%v) failed: %v", packet[0], err)
+		}
+		wg.Wait()
+		return result, err
+	}
+	execute := func(stmtID byte) error {
+		t.Helper()
+		_, err := command([]byte{ComStmtExecute, stmtID, 0, 0, 0, ReadOnly, 1, 0, 0, 0}, func() (*sqltypes.Result, error) {
+			result, status, _, err := cConn.ReadQueryResult(-1, true)
+			if err == nil && (!status.cursorExists() || len(result.Rows) != 0) {
+				err = fmt.Errorf("no cursor was opened: status %v, %v rows", status, len(result.Rows))
+			}
+			return result, err
+		})
+		return err
+	}
+	fetch := func(stmtID byte, numRows byte) ([]string, error) {
+		t.Helper()
+		result, err := command([]byte{ComStmtFetch, stmtID, 0, 0, 0, numRows, 0, 0, 0}, func() (*sqltypes.Result, error) {
+			result, _, _, err := cConn.FetchQueryResult(-1, cursorFields)
+			if err == io.EOF {
+				err = nil
+			}
+			return result, err
+		})
+		if err != nil {
+			return nil, err
+		}
+		var rows []string
+		for _, row := range result.Rows {
+			rows = append(rows, row[0].ToString())
+		}
+		return rows, nil
+	}
+
+	if err := execute(1); err != nil {
+		t.Fatalf("execute failed: %v", err)
+	}
+	rows, err := fetch(1, 3)
+	if err != nil {
+		t.Fatalf("fetch failed: %v", err)
+	}
+	if want := "[1-0 1-1 1-2]"; fmt.Sprint(rows) != want {
+		t.Fatalf("got rows %v, want %v", rows, want)
+	}
+
+	// The cursor of another statement can't be opened meanwhile.
+	if err := execute(2); err == nil {
+		t.Fatalf("execute of another statement with an open cursor succeeded")
+	}
+
+	// Executing the statement again closes its cursor, none of its
+	// rows are left in the new one.
+	if err := execute(1); err != nil {
+		t.Fatalf("execute again failed: %v", err)
+	}
+	if got := handler.cancelledExecutions(); fmt.Sprint(got) != "[1]" {
+		t.Errorf("cancelled executions: %v, want [1]", got)
+	}
+	rows, err = fetch(1, 100)
+	if err != nil {
+		t.Fatalf("fetch failed: %v", err)
+	}
+	if want := "[2-0 2-1 2-2 2-3 2-4 2-5]"; fmt.Sprint(rows) != want {
+		t.Fatalf("got rows %v, want %v", rows, want)
+	}
+	if sConn.cs != nil {
+		t.Errorf("the exhausted cursor is still open")
+	}
+
+	// Resetting another statement keeps the cursor, resetting its
+	// own closes it.
+	if err := execute(1); err != nil {
+		t.Fatalf("execute failed: %v", err)
+	}
+	reset := func(stmtID byte) {
+		t.Helper()
+		_, err := command([]byte{ComStmtReset, stmtID, 0, 0, 0}, func() (*sqltypes.Result, error) {
+			data, err := cConn.ReadPacket()
+			if err == nil && data[0] != OKPacket {
+				err = ParseErrorPacket(data)
+			}
+			return nil, err
+		})
+		if err != nil {
+			t.Fatalf("reset failed: %v", err)
+		}
+	}
+	reset(2)
+	if sConn.cs == nil {
+		t.Fatalf("resetting another statement closed the cursor")
+	}
+	reset(1)
+	if sConn.cs != nil {
+		t.Fatalf("resetting the statement kept its cursor")
+	}
+	if got := handler.cancelledExecutions(); fmt.Sprint(got) != "[1 3]" {
+		t.Errorf("cancelled executions: %v, want [1 3]", got)
+	}
+	if _, err := fetch(1, 1); err == nil {
+		t.Errorf("fetch from a reset statement succeeded")
+	}
+}
@@ -0,0 +1,203 @@
+/*
+Copyright 2019 The Vitess Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mysql
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dolthub/vitess/go/sqltypes"
+
+	querypb "github.com/dolthub/vitess/go/vt/proto/query"
+)
+
+// degradedProfile describes how a degradedConn misbehaves.
+type degradedProfile struct {
+	name string
+
+	// latency is added to every Read and Write.
+	latency time.Duration
+
+	// bytesPerSecond caps the write bandwidth, if non-zero.
+	bytesPerSecond int
+
+	// maxChunk, if non-zero, makes Read return at most that many
+	// bytes, and Write write a random prefix of at most that many
+	// bytes and report a short write, without an error.
+	maxChunk int
+
+	// resetAfter, if non-zero, closes the connection once that
+	// many bytes were written, and fails the write.
+	resetAfter int
+}
+
+var degradedProfiles = []degradedProfile{{
+	name:    "high latency",
+	latency: time.Millisecond,
+}, {
+	name:           "low bandwidth",
+	bytesPerSecond: 1 << 20,
+	maxChunk:       512,
+}, {
+	name:     "short writes",
+	maxChunk: 7,
+}}
+
+var errDegradedReset = errors.New("degradedConn: connection reset")
+
+// degradedConn is a net.Conn that simulates a bad network link.
+type degradedConn struct {
+	net.Conn
+	profile degradedProfile
+
+	mu      sync.Mutex
+	written int
+}
+
+func (dc *degradedConn) Read(p []byte) (int, error) {
+	time.Sleep(dc.profile.latency)
+	if dc.profile.maxChunk > 0 && len(p) > dc.profile.maxChunk {
+		p = p[:rand.Intn(dc.profile.maxChunk)+1]
+	}
+	return dc.Conn.Read(p)
+}
+
+func (dc *degradedConn) Write(p []byte) (int, error) {
+	time.Sleep(dc.profile.latency)
+	if dc.profile.maxChunk > 0 && len(p) > dc.profile.maxChunk {
+		p = p[:rand.Intn(dc.profile.maxChunk)+1]
+	}
+
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+	if reset := dc.profile.resetAfter; reset > 0 && dc.written+len(p) >= reset {
+		n, _ := dc.Conn.Write(p[:reset-dc.written])
+		dc.written += n
+		dc.Conn.Close()
+		return n, errDegradedReset
+	}
+
+	n, err := dc.Conn.Write(p)
+	dc.written += n
+	if dc.profile.bytesPerSecond > 0 {
+		time.Sleep(time.Duration(n) * time.Second / time.Duration(dc.profile.bytesPerSecond))
+	}
+	return n, err
+}
+
+// createDegradedSocketPair returns a socket pair whose both sides
+// misbehave according to profile.
+func createDegradedSocketPair(t *testing.T, profile degradedProfile) (net.Listener, *Conn, *Conn) {
+	return createWrappedSocketPair(t, func(c net.Conn) net.Conn {
+		return &degradedConn{Conn: c, profile: profile}
+	})
+}
+
+func TestDegradedNetworkQueries(t *testing.T) {
+	largeResult := &sqltypes.Result{
+		Fields: []*querypb.Field{
+			{Name: "id", Type: querypb.Type_INT32},
+			{Name: "name", Type: querypb.Type_VARCHAR},
+		},
+	}
+	for i := 0; i < 100; i++ {
+		largeResult.Rows = append(largeResult.Rows, []sqltypes.Value{
+			sqltypes.MakeTrusted(querypb.Type_INT32, []byte(fmt.Sprintf("%v", i))),
+			sqltypes.MakeTrusted(querypb.Type_VARCHAR, []byte(fmt.Sprintf("row number %v", i))),
+		})
+	}
+	largeResult.RowsAffected = uint64(len(largeResult.Rows))
+
+	for _, profile := range degradedProfiles {
+		t.Run(profile.name, func(t *testing.T) {
+			listener, sConn, cConn := createDegradedSocketPair(t, profile)
+			defer func() {
+				listener.Close()
+				sConn.Close()
+				cConn.Close()
+			}()
+
+			checkQuery(t, "insert", sConn, cConn, &sqltypes.Result{
+				RowsAffected: 12,
+				InsertID:     34,
+			})
+			// Use a copy, checkQuery touches the internal state of the fields.
+			checkQuery(t, "select rows", sConn, cConn, selectRowsResult.Copy())
+			checkQuery(t, "large", sConn, cConn, largeResult)
+		})
+	}
+}
+
+func TestDegradedNetworkPreparedStatements(t *testing.T) {
+	for _, profile := range degradedProfiles {
+		t.Run(profile.name, func(t *testing.T) {
+			listener, sConn, cConn := createDegradedSocketPair(t, profile)
+			defer func() {
+				listener.Close()
+				sConn.Close()
+				cConn.Close()
+			}()
+
+			for _, test := range []testExec{
+				{query: "select rows", expectedNumFields: 2, expectedNumRows: 2, maxRows: 100},
+				{query: "large batch", expectedNumFields: 2, expectedNumRows: 256, maxRows: 1000},
+				{query: "large batch", useCursor: 1, expectedNumFields: 2, expectedNumRows: 256, maxRows: 1000},
+			} {
+				checkExecute(t, sConn, cConn, test)
+			}
+		})
+	}
+}
+
+// TestDegradedNetworkResets resets the server side of the connection
+// at various points of a result, and checks both sides fail cleanly.
+func TestDegradedNetworkResets(t *testing.T) {
+	for _, offset := range []int{1, 4, 5, 30, 100} {
+		t.Run(fmt.Sprintf("reset after %v bytes", offset), func(t *testing.T) {
+			listener, sConn, cConn := createSocketPair(t)
+			defer func() {
+				listener.Close()
+				sConn.Close()
+				cConn.Close()
+			}()
+			sConn.Conn = &degradedConn{Conn: sConn.Conn, profile: degradedProfile{resetAfter: offset}}
+
+			var clientErr error
+			wg := sync.WaitGroup{}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, clientErr = cConn.ExecuteFetch("select rows", 10, true)
+			}()
+
+			serverErr := sConn.handleNextCommand(&testHandler{})
+			wg.Wait()
+
+			if serverErr == nil {
+				t.Errorf("server should have failed to send the result")
+			}
+			if clientErr == nil {
+				t.Errorf("client should have failed to read the result")
+			}
+		})
+	}
+}
@@ -251,10 +251,12 @@ func readLenEncString(data []byte, pos int) (string, int, bool) {
 	if !ok {
 		return "", 0, false
 	}
-	s := int(size)
-	if pos+s-1 >= len(data) {
+	// Compare in the unsigned domain, so a bogus length can't
+	// overflow int and slip past the check.
+	if size > uint64(len(data)-pos) {
 		return "", 0, false
 	}
+	s := int(size)
 	return string(data[pos : pos+s]), pos + s, true
 }
 
@@ -263,10 +265,10 @@ func skipLenEncString(data []byte, pos int) (int, bool) {
 	if !ok {
 		return 0, false
 	}
-	s := int(size)
-	if pos+s-1 >= len(data) {
+	if size > uint64(len(data)-pos) {
 		return 0, false
 	}
+	s := int(size)
 	return pos + s, true
 }
 
@@ -275,10 +277,10 @@ func readLenEncStringAsBytes(data []byte, pos int) ([]byte, int, bool) {
 	if !ok {
 		return nil, 0, false
 	}
-	s := int(size)
-	if pos+s-1 >= len(data) {
+	if size > uint64(len(data)-pos) {
 		return nil, 0, false
 	}
+	s := int(size)
 	return data[pos : pos+s], pos + s, true
 }
 
@@ -287,10 +289,10 @@ func readLenEncStringAsBytesCopy(data []byte, pos int) ([]byte, int, bool) {
 	if !ok {
 		return nil, 0, false
 	}
-	s := int(size)
-	if pos+s-1 >= len(data) {
+	if size > uint64(len(data)-pos) {
 		return nil, 0, false
 	}
+	s := int(size)
 	result := make([]byte, size)
 	copy(result, data[pos:pos+s])
 	return result, pos + s, true
@@ -20,7 +20,6 @@ package mysql
 import (
 	"context"
 	"fmt"
-	"io"
 	"time"
 
 	"github.com/dolthub/vitess/go/vt/proto/vtrpc"
@@ -169,15 +168,9 @@ func (mariadbFlavor) waitUntilPositionCommand(ctx context.Context, pos Position)
 
 // readBinlogEvent is part of the Flavor interface.
 func (mariadbFlavor) readBinlogEvent(c *Conn) (BinlogEvent, error) {
-	result, err := c.ReadPacket()
+	event, err := c.ReadRawBinlogEvent()
 	if err != nil {
 		return nil, err
 	}
-	switch result[0] {
-	case EOFPacket:
-		return nil, NewSQLError(CRServerLost, SSUnknownSQLState, "%v", io.EOF)
-	case ErrPacket:
-		return nil, ParseErrorPacket(result)
-	}
-	return NewMariadbBinlogEvent(result[1:]), nil
+	return NewMariadbBinlogEvent(event), nil
 }
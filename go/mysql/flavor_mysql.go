@@ -19,7 +19,6 @@ package mysql
 import (
 	"context"
 	"fmt"
-	"io"
 	"time"
 
 	"github.com/dolthub/vitess/go/vt/proto/vtrpc"
@@ -139,17 +138,11 @@ func (mysqlFlavor) waitUntilPositionCommand(ctx context.Context, pos Position) (
 
 // readBinlogEvent is part of the Flavor interface.
 func (mysqlFlavor) readBinlogEvent(c *Conn) (BinlogEvent, error) {
-	result, err := c.ReadPacket()
+	event, err := c.ReadRawBinlogEvent()
 	if err != nil {
 		return nil, err
 	}
-	switch result[0] {
-	case EOFPacket:
-		return nil, NewSQLError(CRServerLost, SSUnknownSQLState, "%v", io.EOF)
-	case ErrPacket:
-		return nil, ParseErrorPacket(result)
-	}
-	return NewMysql56BinlogEvent(result[1:]), nil
+	return NewMysql56BinlogEvent(event), nil
 }
 
 // enableBinlogPlaybackCommand is part of the Flavor interface.
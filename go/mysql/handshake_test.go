@@ -90,6 +90,76 @@ func TestClearTextClientAuth(t *testing.T) {
 	conn.writeComQuit()
 }
 
+// TestClearTextClientWithoutPluginAuth connects to a server that wants
+// clear text passwords with a client that does not support auth method
+// switches. It can only send a native password scramble, which the
+// server checks instead.
+func TestClearTextClientWithoutPluginAuth(t *testing.T) {
+	th := &testHandler{}
+
+	authServer := NewAuthServerStatic("", "", 0)
+	authServer.Method = MysqlClearPassword
+	authServer.entries["user1"] = []*AuthServerStaticEntry{
+		{Password: "password1"},
+	}
+
+	l, err := NewListener("tcp", ":0", authServer, th, 0, 0)
+	if err != nil {
+		t.Fatalf("NewListener failed: %v", err)
+	}
+	defer l.Close()
+	go func() {
+		l.Accept()
+	}()
+
+	// connect runs the handshake without CLIENT_PLUGIN_AUTH, and returns
+	// the first byte of the server response.
+	connect := func(password string) byte {
+		conn, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			t.Fatalf("Dial failed: %v", err)
+		}
+		c := newConn(conn)
+		defer c.Close()
+
+		data, err := c.readPacket()
+		if err != nil {
+			t.Fatalf("readPacket failed: %v", err)
+		}
+		_, salt, err := c.parseInitialHandshakePacket(data)
+		if err != nil {
+			t.Fatalf("parseInitialHandshakePacket failed: %v", err)
+		}
+
+		scrambledPassword := ScramblePassword(salt, []byte(password))
+		flags := uint32(CapabilityClientLongPassword | CapabilityClientProtocol41 | CapabilityClientSecureConnection | CapabilityClientTransactions)
+		response := make([]byte, 4+4+1+23+len("user1")+1+1+len(scrambledPassword))
+		pos := writeUint32(response, 0, flags)
+		pos = writeUint32(response, pos, uint32(MaxPacketSize))
+		pos = writeByte(response, pos, CharacterSetUtf8)
+		pos = writeZeroes(response, pos, 23)
+		pos = writeNullString(response, pos, "user1")
+		pos = writeByte(response, pos, byte(len(scrambledPassword)))
+		copy(response[pos:], scrambledPassword)
+		if err := c.writePacket(response); err != nil {
+			t.Fatalf("writePacket failed: %v", err)
+		}
+
+		data, err = c.readPacket()
+		if err != nil {
+			t.Fatalf("readPacket failed: %v", err)
+		}
+		return data[0]
+	}
+
+	if got := connect("password1"); got != OKPacket {
+		t.Errorf("got packet type %v with the right password, want OK", got)
+	}
+	if got := connect("bad"); got != ErrPacket {
+		t.Errorf("got packet type %v with a bad password, want an error", got)
+	}
+}
+
 // TestSSLConnection creates a server with TLS support, a client that
 // also has SSL support, and connects them.
 func TestSSLConnection(t *testing.T) {
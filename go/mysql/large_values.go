@@ -0,0 +1,397 @@
+/*
+Copyright 2019 The Vitess Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mysql
+
+import (
+	"io"
+	"math"
+
+	"github.com/dolthub/vitess/go/sqltypes"
+	"github.com/dolthub/vitess/go/vt/proto/vtrpc"
+	"github.com/dolthub/vitess/go/vt/vterrors"
+)
+
+// This file contains the support for values too large to be held in
+// memory, like a multi-GB LONGBLOB.
+//
+// On the server side, a row can contain stream values (see
+// sqltypes.NewStreamValue). The row packet is then written as the
+// values are read, cut into MaxPacketSize chunks, instead of being
+// built in a single buffer.
+//
+// On the client side, FetchNextWithBlobs reads a row one value at a
+// time, and passes the values above a size threshold as stream values
+// reading straight from the connection. At most one MaxPacketSize
+// chunk is held in memory.
+
+// writeStreamRow writes a row that contains stream values.
+//
+// A stream value whose reader returns fewer or more bytes than its
+// declared length is an error. The row packet is then incomplete, and
+// the connection can't be used anymore.
+func (c *Conn) writeStreamRow(row []sqltypes.Value) error {
+	var length int64
+	for _, val := range row {
+		length += rowValueSize(val)
+	}
+
+	pw := &packetWriter{c: c, w: c.getWriter(), left: length}
+	var scratch [9]byte
+	for _, val := range row {
+		switch {
+		case val.IsNull():
+			scratch[0] = NullValue
+			if _, err := pw.Write(scratch[:1]); err != nil {
+				return err
+			}
+		case val.IsStream():
+			r, l := val.Stream()
+			pos := writeLenEncInt(scratch[:], 0, uint64(l))
+			if _, err := pw.Write(scratch[:pos]); err != nil {
+				return err
+			}
+			if err := copyStreamValue(pw, r, l); err != nil {
+				return err
+			}
+		default:
+			pos := writeLenEncInt(scratch[:], 0, uint64(len(val.Raw())))
+			if _, err := pw.Write(scratch[:pos]); err != nil {
+				return err
+			}
+			if _, err := pw.Write(val.Raw()); err != nil {
+				return err
+			}
+		}
+	}
+	return pw.finish()
+}
+
+// copyStreamValue copies exactly length bytes from r to w, and checks
+// r has nothing more to return.
+func copyStreamValue(w io.Writer, r io.Reader, length int64) error {
+	n, err := io.CopyN(w, r, length)
+	if err == io.EOF {
+		return vterrors.Errorf(vtrpc.Code_INVALID_ARGUMENT, "stream value returned %v bytes, but declared a length of %v", n, length)
+	}
+	if err != nil {
+		return err
+	}
+
+	var extra [1]byte
+	switch _, err := io.ReadFull(r, extra[:]); err {
+	case io.EOF:
+		return nil
+	case nil:
+		return vterrors.Errorf(vtrpc.Code_INVALID_ARGUMENT, "stream value returned more than its declared length of %v bytes", length)
+	default:
+		return err
+	}
+}
+
+// rowValueSize returns the size of a value in a text protocol row
+// packet.
+func rowValueSize(val sqltypes.Value) int64 {
+	if val.IsNull() {
+		return 1
+	}
+	if val.IsStream() {
+		_, l := val.Stream()
+		return int64(lenEncIntSize(uint64(l))) + l
+	}
+	l := len(val.Raw())
+	return int64(lenEncIntSize(uint64(l)) + l)
+}
+
+// packetWriter writes a packet of a known length as it is produced,
+// cutting it into MaxPacketSize chunks like writePacket does.
+type packetWriter struct {
+	c *Conn
+	w io.Writer
+
+	// left is the number of bytes of the packet not written yet.
+	left int64
+
+	// chunkLeft is the number of bytes left in the current chunk,
+	// and chunkSize the size of the last chunk started.
+	chunkLeft int
+	chunkSize int
+}
+
+// Write is part of the io.Writer interface.
+func (pw *packetWriter) Write(data []byte) (int, error) {
+	written := 0
+	for len(data) > 0 {
+		if pw.chunkLeft == 0 {
+			if pw.left == 0 {
+				return written, vterrors.Errorf(vtrpc.Code_INTERNAL, "packet is longer than its declared length")
+			}
+			size := MaxPacketSize
+			if pw.left < int64(size) {
+				size = int(pw.left)
+			}
+			if err := pw.writeHeader(size); err != nil {
+				return written, err
+			}
+			pw.chunkLeft = size
+			pw.chunkSize = size
+		}
+
+		chunk := data
+		if len(chunk) > pw.chunkLeft {
+			chunk = chunk[:pw.chunkLeft]
+		}
+		n, err := pw.w.Write(chunk)
+		written += n
+		pw.chunkLeft -= n
+		pw.left -= int64(n)
+		if err != nil {
+			return written, vterrors.Wrapf(err, "Write(packet) failed")
+		}
+		data = data[n:]
+	}
+	return written, nil
+}
+
+// finish checks the whole packet was written, and terminates it.
+func (pw *packetWriter) finish() error {
+	if pw.left != 0 {
+		return vterrors.Errorf(vtrpc.Code_INTERNAL, "packet is %v bytes shorter than its declared length", pw.left)
+	}
+	if pw.chunkSize == MaxPacketSize {
+		// The last chunk had exactly MaxPacketSize size, we
+		// need to send a zero-size chunk too.
+		return pw.writeHeader(0)
+	}
+	return nil
+}
+
+func (pw *packetWriter) writeHeader(size int) error {
+	var header [4]byte
+	header[0] = byte(size)
+	header[1] = byte(size >> 8)
+	header[2] = byte(size >> 16)
+	header[3] = pw.c.sequence
+	if _, err := pw.w.Write(header[:]); err != nil {
+		return vterrors.Wrapf(err, "Write(header) failed")
+	}
+	pw.c.sequence++
+	return nil
+}
+
+// FetchNextWithBlobs reads the next row of an ongoing streaming query,
+// like FetchNext, and calls fn with each of its values, in column
+// order. Values of at least threshold bytes are passed as stream values
+// reading straight from the connection (see sqltypes.NewStreamValue).
+// Their reader is only valid until fn returns, and whatever fn did not
+// read from it is discarded.
+//
+// It returns false if there is nothing more to read. If reading the
+// row or fn fails, the connection is closed, as the rest of the row
+// can't be skipped.
+// Returns a SQLError.
+func (c *Conn) FetchNextWithBlobs(threshold int64, fn func(column int, value sqltypes.Value) error) (bool, error) {
+	if err := c.startResultRead(); err != nil {
+		return false, err
+	}
+	defer c.endCommand()
+
+	if c.fields == nil {
+		// We are already done, and the result was closed.
+		return false, NewSQLError(CRCommandsOutOfSync, SSUnknownSQLState, "no streaming query in progress")
+	}
+
+	if len(c.fields) == 0 {
+		// We received no fields, so there is no data.
+		return false, nil
+	}
+
+	data, err := c.readOnePacket()
+	if err != nil {
+		return false, NewSQLError(CRServerLost, SSUnknownSQLState, "%v", err)
+	}
+
+	if len(data) < MaxPacketSize {
+		if isEOFPacket(data) {
+			// Warnings and status flags are ignored.
+			c.fields = nil
+			return false, nil
+		} else if isErrorPacket(data) {
+			// It ends the result.
+			c.fields = nil
+			return false, ParseErrorPacket(data)
+		}
+	}
+
+	pr := &packetReader{c: c, data: data, more: len(data) == MaxPacketSize}
+	if err := c.readStreamRow(pr, threshold, fn); err != nil {
+		c.fields = nil
+		return false, c.abortResult(err)
+	}
+	if err := c.streamBudget.addRow(int(pr.read)); err != nil {
+		c.fields = nil
+		return false, c.abortResult(err)
+	}
+	return true, nil
+}
+
+// readStreamRow reads the values of a row packet from pr, and passes
+// them to fn.
+func (c *Conn) readStreamRow(pr *packetReader, threshold int64, fn func(column int, value sqltypes.Value) error) error {
+	for i, field := range c.fields {
+		length, isNull, err := pr.readLenEncInt()
+		if err != nil {
+			return err
+		}
+		if isNull {
+			if err := fn(i, sqltypes.NULL); err != nil {
+				return err
+			}
+			continue
+		}
+		if length > math.MaxInt64 {
+			return NewSQLError(CRMalformedPacket, SSUnknownSQLState, "value length %v is too large", length)
+		}
+
+		if int64(length) < threshold {
+			val := make([]byte, length)
+			if _, err := io.ReadFull(pr, val); err != nil {
+				return truncatedRowError(err)
+			}
+			if err := fn(i, sqltypes.MakeTrusted(field.Type, val)); err != nil {
+				return err
+			}
+			continue
+		}
+
+		vr := &valueReader{pr: pr, left: int64(length)}
+		if err := fn(i, sqltypes.NewStreamValue(field.Type, vr, int64(length))); err != nil {
+			return err
+		}
+		// Discard what fn did not read.
+		if _, err := io.Copy(io.Discard, vr); err != nil {
+			return truncatedRowError(err)
+		}
+	}
+
+	// This also reads the zero-size chunk that follows a last chunk
+	// of exactly MaxPacketSize.
+	var extra [1]byte
+	if n, err := pr.Read(extra[:]); n != 0 {
+		return NewSQLError(CRMalformedPacket, SSUnknownSQLState, "row has more data than its %v values", len(c.fields))
+	} else if err != io.EOF {
+		return err
+	}
+	return nil
+}
+
+// truncatedRowError returns the error for a failed read of a row value.
+func truncatedRowError(err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return NewSQLError(CRMalformedPacket, SSUnknownSQLState, "row packet is truncated")
+	}
+	return err
+}
+
+// packetReader reads a packet that may span several MaxPacketSize
+// chunks, without holding more than one chunk in memory.
+type packetReader struct {
+	c *Conn
+
+	// data is the unread part of the current chunk.
+	data []byte
+
+	// more is set if another chunk follows the current one.
+	more bool
+
+	// read is the number of bytes read so far.
+	read int64
+}
+
+// Read is part of the io.Reader interface. It returns io.EOF at the
+// end of the packet.
+func (pr *packetReader) Read(p []byte) (int, error) {
+	for len(pr.data) == 0 {
+		if !pr.more {
+			return 0, io.EOF
+		}
+		next, err := pr.c.readOnePacket()
+		if err != nil {
+			return 0, NewSQLError(CRServerLost, SSUnknownSQLState, "%v", err)
+		}
+		pr.data = next
+		pr.more = len(next) == MaxPacketSize
+	}
+
+	n := copy(p, pr.data)
+	pr.data = pr.data[n:]
+	pr.read += int64(n)
+	return n, nil
+}
+
+// readLenEncInt reads a length-encoded integer, or a NULL value.
+func (pr *packetReader) readLenEncInt() (uint64, bool, error) {
+	var buf [8]byte
+	if _, err := io.ReadFull(pr, buf[:1]); err != nil {
+		return 0, false, truncatedRowError(err)
+	}
+
+	var size int
+	switch buf[0] {
+	case NullValue:
+		return 0, true, nil
+	case 0xfc:
+		size = 2
+	case 0xfd:
+		size = 3
+	case 0xfe:
+		size = 8
+	default:
+		return uint64(buf[0]), false, nil
+	}
+
+	if _, err := io.ReadFull(pr, buf[:size]); err != nil {
+		return 0, false, truncatedRowError(err)
+	}
+	var value uint64
+	for i := size - 1; i >= 0; i-- {
+		value = value<<8 | uint64(buf[i])
+	}
+	return value, false, nil
+}
+
+// valueReader reads a value of a known length from a packetReader.
+type valueReader struct {
+	pr   *packetReader
+	left int64
+}
+
+// Read is part of the io.Reader interface. It returns
+// io.ErrUnexpectedEOF if the packet ends before the value.
+func (vr *valueReader) Read(p []byte) (int, error) {
+	if vr.left == 0 {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > vr.left {
+		p = p[:vr.left]
+	}
+	n, err := vr.pr.Read(p)
+	vr.left -= int64(n)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
@@ -0,0 +1,259 @@
+/*
+Copyright 2019 The Vitess Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mysql
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/dolthub/vitess/go/sqltypes"
+
+	querypb "github.com/dolthub/vitess/go/vt/proto/query"
+)
+
+// patternReader returns n bytes of a repeating pattern, without
+// holding them in memory.
+type patternReader struct {
+	pos, n int64
+}
+
+func (pr *patternReader) Read(p []byte) (int, error) {
+	if pr.pos == pr.n {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > pr.n-pr.pos {
+		p = p[:pr.n-pr.pos]
+	}
+	for i := range p {
+		p[i] = byte((pr.pos + int64(i)) % 251)
+	}
+	pr.pos += int64(len(p))
+	return len(p), nil
+}
+
+// checkPattern checks r returns exactly the n bytes of a patternReader.
+func checkPattern(r io.Reader, n int64) error {
+	want := &patternReader{n: n}
+	got := make([]byte, 64*1024)
+	expected := make([]byte, len(got))
+	var read int64
+	for {
+		count, err := r.Read(got)
+		if count > 0 {
+			io.ReadFull(want, expected[:count])
+			if !bytes.Equal(got[:count], expected[:count]) {
+				return fmt.Errorf("wrong data at offset %v", read)
+			}
+			read += int64(count)
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if read != n {
+		return fmt.Errorf("read %v bytes, want %v", read, n)
+	}
+	return nil
+}
+
+var largeValueFields = []*querypb.Field{
+	{Name: "id", Type: querypb.Type_INT32},
+	{Name: "data", Type: querypb.Type_BLOB},
+	{Name: "name", Type: querypb.Type_VARCHAR},
+}
+
+// serveLargeValue answers the next query on sConn with a single row
+// holding a stream value of the given declared length, read from r.
+func serveLargeValue(sConn *Conn, r io.Reader, length int64) error {
+	if _, err := sConn.ReadPacket(); err != nil {
+		return err
+	}
+	result := &sqltypes.Result{
+		Fields: largeValueFields,
+		Rows: [][]sqltypes.Value{{
+			sqltypes.NewInt32(1),
+			sqltypes.NewStreamValue(querypb.Type_BLOB, r, length),
+			sqltypes.NewVarChar("after"),
+		}},
+	}
+	if err := sConn.writeFields(result); err != nil {
+		return err
+	}
+	if err := sConn.writeRows(result); err != nil {
+		return err
+	}
+	return sConn.writeEndResult(false, 0, 0, 0)
+}
+
+func TestLargeValues(t *testing.T) {
+	// The row holds the id, the length and the data of the blob, and
+	// the name.
+	rowOverhead := int64(2 + 9 + 6)
+	for _, length := range []int64{
+		10,
+		1000,
+		MaxPacketSize,
+		// The row is exactly 2 chunks, and needs a zero-size chunk.
+		2*MaxPacketSize - rowOverhead,
+		3*MaxPacketSize + 1000,
+	} {
+		t.Run(fmt.Sprintf("%v bytes", length), func(t *testing.T) {
+			listener, sConn, cConn := createSocketPair(t)
+			defer func() {
+				listener.Close()
+				sConn.Close()
+				cConn.Close()
+			}()
+
+			var serverErr error
+			wg := sync.WaitGroup{}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				serverErr = serveLargeValue(sConn, &patternReader{n: length}, length)
+			}()
+
+			if err := cConn.ExecuteStreamFetch("select large"); err != nil {
+				t.Fatalf("ExecuteStreamFetch failed: %v", err)
+			}
+			var values []string
+			ok, err := cConn.FetchNextWithBlobs(MaxPacketSize, func(column int, value sqltypes.Value) error {
+				if column != 1 {
+					values = append(values, value.String())
+					return nil
+				}
+				if length < MaxPacketSize {
+					if value.IsStream() {
+						return fmt.Errorf("got a stream value for %v bytes", length)
+					}
+					return checkPattern(bytes.NewReader(value.Raw()), length)
+				}
+				r, l := value.Stream()
+				if !value.IsStream() || l != length {
+					return fmt.Errorf("got value %v, want a stream of %v bytes", value, length)
+				}
+				return checkPattern(r, length)
+			})
+			if !ok || err != nil {
+				t.Fatalf("FetchNextWithBlobs returned %v, %v", ok, err)
+			}
+			if got, want := strings.Join(values, ","), `INT32(1),VARCHAR("after")`; got != want {
+				t.Errorf("got values %v, want %v", got, want)
+			}
+			if ok, err := cConn.FetchNextWithBlobs(MaxPacketSize, nil); ok || err != nil {
+				t.Errorf("FetchNextWithBlobs at the end returned %v, %v", ok, err)
+			}
+			wg.Wait()
+			if serverErr != nil {
+				t.Fatalf("server failed: %v", serverErr)
+			}
+		})
+	}
+}
+
+func TestLargeValueRegularClient(t *testing.T) {
+	listener, sConn, cConn := createSocketPair(t)
+	defer func() {
+		listener.Close()
+		sConn.Close()
+		cConn.Close()
+	}()
+
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if err := serveLargeValue(sConn, strings.NewReader("large value"), 11); err != nil {
+			t.Errorf("server failed: %v", err)
+		}
+	}()
+
+	// The streamed row is a regular row for other clients.
+	result, err := cConn.ExecuteFetch("select large", 10, true)
+	wg.Wait()
+	if err != nil {
+		t.Fatalf("ExecuteFetch failed: %v", err)
+	}
+	want := [][]sqltypes.Value{{
+		sqltypes.NewInt32(1),
+		sqltypes.MakeTrusted(querypb.Type_BLOB, []byte("large value")),
+		sqltypes.NewVarChar("after"),
+	}}
+	if got := fmt.Sprintf("%v", result.Rows); got != fmt.Sprintf("%v", want) {
+		t.Errorf("got rows %v, want %v", got, want)
+	}
+}
+
+func TestLargeValueLengthMismatch(t *testing.T) {
+	for _, tcase := range []struct {
+		name   string
+		actual int64
+	}{
+		{"shorter", MaxPacketSize + 10},
+		{"longer", MaxPacketSize + 30},
+	} {
+		t.Run(tcase.name, func(t *testing.T) {
+			listener, sConn, cConn := createSocketPair(t)
+			defer func() {
+				listener.Close()
+				sConn.Close()
+				cConn.Close()
+			}()
+
+			var serverErr error
+			wg := sync.WaitGroup{}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				serverErr = serveLargeValue(sConn, &patternReader{n: tcase.actual}, MaxPacketSize+20)
+				// The row packet is incomplete, the client needs
+				// to see the connection go away.
+				sConn.Close()
+			}()
+
+			if err := cConn.ExecuteStreamFetch("select large"); err != nil {
+				t.Fatalf("ExecuteStreamFetch failed: %v", err)
+			}
+			_, clientErr := cConn.FetchNextWithBlobs(1000, func(column int, value sqltypes.Value) error {
+				if value.IsStream() {
+					r, _ := value.Stream()
+					_, err := io.Copy(io.Discard, r)
+					return err
+				}
+				return nil
+			})
+			wg.Wait()
+
+			if serverErr == nil || !strings.Contains(serverErr.Error(), "declared") {
+				t.Errorf("got server error %v, want a declared length error", serverErr)
+			}
+			if clientErr == nil {
+				t.Errorf("client should have failed to read the row")
+			}
+			if !cConn.IsClosed() {
+				t.Errorf("client connection should be closed after %v", clientErr)
+			}
+		})
+	}
+}
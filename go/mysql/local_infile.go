@@ -0,0 +1,93 @@
+/*
+Copyright 2019 The Vitess Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mysql
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// This file contains the client side of LOAD DATA LOCAL INFILE. The
+// server side is Conn.LoadInfile.
+
+// localInfilePacketSize is the size of the packets the content of a
+// local file is sent in.
+const localInfilePacketSize = 16 * 1024
+
+// localInfileAllowed returns whether the server may read name with
+// LOAD DATA LOCAL INFILE: it must be one of the files of the allow
+// list, or be in one of its directories.
+func (c *Conn) localInfileAllowed(name string) bool {
+	name = filepath.Clean(name)
+	for _, allowed := range c.localInfileAllowList {
+		allowed = filepath.Clean(allowed)
+		if name == allowed || strings.HasPrefix(name, strings.TrimSuffix(allowed, string(filepath.Separator))+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// sendLocalInfile answers the LOAD DATA LOCAL INFILE request of the
+// server for name: it sends the content of the file, and an empty
+// packet to end it. If the file is not allowed, or can't be read, the
+// empty packet is sent right away, or after what was read, and rejected
+// says why. The server answers the query either way.
+// err is a generic error, returned if writing to the server failed.
+func (c *Conn) sendLocalInfile(name string) (rejected error, err error) {
+	var f io.ReadCloser
+	if c.Capabilities&CapabilityClientLocalFiles == 0 || !c.localInfileAllowed(name) {
+		rejected = NewSQLError(CRLoadDataLocalInfileRejected, SSUnknownSQLState, "LOAD DATA LOCAL INFILE file request rejected due to restrictions on access: %v", name)
+	} else {
+		open := c.openLocalInfile
+		if open == nil {
+			open = func(name string) (io.ReadCloser, error) { return os.Open(name) }
+		}
+		if f, err = open(name); err != nil {
+			rejected = NewSQLError(CRUnknownError, SSUnknownSQLState, "cannot open LOAD DATA LOCAL INFILE file %v: %v", name, err)
+		} else {
+			defer f.Close()
+		}
+	}
+
+	c.startWriterBuffering()
+	defer c.flush()
+	if f != nil {
+		buf := make([]byte, localInfilePacketSize)
+		for {
+			n, readErr := f.Read(buf)
+			if n > 0 {
+				if err := c.writePacket(buf[:n]); err != nil {
+					return nil, err
+				}
+			}
+			if readErr == io.EOF {
+				break
+			}
+			if readErr != nil {
+				rejected = NewSQLError(CRUnknownError, SSUnknownSQLState, "cannot read LOAD DATA LOCAL INFILE file %v: %v", name, readErr)
+				break
+			}
+		}
+	}
+	if err := c.writePacket(nil); err != nil {
+		return nil, err
+	}
+	return rejected, c.flush()
+}
@@ -0,0 +1,164 @@
+/*
+Copyright 2019 The Vitess Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mysql
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dolthub/vitess/go/sqltypes"
+)
+
+// localInfileHandler is a testHandler answering "load <file>" by
+// reading the file from the client with LoadInfile, and returning its
+// size as the rows affected.
+type localInfileHandler struct {
+	testHandler
+
+	mu       sync.Mutex
+	contents []string
+}
+
+func (th *localInfileHandler) ComMultiQuery(ctx context.Context, c *Conn, query string, callback func(*sqltypes.Result, bool) error) (string, error) {
+	return "", th.ComQuery(ctx, c, query, callback)
+}
+
+func (th *localInfileHandler) ComQuery(ctx context.Context, c *Conn, query string, callback func(*sqltypes.Result, bool) error) error {
+	if !strings.HasPrefix(query, "load ") {
+		return th.testHandler.ComQuery(ctx, c, query, callback)
+	}
+	r, err := c.LoadInfile(strings.TrimPrefix(query, "load "))
+	if err != nil {
+		return err
+	}
+	content, err := io.ReadAll(r)
+	if closeErr := r.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	th.mu.Lock()
+	th.contents = append(th.contents, string(content))
+	th.mu.Unlock()
+	return callback(&sqltypes.Result{RowsAffected: uint64(len(content))}, false)
+}
+
+func (th *localInfileHandler) lastContent() string {
+	th.mu.Lock()
+	defer th.mu.Unlock()
+	return th.contents[len(th.contents)-1]
+}
+
+func TestLocalInfile(t *testing.T) {
+	large := bytes.Repeat([]byte("0123456789abcdef\n"), 3*localInfilePacketSize/17)
+	files := map[string]string{
+		"/data/empty.csv": "",
+		"/data/small.csv": "1,a\n2,b\n",
+		"/data/large.csv": string(large),
+		"/etc/passwd":     "root",
+	}
+	handler := &localInfileHandler{}
+	conn := connectResultPending(t, handler, ConnParams{
+		LocalInfileAllowList: []string{"/data"},
+		OpenLocalInfile: func(name string) (io.ReadCloser, error) {
+			content, ok := files[name]
+			if !ok {
+				return nil, os.ErrNotExist
+			}
+			return io.NopCloser(strings.NewReader(content)), nil
+		},
+	})
+	require.NotZero(t, conn.Capabilities&CapabilityClientLocalFiles)
+
+	for _, name := range []string{"/data/empty.csv", "/data/small.csv", "/data/large.csv"} {
+		result, err := conn.ExecuteFetch("load "+name, 10, false)
+		require.NoError(t, err, name)
+		assert.Equal(t, uint64(len(files[name])), result.RowsAffected, name)
+		assert.Equal(t, files[name], handler.lastContent(), name)
+	}
+
+	// The files that are not allowed, or can't be opened, are refused:
+	// the server gets an empty file.
+	_, err := conn.ExecuteFetch("load /etc/passwd", 10, false)
+	assertSQLError(t, err, CRLoadDataLocalInfileRejected, SSUnknownSQLState, "rejected due to restrictions on access", "load /etc/passwd")
+	assert.Equal(t, "", handler.lastContent())
+	_, err = conn.ExecuteFetch("load /data/../etc/passwd", 10, false)
+	assertSQLError(t, err, CRLoadDataLocalInfileRejected, SSUnknownSQLState, "rejected due to restrictions on access", "load /data/../etc/passwd")
+	_, err = conn.ExecuteFetch("load /data/missing.csv", 10, false)
+	assertSQLError(t, err, CRUnknownError, SSUnknownSQLState, "file does not exist", "load /data/missing.csv")
+
+	// The connection is still in sync.
+	result, err := conn.ExecuteFetch("select rows", 10, false)
+	require.NoError(t, err)
+	assert.Equal(t, selectRowsResult.Rows, result.Rows)
+}
+
+func TestLocalInfileFile(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "data.csv")
+	require.NoError(t, os.WriteFile(name, []byte("1,a\n"), 0600))
+
+	handler := &localInfileHandler{}
+	conn := connectResultPending(t, handler, ConnParams{LocalInfileAllowList: []string{name}})
+	result, err := conn.ExecuteFetch("load "+name, 10, false)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(4), result.RowsAffected)
+	assert.Equal(t, "1,a\n", handler.lastContent())
+}
+
+func TestLocalInfileDisabled(t *testing.T) {
+	handler := &localInfileHandler{}
+	conn := connectResultPending(t, handler, ConnParams{})
+	require.Zero(t, conn.Capabilities&CapabilityClientLocalFiles)
+
+	// The server asks for the file anyway, the client refuses it.
+	_, err := conn.ExecuteFetch("load /data/small.csv", 10, false)
+	assertSQLError(t, err, CRLoadDataLocalInfileRejected, SSUnknownSQLState, "rejected due to restrictions on access", "load /data/small.csv")
+	assert.Equal(t, "", handler.lastContent())
+
+	_, err = conn.ExecuteFetch("select rows", 10, false)
+	require.NoError(t, err)
+}
+
+func TestLocalInfileAllowed(t *testing.T) {
+	c := &Conn{localInfileAllowList: []string{"/data/", "/tmp/file.csv", "relative"}}
+	for name, want := range map[string]bool{
+		"/data":               true,
+		"/data/a.csv":         true,
+		"/data/sub/a.csv":     true,
+		"/data/../etc/passwd": false,
+		"/database/a.csv":     false,
+		"/tmp/file.csv":       true,
+		"/tmp/file.csv.bak":   false,
+		"/tmp/other.csv":      false,
+		"relative/a.csv":      true,
+		"./relative/a.csv":    true,
+		"/relative/a.csv":     false,
+	} {
+		assert.Equal(t, want, c.localInfileAllowed(name), name)
+	}
+}
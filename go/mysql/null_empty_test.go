@@ -0,0 +1,299 @@
+/*
+Copyright 2019 The Vitess Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mysql
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/dolthub/vitess/go/sqltypes"
+
+	querypb "github.com/dolthub/vitess/go/vt/proto/query"
+)
+
+// This file checks that NULL and the empty string are never mixed up,
+// whatever the path a value goes through: both are zero bytes long,
+// only the NULL marker (or the NULL-bitmap) tells them apart.
+
+// nullEmptyTypes are the types whose values can be empty.
+var nullEmptyTypes = []querypb.Type{
+	querypb.Type_VARCHAR,
+	querypb.Type_CHAR,
+	querypb.Type_TEXT,
+	querypb.Type_BLOB,
+	querypb.Type_VARBINARY,
+	querypb.Type_BINARY,
+	querypb.Type_ENUM,
+	querypb.Type_SET,
+	querypb.Type_JSON,
+}
+
+// nullEmptyResult returns a result with two adjacent columns of each
+// of nullEmptyTypes, and rows mixing NULL and empty values in them.
+func nullEmptyResult() *sqltypes.Result {
+	result := &sqltypes.Result{}
+	for _, typ := range nullEmptyTypes {
+		name := strings.ToLower(typ.String())
+		result.Fields = append(result.Fields,
+			&querypb.Field{Name: name + "_a", Type: typ},
+			&querypb.Field{Name: name + "_b", Type: typ})
+	}
+	null := sqltypes.NULL
+	for _, pattern := range [][2]*string{
+		{nil, ptr("")},
+		{ptr(""), nil},
+		{nil, nil},
+		{ptr(""), ptr("")},
+		{ptr("x"), ptr("")},
+		{ptr(""), ptr("x")},
+		{nil, ptr("x")},
+	} {
+		var row []sqltypes.Value
+		for _, typ := range nullEmptyTypes {
+			for _, s := range pattern {
+				if s == nil {
+					row = append(row, null)
+				} else {
+					row = append(row, sqltypes.MakeTrusted(typ, []byte(*s)))
+				}
+			}
+		}
+		result.Rows = append(result.Rows, row)
+	}
+	result.RowsAffected = uint64(len(result.Rows))
+	return result
+}
+
+func ptr(s string) *string {
+	return &s
+}
+
+// checkNullEmptyRows checks that got has the same NULL and non-NULL
+// values as want. The types are not compared, some paths lose them.
+func checkNullEmptyRows(t *testing.T, path string, fields []*querypb.Field, got, want [][]sqltypes.Value) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("%v: got %v rows, want %v", path, len(got), len(want))
+	}
+	for i := range want {
+		if len(got[i]) != len(want[i]) {
+			t.Fatalf("%v: row %v has %v values, want %v", path, i, len(got[i]), len(want[i]))
+		}
+		for j := range want[i] {
+			g, w := got[i][j], want[i][j]
+			if g.IsNull() != w.IsNull() || string(g.Raw()) != string(w.Raw()) {
+				t.Errorf("%v: row %v column %v: got %v, want %v", path, i, fields[j].Name, describeNullEmpty(g), describeNullEmpty(w))
+			}
+		}
+	}
+}
+
+func describeNullEmpty(v sqltypes.Value) string {
+	if v.IsNull() {
+		return "NULL"
+	}
+	return fmt.Sprintf("%q", v.Raw())
+}
+
+// nullEmptyHandler is a testHandler returning nullEmptyResult to all
+// the queries.
+type nullEmptyHandler struct {
+	testHandler
+}
+
+func (h *nullEmptyHandler) ComMultiQuery(ctx context.Context, c *Conn, query string, callback func(res *sqltypes.Result, more bool) error) (string, error) {
+	return "", h.ComQuery(ctx, c, query, callback)
+}
+
+func (h *nullEmptyHandler) ComQuery(ctx context.Context, c *Conn, query string, callback func(res *sqltypes.Result, more bool) error) error {
+	return callback(nullEmptyResult(), false)
+}
+
+func TestNullEmptyTextProtocol(t *testing.T) {
+	want := nullEmptyResult()
+	conn := connectResultPending(t, &nullEmptyHandler{}, ConnParams{})
+
+	result, err := conn.ExecuteFetch("select", 100, true)
+	if err != nil {
+		t.Fatalf("ExecuteFetch failed: %v", err)
+	}
+	checkNullEmptyRows(t, "ExecuteFetch", want.Fields, result.Rows, want.Rows)
+
+	if err := conn.ExecuteStreamFetch("select"); err != nil {
+		t.Fatalf("ExecuteStreamFetch failed: %v", err)
+	}
+	var rows [][]sqltypes.Value
+	for {
+		row, err := conn.FetchNext()
+		if err != nil {
+			t.Fatalf("FetchNext failed: %v", err)
+		}
+		if row == nil {
+			break
+		}
+		rows = append(rows, row)
+	}
+	checkNullEmptyRows(t, "FetchNext", want.Fields, rows, want.Rows)
+
+	// Every value is streamed with a threshold of 0.
+	if err := conn.ExecuteStreamFetch("select"); err != nil {
+		t.Fatalf("ExecuteStreamFetch failed: %v", err)
+	}
+	rows = nil
+	for {
+		var row []sqltypes.Value
+		more, err := conn.FetchNextWithBlobs(0, func(column int, value sqltypes.Value) error {
+			if value.IsStream() {
+				r, l := value.Stream()
+				data := make([]byte, l)
+				if _, err := io.ReadFull(r, data); err != nil {
+					return err
+				}
+				value = sqltypes.MakeTrusted(value.Type(), data)
+			}
+			row = append(row, value)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("FetchNextWithBlobs failed: %v", err)
+		}
+		if !more {
+			break
+		}
+		rows = append(rows, row)
+	}
+	checkNullEmptyRows(t, "FetchNextWithBlobs", want.Fields, rows, want.Rows)
+}
+
+func TestNullEmptyBinaryProtocol(t *testing.T) {
+	listener, sConn, cConn := createSocketPair(t)
+	defer func() {
+		listener.Close()
+		sConn.Close()
+		cConn.Close()
+	}()
+
+	want := nullEmptyResult()
+	if err := sConn.writeBinaryRows(want); err != nil {
+		t.Fatalf("writeBinaryRows failed: %v", err)
+	}
+	var rows [][]sqltypes.Value
+	for range want.Rows {
+		data, err := cConn.ReadPacket()
+		if err != nil {
+			t.Fatalf("ReadPacket failed: %v", err)
+		}
+		rows = append(rows, parseNullEmptyBinaryRow(t, data, len(want.Fields)))
+	}
+	checkNullEmptyRows(t, "binary rows", want.Fields, rows, want.Rows)
+}
+
+// parseNullEmptyBinaryRow parses a binary row of columns of
+// nullEmptyTypes, which are all sent as length encoded strings.
+func parseNullEmptyBinaryRow(t *testing.T, data []byte, columns int) []sqltypes.Value {
+	t.Helper()
+	if len(data) == 0 || data[0] != 0x00 {
+		t.Fatalf("invalid binary row header: %v", data)
+	}
+	// The two first bits of the NULL-bitmap are reserved.
+	bitmapLen := (columns + 7 + 2) / 8
+	pos := 1 + bitmapLen
+	row := make([]sqltypes.Value, columns)
+	for i := range row {
+		bit := i + 2
+		if data[1+bit/8]&(1<<uint(bit%8)) != 0 {
+			continue
+		}
+		val, next, ok := readLenEncStringAsBytesCopy(data, pos)
+		if !ok {
+			t.Fatalf("cannot read column %v of binary row %v", i, data)
+		}
+		row[i] = sqltypes.MakeTrusted(querypb.Type_VARBINARY, val)
+		pos = next
+	}
+	if pos != len(data) {
+		t.Fatalf("binary row has %v extra bytes: %v", len(data)-pos, data)
+	}
+	return row
+}
+
+func TestNullEmptyBindVariables(t *testing.T) {
+	listener, sConn, cConn := createSocketPair(t)
+	defer func() {
+		listener.Close()
+		sConn.Close()
+		cConn.Close()
+	}()
+
+	want := nullEmptyResult()
+	for i, row := range want.Rows {
+		prepare := &PrepareData{
+			StatementID: uint32(i + 1),
+			ParamsCount: uint16(len(row)),
+			ParamsType:  make([]int32, len(row)),
+			BindVars:    make(map[string]*querypb.BindVariable),
+		}
+		sConn.PrepareData = map[uint32]*PrepareData{prepare.StatementID: prepare}
+		bindVars := make(map[string]*querypb.BindVariable)
+		for j, val := range row {
+			bindVars[fmt.Sprintf("v%d", j+1)] = sqltypes.ValueBindVariable(val)
+		}
+		if err := cConn.writeComStmtExecute(prepare, NoCursor, bindVars); err != nil {
+			t.Fatalf("writeComStmtExecute failed: %v", err)
+		}
+		sConn.sequence = 0
+		data, err := sConn.ReadPacket()
+		if err != nil {
+			t.Fatalf("ReadPacket failed: %v", err)
+		}
+		if _, _, err := sConn.parseComStmtExecute(sConn.PrepareData, data); err != nil {
+			t.Fatalf("parseComStmtExecute failed: %v", err)
+		}
+
+		got := make([]sqltypes.Value, len(row))
+		for j := range got {
+			bv := prepare.BindVars[fmt.Sprintf("v%d", j+1)]
+			if got[j], err = sqltypes.BindVariableToValue(bv); err != nil {
+				t.Fatalf("BindVariableToValue(%v) failed: %v", bv, err)
+			}
+		}
+		checkNullEmptyRows(t, "bind variables", want.Fields, [][]sqltypes.Value{got}, [][]sqltypes.Value{row})
+	}
+}
+
+func TestNullEmptyProto3(t *testing.T) {
+	want := nullEmptyResult()
+	got := sqltypes.Proto3ToResult(sqltypes.ResultToProto3(want))
+	checkNullEmptyRows(t, "proto3", want.Fields, got.Rows, want.Rows)
+}
+
+func TestNullEmptyJSON(t *testing.T) {
+	want := nullEmptyResult()
+	data, err := json.Marshal(want.Rows)
+	if err != nil {
+		t.Fatalf("json.Marshal failed: %v", err)
+	}
+	var got [][]sqltypes.Value
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatalf("json.Unmarshal(%s) failed: %v", data, err)
+	}
+	checkNullEmptyRows(t, "JSON", want.Fields, got, want.Rows)
+}
@@ -0,0 +1,135 @@
+/*
+Copyright 2019 The Vitess Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mysql
+
+import (
+	"github.com/dolthub/vitess/go/sqltypes"
+
+	querypb "github.com/dolthub/vitess/go/vt/proto/query"
+)
+
+// PreparedStatement is a statement prepared on the server by
+// Conn.Prepare. It can be executed any number of times, until it is
+// closed, on the connection that prepared it only.
+type PreparedStatement struct {
+	conn    *Conn
+	prepare *PrepareData
+
+	// ParamCount is the number of parameters of the statement.
+	ParamCount int
+
+	// Params are the definitions of the parameters. MySQL does not
+	// know their types before the statement is executed, they are
+	// mostly placeholders.
+	Params []*querypb.Field
+
+	// Columns are the definitions of the columns of the result, if
+	// the statement returns rows.
+	Columns []*querypb.Field
+}
+
+// Prepare prepares query on the server, with COM_STMT_PREPARE.
+// Returns a SQLError, or ErrResultPending.
+func (c *Conn) Prepare(query string) (ps *PreparedStatement, err error) {
+	defer func() {
+		if err != nil {
+			if sqlerr, ok := err.(*SQLError); ok {
+				sqlerr.Query = query
+			}
+		}
+	}()
+
+	if err = c.startCommand(); err != nil {
+		return nil, err
+	}
+	defer c.endCommand()
+
+	if err = c.writeComPrepare(query); err != nil {
+		return nil, err
+	}
+	prepare, params, columns, err := c.readComPrepareResponseFields()
+	if err != nil {
+		return nil, err
+	}
+	prepare.PrepareStmt = query
+	return &PreparedStatement{
+		conn:       c,
+		prepare:    prepare,
+		ParamCount: int(prepare.ParamsCount),
+		Params:     params,
+		Columns:    columns,
+	}, nil
+}
+
+// StatementID returns the ID the server gave to the statement.
+func (ps *PreparedStatement) StatementID() uint32 {
+	return ps.prepare.StatementID
+}
+
+// Execute executes the statement with COM_STMT_EXECUTE, with args as
+// the values of its parameters, and returns its result, read in the
+// binary protocol. The parameter types are only sent again when they
+// change. maxrows is handled as in ExecuteFetch, a negative value
+// means no limit.
+// Returns a SQLError, or ErrResultPending.
+func (ps *PreparedStatement) Execute(args []sqltypes.Value, maxrows int) (result *sqltypes.Result, err error) {
+	c := ps.conn
+	defer func() {
+		if err != nil {
+			if sqlerr, ok := err.(*SQLError); ok {
+				sqlerr.Query = ps.prepare.PrepareStmt
+			}
+		}
+	}()
+
+	if err = c.startCommand(); err != nil {
+		return nil, err
+	}
+	defer c.endCommand()
+
+	if err = c.writeComStmtExecuteValues(ps.prepare, NoCursor, args); err != nil {
+		return nil, err
+	}
+	result, _, _, err = c.readQueryResult(maxrows, true)
+	return result, err
+}
+
+// ExecuteBindVars is Execute with the parameters given as bind
+// variables: the value of the n-th parameter is the bind variable named
+// "v<n>". The number of rows is only bounded by the ResultLimits of the
+// connection.
+// Returns a SQLError, or ErrResultPending.
+func (ps *PreparedStatement) ExecuteBindVars(bindVars map[string]*querypb.BindVariable) (*sqltypes.Result, error) {
+	args, err := bindVarsToParamValues(ps.prepare, bindVars)
+	if err != nil {
+		err.(*SQLError).Query = ps.prepare.PrepareStmt
+		return nil, err
+	}
+	return ps.Execute(args, -1)
+}
+
+// Close closes the statement on the server, with COM_STMT_CLOSE. The
+// statement can't be executed anymore.
+// Returns a SQLError, or ErrResultPending.
+func (ps *PreparedStatement) Close() error {
+	c := ps.conn
+	if err := c.startCommand(); err != nil {
+		return err
+	}
+	defer c.endCommand()
+	return c.writeComStmtClose(ps.prepare.StatementID)
+}
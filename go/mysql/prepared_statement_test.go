@@ -0,0 +1,312 @@
+/*
+Copyright 2019 The Vitess Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mysql
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dolthub/vitess/go/sqltypes"
+
+	querypb "github.com/dolthub/vitess/go/vt/proto/query"
+)
+
+// preparedStatementFields are the columns of the "select" statements
+// of preparedStatementHandler: the two parameters, and a constant of
+// each type with a binary encoding of its own.
+var preparedStatementFields = []*querypb.Field{
+	{Name: "p1", Type: querypb.Type_INT64},
+	{Name: "p2", Type: querypb.Type_VARCHAR},
+	{Name: "u64", Type: querypb.Type_UINT64},
+	{Name: "f32", Type: querypb.Type_FLOAT32},
+	{Name: "f64", Type: querypb.Type_FLOAT64},
+	{Name: "dec", Type: querypb.Type_DECIMAL},
+	{Name: "d", Type: querypb.Type_DATE},
+	{Name: "dt", Type: querypb.Type_DATETIME},
+	{Name: "ts", Type: querypb.Type_TIMESTAMP},
+	{Name: "t", Type: querypb.Type_TIME},
+	{Name: "y", Type: querypb.Type_YEAR},
+}
+
+// preparedStatementConstants are the values of the constant columns.
+var preparedStatementConstants = []sqltypes.Value{
+	sqltypes.MakeTrusted(querypb.Type_UINT64, []byte("18446744073709551615")),
+	sqltypes.MakeTrusted(querypb.Type_FLOAT32, []byte("1.5")),
+	sqltypes.MakeTrusted(querypb.Type_FLOAT64, []byte("-2.25")),
+	sqltypes.MakeTrusted(querypb.Type_DECIMAL, []byte("123.4500")),
+	sqltypes.MakeTrusted(querypb.Type_DATE, []byte("2020-01-02")),
+	sqltypes.MakeTrusted(querypb.Type_DATETIME, []byte("2020-01-02 03:04:05")),
+	sqltypes.MakeTrusted(querypb.Type_TIMESTAMP, []byte("2020-01-02 03:04:05.000006")),
+	sqltypes.MakeTrusted(querypb.Type_TIME, []byte("-01:02:03")),
+	sqltypes.MakeTrusted(querypb.Type_YEAR, []byte("2020")),
+}
+
+// preparedStatementHandler is a testHandler for prepared statements:
+// the "select" ones return their two parameters and
+// preparedStatementConstants, the ones from the "echo" table all their
+// parameters, the others insert a row.
+type preparedStatementHandler struct {
+	testHandler
+
+	closeMu sync.Mutex
+	closed  []uint32
+}
+
+func (th *preparedStatementHandler) ComPrepare(ctx context.Context, c *Conn, query string) ([]*querypb.Field, error) {
+	if strings.HasPrefix(query, "select") && !strings.HasSuffix(query, "from echo") {
+		return preparedStatementFields, nil
+	}
+	return nil, nil
+}
+
+func (th *preparedStatementHandler) ComStmtExecute(ctx context.Context, c *Conn, prepare *PrepareData, callback func(*sqltypes.Result) error) error {
+	if strings.HasSuffix(prepare.PrepareStmt, "from echo") {
+		result := &sqltypes.Result{Rows: [][]sqltypes.Value{nil}, RowsAffected: 1}
+		for i := 0; i < int(prepare.ParamsCount); i++ {
+			val, err := sqltypes.BindVariableToValue(prepare.BindVars[fmt.Sprintf("v%d", i+1)])
+			if err != nil {
+				return err
+			}
+			typ := val.Type()
+			if val.IsNull() {
+				typ = querypb.Type_VARBINARY
+			}
+			result.Fields = append(result.Fields, &querypb.Field{Name: fmt.Sprintf("v%d", i+1), Type: typ})
+			result.Rows[0] = append(result.Rows[0], val)
+		}
+		return callback(result)
+	}
+	if !strings.HasPrefix(prepare.PrepareStmt, "select") {
+		return callback(&sqltypes.Result{RowsAffected: 1, InsertID: 42})
+	}
+	row := make([]sqltypes.Value, 0, len(preparedStatementFields))
+	for i, name := range []string{"v1", "v2"} {
+		val, err := sqltypes.BindVariableToValue(prepare.BindVars[name])
+		if err != nil {
+			return err
+		}
+		if !val.IsNull() {
+			val = sqltypes.MakeTrusted(preparedStatementFields[i].Type, val.Raw())
+		}
+		row = append(row, val)
+	}
+	row = append(row, preparedStatementConstants...)
+	return callback(&sqltypes.Result{
+		Fields:       preparedStatementFields,
+		Rows:         [][]sqltypes.Value{row},
+		RowsAffected: 1,
+	})
+}
+
+func (th *preparedStatementHandler) ComStmtClosed(c *Conn, stmtID uint32) {
+	th.closeMu.Lock()
+	defer th.closeMu.Unlock()
+	th.closed = append(th.closed, stmtID)
+}
+
+func TestPreparedStatement(t *testing.T) {
+	th := &preparedStatementHandler{}
+	conn := connectResultPending(t, th, ConnParams{})
+
+	ps, err := conn.Prepare("select ?, ?")
+	require.NoError(t, err)
+	assert.Equal(t, 2, ps.ParamCount)
+	assert.Equal(t, 2, len(ps.Params))
+	require.Equal(t, len(preparedStatementFields), len(ps.Columns))
+	for i, field := range preparedStatementFields {
+		assert.Equal(t, field.Name, ps.Columns[i].Name)
+		assert.Equal(t, field.Type, ps.Columns[i].Type)
+	}
+
+	// The statement can be executed several times.
+	for _, params := range [][2]sqltypes.Value{
+		{sqltypes.NewInt64(-7), sqltypes.NewVarChar("abc")},
+		{sqltypes.NULL, sqltypes.NewVarChar("")},
+		{sqltypes.NewInt64(9223372036854775807), sqltypes.NULL},
+	} {
+		result, err := ps.Execute(params[:], -1)
+		require.NoError(t, err)
+		require.Equal(t, 1, len(result.Rows))
+		row := result.Rows[0]
+		require.Equal(t, len(preparedStatementFields), len(row))
+		for i, field := range preparedStatementFields {
+			assert.Equal(t, field.Type, result.Fields[i].Type, "column %v", field.Name)
+		}
+		for i, want := range params {
+			assert.Equal(t, want.IsNull(), row[i].IsNull(), "parameter %v", i+1)
+			assert.Equal(t, want.ToString(), row[i].ToString(), "parameter %v", i+1)
+		}
+		for i, want := range preparedStatementConstants {
+			got := row[i+2]
+			assert.Equal(t, want.Type(), got.Type(), "column %v", preparedStatementFields[i+2].Name)
+			assert.Equal(t, want.ToString(), got.ToString(), "column %v", preparedStatementFields[i+2].Name)
+		}
+	}
+
+	// The parameters can also be given as bind variables.
+	result, err := ps.ExecuteBindVars(map[string]*querypb.BindVariable{
+		"v1": sqltypes.Int64BindVariable(-7),
+		"v2": sqltypes.StringBindVariable("abc"),
+	})
+	require.NoError(t, err)
+	require.Equal(t, 1, len(result.Rows))
+	assert.Equal(t, "-7", result.Rows[0][0].ToString())
+	assert.Equal(t, "abc", result.Rows[0][1].ToString())
+
+	// A missing parameter is not sent.
+	_, err = ps.Execute([]sqltypes.Value{sqltypes.NewInt64(1)}, -1)
+	assert.Equal(t, CRParamsNotBound, err.(*SQLError).Number())
+	assert.Equal(t, "select ?, ?", err.(*SQLError).Query)
+	_, err = ps.ExecuteBindVars(map[string]*querypb.BindVariable{"v1": sqltypes.Int64BindVariable(1)})
+	assert.Equal(t, CRParamsNotBound, err.(*SQLError).Number())
+	assert.Equal(t, "select ?, ?", err.(*SQLError).Query)
+
+	// A statement without a result.
+	insert, err := conn.Prepare("insert into t values (?)")
+	require.NoError(t, err)
+	assert.Equal(t, 1, insert.ParamCount)
+	assert.Equal(t, 0, len(insert.Columns))
+	result, err = insert.Execute([]sqltypes.Value{sqltypes.NewInt64(1)}, -1)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1), result.RowsAffected)
+	assert.Equal(t, uint64(42), result.InsertID)
+
+	// Once closed, it's gone.
+	require.NoError(t, ps.Close())
+	_, err = ps.Execute([]sqltypes.Value{sqltypes.NewInt64(1), sqltypes.NewVarChar("a")}, -1)
+	assert.Error(t, err)
+	th.closeMu.Lock()
+	assert.Equal(t, []uint32{ps.StatementID()}, th.closed)
+	th.closeMu.Unlock()
+
+	// The connection is intact.
+	_, err = insert.Execute([]sqltypes.Value{sqltypes.NewInt64(2)}, -1)
+	require.NoError(t, err)
+	require.NoError(t, conn.Ping())
+}
+
+func TestPreparedStatementErrors(t *testing.T) {
+	conn := connectResultPending(t, &preparedStatementHandler{}, ConnParams{})
+
+	_, err := conn.Prepare("select from")
+	require.Error(t, err)
+	assert.Equal(t, "select from", err.(*SQLError).Query)
+
+	// Nothing can be sent while rows are read.
+	ps, err := conn.Prepare("select ?, ?")
+	require.NoError(t, err)
+	require.NoError(t, conn.ExecuteStreamFetch("select rows"))
+	_, err = ps.Execute(nil, -1)
+	assert.True(t, errors.Is(err, ErrResultPending), "Execute returned %v", err)
+	_, err = conn.Prepare("select ?")
+	assert.True(t, errors.Is(err, ErrResultPending), "Prepare returned %v", err)
+	conn.CloseResult()
+}
+
+func TestPreparedStatementEcho(t *testing.T) {
+	conn := connectResultPending(t, &preparedStatementHandler{}, ConnParams{})
+
+	ps, err := conn.Prepare("select ?, ?, ? from echo")
+	require.NoError(t, err)
+	require.Equal(t, 3, ps.ParamCount)
+
+	// The second and fourth executions don't resend the types.
+	for _, args := range [][]sqltypes.Value{
+		{sqltypes.NULL, sqltypes.NewInt64(1), sqltypes.NewVarChar(strings.Repeat("a", 251))},
+		{sqltypes.NULL, sqltypes.NewInt64(-2), sqltypes.NewVarChar(strings.Repeat("b", 65536))},
+		{sqltypes.NewVarChar(""), sqltypes.NULL, sqltypes.NewVarChar("c")},
+		{sqltypes.NewVarChar("d"), sqltypes.NULL, sqltypes.NewVarChar("")},
+	} {
+		result, err := ps.Execute(args, -1)
+		require.NoError(t, err)
+		require.Equal(t, 1, len(result.Rows))
+		require.Equal(t, len(args), len(result.Rows[0]))
+		for i, want := range args {
+			got := result.Rows[0][i]
+			assert.Equal(t, want.IsNull(), got.IsNull(), "parameter %v", i+1)
+			assert.Equal(t, want.ToString(), got.ToString(), "parameter %v", i+1)
+		}
+	}
+
+	// maxrows applies as with ExecuteFetch.
+	_, err = ps.Execute([]sqltypes.Value{sqltypes.NULL, sqltypes.NULL, sqltypes.NULL}, 0)
+	assert.Equal(t, ERVitessMaxRowsExceeded, err.(*SQLError).Number())
+
+	// One value per parameter is needed.
+	_, err = ps.Execute([]sqltypes.Value{sqltypes.NULL}, -1)
+	assert.Equal(t, CRParamsNotBound, err.(*SQLError).Number())
+	require.NoError(t, conn.Ping())
+}
+
+func TestWriteComStmtExecuteValues(t *testing.T) {
+	listener, sConn, cConn := createSocketPair(t)
+	defer func() {
+		listener.Close()
+		sConn.Close()
+		cConn.Close()
+	}()
+
+	prepare := &PrepareData{StatementID: 1, ParamsCount: 2, ParamsType: make([]int32, 2)}
+	serverPrepare := &PrepareData{StatementID: 1, ParamsCount: 2, ParamsType: make([]int32, 2)}
+	sConn.PrepareData = map[uint32]*PrepareData{1: serverPrepare}
+
+	for i, tcase := range []struct {
+		values         []sqltypes.Value
+		newParamsBound bool
+	}{{
+		values:         []sqltypes.Value{sqltypes.NewInt64(1), sqltypes.NewVarChar(strings.Repeat("a", 300))},
+		newParamsBound: true,
+	}, {
+		values: []sqltypes.Value{sqltypes.NewInt64(2), sqltypes.NewVarChar("b")},
+	}, {
+		values:         []sqltypes.Value{sqltypes.NULL, sqltypes.NewVarChar("c")},
+		newParamsBound: true,
+	}, {
+		values: []sqltypes.Value{sqltypes.NULL, sqltypes.NewVarChar("d")},
+	}, {
+		values:         []sqltypes.Value{sqltypes.NewInt64(5), sqltypes.NewVarChar("e")},
+		newParamsBound: true,
+	}} {
+		require.NoError(t, cConn.writeComStmtExecuteValues(prepare, NoCursor, tcase.values))
+		sConn.sequence = 0
+		data, err := sConn.ReadPacket()
+		require.NoError(t, err)
+
+		// The flag follows the header and the NULL-bitmap.
+		assert.Equal(t, tcase.newParamsBound, data[11] == 1, "execution %v", i)
+		serverPrepare.BindVars = make(map[string]*querypb.BindVariable)
+		_, _, err = sConn.parseComStmtExecute(sConn.PrepareData, data)
+		require.NoError(t, err, "execution %v", i)
+		for j, want := range tcase.values {
+			got, err := sqltypes.BindVariableToValue(serverPrepare.BindVars[fmt.Sprintf("v%d", j+1)])
+			require.NoError(t, err)
+			assert.Equal(t, want.IsNull(), got.IsNull(), "execution %v, parameter %v", i, j+1)
+			assert.Equal(t, want.ToString(), got.ToString(), "execution %v, parameter %v", i, j+1)
+		}
+	}
+
+	// Nothing is sent without one value per parameter.
+	err := cConn.writeComStmtExecuteValues(prepare, NoCursor, []sqltypes.Value{sqltypes.NULL})
+	assert.Equal(t, CRParamsNotBound, err.(*SQLError).Number())
+}
@@ -0,0 +1,247 @@
+/*
+Copyright 2019 The Vitess Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mysql
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/dolthub/vitess/go/sqltypes"
+	"github.com/dolthub/vitess/go/vt/proto/vtrpc"
+	"github.com/dolthub/vitess/go/vt/vterrors"
+
+	querypb "github.com/dolthub/vitess/go/vt/proto/query"
+)
+
+// This file contains the progress reports a long running query can
+// send to the client before its result is ready.
+//
+// Progress reports are extra results a client doesn't expect, so only
+// the clients that opt in by sending the ConnAttrProgress connection
+// attribute in their handshake get them. Of those, clients that
+// negotiated CapabilityClientSessionTrack get an OK
+// packet whose session state sets the ProgressVariable system
+// variable to "<percent> <message>". Other clients that negotiated
+// CapabilityClientMultiResults get a one-row result set from the
+// ProgressTable pseudo table, with a percent and a message column.
+// In both cases SERVER_MORE_RESULTS_EXISTS is set, so the client keeps
+// reading until the real result arrives. The other clients can't
+// receive progress reports.
+
+const (
+	// ConnAttrProgress is the connection attribute a client sends,
+	// with any value, to receive progress reports.
+	ConnAttrProgress = "_vt_progress_reports"
+
+	// ProgressVariable is the system variable carrying progress
+	// reports in the session state of OK packets.
+	ProgressVariable = "vitess_progress"
+
+	// ProgressTable is the table name of the fields of progress
+	// report result sets.
+	ProgressTable = "_vt_progress"
+)
+
+var progressFields = []*querypb.Field{{
+	Name:  "percent",
+	Table: ProgressTable,
+	Type:  querypb.Type_FLOAT64,
+}, {
+	Name:  "message",
+	Table: ProgressTable,
+	Type:  querypb.Type_VARCHAR,
+}}
+
+// Progress sends a progress report for the query being executed to
+// the client. It can only be called by a Handler from ComQuery or
+// ComMultiQuery, before the first result is passed to the callback.
+// Otherwise it returns an error and sends nothing. Nothing is sent
+// either to the clients that didn't opt in with ConnAttrProgress, or
+// can't receive progress reports.
+func (c *Conn) Progress(percent float64, message string) error {
+	if !c.progressAllowed {
+		return vterrors.Errorf(vtrpc.Code_FAILED_PRECONDITION, "progress can only be reported before the first result of a query")
+	}
+	if _, ok := c.Attributes[ConnAttrProgress]; !ok {
+		return nil
+	}
+
+	value := strconv.FormatFloat(percent, 'f', -1, 64)
+	var err error
+	switch {
+	case c.Capabilities&CapabilityClientSessionTrack != 0:
+		err = c.writeProgressOKPacket(value + " " + message)
+	case c.Capabilities&CapabilityClientMultiResults != 0:
+		err = c.writeProgressResult(value, message)
+	default:
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	// The whole point is for the client to see this now.
+	if c.bufferedWriter != nil {
+		return c.bufferedWriter.Flush()
+	}
+	return nil
+}
+
+// writeProgressOKPacket writes an OK packet with the progress
+// report in its session state.
+// Server -> Client.
+// This method returns a generic error, not a SQLError.
+func (c *Conn) writeProgressOKPacket(report string) error {
+	entryLength := lenEncStringSize(ProgressVariable) + lenEncStringSize(report)
+	stateLength := 1 + // entry type
+		lenEncIntSize(uint64(entryLength)) +
+		entryLength
+	length := 1 + // OKPacket
+		1 + // affected rows
+		1 + // last insert id
+		2 + // flags
+		2 + // warnings
+		1 + // empty info
+		lenEncIntSize(uint64(stateLength)) +
+		stateLength
+	data := c.startEphemeralPacket(length)
+	pos := 0
+	pos = writeByte(data, pos, OKPacket)
+	pos = writeLenEncInt(data, pos, 0)
+	pos = writeLenEncInt(data, pos, 0)
+	pos = writeUint16(data, pos, c.StatusFlags|ServerMoreResultsExists|ServerSessionStateChanged)
+	pos = writeUint16(data, pos, 0)
+	pos = writeLenEncInt(data, pos, 0)
+	pos = writeLenEncInt(data, pos, uint64(stateLength))
+	pos = writeByte(data, pos, SessionTrackSystemVariables)
+	pos = writeLenEncInt(data, pos, uint64(entryLength))
+	pos = writeLenEncString(data, pos, ProgressVariable)
+	_ = writeLenEncString(data, pos, report)
+
+	return c.writeEphemeralPacket()
+}
+
+// writeProgressResult writes the progress report as a one-row
+// result set.
+func (c *Conn) writeProgressResult(percent, message string) error {
+	qr := &sqltypes.Result{
+		Fields: progressFields,
+		Rows: [][]sqltypes.Value{{
+			sqltypes.MakeTrusted(querypb.Type_FLOAT64, []byte(percent)),
+			sqltypes.MakeTrusted(querypb.Type_VARCHAR, []byte(message)),
+		}},
+	}
+	if err := c.writeFields(qr); err != nil {
+		return err
+	}
+	if err := c.writeRows(qr); err != nil {
+		return err
+	}
+	return c.writeEndResult(true, 0, 0, 0)
+}
+
+// progressFromSessionState extracts a progress report from the
+// session state changes of an OK packet, if there is one.
+func progressFromSessionState(changes *sqltypes.SessionStateChanges) (float64, string, bool) {
+	if changes == nil {
+		return 0, "", false
+	}
+	report, ok := changes.SystemVariables[ProgressVariable]
+	if !ok {
+		return 0, "", false
+	}
+	return parseProgressReport(report)
+}
+
+// parseProgressReport parses a "<percent> <message>" report.
+func parseProgressReport(report string) (float64, string, bool) {
+	value, message, _ := strings.Cut(report, " ")
+	percent, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, "", false
+	}
+	return percent, message, true
+}
+
+// isProgressResult returns true if qr is a progress report result set.
+func isProgressResult(qr *sqltypes.Result) bool {
+	return len(qr.Fields) == len(progressFields) &&
+		qr.Fields[0].Table == ProgressTable &&
+		qr.Fields[0].Name == progressFields[0].Name &&
+		qr.Fields[1].Name == progressFields[1].Name &&
+		len(qr.Rows) == 1
+}
+
+// ExecuteFetchWithProgress executes a query like ExecuteFetchMulti,
+// but passes the progress reports the server sends before the result
+// to the progress function. The server only sends them if the
+// connection attributes had ConnAttrProgress. It returns the first real result, and
+// whether more results follow it.
+// Returns a SQLError.
+func (c *Conn) ExecuteFetchWithProgress(query string, maxrows int, wantfields bool, progress func(percent float64, message string)) (result *sqltypes.Result, more bool, err error) {
+	defer func() {
+		if err != nil {
+			if sqlerr, ok := err.(*SQLError); ok {
+				sqlerr.Query = query
+			}
+		}
+	}()
+
+	if err = c.startCommand(); err != nil {
+		return nil, false, err
+	}
+	defer c.endCommand()
+
+	// Send the query as a COM_QUERY packet.
+	if err = c.writeComQuery(query); err != nil {
+		return nil, false, err
+	}
+
+	for {
+		c.lastProgress = nil
+		// Always ask for fields, to recognize progress result sets.
+		res, status, _, err := c.readQueryResult(maxrows, true)
+		if err != nil {
+			return nil, false, err
+		}
+
+		isProgress := c.lastProgress != nil || isProgressResult(res)
+		if isProgress && status.hasMore() {
+			if c.lastProgress != nil {
+				progress(c.lastProgress.percent, c.lastProgress.message)
+			} else {
+				percent, err := sqltypes.ToFloat64(res.Rows[0][0])
+				if err != nil {
+					return nil, false, NewSQLError(CRMalformedPacket, SSUnknownSQLState, "invalid progress report: %v", err)
+				}
+				progress(percent, res.Rows[0][1].ToString())
+			}
+			continue
+		}
+
+		if !wantfields {
+			res.Fields = nil
+		}
+		return res, status.hasMore(), nil
+	}
+}
+
+// progressReport is a progress report received by a client.
+type progressReport struct {
+	percent float64
+	message string
+}
@@ -0,0 +1,134 @@
+/*
+Copyright 2019 The Vitess Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mysql
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestProgress(t *testing.T) {
+	listener, sConn, cConn := createSocketPair(t)
+	defer func() {
+		listener.Close()
+		sConn.Close()
+		cConn.Close()
+	}()
+
+	// The server only knows about the opt-in from the connection
+	// attributes of the handshake.
+	sConn.Attributes = map[string]string{ConnAttrProgress: "1"}
+	defer func() { sConn.Attributes = nil }()
+
+	for _, capabilities := range []uint32{
+		0,
+		CapabilityClientMultiResults,
+		CapabilityClientMultiResults | CapabilityClientDeprecateEOF,
+		CapabilityClientSessionTrack,
+		CapabilityClientSessionTrack | CapabilityClientDeprecateEOF,
+	} {
+		t.Run(fmt.Sprintf("capabilities %x", capabilities), func(t *testing.T) {
+			sConn.Capabilities = capabilities
+			cConn.Capabilities = capabilities
+
+			var reports []string
+			wg := sync.WaitGroup{}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				result, more, err := cConn.ExecuteFetchWithProgress("slow progress", 10, true, func(percent float64, message string) {
+					reports = append(reports, fmt.Sprintf("%v %v", percent, message))
+				})
+				if err != nil {
+					t.Errorf("ExecuteFetchWithProgress failed: %v", err)
+					return
+				}
+				if more {
+					t.Errorf("ExecuteFetchWithProgress returned more results")
+				}
+				if !result.Equal(selectRowsResult.Copy()) {
+					t.Errorf("got result %v, want %v", result, selectRowsResult)
+				}
+			}()
+
+			if err := sConn.handleNextCommand(&testHandler{}); err != nil {
+				t.Fatalf("handleNextCommand failed: %v", err)
+			}
+			wg.Wait()
+
+			// A client without session tracking or multiple results
+			// gets none.
+			var want []string
+			if capabilities&(CapabilityClientSessionTrack|CapabilityClientMultiResults) != 0 {
+				want = []string{"25 step 1", "50 step 2", "75 step 3"}
+			}
+			if !reflect.DeepEqual(reports, want) {
+				t.Errorf("got progress reports %v, want %v", reports, want)
+			}
+
+			// Outside of a query, Progress is an error.
+			if err := sConn.Progress(100, "done"); err == nil {
+				t.Errorf("Progress after the query should have failed")
+			}
+		})
+	}
+}
+
+func TestProgressWithoutOptIn(t *testing.T) {
+	listener, sConn, cConn := createSocketPair(t)
+	defer func() {
+		listener.Close()
+		sConn.Close()
+		cConn.Close()
+	}()
+
+	// Regular drivers negotiate these, but don't expect progress
+	// reports: they get the result of the query only.
+	for _, capabilities := range []uint32{
+		CapabilityClientMultiResults | CapabilityClientDeprecateEOF,
+		CapabilityClientMultiResults | CapabilityClientSessionTrack | CapabilityClientDeprecateEOF,
+	} {
+		t.Run(fmt.Sprintf("capabilities %x", capabilities), func(t *testing.T) {
+			sConn.Capabilities = capabilities
+			cConn.Capabilities = capabilities
+
+			wg := sync.WaitGroup{}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				result, status, err := cConn.ExecuteFetchMulti("slow progress", 10, true)
+				if err != nil {
+					t.Errorf("ExecuteFetchMulti failed: %v", err)
+					return
+				}
+				if status.hasMore() {
+					t.Errorf("ExecuteFetchMulti returned more results")
+				}
+				if !result.Equal(selectRowsResult.Copy()) {
+					t.Errorf("got result %v, want %v", result, selectRowsResult)
+				}
+			}()
+
+			if err := sConn.handleNextCommand(&testHandler{}); err != nil {
+				t.Fatalf("handleNextCommand failed: %v", err)
+			}
+			wg.Wait()
+		})
+	}
+}
@@ -37,10 +37,22 @@ import (
 
 // WriteComQuery writes a query for the server to execute.
 // Client -> Server.
-// Returns SQLError(CRServerGone) if it can't.
+// Returns SQLError(CRServerGone) if it can't, or ErrResultPending.
 func (c *Conn) WriteComQuery(query string) error {
+	if err := c.startCommand(); err != nil {
+		return err
+	}
+	defer c.endCommand()
+	return c.writeComQuery(query)
+}
+
+// writeComQuery is WriteComQuery, for the client methods that already
+// called startCommand.
+func (c *Conn) writeComQuery(query string) error {
 	// This is a new command, need to reset the sequence.
-	c.sequence = 0
+	c.resetSequence()
+
+	c.binaryRows = false
 
 	data := c.startEphemeralPacket(len(query) + 1)
 	data[0] = ComQuery
@@ -76,6 +88,331 @@ func (c *Conn) writeComSetOption(operation uint16) error {
 	return nil
 }
 
+// writeComResetConnection writes a COM_RESET_CONNECTION, for the
+// server to reset the session state.
+// Client -> Server.
+// Returns SQLError(CRServerGone) if it can't.
+func (c *Conn) writeComResetConnection() error {
+	// This is a new command, need to reset the sequence.
+	c.resetSequence()
+
+	data := c.startEphemeralPacket(1)
+	data[0] = ComResetConnection
+	if err := c.writeEphemeralPacket(); err != nil {
+		return NewSQLError(CRServerGone, SSUnknownSQLState, err.Error())
+	}
+	return nil
+}
+
+// writeComPrepare writes a COM_STMT_PREPARE for the server to prepare
+// query.
+// Client -> Server.
+// Returns SQLError(CRServerGone) if it can't.
+func (c *Conn) writeComPrepare(query string) error {
+	// This is a new command, need to reset the sequence.
+	c.resetSequence()
+
+	data := c.startEphemeralPacket(len(query) + 1)
+	data[0] = ComPrepare
+	copy(data[1:], query)
+	if err := c.writeEphemeralPacket(); err != nil {
+		return NewSQLError(CRServerGone, SSUnknownSQLState, err.Error())
+	}
+	return nil
+}
+
+// readComPrepareResponse reads the response to a COM_STMT_PREPARE,
+// and returns the PrepareData of the new statement with its parameter
+// count and column names.
+// Returns a SQLError.
+func (c *Conn) readComPrepareResponse() (*PrepareData, error) {
+	prepare, _, _, err := c.readComPrepareResponseFields()
+	return prepare, err
+}
+
+// readComPrepareResponseFields is readComPrepareResponse, also
+// returning the parameter and column definitions.
+// Returns a SQLError.
+func (c *Conn) readComPrepareResponseFields() (prepare *PrepareData, params, columns []*querypb.Field, err error) {
+	data, err := c.ReadPacket()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if isErrorPacket(data) {
+		return nil, nil, nil, ParseErrorPacket(data)
+	}
+
+	// Skip the status.
+	stmtID, pos, ok := readUint32(data, 1)
+	if !ok {
+		return nil, nil, nil, NewSQLError(CRMalformedPacket, SSUnknownSQLState, "reading statement ID failed")
+	}
+	columnCount, pos, ok := readUint16(data, pos)
+	if !ok {
+		return nil, nil, nil, NewSQLError(CRMalformedPacket, SSUnknownSQLState, "reading column count failed")
+	}
+	paramsCount, _, ok := readUint16(data, pos)
+	if !ok {
+		return nil, nil, nil, NewSQLError(CRMalformedPacket, SSUnknownSQLState, "reading parameter count failed")
+	}
+
+	prepare = &PrepareData{
+		StatementID: stmtID,
+		ParamsCount: paramsCount,
+		ParamsType:  make([]int32, paramsCount),
+		ColumnNames: make([]string, columnCount),
+	}
+
+	// The parameter definitions are mostly placeholders: MySQL does
+	// not know the parameter types before the statement is executed.
+	params = make([]*querypb.Field, paramsCount)
+	for i := range params {
+		params[i] = &querypb.Field{}
+		if err := c.readColumnDefinition(params[i], i); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+	if err := c.readDefinitionsEOF(int(paramsCount)); err != nil {
+		return nil, nil, nil, err
+	}
+
+	columns = make([]*querypb.Field, columnCount)
+	for i := range columns {
+		columns[i] = &querypb.Field{}
+		if err := c.readColumnDefinition(columns[i], i); err != nil {
+			return nil, nil, nil, err
+		}
+		prepare.ColumnNames[i] = columns[i].Name
+	}
+	if err := c.readDefinitionsEOF(int(columnCount)); err != nil {
+		return nil, nil, nil, err
+	}
+
+	return prepare, params, columns, nil
+}
+
+// readDefinitionsEOF reads the EOF packet that follows count column
+// definitions, if there is one.
+// Returns a SQLError.
+func (c *Conn) readDefinitionsEOF(count int) error {
+	if count == 0 || c.Capabilities&CapabilityClientDeprecateEOF != 0 {
+		return nil
+	}
+	data, err := c.ReadPacket()
+	if err != nil {
+		return err
+	}
+	if !isEOFPacket(data) {
+		return NewSQLError(CRCommandsOutOfSync, SSUnknownSQLState, "unexpected packet after definitions: %v", data)
+	}
+	return nil
+}
+
+// writeComStmtExecute writes a COM_STMT_EXECUTE for the prepared
+// statement, with bindVars as its parameters. The value of the n-th
+// parameter is the bind variable named "v<n>", as on the server side.
+// Client -> Server.
+// Returns SQLError(CRParamsNotBound) if a parameter has no value, in
+// which case nothing is sent, or SQLError(CRServerGone) if it can't
+// write the packet.
+func (c *Conn) writeComStmtExecute(prepare *PrepareData, cursorType byte, bindVars map[string]*querypb.BindVariable) error {
+	values, err := bindVarsToParamValues(prepare, bindVars)
+	if err != nil {
+		return err
+	}
+	return c.writeComStmtExecuteValues(prepare, cursorType, values)
+}
+
+// bindVarsToParamValues returns the values of the parameters of the
+// prepared statement, in order: the n-th one is the bind variable
+// named "v<n>".
+// Returns SQLError(CRParamsNotBound) if a parameter has no value.
+func bindVarsToParamValues(prepare *PrepareData, bindVars map[string]*querypb.BindVariable) ([]sqltypes.Value, error) {
+	values := make([]sqltypes.Value, prepare.ParamsCount)
+	for i := range values {
+		name := fmt.Sprintf("v%d", i+1)
+		bv, ok := bindVars[name]
+		if !ok || bv == nil {
+			return nil, NewSQLError(CRParamsNotBound, SSUnknownSQLState, "no value supplied for parameter %v of statement %v", name, prepare.StatementID)
+		}
+		val, err := sqltypes.BindVariableToValue(bv)
+		if err != nil {
+			return nil, NewSQLError(CRUnknownError, SSUnknownSQLState, "invalid value for parameter %v of statement %v: %v", name, prepare.StatementID, err)
+		}
+		values[i] = val
+	}
+	return values, nil
+}
+
+// writeComStmtExecuteValues writes a COM_STMT_EXECUTE for the prepared
+// statement, with values as its parameters. The parameter types are
+// only sent if they changed since the previous execution, the server
+// keeps them otherwise. They are remembered in prepare.ParamsType.
+// Client -> Server.
+// Returns SQLError(CRParamsNotBound) if there isn't one value per
+// parameter, in which case nothing is sent, or SQLError(CRServerGone)
+// if it can't write the packet.
+func (c *Conn) writeComStmtExecuteValues(prepare *PrepareData, cursorType byte, values []sqltypes.Value) error {
+	if len(values) != int(prepare.ParamsCount) {
+		return NewSQLError(CRParamsNotBound, SSUnknownSQLState, "%v values supplied for the %v parameters of statement %v", len(values), prepare.ParamsCount, prepare.StatementID)
+	}
+
+	newParamsBound := !prepare.paramsBound || len(prepare.ParamsType) != len(values)
+	length := 1 + // ComStmtExecute
+		4 + // statement ID
+		1 + // cursor type
+		4 // iteration count
+	if len(values) > 0 {
+		length += (len(values)+7)/8 + // NULL bitmap
+			1 // new params bound flag
+	}
+	for i, val := range values {
+		if !newParamsBound && prepare.ParamsType[i] != int32(val.Type()) {
+			newParamsBound = true
+		}
+		if !val.IsNull() {
+			l, err := val2MySQLLen(val)
+			if err != nil {
+				return NewSQLError(CRUnknownError, SSUnknownSQLState, "invalid value for parameter v%v of statement %v: %v", i+1, prepare.StatementID, err)
+			}
+			length += l
+		}
+	}
+	if newParamsBound {
+		length += 2 * len(values) // parameter types
+	}
+
+	// This is a new command, need to reset the sequence.
+	c.resetSequence()
+
+	data := c.startEphemeralPacket(length)
+	pos := writeByte(data, 0, ComStmtExecute)
+	pos = writeUint32(data, pos, prepare.StatementID)
+	pos = writeByte(data, pos, cursorType)
+	pos = writeUint32(data, pos, 1)
+	if len(values) > 0 {
+		bitmapPos := pos
+		for i := 0; i < (len(values)+7)/8; i++ {
+			pos = writeByte(data, pos, 0)
+		}
+		for i, val := range values {
+			if val.IsNull() {
+				data[bitmapPos+i/8] |= 1 << uint(i%8)
+			}
+		}
+		if newParamsBound {
+			pos = writeByte(data, pos, 1)
+			for _, val := range values {
+				typ, flags := sqltypes.TypeToMySQL(val.Type())
+				pos = writeByte(data, pos, byte(typ))
+				pos = writeByte(data, pos, byte(flags))
+			}
+		} else {
+			pos = writeByte(data, pos, 0)
+		}
+		for _, val := range values {
+			if val.IsNull() {
+				continue
+			}
+			v, err := val2MySQL(val)
+			if err != nil {
+				c.recycleWritePacket()
+				return NewSQLError(CRUnknownError, SSUnknownSQLState, "invalid value %v: %v", val, err)
+			}
+			pos += copy(data[pos:], v)
+		}
+	}
+
+	if pos != length {
+		c.recycleWritePacket()
+		return NewSQLError(CRUnknownError, SSUnknownSQLState, "packing of COM_STMT_EXECUTE used %v bytes instead of %v", pos, length)
+	}
+	if err := c.writeEphemeralPacket(); err != nil {
+		return NewSQLError(CRServerGone, SSUnknownSQLState, err.Error())
+	}
+	if newParamsBound {
+		if len(prepare.ParamsType) != len(values) {
+			prepare.ParamsType = make([]int32, len(values))
+		}
+		for i, val := range values {
+			prepare.ParamsType[i] = int32(val.Type())
+		}
+		prepare.paramsBound = true
+	}
+	c.binaryRows = true
+	return nil
+}
+
+// maxLongDataChunk is the most bytes of a value sent in a single
+// COM_STMT_SEND_LONG_DATA, so its payload stays under MaxPacketSize.
+const maxLongDataChunk = MaxPacketSize - 1 - 4 - 2 - 1
+
+// writeComStmtSendLongData sends the value of the paramID parameter
+// (starting at 0) of a prepared statement, before it is executed. It
+// is cut into as many COM_STMT_SEND_LONG_DATA as needed, which the
+// server appends to each other. The server does not reply to them.
+// Client -> Server.
+// Returns SQLError(CRServerGone) if it can't.
+func (c *Conn) writeComStmtSendLongData(stmtID uint32, paramID uint16, data []byte) error {
+	for {
+		chunk := data
+		if len(chunk) > maxLongDataChunk {
+			chunk = chunk[:maxLongDataChunk]
+		}
+		data = data[len(chunk):]
+
+		// Each chunk is a new command, need to reset the sequence.
+		c.resetSequence()
+
+		packet := c.startEphemeralPacket(1 + 4 + 2 + len(chunk))
+		pos := writeByte(packet, 0, ComStmtSendLongData)
+		pos = writeUint32(packet, pos, stmtID)
+		pos = writeUint16(packet, pos, paramID)
+		copy(packet[pos:], chunk)
+		if err := c.writeEphemeralPacket(); err != nil {
+			return NewSQLError(CRServerGone, SSUnknownSQLState, err.Error())
+		}
+		if len(data) == 0 {
+			return nil
+		}
+	}
+}
+
+// writeComStmtClose closes the stmtID prepared statement on the
+// server. The server does not reply.
+// Client -> Server.
+// Returns SQLError(CRServerGone) if it can't.
+func (c *Conn) writeComStmtClose(stmtID uint32) error {
+	// This is a new command, need to reset the sequence.
+	c.resetSequence()
+
+	data := c.startEphemeralPacket(1 + 4)
+	pos := writeByte(data, 0, ComStmtClose)
+	writeUint32(data, pos, stmtID)
+	if err := c.writeEphemeralPacket(); err != nil {
+		return NewSQLError(CRServerGone, SSUnknownSQLState, err.Error())
+	}
+	return nil
+}
+
+// writeComStmtReset resets the stmtID prepared statement on the
+// server, dropping the long data sent for its parameters. The server
+// replies with an OK or an error packet.
+// Client -> Server.
+// Returns SQLError(CRServerGone) if it can't.
+func (c *Conn) writeComStmtReset(stmtID uint32) error {
+	// This is a new command, need to reset the sequence.
+	c.resetSequence()
+
+	data := c.startEphemeralPacket(1 + 4)
+	pos := writeByte(data, 0, ComStmtReset)
+	writeUint32(data, pos, stmtID)
+	if err := c.writeEphemeralPacket(); err != nil {
+		return NewSQLError(CRServerGone, SSUnknownSQLState, err.Error())
+	}
+	return nil
+}
+
 // readColumnDefinition reads the next Column Definition packet.
 // Returns a SQLError.
 func (c *Conn) readColumnDefinition(field *querypb.Field, index int) error {
@@ -255,6 +592,9 @@ func (c *Conn) parseRow(data []byte, fields []*querypb.Field) ([]sqltypes.Value,
 	result := make([]sqltypes.Value, colNumber)
 	pos := 0
 	for i := 0; i < colNumber; i++ {
+		if pos >= len(data) {
+			return nil, NewSQLError(CRMalformedPacket, SSUnknownSQLState, "row has %v values, expected %v", i, colNumber)
+		}
 		if data[pos] == NullValue {
 			pos++
 			continue
@@ -270,6 +610,158 @@ func (c *Conn) parseRow(data []byte, fields []*querypb.Field) ([]sqltypes.Value,
 	return result, nil
 }
 
+// parseBinaryRow parses an individual row of the binary protocol, as
+// sent for COM_STMT_EXECUTE. The values are decoded from the types of
+// the fields into their text protocol form. See
+// https://dev.mysql.com/doc/dev/mysql-server/latest/page_protocol_binary_resultset.html#sect_protocol_binary_resultset_row
+// Returns a SQLError.
+func (c *Conn) parseBinaryRow(data []byte, fields []*querypb.Field) ([]sqltypes.Value, error) {
+	if len(data) == 0 || data[0] != 0x00 {
+		return nil, NewSQLError(CRMalformedPacket, SSUnknownSQLState, "invalid binary row header")
+	}
+	// The two first bits of the NULL-bitmap are reserved.
+	pos := 1 + (len(fields)+7+2)/8
+	if pos > len(data) {
+		return nil, NewSQLError(CRMalformedPacket, SSUnknownSQLState, "binary row is too short for its NULL-bitmap")
+	}
+	result := make([]sqltypes.Value, len(fields))
+	for i, field := range fields {
+		bit := i + 2
+		if data[1+bit/8]&(1<<uint(bit%8)) != 0 {
+			continue
+		}
+		var ok bool
+		result[i], pos, ok = parseBinaryValue(data, pos, field)
+		if !ok {
+			return nil, NewSQLError(CRMalformedPacket, SSUnknownSQLState, "decoding value %v of type %v failed", i, field.Type)
+		}
+	}
+	if pos != len(data) {
+		return nil, NewSQLError(CRMalformedPacket, SSUnknownSQLState, "binary row has %v extra bytes", len(data)-pos)
+	}
+	return result, nil
+}
+
+// notFixedDecimals is the number of decimals MySQL sends for the
+// floats and temporal values without a fixed number of decimals.
+const notFixedDecimals = 31
+
+// parseBinaryValue parses a value of the binary protocol, and returns
+// it in its text protocol form. As in the text protocol, the floats
+// and the fractional seconds have field.Decimals digits, if it is set.
+func parseBinaryValue(data []byte, pos int, field *querypb.Field) (sqltypes.Value, int, bool) {
+	typ := field.Type
+	switch typ {
+	case sqltypes.Null:
+		return sqltypes.NULL, pos, true
+	case sqltypes.Int8:
+		val, pos, ok := readByte(data, pos)
+		return sqltypes.MakeTrusted(typ, strconv.AppendInt(nil, int64(int8(val)), 10)), pos, ok
+	case sqltypes.Uint8:
+		val, pos, ok := readByte(data, pos)
+		return sqltypes.MakeTrusted(typ, strconv.AppendUint(nil, uint64(val), 10)), pos, ok
+	case sqltypes.Int16:
+		val, pos, ok := readUint16(data, pos)
+		return sqltypes.MakeTrusted(typ, strconv.AppendInt(nil, int64(int16(val)), 10)), pos, ok
+	case sqltypes.Uint16:
+		val, pos, ok := readUint16(data, pos)
+		return sqltypes.MakeTrusted(typ, strconv.AppendUint(nil, uint64(val), 10)), pos, ok
+	case sqltypes.Year:
+		val, pos, ok := readUint16(data, pos)
+		return sqltypes.MakeTrusted(typ, []byte(fmt.Sprintf("%04d", val))), pos, ok
+	case sqltypes.Int24, sqltypes.Int32:
+		val, pos, ok := readUint32(data, pos)
+		return sqltypes.MakeTrusted(typ, strconv.AppendInt(nil, int64(int32(val)), 10)), pos, ok
+	case sqltypes.Uint24, sqltypes.Uint32:
+		val, pos, ok := readUint32(data, pos)
+		return sqltypes.MakeTrusted(typ, strconv.AppendUint(nil, uint64(val), 10)), pos, ok
+	case sqltypes.Int64:
+		val, pos, ok := readUint64(data, pos)
+		return sqltypes.MakeTrusted(typ, strconv.AppendInt(nil, int64(val), 10)), pos, ok
+	case sqltypes.Uint64:
+		val, pos, ok := readUint64(data, pos)
+		return sqltypes.MakeTrusted(typ, strconv.AppendUint(nil, val, 10)), pos, ok
+	case sqltypes.Float32:
+		val, pos, ok := readUint32(data, pos)
+		return sqltypes.MakeTrusted(typ, appendBinaryFloat(float64(math.Float32frombits(val)), 32, field.Decimals)), pos, ok
+	case sqltypes.Float64:
+		val, pos, ok := readUint64(data, pos)
+		return sqltypes.MakeTrusted(typ, appendBinaryFloat(math.Float64frombits(val), 64, field.Decimals)), pos, ok
+	case sqltypes.Timestamp, sqltypes.Date, sqltypes.Datetime:
+		size, pos, ok := readByte(data, pos)
+		if !ok || (size != 0 && size != 4 && size != 7 && size != 11) || len(data) < pos+int(size) {
+			return sqltypes.NULL, 0, false
+		}
+		// The trailing zero parts are not sent, zero dates are empty.
+		value := data[pos : pos+int(size)]
+		var year uint16
+		var month, day, hour, minute, second byte
+		var microSecond uint32
+		if size >= 4 {
+			year, _, _ = readUint16(value, 0)
+			month, day = value[2], value[3]
+		}
+		if size >= 7 {
+			hour, minute, second = value[4], value[5], value[6]
+		}
+		if size == 11 {
+			microSecond, _, _ = readUint32(value, 7)
+		}
+		val := fmt.Sprintf("%04d-%02d-%02d", year, month, day)
+		if typ != sqltypes.Date {
+			val += fmt.Sprintf(" %02d:%02d:%02d", hour, minute, second) + binaryFraction(microSecond, size == 11, field.Decimals)
+		}
+		return sqltypes.MakeTrusted(typ, []byte(val)), pos + int(size), true
+	case sqltypes.Time:
+		size, pos, ok := readByte(data, pos)
+		if !ok || (size != 0 && size != 8 && size != 12) || len(data) < pos+int(size) {
+			return sqltypes.NULL, 0, false
+		}
+		value := data[pos : pos+int(size)]
+		val := "00:00:00"
+		var microSecond uint32
+		if size >= 8 {
+			days, _, _ := readUint32(value, 1)
+			hours := uint64(days)*24 + uint64(value[5])
+			val = fmt.Sprintf("%02d:%02d:%02d", hours, value[6], value[7])
+			if value[0] == 0x01 {
+				val = "-" + val
+			}
+		}
+		if size == 12 {
+			microSecond, _, _ = readUint32(value, 8)
+		}
+		val += binaryFraction(microSecond, size == 12, field.Decimals)
+		return sqltypes.MakeTrusted(typ, []byte(val)), pos + int(size), true
+	default:
+		// Everything else, DECIMAL included, is a string.
+		val, pos, ok := readLenEncStringAsBytesCopy(data, pos)
+		return sqltypes.MakeTrusted(typ, val), pos, ok
+	}
+}
+
+// appendBinaryFloat formats a float of the binary protocol with
+// decimals digits, or as few as needed if it is not set.
+func appendBinaryFloat(f float64, bitSize int, decimals uint32) []byte {
+	if decimals > 0 && decimals < notFixedDecimals {
+		return strconv.AppendFloat(nil, f, 'f', int(decimals), bitSize)
+	}
+	return strconv.AppendFloat(nil, f, 'g', -1, bitSize)
+}
+
+// binaryFraction returns the fractional seconds of a temporal value
+// of the binary protocol: decimals digits if it is set, else all of
+// them if they were sent.
+func binaryFraction(microSecond uint32, sent bool, decimals uint32) string {
+	switch {
+	case decimals > 0 && decimals <= 6:
+		return fmt.Sprintf(".%06d", microSecond)[:1+decimals]
+	case sent:
+		return fmt.Sprintf(".%06d", microSecond)
+	}
+	return ""
+}
+
 // ExecuteFetch executes a query and returns the result.
 // Returns a SQLError. Depending on the transport used, the error
 // returned might be different for the same condition:
@@ -310,15 +802,61 @@ func (c *Conn) ExecuteFetchMulti(query string, maxrows int, wantfields bool) (re
 		}
 	}()
 
+	if err = c.startCommand(); err != nil {
+		return nil, 0, err
+	}
+	defer c.endCommand()
+
 	// Send the query as a COM_QUERY packet.
-	if err = c.WriteComQuery(query); err != nil {
+	if err = c.writeComQuery(query); err != nil {
 		return nil, 0, err
 	}
 
-	res, status, _, err := c.ReadQueryResult(maxrows, wantfields)
+	res, status, _, err := c.readQueryResult(maxrows, wantfields)
 	return res, status, err
 }
 
+// ExecuteFetchAll executes a multi-statement query, and returns the
+// results of all its statements, in order. maxrows applies to each
+// result separately. The connection must have negotiated
+// CapabilityClientMultiStatements. If a statement fails, the
+// statements after it are not run, and its error is returned.
+// Returns a SQLError.
+func (c *Conn) ExecuteFetchAll(query string, maxrows int) (results []*sqltypes.Result, err error) {
+	defer func() {
+		if err != nil {
+			if sqlerr, ok := err.(*SQLError); ok {
+				sqlerr.Query = query
+			}
+		}
+	}()
+
+	if c.Capabilities&CapabilityClientMultiStatements == 0 {
+		return nil, NewSQLError(CRUnknownError, SSUnknownSQLState, "multi statements are not enabled on this connection")
+	}
+
+	if err = c.startCommand(); err != nil {
+		return nil, err
+	}
+	defer c.endCommand()
+
+	// Send the query as a COM_QUERY packet.
+	if err = c.writeComQuery(query); err != nil {
+		return nil, err
+	}
+
+	for {
+		res, status, _, err := c.readQueryResult(maxrows, true)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, res)
+		if !status.hasMore() {
+			return results, nil
+		}
+	}
+}
+
 // ExecuteFetchWithWarningCount is for fetching results and a warning count
 // Note: In a future iteration this should be abolished and merged into the
 // ExecuteFetch API.
@@ -331,17 +869,41 @@ func (c *Conn) ExecuteFetchWithWarningCount(query string, maxrows int, wantfield
 		}
 	}()
 
+	if err = c.startCommand(); err != nil {
+		return nil, 0, err
+	}
+	defer c.endCommand()
+
 	// Send the query as a COM_QUERY packet.
-	if err = c.WriteComQuery(query); err != nil {
+	if err = c.writeComQuery(query); err != nil {
 		return nil, 0, err
 	}
 
-	res, _, warnings, err := c.ReadQueryResult(maxrows, wantfields)
+	res, _, warnings, err := c.readQueryResult(maxrows, wantfields)
 	return res, warnings, err
 }
 
-// ReadQueryResult gets the result from the last written query.
+// ReadQueryResult gets the result from the last written query. The
+// rows are read in the binary protocol if it was a COM_STMT_EXECUTE.
 func (c *Conn) ReadQueryResult(maxrows int, wantfields bool) (result *sqltypes.Result, status serverStatus, warnings uint16, err error) {
+	if err := c.startCommand(); err != nil {
+		return nil, 0, 0, err
+	}
+	defer c.endCommand()
+	return c.readQueryResult(maxrows, wantfields)
+}
+
+// readQueryResult is ReadQueryResult, for the client methods that
+// already called startCommand. A negative maxrows means no limit.
+func (c *Conn) readQueryResult(maxrows int, wantfields bool) (result *sqltypes.Result, status serverStatus, warnings uint16, err error) {
+	return c.readResult(maxrows, wantfields, nil)
+}
+
+// readResult reads the result of the last written query. The rows are
+// passed to onRow as they are read, or added to the result if onRow is
+// nil. If onRow fails, the rest of the result is not read, and the
+// connection is closed.
+func (c *Conn) readResult(maxrows int, wantfields bool, onRow func([]sqltypes.Value) error) (result *sqltypes.Result, status serverStatus, warnings uint16, err error) {
 	// Get the result.
 	affectedRows, lastInsertID, numCols, status, warnings, err := c.readComQueryResponse()
 	if err != nil {
@@ -351,11 +913,18 @@ func (c *Conn) ReadQueryResult(maxrows int, wantfields bool) (result *sqltypes.R
 	if numCols == 0 {
 		// OK packet, means no results. Just use the numbers.
 		return &sqltypes.Result{
-			RowsAffected: affectedRows,
-			InsertID:     lastInsertID,
+			RowsAffected:        affectedRows,
+			InsertID:            lastInsertID,
+			SessionStateChanges: c.sessionStateChanges,
 		}, status, warnings, nil
 	}
 
+	// Column definitions and the EOF that may follow them.
+	budget := c.newResultBudget()
+	if err := budget.addPackets(numCols + 1); err != nil {
+		return nil, 0, 0, c.abortResult(err)
+	}
+
 	fields := make([]querypb.Field, numCols)
 	result = &sqltypes.Result{
 		Fields: make([]*querypb.Field, numCols),
@@ -403,6 +972,7 @@ func (c *Conn) ReadQueryResult(maxrows int, wantfields bool) (result *sqltypes.R
 	}
 
 	// read each row until EOF or OK packet.
+	rows := 0
 	for {
 		data, err := c.ReadPacket()
 		if err != nil {
@@ -414,7 +984,7 @@ func (c *Conn) ReadQueryResult(maxrows int, wantfields bool) (result *sqltypes.R
 			if !wantfields {
 				result.Fields = nil
 			}
-			result.RowsAffected = uint64(len(result.Rows))
+			result.RowsAffected = uint64(rows)
 
 			// The deprecated EOF packets change means that this is either an
 			// EOF packet or an OK packet with the EOF type code.
@@ -428,6 +998,11 @@ func (c *Conn) ReadQueryResult(maxrows int, wantfields bool) (result *sqltypes.R
 				if err != nil {
 					return nil, 0, 0, err
 				}
+				if c.Capabilities&CapabilityClientSessionTrack != 0 {
+					if result.SessionStateChanges, err = parseOKSessionState(data); err != nil {
+						return nil, 0, 0, err
+					}
+				}
 			}
 			return result, status, warnings, nil
 		} else if isErrorPacket(data) {
@@ -435,25 +1010,41 @@ func (c *Conn) ReadQueryResult(maxrows int, wantfields bool) (result *sqltypes.R
 		}
 
 		// Check we're not over the limit before we add more.
-		if len(result.Rows) == maxrows {
+		if rows == maxrows {
 			if err := c.drainResults(); err != nil {
 				return nil, 0, 0, err
 			}
-			return nil, 0, 0, NewSQLError(ERVitessMaxRowsExceeded, SSUnknownSQLState, "Row count exceeded %d", maxrows)
+			return nil, 0, 0, NewSQLErrorFromCode(ERVitessMaxRowsExceeded, maxrows)
 		}
 
 		// Regular row.
-		row, err := c.parseRow(data, result.Fields)
+		if err := budget.addRow(len(data)); err != nil {
+			return nil, 0, 0, c.abortResult(err)
+		}
+		var row []sqltypes.Value
+		if c.binaryRows {
+			row, err = c.parseBinaryRow(data, result.Fields)
+		} else {
+			row, err = c.parseRow(data, result.Fields)
+		}
 		if err != nil {
-			return nil, 0, 0, err
+			return nil, 0, 0, c.abortResult(err)
+		}
+		rows++
+		if onRow == nil {
+			result.Rows = append(result.Rows, row)
+		} else if err := onRow(row); err != nil {
+			return nil, 0, 0, c.abortResult(err)
 		}
-		result.Rows = append(result.Rows, row)
 	}
 }
 
 // FetchQueryResult gets the reset set from the last executed query.
+// It reads the rows a COM_STMT_FETCH returns from a cursor, which are
+// always in the binary protocol.
 func (c *Conn) FetchQueryResult(maxrows int, fields []*querypb.Field) (result *sqltypes.Result, status serverStatus, warnings uint16, err error) {
 	result = &sqltypes.Result{}
+	budget := c.newResultBudget()
 
 	// read each row until EOF or OK packet.
 	for {
@@ -477,6 +1068,11 @@ func (c *Conn) FetchQueryResult(maxrows int, fields []*querypb.Field) (result *s
 				if err != nil {
 					return nil, 0, 0, err
 				}
+				if c.Capabilities&CapabilityClientSessionTrack != 0 {
+					if result.SessionStateChanges, err = parseOKSessionState(data); err != nil {
+						return nil, 0, 0, err
+					}
+				}
 			}
 			return result, status, warnings, nil
 		} else if isErrorPacket(data) {
@@ -488,13 +1084,16 @@ func (c *Conn) FetchQueryResult(maxrows int, fields []*querypb.Field) (result *s
 			if err := c.drainResults(); err != nil {
 				return nil, 0, 0, err
 			}
-			return nil, 0, 0, NewSQLError(ERVitessMaxRowsExceeded, SSUnknownSQLState, "Row count exceeded %d", maxrows)
+			return nil, 0, 0, NewSQLErrorFromCode(ERVitessMaxRowsExceeded, maxrows)
 		}
 
 		// Regular row.
-		row, err := c.parseRow(data, fields)
+		if err := budget.addRow(len(data)); err != nil {
+			return nil, 0, 0, c.abortResult(err)
+		}
+		row, err := c.parseBinaryRow(data, fields)
 		if err != nil {
-			return nil, 0, 0, err
+			return nil, 0, 0, c.abortResult(err)
 		}
 		result.Rows = append(result.Rows, row)
 	}
@@ -533,25 +1132,45 @@ func (s serverStatus) cursorLastRowSent() bool {
 }
 
 func (c *Conn) readComQueryResponse() (affectedRows uint64, lastInsertID uint64, numCols int, status serverStatus, warnings uint16, err error) {
+	c.sessionStateChanges = nil
 	data, err := c.readEphemeralPacket()
 	if err != nil {
 		return 0, 0, 0, 0, 0, NewSQLError(CRServerLost, SSUnknownSQLState, "%v", err)
 	}
-	defer c.recycleReadPacket()
 	if len(data) == 0 {
+		c.recycleReadPacket()
 		return 0, 0, 0, 0, 0, NewSQLError(CRMalformedPacket, SSUnknownSQLState, "invalid empty COM_QUERY response packet")
 	}
+	if data[0] == LocalInfilePacket {
+		// LOAD DATA LOCAL INFILE: the response of the query
+		// follows the file.
+		name := string(data[1:])
+		c.recycleReadPacket()
+		rejected, err := c.sendLocalInfile(name)
+		if err != nil {
+			return 0, 0, 0, 0, 0, NewSQLError(CRServerLost, SSUnknownSQLState, "%v", err)
+		}
+		affectedRows, lastInsertID, numCols, status, warnings, err = c.readComQueryResponse()
+		if err == nil && rejected != nil {
+			return 0, 0, 0, 0, 0, rejected
+		}
+		return affectedRows, lastInsertID, numCols, status, warnings, err
+	}
+	defer c.recycleReadPacket()
 
 	switch data[0] {
 	case OKPacket:
 		affectedRows, lastInsertID, status, warnings, err := parseOKPacket(data)
+		if err == nil && c.Capabilities&CapabilityClientSessionTrack != 0 {
+			c.sessionStateChanges, err = parseOKSessionState(data)
+			if percent, message, ok := progressFromSessionState(c.sessionStateChanges); ok {
+				c.lastProgress = &progressReport{percent: percent, message: message}
+			}
+		}
 		return affectedRows, lastInsertID, 0, serverStatus(status), warnings, err
 	case ErrPacket:
 		// Error
 		return 0, 0, 0, 0, 0, ParseErrorPacket(data)
-	case LocalInfilePacket:
-		// Local infile
-		return 0, 0, 0, 0, 0, vterrors.Errorf(vtrpc.Code_UNIMPLEMENTED, "not implemented")
 	}
 	n, pos, ok := readLenEncInt(data, 0)
 	if !ok {
@@ -560,6 +1179,9 @@ func (c *Conn) readComQueryResponse() (affectedRows uint64, lastInsertID uint64,
 	if pos != len(data) {
 		return 0, 0, 0, 0, 0, NewSQLError(CRMalformedPacket, SSUnknownSQLState, "extra data in COM_QUERY response")
 	}
+	if err := c.resultLimits.checkColumns(n); err != nil {
+		return 0, 0, 0, 0, 0, c.abortResult(err)
+	}
 	return 0, 0, int(n), 0, 0, nil
 }
 
@@ -567,8 +1189,94 @@ func (c *Conn) readComQueryResponse() (affectedRows uint64, lastInsertID uint64,
 // Server side methods.
 //
 
-func (c *Conn) parseComQuery(data []byte) string {
-	return string(data[1:])
+// parseComQuery returns the query of a COM_QUERY packet, and the query
+// attributes sent before it if the client negotiated
+// CLIENT_QUERY_ATTRIBUTES.
+func (c *Conn) parseComQuery(data []byte) (string, map[string]sqltypes.Value, error) {
+	if c.Capabilities&CapabilityClientQueryAttributes == 0 {
+		return string(data[1:]), nil, nil
+	}
+
+	count, pos, ok := readLenEncInt(data, 1)
+	if !ok {
+		return "", nil, NewSQLError(ERMalformedPacket, SSUnknownSQLState, "reading parameter count failed")
+	}
+	// The parameter set count is always 1.
+	if _, pos, ok = readLenEncInt(data, pos); !ok {
+		return "", nil, NewSQLError(ERMalformedPacket, SSUnknownSQLState, "reading parameter set count failed")
+	}
+	if count == 0 {
+		return string(data[pos:]), nil, nil
+	}
+	// Each attribute takes at least 3 bytes.
+	if count > uint64(len(data)) {
+		return "", nil, NewSQLError(ERMalformedPacket, SSUnknownSQLState, "invalid parameter count %v", count)
+	}
+
+	bitMap, pos, ok := readBytes(data, pos, int((count+7)/8))
+	if !ok {
+		return "", nil, NewSQLError(ERMalformedPacket, SSUnknownSQLState, "reading NULL-bitmap failed")
+	}
+	newParamsBoundFlag, pos, ok := readByte(data, pos)
+	if !ok {
+		return "", nil, NewSQLError(ERMalformedPacket, SSUnknownSQLState, "reading new-params-bound flag failed")
+	}
+	if newParamsBoundFlag != 0x01 {
+		return "", nil, NewSQLError(ERMalformedPacket, SSUnknownSQLState, "query attributes sent without their types")
+	}
+	types := make([]querypb.Type, count)
+	names := make([]string, count)
+	for i := range types {
+		var err error
+		if types[i], pos, err = readParamType(data, pos); err != nil {
+			return "", nil, err
+		}
+		if names[i], pos, ok = readLenEncString(data, pos); !ok {
+			return "", nil, NewSQLError(ERMalformedPacket, SSUnknownSQLState, "reading query attribute name failed")
+		}
+	}
+	attributes, pos, err := c.parseQueryAttributes(data, pos, bitMap, 0, types, names)
+	if err != nil {
+		return "", nil, err
+	}
+	return string(data[pos:]), attributes, nil
+}
+
+// readParamType reads the type and flags of a parameter of
+// COM_STMT_EXECUTE, or of a query attribute.
+func readParamType(data []byte, pos int) (querypb.Type, int, error) {
+	mysqlType, pos, ok := readByte(data, pos)
+	if !ok {
+		return 0, 0, NewSQLError(ERMalformedPacket, SSUnknownSQLState, "reading parameter type failed")
+	}
+	flags, pos, ok := readByte(data, pos)
+	if !ok {
+		return 0, 0, NewSQLError(ERMalformedPacket, SSUnknownSQLState, "reading parameter flags failed")
+	}
+	// convert MySQL type to internal type.
+	valType, err := sqltypes.MySQLToType(int64(mysqlType), int64(flags))
+	if err != nil {
+		return 0, 0, NewSQLError(ERMalformedPacket, SSUnknownSQLState, "MySQLToType(%v,%v) failed: %v", mysqlType, flags, err)
+	}
+	return valType, pos, nil
+}
+
+// parseQueryAttributes reads the values of the query attributes of
+// the given types and names. The first one is at index first in the
+// NULL-bitmap.
+func (c *Conn) parseQueryAttributes(data []byte, pos int, bitMap []byte, first int, types []querypb.Type, names []string) (map[string]sqltypes.Value, int, error) {
+	attributes := make(map[string]sqltypes.Value, len(types))
+	for i, typ := range types {
+		var val sqltypes.Value
+		var ok bool
+		if bit := first + i; bitMap[bit/8]&(1<<uint(bit%8)) > 0 {
+			val = sqltypes.NULL
+		} else if val, pos, ok = c.parseStmtArgs(data, typ, pos); !ok {
+			return nil, 0, NewSQLError(ERMalformedPacket, SSUnknownSQLState, "decoding query attribute value failed: %v", typ)
+		}
+		attributes[names[i]] = val
+	}
+	return attributes, pos, nil
 }
 
 // support for deprecated COM_FIELD_LIST command
@@ -598,6 +1306,11 @@ func (c *Conn) parseComPrepare(data []byte) string {
 	return string(data[1:])
 }
 
+// parseComStmtExecute parses a COM_STMT_EXECUTE, and stores the
+// parameters in the PrepareData of the statement. It returns the
+// statement ID and the cursor type.
+// Returns SQLError(ERMalformedPacket) if the packet is truncated or
+// invalid.
 func (c *Conn) parseComStmtExecute(prepareData map[uint32]*PrepareData, data []byte) (uint32, byte, error) {
 	pos := 0
 	payload := data[1:]
@@ -606,56 +1319,96 @@ func (c *Conn) parseComStmtExecute(prepareData map[uint32]*PrepareData, data []b
 	// statement ID
 	stmtID, pos, ok := readUint32(payload, 0)
 	if !ok {
-		return 0, 0, NewSQLError(CRMalformedPacket, SSUnknownSQLState, "reading statement ID failed")
+		return 0, 0, NewSQLError(ERMalformedPacket, SSUnknownSQLState, "reading statement ID failed")
 	}
 	prepare, ok := prepareData[stmtID]
 	if !ok {
 		return 0, 0, NewSQLError(CRCommandsOutOfSync, SSUnknownSQLState, "statement ID is not found from record")
 	}
 
+	// The parameter types and the NULL-bitmap are both indexed up to
+	// ParamsCount, whatever the Handler left in ParamsType.
+	if len(prepare.ParamsType) != int(prepare.ParamsCount) {
+		paramsType := make([]int32, prepare.ParamsCount)
+		copy(paramsType, prepare.ParamsType)
+		prepare.ParamsType = paramsType
+	}
+
 	// cursor type flags
 	cursorType, pos, ok := readByte(payload, pos)
 	if !ok {
-		return stmtID, 0, NewSQLError(CRMalformedPacket, SSUnknownSQLState, "reading cursor type flags failed")
+		return stmtID, 0, NewSQLError(ERMalformedPacket, SSUnknownSQLState, "reading cursor type flags failed")
 	}
 
 	// iteration count
 	iterCount, pos, ok := readUint32(payload, pos)
 	if !ok {
-		return stmtID, 0, NewSQLError(CRMalformedPacket, SSUnknownSQLState, "reading iteration count failed")
+		return stmtID, 0, NewSQLError(ERMalformedPacket, SSUnknownSQLState, "reading iteration count failed")
 	}
 	if iterCount != uint32(1) {
-		return stmtID, 0, NewSQLError(CRMalformedPacket, SSUnknownSQLState, "iteration count is not equal to 1")
+		return stmtID, 0, NewSQLError(ERMalformedPacket, SSUnknownSQLState, "iteration count is not equal to 1")
 	}
 
-	if prepare.ParamsCount > 0 {
-		bitMap, pos, ok = readBytes(payload, pos, int((prepare.ParamsCount+7)/8))
+	// With CLIENT_QUERY_ATTRIBUTES, the query attributes follow the
+	// parameters, and share their NULL-bitmap. All of them are counted
+	// in the parameter count sent first, and their types are followed
+	// by their names.
+	paramsCount := uint64(prepare.ParamsCount)
+	queryAttributes := c.Capabilities&CapabilityClientQueryAttributes != 0
+	if queryAttributes && (paramsCount > 0 || cursorType&ParameterCountAvailable != 0) {
+		paramsCount, pos, ok = readLenEncInt(payload, pos)
 		if !ok {
-			return stmtID, 0, NewSQLError(CRMalformedPacket, SSUnknownSQLState, "reading NULL-bitmap failed")
+			return stmtID, 0, NewSQLError(ERMalformedPacket, SSUnknownSQLState, "reading parameter count failed")
+		}
+		// Each attribute takes at least 3 bytes.
+		if paramsCount < uint64(prepare.ParamsCount) || paramsCount > uint64(len(payload)) {
+			return stmtID, 0, NewSQLError(ERMalformedPacket, SSUnknownSQLState, "invalid parameter count %v for %v parameters", paramsCount, prepare.ParamsCount)
 		}
 	}
+	cursorType &^= ParameterCountAvailable
 
-	newParamsBoundFlag, pos, ok := readByte(payload, pos)
-	if ok && newParamsBoundFlag == 0x01 {
-		var mysqlType, flags byte
-		for i := uint16(0); i < prepare.ParamsCount; i++ {
-			mysqlType, pos, ok = readByte(payload, pos)
-			if !ok {
-				return stmtID, 0, NewSQLError(CRMalformedPacket, SSUnknownSQLState, "reading parameter type failed")
-			}
+	if paramsCount > 0 {
+		bitMap, pos, ok = readBytes(payload, pos, int((paramsCount+7)/8))
+		if !ok {
+			return stmtID, 0, NewSQLError(ERMalformedPacket, SSUnknownSQLState, "reading NULL-bitmap failed")
+		}
+	}
 
-			flags, pos, ok = readByte(payload, pos)
-			if !ok {
-				return stmtID, 0, NewSQLError(CRMalformedPacket, SSUnknownSQLState, "reading parameter flags failed")
+	var newParamsBoundFlag byte
+	if paramsCount > 0 {
+		newParamsBoundFlag, pos, ok = readByte(payload, pos)
+		if !ok {
+			return stmtID, 0, NewSQLError(ERMalformedPacket, SSUnknownSQLState, "reading new-params-bound flag failed")
+		}
+	}
+	attributesCount := int(paramsCount) - int(prepare.ParamsCount)
+	if attributesCount > 0 && newParamsBoundFlag != 0x01 {
+		return stmtID, 0, NewSQLError(ERMalformedPacket, SSUnknownSQLState, "query attributes sent without their types")
+	}
+	attributeTypes := make([]querypb.Type, attributesCount)
+	attributeNames := make([]string, attributesCount)
+	if newParamsBoundFlag == 0x01 {
+		for i := 0; i < int(paramsCount); i++ {
+			valType, next, err := readParamType(payload, pos)
+			if err != nil {
+				return stmtID, 0, err
 			}
+			pos = next
 
-			// convert MySQL type to internal type.
-			valType, err := sqltypes.MySQLToType(int64(mysqlType), int64(flags))
-			if err != nil {
-				return stmtID, 0, NewSQLError(CRMalformedPacket, SSUnknownSQLState, "MySQLToType(%v,%v) failed: %v", mysqlType, flags, err)
+			var name string
+			if queryAttributes {
+				name, pos, ok = readLenEncString(payload, pos)
+				if !ok {
+					return stmtID, 0, NewSQLError(ERMalformedPacket, SSUnknownSQLState, "reading parameter name failed")
+				}
 			}
 
-			prepare.ParamsType[i] = int32(valType)
+			if i < int(prepare.ParamsCount) {
+				prepare.ParamsType[i] = int32(valType)
+			} else {
+				attributeTypes[i-int(prepare.ParamsCount)] = valType
+				attributeNames[i-int(prepare.ParamsCount)] = name
+			}
 		}
 	}
 
@@ -674,12 +1427,20 @@ func (c *Conn) parseComStmtExecute(prepareData map[uint32]*PrepareData, data []b
 			val, pos, ok = c.parseStmtArgs(payload, querypb.Type(prepare.ParamsType[i]), pos)
 		}
 		if !ok {
-			return stmtID, 0, NewSQLError(CRMalformedPacket, SSUnknownSQLState, "decoding parameter value failed: %v", prepare.ParamsType[i])
+			return stmtID, 0, NewSQLError(ERMalformedPacket, SSUnknownSQLState, "decoding parameter value failed: %v", prepare.ParamsType[i])
 		}
 
 		prepare.BindVars[parameterID] = sqltypes.ValueBindVariable(val)
 	}
 
+	if attributesCount > 0 {
+		attributes, _, err := c.parseQueryAttributes(payload, pos, bitMap, int(prepare.ParamsCount), attributeTypes, attributeNames)
+		if err != nil {
+			return stmtID, 0, err
+		}
+		c.QueryAttributes = attributes
+	}
+
 	return stmtID, cursorType, nil
 }
 
@@ -818,89 +1579,7 @@ func (c *Conn) parseStmtArgs(data []byte, typ querypb.Type, pos int) (sqltypes.V
 			return sqltypes.NULL, 0, false
 		}
 	case sqltypes.Time:
-		size, pos, ok := readByte(data, pos)
-		if !ok {
-			return sqltypes.NULL, 0, false
-		}
-		switch size {
-		case 0x00:
-			return sqltypes.NewVarChar("00:00:00"), pos, ok
-		case 0x0c:
-			isNegative, pos, ok := readByte(data, pos)
-			if !ok {
-				return sqltypes.NULL, 0, false
-			}
-			days, pos, ok := readUint32(data, pos)
-			if !ok {
-				return sqltypes.NULL, 0, false
-			}
-			hour, pos, ok := readByte(data, pos)
-			if !ok {
-				return sqltypes.NULL, 0, false
-			}
-
-			hours := uint32(hour) + days*uint32(24)
-
-			minute, pos, ok := readByte(data, pos)
-			if !ok {
-				return sqltypes.NULL, 0, false
-			}
-			second, pos, ok := readByte(data, pos)
-			if !ok {
-				return sqltypes.NULL, 0, false
-			}
-			microSecond, pos, ok := readUint32(data, pos)
-			if !ok {
-				return sqltypes.NULL, 0, false
-			}
-
-			val := ""
-			if isNegative == 0x01 {
-				val += "-"
-			}
-			val += strconv.Itoa(int(hours)) + ":" +
-				strconv.Itoa(int(minute)) + ":" +
-				strconv.Itoa(int(second)) + "." +
-				strconv.Itoa(int(microSecond))
-
-			return sqltypes.NewVarChar(val), pos, ok
-		case 0x08:
-			isNegative, pos, ok := readByte(data, pos)
-			if !ok {
-				return sqltypes.NULL, 0, false
-			}
-			days, pos, ok := readUint32(data, pos)
-			if !ok {
-				return sqltypes.NULL, 0, false
-			}
-			hour, pos, ok := readByte(data, pos)
-			if !ok {
-				return sqltypes.NULL, 0, false
-			}
-
-			hours := uint32(hour) + days*uint32(24)
-
-			minute, pos, ok := readByte(data, pos)
-			if !ok {
-				return sqltypes.NULL, 0, false
-			}
-			second, pos, ok := readByte(data, pos)
-			if !ok {
-				return sqltypes.NULL, 0, false
-			}
-
-			val := ""
-			if isNegative == 0x01 {
-				val += "-"
-			}
-			val += strconv.Itoa(int(hours)) + ":" +
-				strconv.Itoa(int(minute)) + ":" +
-				strconv.Itoa(int(second))
-
-			return sqltypes.NewVarChar(val), pos, ok
-		default:
-			return sqltypes.NULL, 0, false
-		}
+		return parseBinaryTime(data, pos)
 	case sqltypes.Decimal, sqltypes.Text, sqltypes.Blob, sqltypes.VarChar, sqltypes.VarBinary, sqltypes.Char,
 		sqltypes.Bit, sqltypes.Enum, sqltypes.Set, sqltypes.Geometry, sqltypes.Binary, sqltypes.TypeJSON:
 		val, pos, ok := readLenEncStringAsBytesCopy(data, pos)
@@ -910,6 +1589,46 @@ func (c *Conn) parseStmtArgs(data []byte, typ querypb.Type, pos int) (sqltypes.V
 	}
 }
 
+// parseBinaryTime parses a TIME value of the binary protocol, and
+// returns it as a string with the microseconds if they were sent. See
+// https://dev.mysql.com/doc/dev/mysql-server/latest/page_protocol_binary_resultset.html#sect_protocol_binary_resultset_row_value_time
+func parseBinaryTime(data []byte, pos int) (sqltypes.Value, int, bool) {
+	size, pos, ok := readByte(data, pos)
+	if !ok {
+		return sqltypes.NULL, 0, false
+	}
+	if size == 0x00 {
+		return sqltypes.NewVarChar("00:00:00"), pos, true
+	}
+	if size != 0x08 && size != 0x0c {
+		return sqltypes.NULL, 0, false
+	}
+	if len(data) < pos+int(size) {
+		return sqltypes.NULL, 0, false
+	}
+
+	isNegative, pos, _ := readByte(data, pos)
+	days, pos, _ := readUint32(data, pos)
+	hour, pos, _ := readByte(data, pos)
+	minute, pos, _ := readByte(data, pos)
+	second, pos, _ := readByte(data, pos)
+	var microSecond uint32
+	if size == 0x0c {
+		microSecond, pos, _ = readUint32(data, pos)
+	}
+
+	val := ""
+	if isNegative == 0x01 {
+		val = "-"
+	}
+	hours := uint64(days)*24 + uint64(hour)
+	val += fmt.Sprintf("%02d:%02d:%02d", hours, minute, second)
+	if size == 0x0c {
+		val += fmt.Sprintf(".%06d", microSecond)
+	}
+	return sqltypes.NewVarChar(val), pos, true
+}
+
 func (c *Conn) parseComStmtSendLongData(data []byte) (uint32, uint16, []byte, bool) {
 	pos := 1
 	statementID, pos, ok := readUint32(data, pos)
@@ -984,6 +1703,15 @@ func (c *Conn) writeColumnDefinition(field *querypb.Field, withDefaults bool) er
 		flags = int64(field.Flags)
 	}
 
+	charset := uint16(field.Charset)
+	columnLength := field.ColumnLength
+	filler := uint16(0x0000)
+	if c.isBase64Column(field) {
+		charset = CharacterSetUtf8
+		columnLength = base64ColumnLength(columnLength)
+		filler = ColumnBase64Encoded
+	}
+
 	data := c.startEphemeralPacket(length)
 	pos := 0
 
@@ -994,12 +1722,12 @@ func (c *Conn) writeColumnDefinition(field *querypb.Field, withDefaults bool) er
 	pos = writeLenEncString(data, pos, field.Name)
 	pos = writeLenEncString(data, pos, field.OrgName)
 	pos = writeByte(data, pos, 0x0c)
-	pos = writeUint16(data, pos, uint16(field.Charset))
-	pos = writeUint32(data, pos, field.ColumnLength)
+	pos = writeUint16(data, pos, charset)
+	pos = writeUint32(data, pos, columnLength)
 	pos = writeByte(data, pos, byte(typ))
 	pos = writeUint16(data, pos, uint16(flags))
 	pos = writeByte(data, pos, byte(field.Decimals))
-	pos = writeUint16(data, pos, uint16(0x0000))
+	pos = writeUint16(data, pos, filler)
 
 	if withDefaults {
 		pos = writeLenEncString(data, pos, defaultVal)
@@ -1013,10 +1741,17 @@ func (c *Conn) writeColumnDefinition(field *querypb.Field, withDefaults bool) er
 }
 
 func (c *Conn) writeRow(row []sqltypes.Value) error {
+	row, err := encodeRow(c.base64Columns, row)
+	if err != nil {
+		return err
+	}
+
 	length := 0
 	for _, val := range row {
 		if val.IsNull() {
 			length++
+		} else if val.IsStream() {
+			return c.writeStreamRow(row)
 		} else {
 			l := len(val.Raw())
 			length += lenEncIntSize(uint64(l)) + l
@@ -1078,6 +1813,7 @@ func (c *Conn) writeFieldsWithoutEOF(result *sqltypes.Result) error {
 			return err
 		}
 	}
+	c.base64Columns = c.base64ColumnsFor(result.Fields)
 
 	return nil
 }
@@ -1101,7 +1837,7 @@ func (c *Conn) writeEndResult(more bool, affectedRows, lastInsertID uint64, warn
 	if more {
 		flags |= ServerMoreResultsExists
 	}
-	if c.Capabilities&CapabilityClientDeprecateEOF == 0 {
+	if c.Capabilities&CapabilityClientDeprecateEOF == 0 || c.quirks&ClientQuirkClassicEOF != 0 {
 		if err := c.writeEOFPacket(flags, warnings); err != nil {
 			return err
 		}
@@ -1181,6 +1917,11 @@ func (c *Conn) writePrepare(fld []*querypb.Field, prepare *PrepareData) error {
 }
 
 func (c *Conn) writeBinaryRow(fields []*querypb.Field, row []sqltypes.Value) error {
+	row, err := encodeRow(c.base64ColumnsFor(fields), row)
+	if err != nil {
+		return err
+	}
+
 	length := 0
 	nullBitMapLen := (len(fields) + 7 + 2) / 8
 	for _, val := range row {
@@ -1315,6 +2056,11 @@ func val2MySQL(v sqltypes.Value) ([]byte, error) {
 		out = make([]byte, 8)
 		writeUint64(out, pos, bits)
 	case sqltypes.Timestamp, sqltypes.Date, sqltypes.Datetime:
+		// The value is "YYYY-MM-DD", "YYYY-MM-DD HH:MM:SS" or
+		// "YYYY-MM-DD HH:MM:SS.ffffff".
+		if l := len(v.Raw()); (l > 0 && l < 10) || (l > 10 && l < 19) {
+			return []byte{}, fmt.Errorf("incorrect %v value %q", v.Type(), v.Raw())
+		}
 		if len(v.Raw()) > 19 {
 			out = make([]byte, 1+11)
 			out[pos] = 0x0b
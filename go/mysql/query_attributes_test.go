@@ -0,0 +1,286 @@
+/*
+Copyright 2019 The Vitess Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mysql
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/dolthub/vitess/go/sqltypes"
+
+	querypb "github.com/dolthub/vitess/go/vt/proto/query"
+)
+
+const traceparent = "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01"
+
+// queryAttributesPacket returns the query attributes block of a
+// COM_QUERY: a traceparent string, a NULL and an integer.
+func queryAttributesPacket() []byte {
+	data := []byte{3, 1}
+	// NULL-bitmap and new-params-bound flag.
+	data = append(data, 0x02, 0x01)
+	data = append(data, byte(TypeVarString), 0, 11)
+	data = append(data, "traceparent"...)
+	data = append(data, byte(TypeNull), 0, 4)
+	data = append(data, "null"...)
+	data = append(data, byte(TypeLongLong), 0, 5)
+	data = append(data, "count"...)
+	data = append(data, byte(len(traceparent)))
+	data = append(data, traceparent...)
+	return append(data, 42, 0, 0, 0, 0, 0, 0, 0)
+}
+
+var queryAttributesWant = map[string]sqltypes.Value{
+	"traceparent": sqltypes.NewVarBinary(traceparent),
+	"null":        sqltypes.NULL,
+	"count":       sqltypes.NewInt64(42),
+}
+
+func TestParseComQueryAttributes(t *testing.T) {
+	attributes := queryAttributesPacket()
+	for _, tcase := range []struct {
+		name         string
+		capabilities uint32
+		data         []byte
+		query        string
+		want         map[string]sqltypes.Value
+		err          string
+	}{{
+		name:  "no capability",
+		data:  []byte("\x03\x01select 1"),
+		query: "\x03\x01select 1",
+	}, {
+		name:         "no attributes",
+		capabilities: CapabilityClientQueryAttributes,
+		data:         []byte("\x00\x01select 1"),
+		query:        "select 1",
+	}, {
+		name:         "attributes",
+		capabilities: CapabilityClientQueryAttributes,
+		data:         append(append([]byte{}, attributes...), "select 1"...),
+		query:        "select 1",
+		want:         queryAttributesWant,
+	}, {
+		name:         "empty query",
+		capabilities: CapabilityClientQueryAttributes,
+		data:         attributes,
+		want:         queryAttributesWant,
+	}, {
+		name:         "no parameter set count",
+		capabilities: CapabilityClientQueryAttributes,
+		data:         []byte{0},
+		err:          "reading parameter set count failed",
+	}, {
+		name:         "no types",
+		capabilities: CapabilityClientQueryAttributes,
+		data:         []byte{1, 1, 0, 0},
+		err:          "query attributes sent without their types",
+	}, {
+		name:         "truncated name",
+		capabilities: CapabilityClientQueryAttributes,
+		data:         attributes[:10],
+		err:          "reading query attribute name failed",
+	}, {
+		name:         "truncated value",
+		capabilities: CapabilityClientQueryAttributes,
+		data:         attributes[:len(attributes)-1],
+		err:          "decoding query attribute value failed",
+	}, {
+		name:         "invalid count",
+		capabilities: CapabilityClientQueryAttributes,
+		data:         []byte{0xfc, 0xff, 0xff, 1},
+		err:          "invalid parameter count",
+	}} {
+		t.Run(tcase.name, func(t *testing.T) {
+			c := &Conn{Capabilities: tcase.capabilities}
+			query, got, err := c.parseComQuery(append([]byte{ComQuery}, tcase.data...))
+			if tcase.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tcase.err) {
+					t.Fatalf("parseComQuery returned %v, want error %q", err, tcase.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseComQuery failed: %v", err)
+			}
+			if query != tcase.query {
+				t.Errorf("parseComQuery returned query %q, want %q", query, tcase.query)
+			}
+			if !reflect.DeepEqual(got, tcase.want) {
+				t.Errorf("parseComQuery returned attributes %v, want %v", got, tcase.want)
+			}
+		})
+	}
+}
+
+func TestParseComStmtExecuteAttributes(t *testing.T) {
+	header := func(cursorType byte) []byte {
+		return []byte{ComStmtExecute, 1, 0, 0, 0, cursorType, 1, 0, 0, 0}
+	}
+	// One parameter, and a traceparent attribute.
+	withParam := append(header(NoCursor), 2, 0x00, 0x01)
+	withParam = append(withParam, byte(TypeLongLong), 0, 0)
+	withParam = append(withParam, byte(TypeVarString), 0, 11)
+	withParam = append(withParam, "traceparent"...)
+	withParam = append(withParam, 7, 0, 0, 0, 0, 0, 0, 0)
+	withParam = append(withParam, byte(len(traceparent)))
+	withParam = append(withParam, traceparent...)
+	// No parameter, the count is flagged.
+	withoutParam := append(header(ReadOnly|ParameterCountAvailable), 1, 0x00, 0x01)
+	withoutParam = append(withoutParam, byte(TypeVarString), 0, 11)
+	withoutParam = append(withoutParam, "traceparent"...)
+	withoutParam = append(withoutParam, byte(len(traceparent)))
+	withoutParam = append(withoutParam, traceparent...)
+	// The parameter of a client without the capability.
+	legacy := append(header(NoCursor), 0x00, 0x01, byte(TypeLongLong), 0)
+	legacy = append(legacy, 7, 0, 0, 0, 0, 0, 0, 0)
+
+	for _, tcase := range []struct {
+		name         string
+		capabilities uint32
+		paramsCount  uint16
+		data         []byte
+		cursorType   byte
+		want         map[string]sqltypes.Value
+		err          string
+	}{{
+		name:        "no capability",
+		paramsCount: 1,
+		data:        legacy,
+	}, {
+		name:         "parameter and attribute",
+		capabilities: CapabilityClientQueryAttributes,
+		paramsCount:  1,
+		data:         withParam,
+		want:         map[string]sqltypes.Value{"traceparent": sqltypes.NewVarBinary(traceparent)},
+	}, {
+		name:         "attribute only",
+		capabilities: CapabilityClientQueryAttributes,
+		data:         withoutParam,
+		cursorType:   ReadOnly,
+		want:         map[string]sqltypes.Value{"traceparent": sqltypes.NewVarBinary(traceparent)},
+	}, {
+		name:         "no attribute",
+		capabilities: CapabilityClientQueryAttributes,
+		data:         header(NoCursor),
+	}, {
+		name:         "fewer parameters than the statement",
+		capabilities: CapabilityClientQueryAttributes,
+		paramsCount:  2,
+		data:         append(header(NoCursor), 1, 0x00, 0x01),
+		err:          "invalid parameter count 1 for 2 parameters",
+	}, {
+		name:         "attribute without type",
+		capabilities: CapabilityClientQueryAttributes,
+		paramsCount:  1,
+		data:         append(header(NoCursor), 2, 0x00, 0x00),
+		err:          "query attributes sent without their types",
+	}, {
+		name:         "truncated attribute",
+		capabilities: CapabilityClientQueryAttributes,
+		paramsCount:  1,
+		data:         withParam[:len(withParam)-1],
+		err:          "decoding query attribute value failed",
+	}} {
+		t.Run(tcase.name, func(t *testing.T) {
+			c := &Conn{Capabilities: tcase.capabilities}
+			prepare := &PrepareData{
+				StatementID: 1,
+				ParamsCount: tcase.paramsCount,
+				ParamsType:  make([]int32, tcase.paramsCount),
+				BindVars:    make(map[string]*querypb.BindVariable),
+			}
+			stmtID, cursorType, err := c.parseComStmtExecute(map[uint32]*PrepareData{1: prepare}, tcase.data)
+			if tcase.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tcase.err) {
+					t.Fatalf("parseComStmtExecute returned %v, want error %q", err, tcase.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseComStmtExecute failed: %v", err)
+			}
+			if stmtID != 1 || cursorType != tcase.cursorType {
+				t.Errorf("parseComStmtExecute returned statement %v, cursor type %v", stmtID, cursorType)
+			}
+			if tcase.paramsCount > 0 {
+				if got := prepare.BindVars["v1"]; !reflect.DeepEqual(got, sqltypes.Int64BindVariable(7)) {
+					t.Errorf("got parameter %v, want 7", got)
+				}
+			}
+			if !reflect.DeepEqual(c.QueryAttributes, tcase.want) {
+				t.Errorf("got query attributes %v, want %v", c.QueryAttributes, tcase.want)
+			}
+		})
+	}
+}
+
+// queryAttributesHandler is a testHandler recording the query
+// attributes of the last query.
+type queryAttributesHandler struct {
+	testHandler
+
+	attributes map[string]sqltypes.Value
+}
+
+func (th *queryAttributesHandler) ComQuery(ctx context.Context, c *Conn, query string, callback func(*sqltypes.Result, bool) error) error {
+	th.attributes = c.QueryAttributes
+	return callback(&sqltypes.Result{}, false)
+}
+
+func TestQueryAttributes(t *testing.T) {
+	listener, sConn, cConn := createSocketPair(t)
+	defer func() {
+		listener.Close()
+		sConn.Close()
+		cConn.Close()
+	}()
+	sConn.Capabilities |= CapabilityClientQueryAttributes
+	handler := &queryAttributesHandler{}
+
+	packet := append([]byte{ComQuery}, queryAttributesPacket()...)
+	packet = append(packet, "select 1"...)
+	if err := writeRawPacketToConn(cConn, packet); err != nil {
+		t.Fatalf("writeRawPacketToConn failed: %v", err)
+	}
+	if err := sConn.handleNextCommand(handler); err != nil {
+		t.Fatalf("handleNextCommand failed: %v", err)
+	}
+	if _, _, _, err := cConn.ReadQueryResult(1, false); err != nil {
+		t.Fatalf("ReadQueryResult failed: %v", err)
+	}
+	if !reflect.DeepEqual(handler.attributes, queryAttributesWant) {
+		t.Errorf("got query attributes %v, want %v", handler.attributes, queryAttributesWant)
+	}
+	if sConn.QueryAttributes != nil {
+		t.Errorf("query attributes are still set after the query: %v", sConn.QueryAttributes)
+	}
+
+	// A malformed block is an error, the connection is kept.
+	if err := writeRawPacketToConn(cConn, []byte{ComQuery, 1, 1, 0, 0}); err != nil {
+		t.Fatalf("writeRawPacketToConn failed: %v", err)
+	}
+	if err := sConn.handleNextCommand(handler); err != nil {
+		t.Fatalf("handleNextCommand failed: %v", err)
+	}
+	_, _, _, err := cConn.ReadQueryResult(1, false)
+	if sqlErr, ok := err.(*SQLError); !ok || sqlErr.Number() != ERMalformedPacket {
+		t.Fatalf("ReadQueryResult returned %v, want a malformed packet error", err)
+	}
+}
@@ -0,0 +1,180 @@
+/*
+Copyright 2019 The Vitess Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mysql
+
+import (
+	"fmt"
+
+	"github.com/dolthub/vitess/go/sqltypes"
+	querypb "github.com/dolthub/vitess/go/vt/proto/query"
+)
+
+// QueryRows iterates over the rows of a query sent with Conn.Query,
+// reading them from the connection as they are needed:
+//
+//	rows, err := conn.Query("select id, name from t")
+//	if err != nil {
+//		return err
+//	}
+//	defer rows.Close()
+//	for rows.Next() {
+//		var id int64
+//		var name string
+//		if err := rows.Scan(&id, &name); err != nil {
+//			return err
+//		}
+//	}
+//	return rows.Err()
+//
+// The other client methods of the connection fail with
+// ErrResultPending until Next returned false or Close was called.
+type QueryRows struct {
+	c      *Conn
+	fields []*querypb.Field
+	row    []sqltypes.Value
+	err    error
+	done   bool
+}
+
+// Query starts a streaming query, like ExecuteStreamFetch, and returns
+// an iterator over its rows. A query without a result set, like an
+// INSERT, returns an iterator without rows.
+// Returns a SQLError, or ErrResultPending.
+func (c *Conn) Query(query string) (*QueryRows, error) {
+	if err := c.ExecuteStreamFetch(query); err != nil {
+		return nil, err
+	}
+	fields, err := c.Fields()
+	if err != nil {
+		return nil, err
+	}
+	return &QueryRows{c: c, fields: fields}, nil
+}
+
+// Fields returns the fields of the result. They are nil for a query
+// without a result set.
+func (qr *QueryRows) Fields() []*querypb.Field {
+	return qr.fields
+}
+
+// Next reads the next row, for Scan and Row. It returns false once
+// there are no more rows, or if reading them failed: Err returns the
+// error then.
+func (qr *QueryRows) Next() bool {
+	if qr.done {
+		return false
+	}
+	row, err := qr.c.FetchNext()
+	if err != nil || row == nil {
+		qr.err = err
+		qr.Close()
+		return false
+	}
+	qr.row = row
+	return true
+}
+
+// Row returns the row Next read.
+func (qr *QueryRows) Row() []sqltypes.Value {
+	return qr.row
+}
+
+// Scan copies the values of the row Next read into dest, one pointer
+// per column. The supported pointers are *sqltypes.Value, *interface{}
+// (set as sqltypes.ToNative does), *string, *[]byte, *int64, *int,
+// *uint64, *float64 and *bool. A NULL value can only be scanned into
+// a *sqltypes.Value, which keeps it, an *interface{} or a *[]byte,
+// which are set to nil.
+func (qr *QueryRows) Scan(dest ...interface{}) error {
+	if qr.row == nil {
+		return fmt.Errorf("Scan called without a row, Next must be called first")
+	}
+	if len(dest) != len(qr.row) {
+		return fmt.Errorf("Scan: expected %d destinations, got %d", len(qr.row), len(dest))
+	}
+	for i, value := range qr.row {
+		if err := scanValue(value, dest[i]); err != nil {
+			return fmt.Errorf("Scan: column %d (%s): %v", i, qr.fields[i].Name, err)
+		}
+	}
+	return nil
+}
+
+// scanValue copies value into dest, for Scan.
+func scanValue(value sqltypes.Value, dest interface{}) error {
+	switch d := dest.(type) {
+	case *sqltypes.Value:
+		*d = value
+		return nil
+	case *interface{}:
+		native, err := sqltypes.ToNative(value)
+		if err != nil {
+			return err
+		}
+		*d = native
+		return nil
+	case *[]byte:
+		if value.IsNull() {
+			*d = nil
+		} else {
+			*d = append([]byte(nil), value.ToBytes()...)
+		}
+		return nil
+	}
+
+	if value.IsNull() {
+		return fmt.Errorf("cannot scan NULL into %T", dest)
+	}
+	var err error
+	switch d := dest.(type) {
+	case *string:
+		*d = value.ToString()
+	case *int64:
+		*d, err = sqltypes.ToInt64(value)
+	case *int:
+		var v int64
+		v, err = sqltypes.ToInt64(value)
+		*d = int(v)
+	case *uint64:
+		*d, err = sqltypes.ToUint64(value)
+	case *float64:
+		*d, err = sqltypes.ToFloat64(value)
+	case *bool:
+		var v int64
+		v, err = sqltypes.ToInt64(value)
+		*d = v != 0
+	default:
+		return fmt.Errorf("unsupported destination %T", dest)
+	}
+	return err
+}
+
+// Err returns the error that stopped Next, if any.
+func (qr *QueryRows) Err() error {
+	return qr.err
+}
+
+// Close drains the rows that were not read, so the connection can be
+// used again. Calling it more than once is fine.
+func (qr *QueryRows) Close() {
+	if qr.done {
+		return
+	}
+	qr.done = true
+	qr.row = nil
+	qr.c.CloseResult()
+}
@@ -0,0 +1,135 @@
+/*
+Copyright 2019 The Vitess Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mysql
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dolthub/vitess/go/sqltypes"
+	querypb "github.com/dolthub/vitess/go/vt/proto/query"
+)
+
+func TestQueryRows(t *testing.T) {
+	listener, sConn, cConn := createSocketPair(t)
+	defer func() {
+		listener.Close()
+		sConn.Close()
+		cConn.Close()
+	}()
+
+	typesResult := &sqltypes.Result{
+		Fields: []*querypb.Field{
+			{Name: "id", Type: querypb.Type_INT64},
+			{Name: "name", Type: querypb.Type_VARCHAR},
+			{Name: "score", Type: querypb.Type_FLOAT64},
+			{Name: "comment", Type: querypb.Type_VARCHAR},
+		},
+		Rows: [][]sqltypes.Value{
+			{sqltypes.NewInt64(1), sqltypes.NewVarChar("one"), sqltypes.NewFloat64(1.5), sqltypes.NULL},
+			{sqltypes.NewInt64(2), sqltypes.NewVarChar("two"), sqltypes.NewFloat64(2.5), sqltypes.NewVarChar("second")},
+		},
+	}
+	results := []*sqltypes.Result{
+		typesResult,
+		manyRowsResult(100),
+		{RowsAffected: 3},
+		selectRowsResult,
+	}
+
+	// The server answers each query with the next result.
+	var serverErr error
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for _, result := range results {
+			sConn.resetSequence()
+			if _, serverErr = sConn.ReadPacket(); serverErr != nil {
+				return
+			}
+			if serverErr = writeResult(sConn, result); serverErr != nil {
+				return
+			}
+		}
+	}()
+
+	// All the rows, scanned.
+	rows, err := cConn.Query("select types")
+	require.NoError(t, err)
+	assert.Len(t, rows.Fields(), 4)
+	var got []string
+	for rows.Next() {
+		var id int64
+		var name string
+		var score float64
+		var comment []byte
+		require.NoError(t, rows.Scan(&id, &name, &score, &comment))
+		got = append(got, sqltypes.NewInt64(id).ToString()+" "+name+" "+sqltypes.NewFloat64(score).ToString()+" "+string(comment))
+
+		// A NULL needs a destination that can hold it.
+		var s string
+		var value sqltypes.Value
+		var native interface{}
+		err := rows.Scan(&id, &name, &native, &s)
+		if comment == nil {
+			assert.Error(t, err)
+		} else {
+			assert.NoError(t, err)
+			assert.Equal(t, "second", s)
+		}
+		require.NoError(t, rows.Scan(&value, &name, &native, &native))
+		assert.Equal(t, rows.Row()[0], value)
+		if comment == nil {
+			assert.Nil(t, native)
+		} else {
+			assert.Equal(t, []byte("second"), native)
+		}
+	}
+	require.NoError(t, rows.Err())
+	assert.Equal(t, []string{"1 one 1.5 ", "2 two 2.5 second"}, got)
+	assert.False(t, rows.Next())
+
+	// Closing before the last row drains the result.
+	rows, err = cConn.Query("select many")
+	require.NoError(t, err)
+	require.True(t, rows.Next())
+	assert.Error(t, rows.Scan(new(int64)), "wrong number of destinations")
+	assert.Error(t, rows.Scan(new(int64), new(chan int)), "unsupported destination")
+	rows.Close()
+	rows.Close()
+	assert.False(t, rows.Next())
+	assert.Error(t, rows.Scan(new(int64), new(string)), "no row")
+
+	// A query without a result set has no rows.
+	rows, err = cConn.Query("insert")
+	require.NoError(t, err)
+	assert.Nil(t, rows.Fields())
+	assert.False(t, rows.Next())
+	require.NoError(t, rows.Err())
+
+	// And the connection can still be used.
+	result, err := cConn.ExecuteFetch("select rows", 10, false)
+	require.NoError(t, err)
+	assert.Equal(t, selectRowsResult.Rows, result.Rows)
+
+	wg.Wait()
+	require.NoError(t, serverErr)
+}
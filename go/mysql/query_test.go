@@ -17,11 +17,15 @@ limitations under the License.
 package mysql
 
 import (
+	"bytes"
 	"fmt"
 	"io"
+	"math/rand"
 	"reflect"
+	"strings"
 	"sync"
 	"testing"
+	"time"
 
 	"google.golang.org/protobuf/proto"
 
@@ -199,6 +203,104 @@ func TestComStmtSendLongData(t *testing.T) {
 	}
 }
 
+func TestWriteComStmtSendLongData(t *testing.T) {
+	listener, sConn, cConn := createSocketPair(t)
+	defer func() {
+		listener.Close()
+		sConn.Close()
+		cConn.Close()
+	}()
+
+	prepare := &PrepareData{
+		StatementID: 18,
+		PrepareStmt: "insert into t values (?, ?)",
+		ParamsCount: 2,
+		ParamsType:  []int32{int32(querypb.Type_BLOB), int32(querypb.Type_BLOB)},
+		BindVars:    make(map[string]*querypb.BindVariable),
+	}
+	sConn.PrepareData = map[uint32]*PrepareData{prepare.StatementID: prepare}
+
+	// The first parameter is a blob bigger than a packet, sent in two
+	// parts with the chunks of the second parameter in between.
+	blob := make([]byte, maxLongDataChunk+5*1024*1024)
+	for i := range blob {
+		blob[i] = byte(i % 251)
+	}
+	split := maxLongDataChunk + 1024
+	sends := []struct {
+		paramID uint16
+		data    []byte
+	}{
+		{0, blob[:split]},
+		{1, []byte("small ")},
+		{0, blob[split:]},
+		{1, []byte("value")},
+		{1, nil},
+	}
+	packets := 0
+	for _, send := range sends {
+		packets++
+		if len(send.data) > 0 {
+			packets += (len(send.data) - 1) / maxLongDataChunk
+		}
+	}
+
+	var clientErr error
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for _, send := range sends {
+			if clientErr = cConn.writeComStmtSendLongData(prepare.StatementID, send.paramID, send.data); clientErr != nil {
+				return
+			}
+		}
+
+		// Execute the statement, with no value for the parameters
+		// sent as long data.
+		typ, flags := sqltypes.TypeToMySQL(querypb.Type_BLOB)
+		clientErr = writeRawPacketToConn(cConn, []byte{
+			ComStmtExecute,
+			18, 0, 0, 0, // statement ID
+			0,          // cursor type
+			1, 0, 0, 0, // iteration count
+			0, // NULL bitmap
+			1, // new params bound flag
+			byte(typ), byte(flags),
+			byte(typ), byte(flags),
+		})
+	}()
+
+	for i := 0; i < packets; i++ {
+		if err := sConn.handleNextCommand(&testHandler{}); err != nil {
+			t.Fatalf("handleNextCommand(ComStmtSendLongData) failed: %v", err)
+		}
+	}
+	sConn.sequence = 0
+	data, err := sConn.ReadPacket()
+	if err != nil {
+		t.Fatalf("ReadPacket(ComStmtExecute) failed: %v", err)
+	}
+	wg.Wait()
+	if clientErr != nil {
+		t.Fatalf("client failed: %v", clientErr)
+	}
+
+	stmtID, _, err := sConn.parseComStmtExecute(sConn.PrepareData, data)
+	if err != nil {
+		t.Fatalf("parseComStmtExecute failed: %v", err)
+	}
+	if stmtID != prepare.StatementID {
+		t.Errorf("got statement ID %v, want %v", stmtID, prepare.StatementID)
+	}
+	if got := prepare.BindVars["v1"].Value; !bytes.Equal(got, blob) {
+		t.Errorf("got a %v bytes first parameter, want the %v bytes blob", len(got), len(blob))
+	}
+	if got, want := string(prepare.BindVars["v2"].Value), "small value"; got != want {
+		t.Errorf("got second parameter %q, want %q", got, want)
+	}
+}
+
 func TestComStmtExecute(t *testing.T) {
 	listener, sConn, cConn := createSocketPair(t)
 	defer func() {
@@ -223,6 +325,296 @@ func TestComStmtExecute(t *testing.T) {
 	}
 }
 
+func TestParseBinaryTime(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		want string
+		ok   bool
+	}{{
+		name: "zero",
+		data: []byte{0x00},
+		want: "00:00:00",
+		ok:   true,
+	}, {
+		name: "no microseconds",
+		data: []byte{0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0x02, 0x03},
+		want: "01:02:03",
+		ok:   true,
+	}, {
+		name: "days",
+		data: []byte{0x08, 0x00, 0x02, 0x00, 0x00, 0x00, 0x01, 0x02, 0x03},
+		want: "49:02:03",
+		ok:   true,
+	}, {
+		name: "microseconds",
+		data: []byte{0x0c, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0x02, 0x03, 0x05, 0x00, 0x00, 0x00},
+		want: "01:02:03.000005",
+		ok:   true,
+	}, {
+		name: "all the microseconds",
+		data: []byte{0x0c, 0x00, 0x01, 0x00, 0x00, 0x00, 0x17, 0x3b, 0x3b, 0x3f, 0x42, 0x0f, 0x00},
+		want: "47:59:59.999999",
+		ok:   true,
+	}, {
+		name: "negative",
+		data: []byte{0x08, 0x01, 0x00, 0x00, 0x00, 0x00, 0x01, 0x02, 0x03},
+		want: "-01:02:03",
+		ok:   true,
+	}, {
+		name: "negative microseconds",
+		data: []byte{0x0c, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0xa0, 0x86, 0x01, 0x00},
+		want: "-00:00:00.100000",
+		ok:   true,
+	}, {
+		name: "truncated",
+		data: []byte{0x0c, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0x02, 0x03, 0x05},
+	}, {
+		name: "bad length",
+		data: []byte{0x04, 0x00, 0x00, 0x00, 0x00},
+	}}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			val, pos, ok := parseBinaryTime(test.data, 0)
+			if ok != test.ok {
+				t.Fatalf("parseBinaryTime(%v) returned ok %v, want %v", test.data, ok, test.ok)
+			}
+			if !ok {
+				return
+			}
+			if got := val.ToString(); got != test.want {
+				t.Errorf("parseBinaryTime(%v) = %q, want %q", test.data, got, test.want)
+			}
+			if pos != len(test.data) {
+				t.Errorf("parseBinaryTime(%v) read %v bytes, want %v", test.data, pos, len(test.data))
+			}
+
+			// The encoder gives back the same packet.
+			data, err := val2MySQL(sqltypes.MakeTrusted(querypb.Type_TIME, []byte(test.want)))
+			if err != nil {
+				t.Fatalf("val2MySQL(%q) failed: %v", test.want, err)
+			}
+			if !bytes.Equal(data, test.data) {
+				t.Errorf("val2MySQL(%q) = %v, want %v", test.want, data, test.data)
+			}
+		})
+	}
+}
+
+// stmtExecuteLogHandler is a testHandler that records the logged
+// executions.
+type stmtExecuteLogHandler struct {
+	testHandler
+	queries  []string
+	bindVars []map[string]*querypb.BindVariable
+}
+
+func (h *stmtExecuteLogHandler) ComStmtExecuteLog(c *Conn, query string, bindVars map[string]*querypb.BindVariable) {
+	h.queries = append(h.queries, query)
+	h.bindVars = append(h.bindVars, bindVars)
+}
+
+func TestComStmtExecuteLog(t *testing.T) {
+	listener, sConn, cConn := createSocketPair(t)
+	defer func() {
+		listener.Close()
+		sConn.Close()
+		cConn.Close()
+	}()
+
+	prepare := &PrepareData{
+		StatementID: 18,
+		PrepareStmt: "select rows",
+		ParamsCount: 2,
+		ParamsType:  make([]int32, 2),
+		BindVars:    make(map[string]*querypb.BindVariable),
+	}
+	sConn.PrepareData = map[uint32]*PrepareData{prepare.StatementID: prepare}
+	handler := &stmtExecuteLogHandler{}
+
+	execute := func(value int64, name string) {
+		t.Helper()
+		data := []byte{ComStmtExecute, 18, 0, 0, 0, 0, 1, 0, 0, 0}
+		// No NULL, new params: a LONGLONG and a STRING.
+		data = append(data, 0, 1, 0x08, 0x00, 0xfe, 0x00)
+		data = append(data, make([]byte, 8)...)
+		writeUint64(data, len(data)-8, uint64(value))
+		data = append(data, byte(len(name)))
+		data = append(data, name...)
+		if err := writeRawPacketToConn(cConn, data); err != nil {
+			t.Fatalf("writeRawPacketToConn failed: %v", err)
+		}
+		if err := sConn.handleNextCommand(handler); err != nil {
+			t.Fatalf("handleNextCommand(ComStmtExecute) failed: %v", err)
+		}
+	}
+	execute(42, "first")
+	execute(-1, "second")
+
+	want := []map[string]*querypb.BindVariable{{
+		"v1": sqltypes.Int64BindVariable(42),
+		"v2": sqltypes.BytesBindVariable([]byte("first")),
+	}, {
+		"v1": sqltypes.Int64BindVariable(-1),
+		"v2": sqltypes.BytesBindVariable([]byte("second")),
+	}}
+	if !reflect.DeepEqual(handler.queries, []string{"select rows", "select rows"}) {
+		t.Errorf("logged queries: %v", handler.queries)
+	}
+	if len(handler.bindVars) != len(want) {
+		t.Fatalf("logged %v executions, want %v", len(handler.bindVars), len(want))
+	}
+	// The first map was not reused for the second execution.
+	for i := range want {
+		if !sqltypes.BindVariablesEqual(handler.bindVars[i], want[i]) {
+			t.Errorf("logged bind vars of execution %v: %v, want %v", i, handler.bindVars[i], want[i])
+		}
+	}
+}
+
+func TestComStmtExecuteMalformed(t *testing.T) {
+	listener, sConn, cConn := createSocketPair(t)
+	defer func() {
+		listener.Close()
+		sConn.Close()
+		cConn.Close()
+	}()
+
+	newPrepare := func() *PrepareData {
+		return &PrepareData{
+			StatementID: 18,
+			PrepareStmt: "select * from t where a = ? and b = ? and c = ? and d = ? and e = ? and f = ?",
+			ParamsCount: 6,
+			ParamsType:  make([]int32, 6),
+			BindVars:    make(map[string]*querypb.BindVariable),
+		}
+	}
+	// A LONGLONG, a STRING, a NULL, a DATETIME, a TIME and a DECIMAL.
+	valid := []byte{ComStmtExecute, 18, 0, 0, 0, 0, 1, 0, 0, 0, 0x04, 1,
+		0x08, 0x00, 0xfe, 0x00, 0x06, 0x00, 0x0c, 0x00, 0x0b, 0x00, 0xf6, 0x00,
+		1, 0, 0, 0, 0, 0, 0, 0,
+		3, 'a', 'b', 'c',
+		11, 0xe4, 0x07, 1, 2, 3, 4, 5, 6, 0, 0, 0,
+		8, 0, 0, 0, 0, 0, 1, 2, 3,
+		4, '1', '.', '2', '3',
+	}
+	prepare := newPrepare()
+	if _, _, err := sConn.parseComStmtExecute(map[uint32]*PrepareData{18: prepare}, valid); err != nil {
+		t.Fatalf("parseComStmtExecute failed: %v", err)
+	}
+	if len(prepare.BindVars) != 6 {
+		t.Fatalf("got bind vars %v, want 6", prepare.BindVars)
+	}
+
+	// ParamsType is sized from ParamsCount if the Handler did not.
+	prepare = newPrepare()
+	prepare.ParamsType = nil
+	if _, _, err := sConn.parseComStmtExecute(map[uint32]*PrepareData{18: prepare}, valid); err != nil {
+		t.Fatalf("parseComStmtExecute without ParamsType failed: %v", err)
+	}
+	if len(prepare.ParamsType) != 6 || len(prepare.BindVars) != 6 {
+		t.Fatalf("got types %v and bind vars %v, want 6 of each", prepare.ParamsType, prepare.BindVars)
+	}
+
+	parse := func(data []byte) (err error) {
+		defer func() {
+			if x := recover(); x != nil {
+				t.Fatalf("parseComStmtExecute(%v) panicked: %v", data, x)
+			}
+		}()
+		_, _, err = sConn.parseComStmtExecute(map[uint32]*PrepareData{18: newPrepare()}, data)
+		return err
+	}
+
+	// All the truncations fail.
+	for i := 1; i < len(valid); i++ {
+		err := parse(valid[:i])
+		if err == nil {
+			t.Errorf("parseComStmtExecute of %v bytes out of %v succeeded", i, len(valid))
+			continue
+		}
+		if sqlErr, ok := err.(*SQLError); !ok || sqlErr.Number() != ERMalformedPacket {
+			t.Errorf("parseComStmtExecute of %v bytes out of %v returned %v, want an ERMalformedPacket error", i, len(valid), err)
+		}
+	}
+
+	// Random changes may succeed or fail, but never panic.
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 10000; i++ {
+		data := append([]byte{}, valid[:2+r.Intn(len(valid)-1)]...)
+		for j := r.Intn(4); j >= 0; j-- {
+			data[1+r.Intn(len(data)-1)] = byte(r.Intn(256))
+		}
+		parse(data)
+	}
+}
+
+func TestComStmtExecuteMissingBindVar(t *testing.T) {
+	listener, sConn, cConn := createSocketPair(t)
+	defer func() {
+		listener.Close()
+		sConn.Close()
+		cConn.Close()
+	}()
+	sConn.PrepareData = make(map[uint32]*PrepareData)
+
+	var prepare *PrepareData
+	var clientErr error
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if clientErr = cConn.writeComPrepare("select ?"); clientErr != nil {
+			return
+		}
+		prepare, clientErr = cConn.readComPrepareResponse()
+	}()
+	if err := sConn.handleNextCommand(&testHandler{}); err != nil {
+		t.Fatalf("handleNextCommand(ComPrepare) failed: %v", err)
+	}
+	wg.Wait()
+	if clientErr != nil {
+		t.Fatalf("prepare failed: %v", clientErr)
+	}
+	if prepare.ParamsCount != 1 {
+		t.Fatalf("got %v parameters, want 1", prepare.ParamsCount)
+	}
+
+	// Nothing is written when a bind variable is missing.
+	err := cConn.writeComStmtExecute(prepare, NoCursor, nil)
+	sqlErr, ok := err.(*SQLError)
+	if !ok || sqlErr.Number() != CRParamsNotBound || !strings.Contains(sqlErr.Message, "v1") {
+		t.Fatalf("writeComStmtExecute without bind vars returned %v, want a CRParamsNotBound error for v1", err)
+	}
+	err = cConn.writeComStmtExecute(prepare, NoCursor, map[string]*querypb.BindVariable{
+		"v2": sqltypes.Int64BindVariable(1),
+	})
+	if sqlErr, ok := err.(*SQLError); !ok || sqlErr.Number() != CRParamsNotBound {
+		t.Fatalf("writeComStmtExecute with the wrong bind var returned %v, want a CRParamsNotBound error", err)
+	}
+
+	if err := cConn.writeComStmtExecute(prepare, NoCursor, map[string]*querypb.BindVariable{
+		"v1": sqltypes.Int64BindVariable(10),
+	}); err != nil {
+		t.Fatalf("writeComStmtExecute failed: %v", err)
+	}
+	sConn.sequence = 0
+	data, err := sConn.ReadPacket()
+	if err != nil {
+		t.Fatalf("sConn.ReadPacket - ComStmtExecute failed: %v", err)
+	}
+	stmtID, _, err := sConn.parseComStmtExecute(sConn.PrepareData, data)
+	if err != nil {
+		t.Fatalf("parseComStmtExecute failed: %v", err)
+	}
+	if stmtID != prepare.StatementID {
+		t.Fatalf("got statement ID %v, want %v", stmtID, prepare.StatementID)
+	}
+	if got, want := sConn.PrepareData[stmtID].BindVars["v1"], sqltypes.Int64BindVariable(10); !proto.Equal(got, want) {
+		t.Errorf("got bind var %v, want %v", got, want)
+	}
+}
+
 func TestComStmtFetch(t *testing.T) {
 	listener, sConn, cConn := createSocketPair(t)
 	defer func() {
@@ -258,16 +650,13 @@ func TestComStmtClose(t *testing.T) {
 		cConn.Close()
 	}()
 
-	prepare, result := MockPrepareData(t)
-	cConn.PrepareData = make(map[uint32]*PrepareData)
-	cConn.PrepareData[prepare.StatementID] = prepare
-	if err := cConn.writePrepare(result.Fields, prepare); err != nil {
-		t.Fatalf("writePrepare failed: %v", err)
+	prepare, _ := MockPrepareData(t)
+	if err := cConn.writeComStmtClose(prepare.StatementID); err != nil {
+		t.Fatalf("writeComStmtClose failed: %v", err)
 	}
 
-	// Since there's no writeComStmtClose, we'll write a prepareStmt and check if we can read the StatementID
 	data, err := sConn.ReadPacket()
-	if err != nil || len(data) == 0 {
+	if err != nil || len(data) != 5 {
 		t.Fatalf("sConn.ReadPacket - ComStmtClose failed: %v %v", data, err)
 	}
 	stmtID, ok := sConn.parseComStmtClose(data)
@@ -279,6 +668,605 @@ func TestComStmtClose(t *testing.T) {
 	}
 }
 
+func TestWriteComStmtReset(t *testing.T) {
+	listener, sConn, cConn := createSocketPair(t)
+	defer func() {
+		listener.Close()
+		sConn.Close()
+		cConn.Close()
+	}()
+
+	prepare, _ := MockPrepareData(t)
+	if err := cConn.writeComStmtReset(prepare.StatementID); err != nil {
+		t.Fatalf("writeComStmtReset failed: %v", err)
+	}
+
+	data, err := sConn.ReadPacket()
+	if err != nil || len(data) != 5 || data[0] != ComStmtReset {
+		t.Fatalf("sConn.ReadPacket - ComStmtReset failed: %v %v", data, err)
+	}
+	stmtID, ok := sConn.parseComStmtReset(data)
+	if !ok || stmtID != prepare.StatementID {
+		t.Fatalf("parseComStmtReset returned %v, %v, want %v, true", stmtID, ok, prepare.StatementID)
+	}
+}
+
+func TestComStmtReset(t *testing.T) {
+	listener, sConn, cConn := createSocketPair(t)
+	defer func() {
+		listener.Close()
+		sConn.Close()
+		cConn.Close()
+	}()
+
+	prepare, _ := MockPrepareData(t)
+	prepare.BindVars = make(map[string]*querypb.BindVariable)
+	sConn.PrepareData = map[uint32]*PrepareData{prepare.StatementID: prepare}
+
+	sendLongData := func(chunk string) {
+		t.Helper()
+		packet := make([]byte, 7+len(chunk))
+		pos := writeByte(packet, 0, ComStmtSendLongData)
+		pos = writeUint32(packet, pos, prepare.StatementID)
+		pos = writeUint16(packet, pos, 0)
+		copy(packet[pos:], chunk)
+		if err := writeRawPacketToConn(cConn, packet); err != nil {
+			t.Fatalf("writeRawPacketToConn failed: %v", err)
+		}
+		if err := sConn.handleNextCommand(&testHandler{}); err != nil {
+			t.Fatalf("handleNextCommand(ComStmtSendLongData) failed: %v", err)
+		}
+	}
+	reset := func(stmtID uint32) []byte {
+		t.Helper()
+		if err := cConn.writeComStmtReset(stmtID); err != nil {
+			t.Fatalf("writeComStmtReset failed: %v", err)
+		}
+
+		wg := sync.WaitGroup{}
+		wg.Add(1)
+		var data []byte
+		var err error
+		go func() {
+			defer wg.Done()
+			data, err = cConn.ReadPacket()
+		}()
+		if err := sConn.handleNextCommand(&testHandler{}); err != nil {
+			t.Fatalf("handleNextCommand(ComStmtReset) failed: %v", err)
+		}
+		wg.Wait()
+		if err != nil || len(data) == 0 {
+			t.Fatalf("ReadPacket after ComStmtReset failed: %v %v", data, err)
+		}
+		return data
+	}
+
+	sendLongData("stale ")
+	sendLongData("data")
+	if got := string(prepare.BindVars["v1"].Value); got != "stale data" {
+		t.Fatalf("got long data %q before reset, want %q", got, "stale data")
+	}
+
+	if data := reset(prepare.StatementID); data[0] != OKPacket {
+		t.Fatalf("expected OK packet after ComStmtReset, got: %v", data)
+	}
+	if len(prepare.BindVars) != 0 {
+		t.Errorf("long data was not cleared by ComStmtReset: %v", prepare.BindVars)
+	}
+
+	sendLongData("fresh")
+	if got := string(prepare.BindVars["v1"].Value); got != "fresh" {
+		t.Errorf("got long data %q after reset, want %q", got, "fresh")
+	}
+
+	data := reset(prepare.StatementID + 1)
+	assertSQLError(t, ParseErrorPacket(data), ERUnknownStmtHandler, SSUnknownSQLState, "Unknown prepared statement handler", "")
+}
+
+func TestComStmtResetThenExecute(t *testing.T) {
+	listener, sConn, cConn := createSocketPair(t)
+	defer func() {
+		listener.Close()
+		sConn.Close()
+		cConn.Close()
+	}()
+
+	prepare := &PrepareData{
+		StatementID: 18,
+		PrepareStmt: "select * from t where a = ?",
+		ParamsCount: 1,
+		ParamsType:  []int32{int32(querypb.Type_VARCHAR)},
+		BindVars:    make(map[string]*querypb.BindVariable),
+	}
+	sConn.PrepareData = map[uint32]*PrepareData{prepare.StatementID: prepare}
+
+	var resetResponse []byte
+	var clientErr error
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if clientErr = cConn.writeComStmtSendLongData(prepare.StatementID, 0, []byte("stale")); clientErr != nil {
+			return
+		}
+		if clientErr = writeRawPacketToConn(cConn, []byte{ComStmtReset, 18, 0, 0, 0}); clientErr != nil {
+			return
+		}
+		if resetResponse, clientErr = cConn.ReadPacket(); clientErr != nil {
+			return
+		}
+		clientErr = cConn.writeComStmtExecute(prepare, 0, map[string]*querypb.BindVariable{
+			"v1": sqltypes.StringBindVariable("fresh"),
+		})
+	}()
+
+	for _, command := range []string{"ComStmtSendLongData", "ComStmtReset"} {
+		if err := sConn.handleNextCommand(&testHandler{}); err != nil {
+			t.Fatalf("handleNextCommand(%v) failed: %v", command, err)
+		}
+	}
+	sConn.sequence = 0
+	data, err := sConn.ReadPacket()
+	if err != nil {
+		t.Fatalf("ReadPacket(ComStmtExecute) failed: %v", err)
+	}
+	wg.Wait()
+	if clientErr != nil {
+		t.Fatalf("client failed: %v", clientErr)
+	}
+	if len(resetResponse) == 0 || resetResponse[0] != OKPacket {
+		t.Fatalf("expected OK packet after ComStmtReset, got: %v", resetResponse)
+	}
+
+	if _, _, err := sConn.parseComStmtExecute(sConn.PrepareData, data); err != nil {
+		t.Fatalf("parseComStmtExecute failed: %v", err)
+	}
+	if got := string(prepare.BindVars["v1"].Value); got != "fresh" {
+		t.Errorf("got parameter %q after reset, want %q", got, "fresh")
+	}
+}
+
+// stmtCloseHandler is a testHandler that records the statements it was
+// told are closed.
+type stmtCloseHandler struct {
+	testHandler
+	closed []uint32
+}
+
+func (h *stmtCloseHandler) ComStmtClosed(c *Conn, stmtID uint32) {
+	if _, ok := c.PrepareData[stmtID]; !ok {
+		panic(fmt.Sprintf("statement %v closed after it was removed", stmtID))
+	}
+	h.closed = append(h.closed, stmtID)
+}
+
+func TestComStmtCloseHandler(t *testing.T) {
+	listener, sConn, cConn := createSocketPair(t)
+	defer func() {
+		listener.Close()
+		sConn.Close()
+		cConn.Close()
+	}()
+
+	prepare, _ := MockPrepareData(t)
+	other := &PrepareData{StatementID: prepare.StatementID + 1}
+	sConn.PrepareData = map[uint32]*PrepareData{
+		prepare.StatementID: prepare,
+		other.StatementID:   other,
+	}
+	handler := &stmtCloseHandler{}
+
+	// The statement is closed once, a second close and a close of an
+	// unknown statement are ignored.
+	for _, stmtID := range []uint32{prepare.StatementID, prepare.StatementID, 1000} {
+		if err := cConn.writeComStmtClose(stmtID); err != nil {
+			t.Fatalf("writeComStmtClose(%v) failed: %v", stmtID, err)
+		}
+		if err := sConn.handleNextCommand(handler); err != nil {
+			t.Fatalf("handleNextCommand(ComStmtClose %v) failed: %v", stmtID, err)
+		}
+	}
+
+	if len(handler.closed) != 1 || handler.closed[0] != prepare.StatementID {
+		t.Errorf("ComStmtClosed called for %v, want [%v]", handler.closed, prepare.StatementID)
+	}
+	if _, ok := sConn.PrepareData[prepare.StatementID]; ok {
+		t.Errorf("closed statement is still in PrepareData")
+	}
+	if _, ok := sConn.PrepareData[other.StatementID]; !ok {
+		t.Errorf("other statement was removed from PrepareData")
+	}
+
+	// No response is sent for any of them.
+	cConn.Conn.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	if data, err := cConn.ReadPacket(); err == nil {
+		t.Errorf("got a response to ComStmtClose: %v", data)
+	}
+}
+
+// stmtPrepareHandler is a stmtCloseHandler that records the
+// statements it is asked to prepare. It counts the parameters of the
+// "frobnicate" statements, which can't be parsed, and refuses the
+// "fail" ones.
+type stmtPrepareHandler struct {
+	stmtCloseHandler
+	prepared []string
+}
+
+func (h *stmtPrepareHandler) ComStmtPrepare(c *Conn, query string) (*PrepareData, error) {
+	h.prepared = append(h.prepared, query)
+	switch {
+	case strings.HasPrefix(query, "frobnicate"):
+		return &PrepareData{ParamsCount: uint16(strings.Count(query, "?"))}, nil
+	case strings.HasPrefix(query, "fail"):
+		return nil, NewSQLError(ERNotSupportedYet, SSUnknownSQLState, "cannot prepare %v", query)
+	}
+	return nil, nil
+}
+
+func TestComStmtPrepareHandler(t *testing.T) {
+	listener, sConn, cConn := createSocketPair(t)
+	defer func() {
+		listener.Close()
+		sConn.Close()
+		cConn.Close()
+	}()
+	sConn.PrepareData = make(map[uint32]*PrepareData)
+	handler := &stmtPrepareHandler{}
+
+	prepare := func(query string) (*PrepareData, error) {
+		t.Helper()
+		wg := sync.WaitGroup{}
+		wg.Add(1)
+		var prepare *PrepareData
+		var clientErr error
+		go func() {
+			defer wg.Done()
+			if clientErr = cConn.writeComPrepare(query); clientErr != nil {
+				return
+			}
+			prepare, clientErr = cConn.readComPrepareResponse()
+		}()
+		if err := sConn.handleNextCommand(handler); err != nil {
+			t.Fatalf("handleNextCommand(ComPrepare %v) failed: %v", query, err)
+		}
+		wg.Wait()
+		return prepare, clientErr
+	}
+
+	// The handler counts the parameters.
+	frobnicate, err := prepare("frobnicate ?, ?, ?")
+	if err != nil {
+		t.Fatalf("prepare failed: %v", err)
+	}
+	if frobnicate.ParamsCount != 3 {
+		t.Errorf("got %v parameters, want 3", frobnicate.ParamsCount)
+	}
+	got, ok := sConn.PrepareData[frobnicate.StatementID]
+	if !ok {
+		t.Fatalf("prepared statement %v is not in PrepareData", frobnicate.StatementID)
+	}
+	if got.StatementID != frobnicate.StatementID || got.PrepareStmt != "frobnicate ?, ?, ?" || len(got.ParamsType) != 3 || got.BindVars == nil {
+		t.Errorf("got PrepareData %+v", got)
+	}
+
+	// Or lets the query be parsed.
+	selectOne, err := prepare("select ?")
+	if err != nil {
+		t.Fatalf("prepare failed: %v", err)
+	}
+	if selectOne.ParamsCount != 1 {
+		t.Errorf("got %v parameters, want 1", selectOne.ParamsCount)
+	}
+
+	// Or refuses the statement.
+	if _, err := prepare("fail ?"); err == nil || err.(*SQLError).Number() != ERNotSupportedYet {
+		t.Errorf("prepare returned %v, want ERNotSupportedYet", err)
+	}
+	if len(sConn.PrepareData) != 2 {
+		t.Errorf("got %v prepared statements, want 2", len(sConn.PrepareData))
+	}
+
+	if want := []string{"frobnicate ?, ?, ?", "select ?", "fail ?"}; !reflect.DeepEqual(handler.prepared, want) {
+		t.Errorf("ComStmtPrepare called for %v, want %v", handler.prepared, want)
+	}
+
+	// Closing the statement calls ComStmtClosed.
+	if err := cConn.writeComStmtClose(frobnicate.StatementID); err != nil {
+		t.Fatalf("writeComStmtClose failed: %v", err)
+	}
+	if err := sConn.handleNextCommand(handler); err != nil {
+		t.Fatalf("handleNextCommand(ComStmtClose) failed: %v", err)
+	}
+	if want := []uint32{frobnicate.StatementID}; !reflect.DeepEqual(handler.closed, want) {
+		t.Errorf("ComStmtClosed called for %v, want %v", handler.closed, want)
+	}
+	if _, ok := sConn.PrepareData[selectOne.StatementID]; !ok || len(sConn.PrepareData) != 1 {
+		t.Errorf("got PrepareData %v after the close", sConn.PrepareData)
+	}
+}
+
+func TestComResetConnection(t *testing.T) {
+	listener, sConn, cConn := createSocketPair(t)
+	defer func() {
+		listener.Close()
+		sConn.Close()
+		cConn.Close()
+	}()
+
+	prepare, _ := MockPrepareData(t)
+	sConn.PrepareData = map[uint32]*PrepareData{prepare.StatementID: prepare}
+	sConn.schemaName = "my_db"
+	sConn.handshakeCharacterSet = CharacterSetMap["utf8mb4"]
+	sConn.CharacterSet = CharacterSetMap["latin1"]
+	// The handler enabled autocommit, the session disabled it.
+	sConn.initialStatusFlags = ServerStatusAutocommit
+	sConn.StatusFlags = ServerInTransaction
+
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	var data []byte
+	var err error
+	go func() {
+		defer wg.Done()
+		if err = writeRawPacketToConn(cConn, []byte{ComResetConnection}); err != nil {
+			return
+		}
+		data, err = cConn.ReadPacket()
+	}()
+
+	if err := sConn.handleNextCommand(&testHandler{}); err != nil {
+		t.Fatalf("handleNextCommand failed: %v", err)
+	}
+	wg.Wait()
+
+	if err != nil || len(data) == 0 || data[0] != OKPacket {
+		t.Fatalf("expected OK packet after ComResetConnection, got: %v %v", data, err)
+	}
+	if sConn.schemaName != "" {
+		t.Errorf("schemaName was not reset: %v", sConn.schemaName)
+	}
+	if sConn.CharacterSet != CharacterSetMap["utf8mb4"] {
+		t.Errorf("CharacterSet was not reset to the one of the handshake: %v", sConn.CharacterSet)
+	}
+	if sConn.StatusFlags != ServerStatusAutocommit {
+		t.Errorf("StatusFlags were not reset: %v", sConn.StatusFlags)
+	}
+	if len(sConn.PrepareData) != 0 {
+		t.Errorf("PrepareData was not reset: %v", sConn.PrepareData)
+	}
+}
+
+func TestComResetConnectionPrepared(t *testing.T) {
+	listener, sConn, cConn := createSocketPair(t)
+	defer func() {
+		listener.Close()
+		sConn.Close()
+		cConn.Close()
+	}()
+	sConn.PrepareData = make(map[uint32]*PrepareData)
+	handler := &stmtCloseHandler{}
+
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	var prepare *PrepareData
+	var clientErr error
+	go func() {
+		defer wg.Done()
+		if clientErr = cConn.writeComPrepare("select ?"); clientErr != nil {
+			return
+		}
+		prepare, clientErr = cConn.readComPrepareResponse()
+	}()
+	if err := sConn.handleNextCommand(handler); err != nil {
+		t.Fatalf("handleNextCommand(ComPrepare) failed: %v", err)
+	}
+	wg.Wait()
+	if clientErr != nil {
+		t.Fatalf("prepare failed: %v", clientErr)
+	}
+	if _, ok := sConn.PrepareData[prepare.StatementID]; !ok {
+		t.Fatalf("prepared statement %v is not in PrepareData", prepare.StatementID)
+	}
+
+	// The reset closes the statement.
+	wg.Add(1)
+	var data []byte
+	go func() {
+		defer wg.Done()
+		if clientErr = writeRawPacketToConn(cConn, []byte{ComResetConnection}); clientErr != nil {
+			return
+		}
+		data, clientErr = cConn.ReadPacket()
+	}()
+	if err := sConn.handleNextCommand(handler); err != nil {
+		t.Fatalf("handleNextCommand(ComResetConnection) failed: %v", err)
+	}
+	wg.Wait()
+	if clientErr != nil || len(data) == 0 || data[0] != OKPacket {
+		t.Fatalf("expected OK packet after ComResetConnection, got: %v %v", data, clientErr)
+	}
+	if len(sConn.PrepareData) != 0 {
+		t.Errorf("PrepareData was not reset: %v", sConn.PrepareData)
+	}
+	if len(handler.closed) != 1 || handler.closed[0] != prepare.StatementID {
+		t.Errorf("ComStmtClosed called for %v, want [%v]", handler.closed, prepare.StatementID)
+	}
+}
+
+func TestResetConnection(t *testing.T) {
+	listener, sConn, cConn := createSocketPair(t)
+	defer func() {
+		listener.Close()
+		sConn.Close()
+		cConn.Close()
+	}()
+	sConn.PrepareData = make(map[uint32]*PrepareData)
+	handler := &stmtCloseHandler{}
+
+	// The statement is prepared with a few packets, so the sequence
+	// does not start from 0 anymore.
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	var prepare *PrepareData
+	var clientErr error
+	go func() {
+		defer wg.Done()
+		if clientErr = cConn.writeComPrepare("select ?"); clientErr != nil {
+			return
+		}
+		prepare, clientErr = cConn.readComPrepareResponse()
+	}()
+	if err := sConn.handleNextCommand(handler); err != nil {
+		t.Fatalf("handleNextCommand(ComPrepare) failed: %v", err)
+	}
+	wg.Wait()
+	if clientErr != nil {
+		t.Fatalf("prepare failed: %v", clientErr)
+	}
+
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			clientErr = cConn.ResetConnection()
+		}()
+		if err := sConn.handleNextCommand(handler); err != nil {
+			t.Fatalf("handleNextCommand(ComResetConnection) failed: %v", err)
+		}
+		wg.Wait()
+		if clientErr != nil {
+			t.Fatalf("ResetConnection failed: %v", clientErr)
+		}
+	}
+	if len(sConn.PrepareData) != 0 {
+		t.Errorf("PrepareData was not reset: %v", sConn.PrepareData)
+	}
+	if len(handler.closed) != 1 || handler.closed[0] != prepare.StatementID {
+		t.Errorf("ComStmtClosed called for %v, want [%v]", handler.closed, prepare.StatementID)
+	}
+
+	// The statement is gone.
+	data := make([]byte, 10)
+	data[0] = ComStmtExecute
+	writeUint32(data, 1, prepare.StatementID)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if clientErr = writeRawPacketToConn(cConn, data); clientErr != nil {
+			return
+		}
+		data, clientErr = cConn.ReadPacket()
+	}()
+	if err := sConn.handleNextCommand(handler); err != nil {
+		t.Fatalf("handleNextCommand(ComStmtExecute) failed: %v", err)
+	}
+	wg.Wait()
+	if clientErr != nil || len(data) == 0 || data[0] != ErrPacket {
+		t.Fatalf("expected an error packet after executing a reset statement, got: %v %v", data, clientErr)
+	}
+}
+
+func TestExecuteFetchAll(t *testing.T) {
+	listener, sConn, cConn := createSocketPair(t)
+	defer func() {
+		listener.Close()
+		sConn.Close()
+		cConn.Close()
+	}()
+
+	if _, err := cConn.ExecuteFetchAll("select rows; insert", 10); err == nil {
+		t.Fatalf("ExecuteFetchAll without multi statements should have failed")
+	}
+
+	sConn.Capabilities = CapabilityClientMultiStatements
+	cConn.Capabilities = CapabilityClientMultiStatements
+
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		results, err := cConn.ExecuteFetchAll("select rows; insert", 10)
+		if err != nil {
+			t.Errorf("ExecuteFetchAll failed: %v", err)
+			return
+		}
+		if len(results) != 2 {
+			t.Errorf("got %v results, want 2", len(results))
+			return
+		}
+		if !results[0].Equal(selectRowsResult.Copy()) {
+			t.Errorf("got first result %v, want %v", results[0], selectRowsResult)
+		}
+		want := &sqltypes.Result{RowsAffected: 123, InsertID: 123456789}
+		if !results[1].Equal(want) {
+			t.Errorf("got second result %v, want %v", results[1], want)
+		}
+	}()
+
+	if err := sConn.handleNextCommand(&testHandler{}); err != nil {
+		t.Fatalf("handleNextCommand failed: %v", err)
+	}
+	wg.Wait()
+
+	// A statement without a result set can come first, and semicolons
+	// in strings do not end statements.
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		results, err := cConn.ExecuteFetchAll("insert; select ';' ; select rows; ", 10)
+		if err != nil {
+			t.Errorf("ExecuteFetchAll failed: %v", err)
+			return
+		}
+		if len(results) != 3 {
+			t.Errorf("got %v results, want 3", len(results))
+			return
+		}
+		want := &sqltypes.Result{RowsAffected: 123, InsertID: 123456789}
+		if !results[0].Equal(want) {
+			t.Errorf("got first result %v, want %v", results[0], want)
+		}
+		if !results[2].Equal(selectRowsResult.Copy()) {
+			t.Errorf("got last result %v, want %v", results[2], selectRowsResult)
+		}
+	}()
+
+	if err := sConn.handleNextCommand(&testHandler{}); err != nil {
+		t.Fatalf("handleNextCommand failed: %v", err)
+	}
+	wg.Wait()
+
+	// An error ends the query, the statements after it are not run.
+	th := &testHandler{}
+	th.SetErr(NewSQLError(ERUnknownComError, SSUnknownComError, "forced query error"))
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if _, err := cConn.ExecuteFetchAll("insert; error; select rows", 10); err == nil || !strings.Contains(err.Error(), "forced query error") {
+			t.Errorf("ExecuteFetchAll returned %v, want forced query error", err)
+			return
+		}
+
+		// The connection is still in sync.
+		result, err := cConn.ExecuteFetch("select rows", 10, true)
+		if err != nil {
+			t.Errorf("ExecuteFetch after error failed: %v", err)
+			return
+		}
+		if !result.Equal(selectRowsResult.Copy()) {
+			t.Errorf("got result %v, want %v", result, selectRowsResult)
+		}
+	}()
+
+	for i := 0; i < 2; i++ {
+		if err := sConn.handleNextCommand(th); err != nil {
+			t.Fatalf("handleNextCommand failed: %v", err)
+		}
+	}
+	wg.Wait()
+}
+
 func TestQueries(t *testing.T) {
 	listener, sConn, cConn := createSocketPair(t)
 	defer func() {
@@ -692,7 +1680,7 @@ func RowString(row []sqltypes.Value) string {
 }
 
 func writeRawPacketToConn(c *Conn, packet []byte) error {
-	c.sequence = 0
+	c.resetSequence()
 	data := c.startEphemeralPacket(len(packet))
 	copy(data, packet)
 	return c.writeEphemeralPacket()
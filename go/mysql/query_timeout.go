@@ -0,0 +1,67 @@
+/*
+Copyright 2019 The Vitess Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mysql
+
+import (
+	"context"
+	"time"
+)
+
+// This file contains the support for bounding how long the server
+// side spends on a query.
+//
+// The Handler query methods get a context, with a deadline if the
+// connection has a query timeout. The Handler is expected to give up
+// once it is done. Results it sends after the deadline are refused, and
+// the client gets ERQueryInterrupted. If part of a result set was
+// already sent, the error packet ends it, as MySQL does for killed
+// queries, and the connection stays usable.
+//
+// Results of server side cursors are fetched at the pace of the
+// client, they are not bounded by the query timeout.
+
+// SetQueryTimeout bounds the time the next queries on the connection
+// can run for. 0 means no timeout.
+func (c *Conn) SetQueryTimeout(d time.Duration) {
+	c.queryTimeout = d
+}
+
+// QueryTimeout returns the query timeout of the connection, 0 if
+// there is none.
+func (c *Conn) QueryTimeout() time.Duration {
+	return c.queryTimeout
+}
+
+// queryContext returns the context a query runs in.
+func (c *Conn) queryContext() (context.Context, context.CancelFunc) {
+	if c.queryTimeout <= 0 {
+		return context.WithCancel(context.Background())
+	}
+	return context.WithTimeout(context.Background(), c.queryTimeout)
+}
+
+// queryTimedOut returns true if the query running in ctx was stopped
+// by the query timeout.
+func queryTimedOut(ctx context.Context, err error) bool {
+	return err != nil && ctx.Err() == context.DeadlineExceeded
+}
+
+// newQueryTimeoutError returns the error sent to the client when its
+// query times out.
+func (c *Conn) newQueryTimeoutError() error {
+	return NewSQLError(ERQueryInterrupted, SSQueryInterrupted, "Query execution was interrupted, maximum statement execution time (%v) exceeded", c.queryTimeout)
+}
@@ -0,0 +1,112 @@
+/*
+Copyright 2019 The Vitess Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mysql
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/dolthub/vitess/go/sqltypes"
+)
+
+// timeoutHandler is a testHandler with a query timeout, and queries
+// that run for too long.
+type timeoutHandler struct {
+	testHandler
+	timeout time.Duration
+}
+
+func (th *timeoutHandler) NewConnection(c *Conn) {
+	th.testHandler.NewConnection(c)
+	c.SetQueryTimeout(th.timeout)
+}
+
+func (th *timeoutHandler) ComMultiQuery(ctx context.Context, c *Conn, query string, callback func(res *sqltypes.Result, more bool) error) (string, error) {
+	return "", th.ComQuery(ctx, c, query, callback)
+}
+
+func (th *timeoutHandler) ComQuery(ctx context.Context, c *Conn, query string, callback func(res *sqltypes.Result, more bool) error) error {
+	switch query {
+	case "wait":
+		// Stops when told to.
+		<-ctx.Done()
+		return ctx.Err()
+	case "stream then wait":
+		if err := callback(selectRowsResult, false); err != nil {
+			return err
+		}
+		<-ctx.Done()
+		return ctx.Err()
+	case "sleep then send":
+		// Ignores the context.
+		time.Sleep(2 * th.timeout)
+		return callback(selectRowsResult, false)
+	}
+	return th.testHandler.ComQuery(ctx, c, query, callback)
+}
+
+func TestQueryTimeout(t *testing.T) {
+	th := &timeoutHandler{timeout: 50 * time.Millisecond}
+	authServer := NewAuthServerStatic("", "", 0)
+	authServer.entries["user1"] = []*AuthServerStaticEntry{{
+		Password: "password1",
+	}}
+	l, err := NewListener("tcp", ":0", authServer, th, 0, 0)
+	if err != nil {
+		t.Fatalf("NewListener failed: %v", err)
+	}
+	defer l.Close()
+	go l.Accept()
+
+	host, port := getHostPort(t, l.Addr())
+	params := &ConnParams{
+		Host:  host,
+		Port:  port,
+		Uname: "user1",
+		Pass:  "password1",
+	}
+	conn, err := Connect(context.Background(), params)
+	if err != nil {
+		t.Fatalf("Connect failed: %v", err)
+	}
+	defer conn.Close()
+
+	for _, query := range []string{"wait", "stream then wait", "sleep then send"} {
+		start := time.Now()
+		_, err := conn.ExecuteFetch(query, 1000, true)
+		assertSQLError(t, err, ERQueryInterrupted, SSQueryInterrupted, "maximum statement execution time", query)
+		if query != "sleep then send" && time.Since(start) > 10*th.timeout {
+			t.Errorf("%v took %v with a %v timeout", query, time.Since(start), th.timeout)
+		}
+
+		// The connection is still usable.
+		result, err := conn.ExecuteFetch("select rows", 1000, true)
+		if err != nil {
+			t.Fatalf("ExecuteFetch after %v timed out failed: %v", query, err)
+		}
+		if !result.Equal(selectRowsResult.Copy()) {
+			t.Errorf("got %v after %v timed out, want %v", result, query, selectRowsResult)
+		}
+	}
+
+	// Without a timeout, the query runs until it is done.
+	th.LastConn().SetQueryTimeout(0)
+	if _, err := conn.ExecuteFetch("sleep then send", 1000, true); err != nil {
+		t.Errorf("ExecuteFetch without a timeout failed: %v", err)
+	}
+}
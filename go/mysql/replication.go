@@ -16,13 +16,30 @@ limitations under the License.
 
 package mysql
 
+import (
+	"io"
+)
+
 // This file contains the methods related to replication.
 
+// The semi-sync header a master puts before the binlog events it
+// sends to a semi-sync replica.
+const (
+	// semiSyncIndicator is the first byte of the header.
+	semiSyncIndicator = 0xef
+
+	// semiSyncAckRequested is the flag of the second byte of the
+	// header telling that the master waits for the ack of the event.
+	semiSyncAckRequested = 0x01
+)
+
 // WriteComBinlogDump writes a ComBinlogDump command.
 // See http://dev.mysql.com/doc/internals/en/com-binlog-dump.html for syntax.
 // Returns a SQLError.
 func (c *Conn) WriteComBinlogDump(serverID uint32, binlogFilename string, binlogPos uint32, flags uint16) error {
-	c.sequence = 0
+	c.resetSequence()
+	c.binlogStreamStarted = false
+	c.binlogAckRequested = false
 	length := 1 + // ComBinlogDump
 		4 + // binlog-pos
 		2 + // flags
@@ -44,7 +61,9 @@ func (c *Conn) WriteComBinlogDump(serverID uint32, binlogFilename string, binlog
 // Only works with MySQL 5.6+ (and not MariaDB).
 // See http://dev.mysql.com/doc/internals/en/com-binlog-dump-gtid.html for syntax.
 func (c *Conn) WriteComBinlogDumpGTID(serverID uint32, binlogFilename string, binlogPos uint64, flags uint16, gtidSet []byte) error {
-	c.sequence = 0
+	c.resetSequence()
+	c.binlogStreamStarted = false
+	c.binlogAckRequested = false
 	length := 1 + // ComBinlogDumpGTID
 		2 + // flags
 		4 + // server-id
@@ -68,6 +87,97 @@ func (c *Conn) WriteComBinlogDumpGTID(serverID uint32, binlogFilename string, bi
 	return nil
 }
 
+// ReadRawBinlogEvent reads the next event of the binlog stream started
+// with WriteComBinlogDump or WriteComBinlogDumpGTID, and returns its
+// bytes, from its header on. If the master sends the events with the
+// semi-sync header, it is stripped, and BinlogAckRequested tells if
+// the event must be acked with WriteSemiSyncAck. The stream ends with
+// an error: a SQLError(CRServerLost) wrapping io.EOF when the master
+// has no more events to send, or the error the master sent.
+// Returns a SQLError.
+func (c *Conn) ReadRawBinlogEvent() ([]byte, error) {
+	data, err := c.ReadPacket()
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 0 {
+		return nil, NewSQLError(CRMalformedPacket, SSUnknownSQLState, "invalid empty binlog event packet")
+	}
+	switch data[0] {
+	case OKPacket:
+	case EOFPacket:
+		return nil, NewSQLError(CRServerLost, SSUnknownSQLState, "%v", io.EOF)
+	case ErrPacket:
+		return nil, ParseErrorPacket(data)
+	default:
+		return nil, NewSQLError(CRMalformedPacket, SSUnknownSQLState, "unexpected binlog event packet: %v", data[0])
+	}
+
+	event := data[1:]
+	if !c.binlogStreamStarted {
+		// The stream starts with an artificial ROTATE_EVENT, whose
+		// timestamp is 0: its first byte is the semi-sync
+		// indicator only if the header is there.
+		c.binlogStreamStarted = true
+		c.binlogSemiSync = len(event) > 2+4 && event[0] == semiSyncIndicator && event[2+4] == eRotateEvent
+	}
+	if c.binlogSemiSync {
+		if len(event) < 2 || event[0] != semiSyncIndicator {
+			return nil, NewSQLError(CRMalformedPacket, SSUnknownSQLState, "binlog event without its semi-sync header")
+		}
+		c.binlogAckRequested = event[1]&semiSyncAckRequested != 0
+		if c.binlogAckRequested {
+			// The master starts the sequence again after an event
+			// waiting for its ack, whether it gets it or not.
+			c.sequence = 1
+		}
+		event = event[2:]
+	}
+	return event, nil
+}
+
+// BinlogAckRequested returns whether the master asked for the ack of
+// the last event ReadRawBinlogEvent read: it waits for it, up to
+// rpl_semi_sync_master_timeout, before committing the transaction
+// the event ends. The caller can make the event durable, and then ack
+// it with WriteSemiSyncAck.
+func (c *Conn) BinlogAckRequested() bool {
+	return c.binlogAckRequested
+}
+
+// WriteSemiSyncAck acks the binlog events up to binlogPos in the
+// binlog file binlogFilename, usually the end of the event whose ack
+// the master asked for: binlogPos is then the next position in its
+// header. It is only
+// meaningful on a semi-sync replica connection, see
+// EnableSemiSyncReplica.
+// Returns a SQLError.
+func (c *Conn) WriteSemiSyncAck(binlogFilename string, binlogPos uint64) error {
+	c.resetSequence()
+	length := 1 + // semi-sync indicator
+		8 + // binlog-pos
+		len(binlogFilename) // binlog-filename
+	data := c.startEphemeralPacket(length)
+	pos := writeByte(data, 0, semiSyncIndicator)
+	pos = writeUint64(data, pos, binlogPos)
+	_ = writeEOFString(data, pos, binlogFilename)
+	if err := c.writeEphemeralPacket(); err != nil {
+		return NewSQLError(CRServerGone, SSUnknownSQLState, "%v", err)
+	}
+	return nil
+}
+
+// EnableSemiSyncReplica tells the master this connection is a
+// semi-sync replica, before it starts a binlog stream: if semi-sync
+// is enabled on the master, the events then have the semi-sync header.
+// Both the old and the new name of the user variable the master checks
+// are set. ConnParams.SemiSyncReplica calls it once connected.
+// Returns a SQLError.
+func (c *Conn) EnableSemiSyncReplica() error {
+	_, err := c.ExecuteFetch("SET @rpl_semi_sync_slave = 1, @rpl_semi_sync_replica = 1", 0, false)
+	return err
+}
+
 // SemiSyncExtensionLoaded checks if the semisync extension has been loaded.
 // It should work for both MariaDB and MySQL.
 func (c *Conn) SemiSyncExtensionLoaded() bool {
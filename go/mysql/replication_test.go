@@ -17,8 +17,16 @@ limitations under the License.
 package mysql
 
 import (
+	"context"
+	"fmt"
 	"reflect"
+	"sync"
 	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dolthub/vitess/go/sqltypes"
 )
 
 func TestComBinlogDump(t *testing.T) {
@@ -128,3 +136,216 @@ func TestComBinlogDumpGTID(t *testing.T) {
 		t.Errorf("ComBinlogDumpGTID returned unexpected data:\n%v\nwas expecting:\n%v", data, expectedData)
 	}
 }
+
+func TestReadRawBinlogEvent(t *testing.T) {
+	f := NewMySQL56BinlogFormat()
+	s := NewFakeBinlogStream()
+	bytes := func(ev BinlogEvent) []byte {
+		return ev.(mysql56BinlogEvent).Bytes()
+	}
+	rotate := bytes(NewRotateEvent(f, s, 4, "vt-0000062344-bin.000001"))
+	heartbeat := (&FakeBinlogStream{ServerID: 1}).Packetize(f, eHeartbeatEvent, 0, []byte("vt-0000062344-bin.000001"))
+	query := bytes(NewQueryEvent(f, s, Query{Database: "db", SQL: "insert into t values (1)"}))
+	// An event whose first byte is the semi-sync indicator.
+	s.Timestamp = 0x5f0000ef
+	xid := bytes(NewXIDEvent(f, s))
+
+	for _, semiSync := range []bool{false, true} {
+		t.Run(fmt.Sprintf("semi-sync=%v", semiSync), func(t *testing.T) {
+			listener, sConn, cConn := createSocketPair(t)
+			defer func() {
+				listener.Close()
+				sConn.Close()
+				cConn.Close()
+			}()
+
+			require.NoError(t, cConn.WriteComBinlogDumpGTID(1, "", 4, 0, nil))
+			_, err := sConn.ReadPacket()
+			require.NoError(t, err)
+
+			// The stream starts with the rotate event, and has
+			// heartbeats between the events.
+			events := [][]byte{rotate, heartbeat, query, xid, heartbeat}
+			for i, event := range events {
+				packet := []byte{OKPacket}
+				if semiSync {
+					var flags byte
+					if i == 3 {
+						flags = semiSyncAckRequested
+					}
+					packet = append(packet, semiSyncIndicator, flags)
+				}
+				require.NoError(t, sConn.writePacket(append(packet, event...)))
+				if semiSync && i == 3 {
+					// The master starts the sequence again
+					// after an event waiting for its ack.
+					sConn.sequence = 1
+				}
+			}
+			require.NoError(t, sConn.writeEOFPacket(0, 0))
+
+			for i, want := range events {
+				got, err := cConn.ReadRawBinlogEvent()
+				require.NoError(t, err)
+				assert.Equal(t, want, got)
+				assert.Equal(t, semiSync && i == 3, cConn.BinlogAckRequested())
+			}
+			_, err = cConn.ReadRawBinlogEvent()
+			assertSQLError(t, err, CRServerLost, SSUnknownSQLState, "EOF", "")
+		})
+	}
+
+	t.Run("errors", func(t *testing.T) {
+		listener, sConn, cConn := createSocketPair(t)
+		defer func() {
+			listener.Close()
+			sConn.Close()
+			cConn.Close()
+		}()
+
+		// dump starts a stream, and sends packets in it.
+		dump := func(packets ...[]byte) {
+			t.Helper()
+			require.NoError(t, cConn.WriteComBinlogDump(1, "binlog.000001", 4, 0))
+			sConn.sequence = 0
+			_, err := sConn.ReadPacket()
+			require.NoError(t, err)
+			for _, packet := range packets {
+				require.NoError(t, sConn.writePacket(packet))
+			}
+		}
+
+		dump()
+		require.NoError(t, sConn.writeErrorPacket(ERMasterFatalReadingBinlog, SSUnknownSQLState, "Could not find first log file name in binary log index file"))
+		_, err := cConn.ReadRawBinlogEvent()
+		assertSQLError(t, err, ERMasterFatalReadingBinlog, SSUnknownSQLState, "Could not find first log file name", "")
+
+		dump([]byte{})
+		_, err = cConn.ReadRawBinlogEvent()
+		assertSQLError(t, err, CRMalformedPacket, SSUnknownSQLState, "invalid empty binlog event packet", "")
+
+		dump([]byte{0x01, 0x02})
+		_, err = cConn.ReadRawBinlogEvent()
+		assertSQLError(t, err, CRMalformedPacket, SSUnknownSQLState, "unexpected binlog event packet", "")
+
+		// Once the stream started with the semi-sync header, all the
+		// events have it.
+		dump(append([]byte{OKPacket, semiSyncIndicator, 0}, rotate...), append([]byte{OKPacket}, query...))
+		got, err := cConn.ReadRawBinlogEvent()
+		require.NoError(t, err)
+		assert.Equal(t, rotate, got)
+		_, err = cConn.ReadRawBinlogEvent()
+		assertSQLError(t, err, CRMalformedPacket, SSUnknownSQLState, "binlog event without its semi-sync header", "")
+	})
+}
+
+func TestSemiSyncAck(t *testing.T) {
+	f := NewMySQL56BinlogFormat()
+	s := NewFakeBinlogStream()
+	bytes := func(ev BinlogEvent) []byte {
+		return ev.(mysql56BinlogEvent).Bytes()
+	}
+	rotate := bytes(NewRotateEvent(f, s, 4, "binlog.000001"))
+	query := bytes(NewQueryEvent(f, s, Query{Database: "db", SQL: "insert into t values (1)"}))
+	xid := bytes(NewXIDEvent(f, s))
+
+	listener, sConn, cConn := createSocketPair(t)
+	defer func() {
+		listener.Close()
+		sConn.Close()
+		cConn.Close()
+	}()
+
+	// The master waits for the ack of the xid events, and reads it
+	// before sending the next event, as the ack is a packet of its
+	// own.
+	events := []struct {
+		event        []byte
+		ackRequested bool
+		pos          uint64
+	}{
+		{event: rotate},
+		{event: query},
+		{event: xid, ackRequested: true, pos: 0x1234},
+		{event: query},
+		{event: xid, ackRequested: true, pos: 0x0102030405060708},
+	}
+	var acks [][]byte
+	var serverErr error
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if _, serverErr = sConn.ReadPacket(); serverErr != nil {
+			return
+		}
+		for _, ev := range events {
+			var flags byte
+			if ev.ackRequested {
+				flags = semiSyncAckRequested
+			}
+			if serverErr = sConn.writePacket(append([]byte{OKPacket, semiSyncIndicator, flags}, ev.event...)); serverErr != nil {
+				return
+			}
+			if ev.ackRequested {
+				sConn.sequence = 0
+				var ack []byte
+				if ack, serverErr = sConn.ReadPacket(); serverErr != nil {
+					return
+				}
+				acks = append(acks, ack)
+			}
+		}
+		serverErr = sConn.writeEOFPacket(0, 0)
+	}()
+
+	require.NoError(t, cConn.WriteComBinlogDumpGTID(1, "binlog.000001", 4, 0, nil))
+	for _, ev := range events {
+		got, err := cConn.ReadRawBinlogEvent()
+		require.NoError(t, err)
+		assert.Equal(t, ev.event, got, "the semi-sync header is stripped")
+		require.Equal(t, ev.ackRequested, cConn.BinlogAckRequested())
+		if cConn.BinlogAckRequested() {
+			require.NoError(t, cConn.WriteSemiSyncAck("binlog.000001", ev.pos))
+		}
+	}
+	_, err := cConn.ReadRawBinlogEvent()
+	assertSQLError(t, err, CRServerLost, SSUnknownSQLState, "EOF", "")
+	wg.Wait()
+	require.NoError(t, serverErr)
+
+	assert.Equal(t, [][]byte{{
+		semiSyncIndicator,
+		0x34, 0x12, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, // binlog-pos
+		'b', 'i', 'n', 'l', 'o', 'g', '.', '0', '0', '0', '0', '0', '1', // binlog-filename
+	}, {
+		semiSyncIndicator,
+		0x08, 0x07, 0x06, 0x05, 0x04, 0x03, 0x02, 0x01, // binlog-pos
+		'b', 'i', 'n', 'l', 'o', 'g', '.', '0', '0', '0', '0', '0', '1', // binlog-filename
+	}}, acks)
+}
+
+// queryRecorder is a testHandler recording the queries it gets.
+type queryRecorder struct {
+	testHandler
+	queries []string
+}
+
+func (th *queryRecorder) ComMultiQuery(ctx context.Context, c *Conn, query string, callback func(*sqltypes.Result, bool) error) (string, error) {
+	return "", th.ComQuery(ctx, c, query, callback)
+}
+
+func (th *queryRecorder) ComQuery(ctx context.Context, c *Conn, query string, callback func(*sqltypes.Result, bool) error) error {
+	th.mu.Lock()
+	th.queries = append(th.queries, query)
+	th.mu.Unlock()
+	return th.testHandler.ComQuery(ctx, c, query, callback)
+}
+
+func TestSemiSyncReplicaParam(t *testing.T) {
+	handler := &queryRecorder{}
+	connectResultPending(t, handler, ConnParams{SemiSyncReplica: true})
+	handler.mu.Lock()
+	defer handler.mu.Unlock()
+	assert.Equal(t, []string{"SET @rpl_semi_sync_slave = 1, @rpl_semi_sync_replica = 1"}, handler.queries)
+}
@@ -0,0 +1,171 @@
+/*
+Copyright 2019 The Vitess Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mysql
+
+import (
+	"encoding/base64"
+	"math"
+
+	"github.com/dolthub/vitess/go/sqltypes"
+
+	querypb "github.com/dolthub/vitess/go/vt/proto/query"
+)
+
+// This file contains the support for an alternate encoding of result
+// sets, for clients that cannot handle arbitrary bytes in rows, like
+// web tooling expecting valid utf8mb4.
+//
+// With ResultEncodingBase64, the values of binary columns (BINARY,
+// VARBINARY and BLOB) are base64 encoded in rows. Their column
+// definitions advertise the utf8 character set, the length of the
+// encoded values, and have ColumnBase64Encoded set in the two filler
+// bytes that follow the decimals, so the client knows to decode them.
+// The filler is used because all the bits of the column flags already
+// have a meaning.
+//
+// A client opts in by sending the ConnAttrResultEncoding connection
+// attribute in its handshake.
+
+// ResultEncoding is the encoding of the values of result sets sent on
+// a connection.
+type ResultEncoding int
+
+const (
+	// ResultEncodingText is the regular MySQL encoding.
+	ResultEncodingText ResultEncoding = iota
+
+	// ResultEncodingBase64 base64 encodes the values of binary
+	// columns.
+	ResultEncodingBase64
+)
+
+const (
+	// ConnAttrResultEncoding is the connection attribute a client
+	// sends to pick the result encoding. Its value is
+	// ResultEncodingTextName or ResultEncodingBase64Name.
+	ConnAttrResultEncoding = "_vt_result_encoding"
+
+	// ResultEncodingTextName is the name of ResultEncodingText.
+	ResultEncodingTextName = "text"
+
+	// ResultEncodingBase64Name is the name of ResultEncodingBase64.
+	ResultEncodingBase64Name = "base64"
+
+	// ColumnBase64Encoded is set in the filler of the column
+	// definitions of base64 encoded columns.
+	ColumnBase64Encoded = 0x0001
+)
+
+// negotiateResultEncoding sets the result encoding the client asked
+// for in its connection attributes. An unknown encoding is an error,
+// so a client that needs one doesn't get rows it can't read.
+func (c *Conn) negotiateResultEncoding() error {
+	name, ok := c.Attributes[ConnAttrResultEncoding]
+	if !ok {
+		return nil
+	}
+	switch name {
+	case ResultEncodingTextName:
+		c.resultEncoding = ResultEncodingText
+	case ResultEncodingBase64Name:
+		c.resultEncoding = ResultEncodingBase64
+	default:
+		return NewSQLError(ERNotSupportedYet, SSUnknownSQLState, "unsupported result encoding %q", name)
+	}
+	return nil
+}
+
+// ResultEncoding returns the encoding of the result sets sent on the
+// connection.
+func (c *Conn) ResultEncoding() ResultEncoding {
+	return c.resultEncoding
+}
+
+// SetResultEncoding changes the encoding of the next result sets sent
+// on the connection, for instance when a handler gets a session
+// variable for it. Only clients that opted in with the
+// ConnAttrResultEncoding connection attribute can use
+// ResultEncodingBase64, it is an error for the others.
+// Returns a SQLError.
+func (c *Conn) SetResultEncoding(encoding ResultEncoding) error {
+	switch encoding {
+	case ResultEncodingText:
+	case ResultEncodingBase64:
+		if _, ok := c.Attributes[ConnAttrResultEncoding]; !ok {
+			return NewSQLError(ERNotSupportedYet, SSUnknownSQLState, "client did not opt in to result encodings with the %v connection attribute", ConnAttrResultEncoding)
+		}
+	default:
+		return NewSQLError(ERNotSupportedYet, SSUnknownSQLState, "unsupported result encoding %v", encoding)
+	}
+	c.resultEncoding = encoding
+	return nil
+}
+
+// isBase64Column returns true if the values of the field are base64
+// encoded.
+func (c *Conn) isBase64Column(field *querypb.Field) bool {
+	return c.resultEncoding == ResultEncodingBase64 && sqltypes.IsBinary(field.Type)
+}
+
+// base64ColumnsFor returns which of the fields are base64 encoded, or
+// nil if none are.
+func (c *Conn) base64ColumnsFor(fields []*querypb.Field) []bool {
+	var columns []bool
+	for i, field := range fields {
+		if !c.isBase64Column(field) {
+			continue
+		}
+		if columns == nil {
+			columns = make([]bool, len(fields))
+		}
+		columns[i] = true
+	}
+	return columns
+}
+
+// encodeRow returns the row with the values of the base64 columns
+// encoded. It returns the row itself if there are none.
+func encodeRow(base64Columns []bool, row []sqltypes.Value) ([]sqltypes.Value, error) {
+	if base64Columns == nil {
+		return row, nil
+	}
+	encoded := make([]sqltypes.Value, len(row))
+	for i, val := range row {
+		if i >= len(base64Columns) || !base64Columns[i] || val.IsNull() {
+			encoded[i] = val
+			continue
+		}
+		if val.IsStream() {
+			return nil, NewSQLError(ERNotSupportedYet, SSUnknownSQLState, "stream values cannot be base64 encoded")
+		}
+		raw := val.Raw()
+		buf := make([]byte, base64.StdEncoding.EncodedLen(len(raw)))
+		base64.StdEncoding.Encode(buf, raw)
+		encoded[i] = sqltypes.MakeTrusted(val.Type(), buf)
+	}
+	return encoded, nil
+}
+
+// base64ColumnLength returns the column length of a base64 encoded
+// column.
+func base64ColumnLength(length uint32) uint32 {
+	encoded := (int64(length) + 2) / 3 * 4
+	if encoded > math.MaxUint32 {
+		return math.MaxUint32
+	}
+	return uint32(encoded)
+}
@@ -0,0 +1,158 @@
+/*
+Copyright 2019 The Vitess Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mysql
+
+import (
+	"encoding/base64"
+	"sync"
+	"testing"
+
+	"github.com/dolthub/vitess/go/sqltypes"
+
+	querypb "github.com/dolthub/vitess/go/vt/proto/query"
+)
+
+func TestResultEncoding(t *testing.T) {
+	binaryValue := []byte{0x00, 0xff, 0xfe, 'a', 0x80}
+	result := &sqltypes.Result{
+		Fields: []*querypb.Field{
+			{Name: "data", Type: querypb.Type_VARBINARY, Charset: CharacterSetBinary, ColumnLength: 30},
+			{Name: "name", Type: querypb.Type_VARCHAR, Charset: CharacterSetUtf8, ColumnLength: 30},
+		},
+		Rows: [][]sqltypes.Value{
+			{sqltypes.MakeTrusted(querypb.Type_VARBINARY, binaryValue), sqltypes.NewVarChar("text")},
+			{sqltypes.NULL, sqltypes.NewVarChar("null")},
+		},
+	}
+
+	for _, tcase := range []struct {
+		name      string
+		attrs     map[string]string
+		wantData  string
+		wantField bool
+	}{{
+		name:     "text",
+		wantData: string(binaryValue),
+	}, {
+		name:     "text attribute",
+		attrs:    map[string]string{ConnAttrResultEncoding: ResultEncodingTextName},
+		wantData: string(binaryValue),
+	}, {
+		name:      "base64",
+		attrs:     map[string]string{ConnAttrResultEncoding: ResultEncodingBase64Name},
+		wantData:  base64.StdEncoding.EncodeToString(binaryValue),
+		wantField: true,
+	}} {
+		t.Run(tcase.name, func(t *testing.T) {
+			listener, sConn, cConn := createSocketPair(t)
+			defer func() {
+				listener.Close()
+				sConn.Close()
+				cConn.Close()
+			}()
+			sConn.Attributes = tcase.attrs
+			if err := sConn.negotiateResultEncoding(); err != nil {
+				t.Fatalf("negotiateResultEncoding failed: %v", err)
+			}
+
+			var serverErr error
+			wg := sync.WaitGroup{}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if _, serverErr = sConn.ReadPacket(); serverErr == nil {
+					serverErr = writeResult(sConn, result)
+				}
+			}()
+
+			if err := cConn.WriteComQuery("select"); err != nil {
+				t.Fatalf("WriteComQuery failed: %v", err)
+			}
+			// Read the raw column definitions, to see the filler.
+			packets := make([][]byte, 7)
+			for i := range packets {
+				data, err := cConn.ReadPacket()
+				if err != nil {
+					t.Fatalf("ReadPacket failed: %v", err)
+				}
+				packets[i] = data
+			}
+			wg.Wait()
+			if serverErr != nil {
+				t.Fatalf("server failed: %v", serverErr)
+			}
+
+			for i, want := range []bool{tcase.wantField, false} {
+				def := packets[1+i]
+				if got := def[len(def)-2] == ColumnBase64Encoded; got != want {
+					t.Errorf("column %v marked as base64: %v, want %v", i, got, want)
+				}
+				// The fixed length fields are the last 13 bytes.
+				charset, pos, _ := readUint16(def, len(def)-12)
+				length, _, _ := readUint32(def, pos)
+				wantCharset, wantLength := uint16(result.Fields[i].Charset), result.Fields[i].ColumnLength
+				if want {
+					wantCharset, wantLength = CharacterSetUtf8, 40
+				}
+				if charset != wantCharset || length != wantLength {
+					t.Errorf("column %v has charset %v and length %v, want %v and %v", i, charset, length, wantCharset, wantLength)
+				}
+			}
+
+			data, _, ok := readLenEncString(packets[4], 0)
+			if !ok || data != tcase.wantData {
+				t.Errorf("got binary value %q, want %q", data, tcase.wantData)
+			}
+			name, _, _ := readLenEncString(packets[4], 1+len(tcase.wantData))
+			if name != "text" {
+				t.Errorf("got text value %q, want %q", name, "text")
+			}
+			if packets[5][0] != NullValue {
+				t.Errorf("got NULL binary value %v, want a NULL", packets[5])
+			}
+		})
+	}
+}
+
+func TestResultEncodingRefused(t *testing.T) {
+	c := &Conn{}
+	if err := c.SetResultEncoding(ResultEncodingBase64); err == nil {
+		t.Errorf("SetResultEncoding(ResultEncodingBase64) without opting in should have failed")
+	}
+	if c.ResultEncoding() != ResultEncodingText {
+		t.Errorf("got result encoding %v, want ResultEncodingText", c.ResultEncoding())
+	}
+	if err := c.SetResultEncoding(ResultEncodingText); err != nil {
+		t.Errorf("SetResultEncoding(ResultEncodingText) failed: %v", err)
+	}
+
+	c.Attributes = map[string]string{ConnAttrResultEncoding: "hex"}
+	if err := c.negotiateResultEncoding(); err == nil {
+		t.Errorf("negotiateResultEncoding(hex) should have failed")
+	}
+
+	c.Attributes = map[string]string{ConnAttrResultEncoding: ResultEncodingTextName}
+	if err := c.negotiateResultEncoding(); err != nil {
+		t.Fatalf("negotiateResultEncoding failed: %v", err)
+	}
+	if err := c.SetResultEncoding(ResultEncodingBase64); err != nil {
+		t.Errorf("SetResultEncoding(ResultEncodingBase64) after opting in failed: %v", err)
+	}
+	if c.ResultEncoding() != ResultEncodingBase64 {
+		t.Errorf("got result encoding %v, want ResultEncodingBase64", c.ResultEncoding())
+	}
+}
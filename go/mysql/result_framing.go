@@ -0,0 +1,190 @@
+/*
+Copyright 2019 The Vitess Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mysql
+
+// This file contains the support for proxies forwarding query results
+// between peers that did not negotiate the same
+// CapabilityClientDeprecateEOF.
+//
+// Without it, an EOF packet follows the column definitions, and
+// another one ends the rows. With it, the first EOF packet is
+// omitted, and the rows end with an OK packet with an EOF header, that
+// also carries the affected rows and last insert id.
+
+// framerState is the position of a ResultFramer in a response.
+type framerState int
+
+const (
+	framerStart framerState = iota
+	framerColumns
+	framerColumnsEOF
+	framerRows
+)
+
+// ResultFramer re-frames the raw packets of COM_QUERY responses read
+// from a peer with one CapabilityClientDeprecateEOF setting, so they
+// can be written to a peer with the other setting. A ResultFramer
+// follows a single response at a time, and can be reused for the next
+// one once Translate reports it done.
+type ResultFramer struct {
+	fromDeprecateEOF bool
+	toDeprecateEOF   bool
+
+	state       framerState
+	columnsLeft uint64
+}
+
+// NewResultFramer returns a ResultFramer translating packets read with
+// the fromCapabilities capabilities into packets for a peer with the
+// toCapabilities capabilities.
+func NewResultFramer(fromCapabilities, toCapabilities uint32) *ResultFramer {
+	return &ResultFramer{
+		fromDeprecateEOF: fromCapabilities&CapabilityClientDeprecateEOF != 0,
+		toDeprecateEOF:   toCapabilities&CapabilityClientDeprecateEOF != 0,
+	}
+}
+
+// Translate returns the packets to write in place of packet, which may
+// be none. done is true once packet ended the response, including all
+// the results that may follow with ServerMoreResultsExists.
+// Returns a SQLError.
+func (f *ResultFramer) Translate(packet []byte) (packets [][]byte, done bool, err error) {
+	if len(packet) == 0 {
+		return nil, false, NewSQLError(CRMalformedPacket, SSUnknownSQLState, "invalid empty result packet")
+	}
+
+	switch f.state {
+	case framerStart:
+		switch packet[0] {
+		case OKPacket:
+			_, _, status, _, err := parseOKPacket(packet)
+			if err != nil {
+				return nil, false, NewSQLError(CRMalformedPacket, SSUnknownSQLState, "%v", err)
+			}
+			return [][]byte{packet}, !status.hasMore(), nil
+		case ErrPacket:
+			return [][]byte{packet}, true, nil
+		case LocalInfilePacket:
+			return nil, false, NewSQLError(CRUnknownError, SSUnknownSQLState, "cannot translate LOAD DATA LOCAL requests")
+		}
+		columns, _, ok := readLenEncInt(packet, 0)
+		if !ok || columns == 0 {
+			return nil, false, NewSQLError(CRMalformedPacket, SSUnknownSQLState, "cannot get column number")
+		}
+		f.state = framerColumns
+		f.columnsLeft = columns
+		return [][]byte{packet}, false, nil
+
+	case framerColumns:
+		packets = [][]byte{packet}
+		f.columnsLeft--
+		if f.columnsLeft > 0 {
+			return packets, false, nil
+		}
+		if !f.fromDeprecateEOF {
+			f.state = framerColumnsEOF
+			return packets, false, nil
+		}
+		f.state = framerRows
+		if !f.toDeprecateEOF {
+			// The status flags are not known yet. Clients
+			// only look at the ones of the final EOF.
+			packets = append(packets, eofPacket(0, 0))
+		}
+		return packets, false, nil
+
+	case framerColumnsEOF:
+		if isErrorPacket(packet) {
+			f.state = framerStart
+			return [][]byte{packet}, true, nil
+		}
+		if !isEOFPacket(packet) {
+			return nil, false, NewSQLError(CRCommandsOutOfSync, SSUnknownSQLState, "unexpected packet after fields: %v", packet)
+		}
+		f.state = framerRows
+		if f.toDeprecateEOF {
+			return nil, false, nil
+		}
+		return [][]byte{packet}, false, nil
+
+	default:
+		if isErrorPacket(packet) {
+			f.state = framerStart
+			return [][]byte{packet}, true, nil
+		}
+		if !isEOFPacket(packet) {
+			// A row.
+			return [][]byte{packet}, false, nil
+		}
+
+		var affectedRows, lastInsertID uint64
+		var status serverStatus
+		var warnings uint16
+		if f.fromDeprecateEOF {
+			affectedRows, lastInsertID, status, warnings, err = parseOKPacket(packet)
+		} else {
+			warnings, status, err = parseEOFPacket(packet)
+		}
+		if err != nil {
+			return nil, false, NewSQLError(CRMalformedPacket, SSUnknownSQLState, "%v", err)
+		}
+
+		f.state = framerStart
+		done = !status.hasMore()
+		if f.fromDeprecateEOF == f.toDeprecateEOF {
+			return [][]byte{packet}, done, nil
+		}
+		if f.toDeprecateEOF {
+			return [][]byte{okPacketWithEOFHeader(affectedRows, lastInsertID, uint16(status), warnings)}, done, nil
+		}
+		return [][]byte{eofPacket(uint16(status), warnings)}, done, nil
+	}
+}
+
+// eofPacket returns the contents of an EOF packet.
+func eofPacket(flags, warnings uint16) []byte {
+	data := make([]byte, 5)
+	pos := writeByte(data, 0, EOFPacket)
+	pos = writeUint16(data, pos, warnings)
+	_ = writeUint16(data, pos, flags)
+	return data
+}
+
+// okPacketWithEOFHeader returns the contents of an OK packet with an
+// EOF header, as written by writeOKPacketWithEOFHeader.
+func okPacketWithEOFHeader(affectedRows, lastInsertID uint64, flags, warnings uint16) []byte {
+	data := make([]byte, 1+lenEncIntSize(affectedRows)+lenEncIntSize(lastInsertID)+2+2)
+	pos := writeByte(data, 0, EOFPacket)
+	pos = writeLenEncInt(data, pos, affectedRows)
+	pos = writeLenEncInt(data, pos, lastInsertID)
+	pos = writeUint16(data, pos, flags)
+	_ = writeUint16(data, pos, warnings)
+	return data
+}
+
+// WritePacket writes a raw packet to the underlying connection, cutting
+// it into multiple chunks if needed. It is the public API version of
+// writePacket, for proxies forwarding packets read with ReadPacket.
+// On a buffered connection, the packet is sent when the buffer is
+// flushed.
+// Returns a SQLError.
+func (c *Conn) WritePacket(data []byte) error {
+	if err := c.writePacket(data); err != nil {
+		return NewSQLError(CRServerGone, SSUnknownSQLState, "%v", err)
+	}
+	return nil
+}
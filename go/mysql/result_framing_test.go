@@ -0,0 +1,135 @@
+/*
+Copyright 2019 The Vitess Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mysql
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/dolthub/vitess/go/sqltypes"
+)
+
+// proxyQuery forwards the next query read on dConn to uConn, and the
+// response back, through a ResultFramer.
+func proxyQuery(dConn, uConn *Conn) error {
+	dConn.sequence = 0
+	query, err := dConn.ReadPacket()
+	if err != nil {
+		return err
+	}
+	uConn.sequence = 0
+	if err := uConn.WritePacket(query); err != nil {
+		return err
+	}
+
+	framer := NewResultFramer(uConn.Capabilities, dConn.Capabilities)
+	for done := false; !done; {
+		packet, err := uConn.ReadPacket()
+		if err != nil {
+			return err
+		}
+		var packets [][]byte
+		packets, done, err = framer.Translate(packet)
+		if err != nil {
+			return err
+		}
+		for _, packet := range packets {
+			if err := dConn.WritePacket(packet); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func TestResultFramer(t *testing.T) {
+	insertResult := &sqltypes.Result{
+		RowsAffected: 123,
+		InsertID:     123456789,
+	}
+	queries := []struct {
+		query  string
+		result *sqltypes.Result
+		err    bool
+	}{
+		{query: "select rows", result: selectRowsResult.Copy()},
+		{query: "insert", result: insertResult},
+		{query: "error", err: true},
+	}
+
+	for _, tcase := range []struct {
+		upstream, downstream uint32
+	}{
+		{CapabilityClientDeprecateEOF, 0},
+		{0, CapabilityClientDeprecateEOF},
+		{CapabilityClientDeprecateEOF, CapabilityClientDeprecateEOF},
+		{0, 0},
+	} {
+		t.Run(fmt.Sprintf("upstream %x downstream %x", tcase.upstream, tcase.downstream), func(t *testing.T) {
+			uListener, uServer, uClient := createSocketPair(t)
+			dListener, dServer, dClient := createSocketPair(t)
+			defer func() {
+				uListener.Close()
+				uServer.Close()
+				uClient.Close()
+				dListener.Close()
+				dServer.Close()
+				dClient.Close()
+			}()
+			uServer.Capabilities = tcase.upstream
+			uClient.Capabilities = tcase.upstream
+			dServer.Capabilities = tcase.downstream
+			dClient.Capabilities = tcase.downstream
+
+			th := &testHandler{}
+			th.SetErr(NewSQLError(ERUnknownComError, SSUnknownComError, "forced query error"))
+			for _, q := range queries {
+				var result *sqltypes.Result
+				var clientErr error
+				wg := sync.WaitGroup{}
+				wg.Add(2)
+				go func() {
+					defer wg.Done()
+					result, clientErr = dClient.ExecuteFetch(q.query, 10, true)
+				}()
+				go func() {
+					defer wg.Done()
+					uServer.handleNextCommand(th)
+				}()
+
+				if err := proxyQuery(dServer, uClient); err != nil {
+					t.Fatalf("proxyQuery(%v) failed: %v", q.query, err)
+				}
+				wg.Wait()
+
+				if q.err {
+					if clientErr == nil {
+						t.Errorf("ExecuteFetch(%v) should have failed", q.query)
+					}
+					continue
+				}
+				if clientErr != nil {
+					t.Fatalf("ExecuteFetch(%v) failed: %v", q.query, clientErr)
+				}
+				if !result.Equal(q.result) {
+					t.Errorf("ExecuteFetch(%v) returned %v, want %v", q.query, result, q.result)
+				}
+			}
+		})
+	}
+}
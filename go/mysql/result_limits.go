@@ -0,0 +1,108 @@
+/*
+Copyright 2019 The Vitess Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mysql
+
+import "math"
+
+// ResultLimits bounds the work the client side of a connection is
+// willing to do while decoding a single result set. They protect us
+// from servers that announce absurd column counts or never terminate
+// a result set. A zero value for any field means that dimension is
+// not limited.
+type ResultLimits struct {
+	// MaxColumns is the largest column count accepted for a result set.
+	MaxColumns int
+
+	// MaxRows is the largest number of rows accepted in a result set.
+	// Unlike the maxrows argument of ExecuteFetch, exceeding it is
+	// treated as a protocol violation and the connection is closed.
+	MaxRows int
+
+	// MaxBytes is the largest cumulative size of the row packets
+	// of a result set.
+	MaxBytes int64
+
+	// MaxPackets is the largest number of packets in a result set,
+	// counting column definitions, rows and EOF packets.
+	MaxPackets int
+}
+
+// DefaultResultLimits are used by client connections when
+// ConnParams.ResultLimits is not set. They are far above anything a
+// legitimate MySQL server would send.
+var DefaultResultLimits = ResultLimits{
+	MaxColumns: 1 << 16,
+	MaxRows:    1 << 30,
+	MaxBytes:   1 << 40,
+	MaxPackets: math.MaxInt32,
+}
+
+// checkColumns validates a column count announced by the server,
+// before we allocate anything for it.
+func (l ResultLimits) checkColumns(n uint64) error {
+	if l.MaxColumns > 0 && n > uint64(l.MaxColumns) {
+		return NewSQLErrorFromCode(ERVitessResultTooManyColumns, n, l.MaxColumns)
+	}
+	return nil
+}
+
+// resultBudget tracks the work done decoding one result set against
+// the connection's ResultLimits.
+type resultBudget struct {
+	limits  ResultLimits
+	rows    int
+	bytes   int64
+	packets int
+}
+
+// newResultBudget returns a budget for the next result set.
+func (c *Conn) newResultBudget() resultBudget {
+	return resultBudget{limits: c.resultLimits}
+}
+
+// addPackets accounts for n packets that are not rows.
+func (b *resultBudget) addPackets(n int) error {
+	b.packets += n
+	if b.limits.MaxPackets > 0 && b.packets > b.limits.MaxPackets {
+		return NewSQLErrorFromCode(ERVitessResultTooManyPackets, b.limits.MaxPackets)
+	}
+	return nil
+}
+
+// addRow accounts for a row packet of the given size.
+func (b *resultBudget) addRow(size int) error {
+	if err := b.addPackets(1); err != nil {
+		return err
+	}
+	b.rows++
+	if b.limits.MaxRows > 0 && b.rows > b.limits.MaxRows {
+		return NewSQLErrorFromCode(ERVitessResultTooManyRows, b.limits.MaxRows)
+	}
+	b.bytes += int64(size)
+	if b.limits.MaxBytes > 0 && b.bytes > b.limits.MaxBytes {
+		return NewSQLErrorFromCode(ERVitessResultTooLarge, b.limits.MaxBytes)
+	}
+	return nil
+}
+
+// abortResult is called when we stop decoding a result set half way
+// through. The rest of it is still in flight, so the connection is
+// out of sync and can't be used any more: we close it.
+func (c *Conn) abortResult(err error) error {
+	c.Close()
+	return err
+}
@@ -0,0 +1,210 @@
+/*
+Copyright 2019 The Vitess Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mysql
+
+import (
+	"math/rand"
+	"sync"
+	"testing"
+
+	"github.com/dolthub/vitess/go/sqltypes"
+)
+
+var (
+	hostileColumnDefinition = []byte{
+		3, 'd', 'e', 'f', // catalog
+		0, 0, 0, // schema, table, org_table
+		1, 'a', // name
+		0,     // org_name
+		0x0c,  // length of fixed-length fields
+		33, 0, // character set
+		0, 0, 0, 0, // column length
+		0xfd, // type: VAR_STRING
+		0, 0, // flags
+		0,    // decimals
+		0, 0, // filler
+	}
+	hostileEOF = []byte{EOFPacket, 0, 0, 2, 0}
+)
+
+// playHostileServer acts as a misbehaving server on sConn: it reads
+// the client's command and answers with the given raw packets, then
+// closes the connection so the client never blocks on a truncated
+// result. Write errors are ignored, as the client may hang up first.
+func playHostileServer(sConn *Conn, packets [][]byte) {
+	defer sConn.Close()
+	if _, err := sConn.ReadPacket(); err != nil {
+		return
+	}
+	for _, packet := range packets {
+		data := sConn.startEphemeralPacket(len(packet))
+		copy(data, packet)
+		if err := sConn.writeEphemeralPacket(); err != nil {
+			return
+		}
+	}
+}
+
+// hostileResultPackets returns a result with a single column,
+// followed by the given rows and no terminating EOF.
+func hostileResultPackets(rows ...[]byte) [][]byte {
+	packets := [][]byte{{1}, hostileColumnDefinition, hostileEOF}
+	return append(packets, rows...)
+}
+
+func TestHostileResultSets(t *testing.T) {
+	row := []byte{3, 'a', 'b', 'c'}
+	tcases := []struct {
+		name    string
+		limits  *ResultLimits
+		packets [][]byte
+		stream  bool
+		errCode int
+	}{{
+		name:    "2^24-1 columns",
+		packets: [][]byte{{0xfd, 0xff, 0xff, 0xff}},
+		errCode: ERVitessResultTooManyColumns,
+	}, {
+		name:    "value length claims 4GB",
+		packets: hostileResultPackets([]byte{0xfe, 0, 0, 0, 0, 1, 0, 0, 0, 'x'}),
+		errCode: CRMalformedPacket,
+	}, {
+		name:    "value length overflows int",
+		packets: hostileResultPackets([]byte{0xfe, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 'x'}),
+		errCode: CRMalformedPacket,
+	}, {
+		name:    "column count larger than the limit",
+		limits:  &ResultLimits{MaxColumns: 4},
+		packets: [][]byte{{5}},
+		errCode: ERVitessResultTooManyColumns,
+	}, {
+		name:    "too many rows",
+		limits:  &ResultLimits{MaxRows: 2},
+		packets: hostileResultPackets(row, row, row),
+		errCode: ERVitessResultTooManyRows,
+	}, {
+		name:    "too many bytes",
+		limits:  &ResultLimits{MaxBytes: 10},
+		packets: hostileResultPackets(row, row, row),
+		errCode: ERVitessResultTooLarge,
+	}, {
+		name:    "too many packets",
+		limits:  &ResultLimits{MaxPackets: 3},
+		packets: hostileResultPackets(row, row),
+		errCode: ERVitessResultTooManyPackets,
+	}, {
+		name:    "too many streamed rows",
+		limits:  &ResultLimits{MaxRows: 2},
+		packets: hostileResultPackets(row, row, row),
+		stream:  true,
+		errCode: ERVitessResultTooManyRows,
+	}}
+
+	for _, tcase := range tcases {
+		t.Run(tcase.name, func(t *testing.T) {
+			listener, sConn, cConn := createSocketPair(t)
+			defer func() {
+				listener.Close()
+				sConn.Close()
+				cConn.Close()
+			}()
+			if tcase.limits != nil {
+				cConn.resultLimits = *tcase.limits
+			}
+
+			wg := sync.WaitGroup{}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				playHostileServer(sConn, tcase.packets)
+			}()
+
+			var err error
+			if tcase.stream {
+				err = cConn.ExecuteStreamFetch("select a from t")
+				for err == nil {
+					var row []sqltypes.Value
+					row, err = cConn.FetchNext()
+					if row == nil && err == nil {
+						t.Fatalf("FetchNext reached the end of the result")
+					}
+				}
+			} else {
+				_, err = cConn.ExecuteFetch("select a from t", 10000, true)
+			}
+			wg.Wait()
+
+			sqlErr, ok := err.(*SQLError)
+			if !ok || sqlErr.Number() != tcase.errCode {
+				t.Fatalf("got error %v, want code %v", err, tcase.errCode)
+			}
+			if !cConn.IsClosed() {
+				t.Errorf("client connection should be closed after %v", err)
+			}
+		})
+	}
+}
+
+// TestHostileResultSetsFuzz mutates a valid result set and makes sure
+// the client either decodes it or returns an error, without panicking.
+func TestHostileResultSetsFuzz(t *testing.T) {
+	valid := [][]byte{
+		{2},
+		hostileColumnDefinition,
+		hostileColumnDefinition,
+		hostileEOF,
+		{1, '1', 3, 'a', 'b', 'c'},
+		{NullValue, 0},
+		hostileEOF,
+	}
+
+	for i := 0; i < 100; i++ {
+		packets := make([][]byte, len(valid))
+		for j, packet := range valid {
+			packets[j] = append([]byte(nil), packet...)
+		}
+		packet := packets[rand.Intn(len(packets))]
+		switch rand.Intn(3) {
+		case 0:
+			packet[rand.Intn(len(packet))] = byte(rand.Intn(256))
+		case 1:
+			packet[rand.Intn(len(packet))] = []byte{0xfb, 0xfc, 0xfd, 0xfe, 0xff}[rand.Intn(5)]
+		case 2:
+			packets = packets[:rand.Intn(len(packets))+1]
+		}
+
+		func() {
+			listener, sConn, cConn := createSocketPair(t)
+			defer func() {
+				listener.Close()
+				sConn.Close()
+				cConn.Close()
+			}()
+			cConn.Capabilities = 0
+
+			wg := sync.WaitGroup{}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				playHostileServer(sConn, packets)
+			}()
+			// Any outcome but a panic or a hang is acceptable.
+			_, _ = cConn.ExecuteFetch("select a, b from t", 10000, true)
+			wg.Wait()
+		}()
+	}
+}
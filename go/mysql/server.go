@@ -17,10 +17,13 @@ limitations under the License.
 package mysql
 
 import (
+	"context"
+	"crypto/rsa"
 	"crypto/tls"
 	"io"
 	"net"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/dolthub/vitess/go/netutil"
@@ -57,6 +60,8 @@ var (
 	connAccept = stats.NewCounter("MysqlServerConnAccepted", "Connections accepted by MySQL server")
 	connSlow   = stats.NewCounter("MysqlServerConnSlow", "Connections that took more than the configured mysql_slow_connect_warn_threshold to establish")
 
+	commandCount = stats.NewCountersWithSingleLabel("MysqlServerCommandCount", "Commands received by MySQL server, by type", "command")
+
 	connCountByTLSVer = stats.NewGaugesWithSingleLabel("MysqlServerConnCountByTLSVer", "Active MySQL server connections by TLS version", "tls")
 	connCountPerUser  = stats.NewGaugesWithSingleLabel("MysqlServerConnCountPerUser", "Active MySQL server connections per user", "count")
 	_                 = stats.NewGaugeFunc("MysqlServerConnCountUnauthenticated", "Active MySQL server connections that haven't authenticated yet", func() int64 {
@@ -96,22 +101,34 @@ type Handler interface {
 	// ComQuery is called when a connection receives a query.
 	// Note the contents of the query slice may change after
 	// the first call to callback. So the Handler should not
-	// hang on to the byte slice.
-	ComQuery(c *Conn, query string, callback func(res *sqltypes.Result, more bool) error) error
+	// hang on to the byte slice. Long running queries can call
+	// c.Progress before the first call to callback.
+	// ctx expires after the query timeout of the connection, see
+	// SetQueryTimeout, the handler should then stop and return.
+	ComQuery(ctx context.Context, c *Conn, query string, callback func(res *sqltypes.Result, more bool) error) error
 
 	// ComMultiQuery is called when a connection receives a query and the
 	// client supports MULTI_STATEMENT. It should process the first
 	// statement in |query| and return the remainder. It will be called
-	// multiple times until the remainder is |""|.
-	ComMultiQuery(c *Conn, query string, callback func(res *sqltypes.Result, more bool) error) (string, error)
+	// multiple times until the remainder is |""|, or only spaces.
+	// sqlparser.SplitStatement splits a statement from the remainder,
+	// taking quotes and comments into account. The server sets
+	// SERVER_MORE_RESULTS_EXISTS on the results of all the statements
+	// but the last one.
+	// ctx is as for ComQuery, for the first statement.
+	ComMultiQuery(ctx context.Context, c *Conn, query string, callback func(res *sqltypes.Result, more bool) error) (string, error)
 
 	// ComPrepare is called when a connection receives a prepared
-	// statement query.
-	ComPrepare(c *Conn, query string) ([]*querypb.Field, error)
+	// statement query, once its PrepareData is in c.PrepareData. It
+	// returns the definitions of the columns of the statement.
+	// ctx is as for ComQuery.
+	ComPrepare(ctx context.Context, c *Conn, query string) ([]*querypb.Field, error)
 
 	// ComStmtExecute is called when a connection receives a statement
 	// execute query.
-	ComStmtExecute(c *Conn, prepare *PrepareData, callback func(*sqltypes.Result) error) error
+	// ctx is as for ComQuery, except for statements executed with a
+	// cursor: they are not bounded by the query timeout.
+	ComStmtExecute(ctx context.Context, c *Conn, prepare *PrepareData, callback func(*sqltypes.Result) error) error
 
 	// WarningCount is called at the end of each query to obtain
 	// the value to be returned to the client in the EOF packet.
@@ -120,37 +137,145 @@ type Handler interface {
 	// or after the last ComQuery call completes.
 	WarningCount(c *Conn) uint16
 
+	// ComResetConnection is called when a connection receives a
+	// COM_RESET_CONNECTION. By the time it is called, the session
+	// state tracked by the Conn (schema name, character set, status
+	// flags and prepared statements) has already been reset to its
+	// defaults. The handler should reset any state it keeps for the
+	// connection, such as user variables or open transactions.
 	ComResetConnection(c *Conn)
 }
 
-// Listener is the MySQL server protocol listener.
-type Listener struct {
-	// Construction parameters, set by NewListener.
+// The following interfaces are optional hooks a Handler can also
+// implement. The server checks for them when it needs them.
+
+// StmtPrepareHandler is a Handler building the PrepareData of its
+// prepared statements.
+type StmtPrepareHandler interface {
+	Handler
+
+	// ComStmtPrepare is called when a connection receives a
+	// COM_STMT_PREPARE, before ComPrepare. The handler can return the
+	// PrepareData of the statement, with its ParamsCount, for instance
+	// to build a plan for it. It is kept in c.PrepareData, once its
+	// StatementID, PrepareStmt, ParamsType and BindVars are set. If it
+	// returns nil, the query is parsed to count its parameters. If an
+	// error is returned, it is sent to the client and the statement is
+	// not prepared.
+	ComStmtPrepare(c *Conn, query string) (*PrepareData, error)
+}
 
-	// authServer is the AuthServer object to use for authentication.
-	authServer AuthServer
+// StmtExecuteLogHandler is a Handler logging the executions of
+// prepared statements.
+type StmtExecuteLogHandler interface {
+	Handler
+
+	// ComStmtExecuteLog is called when a connection receives a
+	// COM_STMT_EXECUTE, once the parameters are parsed and before
+	// ComStmtExecute. query is the prepared statement, and bindVars
+	// the parameters it is executed with, named v1, v2... as in
+	// PrepareData.BindVars. It is meant for audit logs: bindVars must
+	// not be modified, but it can be kept, a new map is allocated for
+	// the next execution.
+	ComStmtExecuteLog(c *Conn, query string, bindVars map[string]*querypb.BindVariable)
+}
 
-	// handler is the data handler.
-	handler Handler
+// StmtCloseHandler is a Handler keeping state for prepared
+// statements.
+type StmtCloseHandler interface {
+	Handler
+
+	// ComStmtClosed is called when a connection receives a
+	// COM_STMT_CLOSE for one of its prepared statements, before it is
+	// removed from c.PrepareData. The handler can free what it keeps
+	// for the statement, like cursors or plans. Nothing is sent back
+	// to the client. It is not called for unknown statements.
+	// It is also called for each prepared statement when a
+	// connection receives a COM_RESET_CONNECTION, before
+	// ComResetConnection.
+	ComStmtClosed(c *Conn, stmtID uint32)
+}
+
+// SessionStateHandler is a Handler reporting the changes of the
+// session state to the clients tracking it.
+type SessionStateHandler interface {
+	Handler
+
+	// SessionStateChanges is called at the end of each ComQuery or
+	// ComMultiQuery statement without a result set, like WarningCount,
+	// if the client negotiated CapabilityClientSessionTrack, which
+	// ListenerConfig.AllowSessionTracking allows. The changes it returns
+	// are sent to the client in the OK packet, for instance the new
+	// schema after a USE, so it can follow the session state. It
+	// returns nil if there are none.
+	SessionStateChanges(c *Conn) *sqltypes.SessionStateChanges
+}
+
+// ChangeUserHandler is a Handler keeping state for the user of a
+// connection. Without it, COM_CHANGE_USER only resets the session
+// state tracked by the Conn.
+type ChangeUserHandler interface {
+	Handler
+
+	// ComChangeUser is called when a connection receives a
+	// COM_CHANGE_USER, once the new user is authenticated. As for
+	// ComResetConnection, the session state tracked by the Conn has
+	// already been reset, ComStmtClosed was called for each prepared
+	// statement, and c.User and c.UserData are the new ones.
+	// previousUser is the user before the change. ComInitDB is called
+	// next with the database the client asked for. If an error is
+	// returned, it is sent to the client and the connection is closed.
+	ComChangeUser(c *Conn, previousUser string) error
+}
+
+// Listener is the MySQL server protocol listener.
+type Listener struct {
+	// cfg is the configuration of the listener. It is frozen once
+	// Accept is called, see freeze. It is read by multiple connection
+	// go routines, without a mutex.
+	cfg ListenerConfig
 
 	// This is the main listener socket.
 	listener net.Listener
 
-	// Max limit for connections
-	maxConns uint64
-
-	// The following parameters are read by multiple connection go
-	// routines.  They are not protected by a mutex, so they
-	// should be set after NewListener, and not changed while
-	// Accept is running.
+	// The following exported parameters are deprecated, they are
+	// kept for one release for the code setting them after
+	// NewListener: use ListenerConfig or the ListenerOptions instead.
+	// They are copied to cfg when Accept is called, changing them
+	// afterwards has no effect.
 
 	// ServerVersion is the version we will advertise.
+	//
+	// Deprecated: use ListenerConfig.ServerVersion or WithServerVersion.
 	ServerVersion string
 
 	// TLSConfig is the server TLS config. If set, we will advertise
 	// that we support SSL.
+	//
+	// Deprecated: use ListenerConfig.TLSConfig or WithTLS.
 	TLSConfig *tls.Config
 
+	// ClientQuirks are the rules giving protocol quirks to known
+	// clients, see ClientQuirkRule. The quirks of all the rules a
+	// client matches are used.
+	//
+	// Deprecated: use ListenerConfig.ClientQuirks or WithClientQuirks.
+	ClientQuirks []ClientQuirkRule
+
+	// AllowCompression lets the clients use the compressed protocol,
+	// if they ask for it with CapabilityClientCompress.
+	//
+	// Deprecated: use ListenerConfig.AllowCompression or WithCompression.
+	AllowCompression bool
+
+	// RequireSecureTransport configures the server to reject connections from insecure clients
+	//
+	// Deprecated: use ListenerConfig.RequireSecureTransport or WithTLS.
+	RequireSecureTransport bool
+
+	// The following parameters can be changed at any time, they are
+	// read by the connections as they need them.
+
 	// AllowClearTextWithoutTLS needs to be set for the
 	// mysql_clear_password authentication method to be accepted
 	// by the server when TLS is not in use.
@@ -162,25 +287,36 @@ type Listener struct {
 
 	// The following parameters are changed by the Accept routine.
 
+	// started is set once Accept is called, and cfg is frozen.
+	started sync2.AtomicBool
+
 	// Incrementing ID for connection id.
 	connectionID uint32
 
-	// Read timeout on a given connection
-	connReadTimeout time.Duration
-	// Write timeout on a given connection
-	connWriteTimeout time.Duration
-	// connReadBufferSize is size of buffer for reads from underlying connection.
-	// Reads are unbuffered if it's <=0.
-	connReadBufferSize int
-
 	// shutdown indicates that Shutdown method was called.
 	shutdown sync2.AtomicBool
 
-	// RequireSecureTransport configures the server to reject connections from insecure clients
-	RequireSecureTransport bool
+	// commandCount counts the commands received by this listener's
+	// connections, by type. It is not exported as a stats variable,
+	// see the global commandCount for that.
+	commandCount *stats.CountersWithSingleLabel
+
+	// status, abortedConnects and connections are the global status
+	// variables of this listener, see Status.
+	status          statusCounters
+	abortedConnects sync2.AtomicInt64
+	connections     sync2.AtomicInt64
+
+	// connsMu protects conns.
+	connsMu sync.Mutex
+	// conns are the open connections, for CloseConnections.
+	conns map[*Conn]struct{}
 }
 
 // NewFromListener creates a new mysql listener from an existing net.Listener
+//
+// Deprecated: use NewListenerWithOptions with WithListener, or
+// NewListenerWithConfig.
 func NewFromListener(l net.Listener, authServer AuthServer, handler Handler, connReadTimeout time.Duration, connWriteTimeout time.Duration) (*Listener, error) {
 	cfg := ListenerConfig{
 		Listener:           l,
@@ -194,6 +330,9 @@ func NewFromListener(l net.Listener, authServer AuthServer, handler Handler, con
 }
 
 // NewListener creates a new Listener.
+//
+// Deprecated: use NewListenerWithOptions with WithAddress, or
+// NewListenerWithConfig.
 func NewListener(protocol, address string, authServer AuthServer, handler Handler, connReadTimeout time.Duration, connWriteTimeout time.Duration) (*Listener, error) {
 	listener, err := net.Listen(protocol, address)
 	if err != nil {
@@ -204,6 +343,7 @@ func NewListener(protocol, address string, authServer AuthServer, handler Handle
 }
 
 // ListenerConfig should be used with NewListenerWithConfig to specify listener parameters.
+// It can also be built with ListenerOptions, see NewListenerWithOptions.
 type ListenerConfig struct {
 	// Protocol-Address pair and Listener are mutually exclusive parameters
 	Protocol                 string
@@ -214,13 +354,55 @@ type ListenerConfig struct {
 	ConnReadTimeout          time.Duration
 	ConnWriteTimeout         time.Duration
 	ConnReadBufferSize       int
+	ConnWriteBufferSize      int
 	MaxConns                 uint64
 	AllowClearTextWithoutTLS bool
+	ClientQuirks             []ClientQuirkRule
+	AllowCompression         bool
+	AllowZstdCompression     bool
+	// AllowSessionTracking lets the clients negotiate
+	// CapabilityClientSessionTrack, for the Handler to report session
+	// state changes and for progress reports in OK packets. It changes
+	// the layout of all the OK packets the server sends them.
+	AllowSessionTracking bool
+	// ZstdCompressionLevel is the level the server compresses with
+	// when zstd is negotiated, between 1 and 22. If 0, it is the level
+	// the client asked for.
+	ZstdCompressionLevel int
+	ConnBufferPool       *ConnBufferPool
+	// ServerVersion is DefaultServerVersion if empty.
+	ServerVersion            string
+	TLSConfig                *tls.Config
+	RequireSecureTransport   bool
+	SlowConnectWarnThreshold time.Duration
+	// DisableNoDelay turns TCP_NODELAY off on the connections, for the
+	// kernel to coalesce small segments. The packets of a response are
+	// already coalesced in writes of up to ConnWriteBufferSize bytes,
+	// so it mostly matters for slow streaming results, at the cost of
+	// latency.
+	DisableNoDelay bool
+	// SlowQueryThreshold is the duration after which a query is
+	// counted in the Slow_queries status variable. 0 disables it.
+	SlowQueryThreshold time.Duration
+	// DefaultAuthMethod is the auth method advertised in the initial
+	// handshake: MysqlNativePassword if empty, or CachingSha2Password.
+	DefaultAuthMethod string
+	// RSAKey is the key the clients encrypt their password with,
+	// for the full caching_sha2_password authentication without TLS.
+	RSAKey *rsa.PrivateKey
 }
 
 // NewListenerWithConfig creates new listener using provided config. There are
-// no default values for config, so caller should ensure its correctness.
+// no default values for config, except for ServerVersion, so caller should
+// ensure its correctness. The config is checked with Validate.
 func NewListenerWithConfig(cfg ListenerConfig) (*Listener, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	if cfg.ServerVersion == "" {
+		cfg.ServerVersion = DefaultServerVersion
+	}
+
 	var l net.Listener
 	if cfg.Listener != nil {
 		l = cfg.Listener
@@ -233,19 +415,27 @@ func NewListenerWithConfig(cfg ListenerConfig) (*Listener, error) {
 	}
 
 	return &Listener{
-		authServer:               cfg.AuthServer,
-		handler:                  cfg.Handler,
+		cfg:                      cfg,
 		listener:                 l,
-		ServerVersion:            DefaultServerVersion,
-		connectionID:             1,
-		connReadTimeout:          cfg.ConnReadTimeout,
-		connWriteTimeout:         cfg.ConnWriteTimeout,
-		connReadBufferSize:       cfg.ConnReadBufferSize,
-		maxConns:                 cfg.MaxConns,
+		ServerVersion:            cfg.ServerVersion,
+		TLSConfig:                cfg.TLSConfig,
+		ClientQuirks:             cfg.ClientQuirks,
+		AllowCompression:         cfg.AllowCompression,
+		RequireSecureTransport:   cfg.RequireSecureTransport,
 		AllowClearTextWithoutTLS: sync2.NewAtomicBool(cfg.AllowClearTextWithoutTLS),
+		SlowConnectWarnThreshold: sync2.NewAtomicDuration(cfg.SlowConnectWarnThreshold),
+		connectionID:             1,
+		commandCount:             stats.NewCountersWithSingleLabel("", "", "command"),
 	}, nil
 }
 
+// CommandCounts returns the number of commands received by the
+// connections of this listener, indexed by command name (see
+// CommandName).
+func (l *Listener) CommandCounts() map[string]int64 {
+	return l.commandCount.Counts()
+}
+
 // Addr returns the listener address.
 func (l *Listener) Addr() net.Addr {
 	return l.listener.Addr()
@@ -253,6 +443,7 @@ func (l *Listener) Addr() net.Addr {
 
 // Accept runs an accept loop until the listener is closed.
 func (l *Listener) Accept() {
+	l.freeze()
 	for {
 		conn, err := l.listener.Accept()
 		if err != nil {
@@ -265,7 +456,7 @@ func (l *Listener) Accept() {
 		connectionID := l.connectionID
 		l.connectionID++
 
-		for l.maxConns > 0 && uint64(connCount.Get()) >= l.maxConns {
+		for l.cfg.MaxConns > 0 && uint64(connCount.Get()) >= l.cfg.MaxConns {
 			// TODO: make this behavior configurable (wait v. reject)
 			time.Sleep(500 * time.Millisecond)
 		}
@@ -279,11 +470,18 @@ func (l *Listener) Accept() {
 // handle is called in a go routine for each client connection.
 // FIXME(alainjobart) handle per-connection logs in a way that makes sense.
 func (l *Listener) handle(conn net.Conn, connectionID uint32, acceptTime time.Time) {
-	if l.connReadTimeout != 0 || l.connWriteTimeout != 0 {
-		conn = netutil.NewConnWithTimeouts(conn, l.connReadTimeout, l.connWriteTimeout)
+	if tcpConn, ok := conn.(*net.TCPConn); ok && l.cfg.DisableNoDelay {
+		if err := tcpConn.SetNoDelay(false); err != nil {
+			log.Warningf("Cannot turn TCP_NODELAY off on connection %v: %v", connectionID, err)
+		}
+	}
+	if l.cfg.ConnReadTimeout != 0 || l.cfg.ConnWriteTimeout != 0 {
+		conn = netutil.NewConnWithTimeouts(conn, l.cfg.ConnReadTimeout, l.cfg.ConnWriteTimeout)
 	}
 	c := newServerConn(conn, l)
 	c.ConnectionID = connectionID
+	l.addConn(c)
+	l.connections.Add(1)
 
 	// Catch panics, and close the connection in any case.
 	defer func() {
@@ -295,20 +493,31 @@ func (l *Listener) handle(conn net.Conn, connectionID uint32, acceptTime time.Ti
 		// startWriterBuffering is called
 		c.flush()
 
-		conn.Close()
+		c.Close()
+		l.removeConn(c)
 	}()
 
 	// Tell the handler about the connection coming and going.
-	l.handler.NewConnection(c)
-	defer l.handler.ConnectionClosed(c)
+	l.cfg.Handler.NewConnection(c)
+	defer l.cfg.Handler.ConnectionClosed(c)
+	c.initialStatusFlags = c.StatusFlags
 
 	// Adjust the count of open connections
 	defer connCount.Add(-1)
 
 	defer c.discardCursor()
 
+	// The connections closed before the end of the handshake are
+	// aborted connects.
+	connected := false
+	defer func() {
+		if !connected {
+			l.abortedConnects.Add(1)
+		}
+	}()
+
 	// First build and send the server handshake packet.
-	salt, err := c.writeHandshakeV10(l.ServerVersion, l.authServer, l.TLSConfig != nil)
+	salt, err := c.writeHandshakeV10(l.cfg.ServerVersion, l.cfg.AuthServer, l.cfg.TLSConfig != nil)
 	if err != nil {
 		if err != io.EOF {
 			log.Errorf("Cannot send HandshakeV10 packet to %s: %v", c, err)
@@ -359,18 +568,91 @@ func (l *Listener) handle(conn net.Conn, connectionID uint32, acceptTime time.Ti
 			}
 		}
 	} else {
-		if l.RequireSecureTransport {
+		if l.cfg.RequireSecureTransport {
 			c.writeErrorPacketFromError(vterrors.Errorf(vtrpc.Code_UNAVAILABLE, "server does not allow insecure connections, client must use SSL/TLS"))
 		}
 		connCountByTLSVer.Add(versionNoTLS, 1)
 		defer connCountByTLSVer.Add(versionNoTLS, -1)
 	}
 
+	if err := c.negotiateResultEncoding(); err != nil {
+		log.Errorf("Refusing result encoding of %s: %v", c, err)
+		c.writeErrorPacketFromError(err)
+		return
+	}
+	c.applyClientQuirks(l.cfg.ClientQuirks)
+
+	// The salt is kept for COM_CHANGE_USER.
+	c.salt = salt
+	userData, ok := l.authenticate(c, user, authMethod, salt, authResponse)
+	if !ok {
+		return
+	}
+	c.User = user
+	c.UserData = userData
+
+	// c.User can change with COM_CHANGE_USER, see changeUser.
+	if c.User != "" {
+		connCountPerUser.Add(c.User, 1)
+	}
+	defer func() {
+		if c.User != "" {
+			connCountPerUser.Add(c.User, -1)
+		}
+	}()
+
+	// Set db name.
+	if err = l.cfg.Handler.ComInitDB(c, c.schemaName); err != nil {
+		log.Errorf("failed to set the database %s: %v", c, err)
+
+		c.writeErrorPacketFromError(err)
+		return
+	}
+
+	// Negotiation worked, send OK packet.
+	if err := c.writeOKPacket(0, 0, c.StatusFlags, 0); err != nil {
+		log.Errorf("Cannot write OK packet to %s: %v", c, err)
+		return
+	}
+
+	// The packets after the handshake are compressed, if the client
+	// asked for it.
+	if c.Capabilities&(CapabilityClientCompress|CapabilityClientZstdCompressionAlgorithm) != 0 {
+		if l.cfg.ZstdCompressionLevel != 0 {
+			c.zstdCompressionLevel = l.cfg.ZstdCompressionLevel
+		}
+		c.enableCompression()
+	}
+	connected = true
+
+	// Record how long we took to establish the connection
+	timings.Record(connectTimingKey, acceptTime)
+
+	// Log a warning if it took too long to connect
+	connectTime := time.Since(acceptTime)
+	if threshold := l.SlowConnectWarnThreshold.Get(); threshold != 0 && connectTime > threshold {
+		connSlow.Add(1)
+		log.Warningf("Slow connection from %s: %v", c, connectTime)
+	}
+
+	for {
+		err := c.handleNextCommand(l.cfg.Handler)
+		if err != nil {
+			return
+		}
+	}
+}
+
+// authenticate authenticates user with the AuthServer, switching the
+// auth method of the client if needed. authMethod and authResponse are
+// what the client sent. It returns false if the authentication failed,
+// after sending the error to the client if possible.
+func (l *Listener) authenticate(c *Conn, user, authMethod string, salt, authResponse []byte) (Getter, bool) {
 	// See what auth method the AuthServer wants to use for that user.
-	authServerMethod, err := l.authServer.AuthMethod(user, conn.RemoteAddr().String())
+	authServerMethod, err := l.cfg.AuthServer.AuthMethod(user, c.RemoteAddr().String())
 	if err != nil {
 		c.writeErrorPacketFromError(err)
-		return
+		return nil, false
 	}
 
 	// Compare with what the client sent back.
@@ -379,46 +661,68 @@ func (l *Listener) handle(conn net.Conn, connectionID uint32, acceptTime time.Ti
 		// Both server and client want to use MysqlNativePassword:
 		// the negotiation can be completed right away, using the
 		// ValidateHash() method.
-		userData, err := l.authServer.ValidateHash(salt, user, authResponse, conn.RemoteAddr())
+		userData, err := l.cfg.AuthServer.ValidateHash(salt, user, authResponse, c.RemoteAddr())
 		if err != nil {
 			log.Warningf("Error authenticating user using MySQL native password: %v", err)
 			c.writeErrorPacketFromError(err)
-			return
+			return nil, false
 		}
-		c.User = user
-		c.UserData = userData
+		return userData, true
 
 	case authServerMethod == MysqlNativePassword:
 		// The server really wants to use MysqlNativePassword,
 		// but the client returned a result for something else.
 
-		salt, err := l.authServer.Salt()
+		salt, err := l.cfg.AuthServer.Salt()
 		if err != nil {
-			return
+			return nil, false
 		}
+		c.salt = salt
 		//lint:ignore SA4006 This line is required because the binary protocol requires padding with 0
 		data := make([]byte, 21)
 		data = append(salt, byte(0x00))
 		if err := c.writeAuthSwitchRequest(MysqlNativePassword, data); err != nil {
 			log.Errorf("Error writing auth switch packet for %s: %v", c, err)
-			return
+			return nil, false
 		}
 
 		response, err := c.readEphemeralPacket()
 		if err != nil {
 			log.Errorf("Error reading auth switch response for %s: %v", c, err)
-			return
+			return nil, false
 		}
 		c.recycleReadPacket()
 
-		userData, err := l.authServer.ValidateHash(salt, user, response, conn.RemoteAddr())
+		userData, err := l.cfg.AuthServer.ValidateHash(salt, user, response, c.RemoteAddr())
 		if err != nil {
 			log.Warningf("Error authenticating user using MySQL native password: %v", err)
 			c.writeErrorPacketFromError(err)
-			return
+			return nil, false
+		}
+		return userData, true
+
+	case c.Capabilities&CapabilityClientPluginAuth == 0:
+		// The server wants to use something else, but the client
+		// cannot switch auth methods. What it sent is a
+		// MysqlNativePassword scramble, try that.
+		userData, err := l.cfg.AuthServer.ValidateHash(salt, user, authResponse, c.RemoteAddr())
+		if err != nil {
+			log.Warningf("Error authenticating user without auth method switch using MySQL native password: %v", err)
+			c.writeErrorPacketFromError(err)
+			return nil, false
 		}
-		c.User = user
-		c.UserData = userData
+		return userData, true
+
+	case authServerMethod == CachingSha2Password:
+		// The exchanges of caching_sha2_password are handled here,
+		// see negotiateCachingSha2.
+		userData, err := l.negotiateCachingSha2(c, user, authMethod, salt, authResponse)
+		if err != nil {
+			log.Warningf("Error authenticating user using caching_sha2_password: %v", err)
+			c.writeErrorPacketFromError(err)
+			return nil, false
+		}
+		return userData, true
 
 	default:
 		// The server wants to use something else, re-negotiate.
@@ -426,7 +730,7 @@ func (l *Listener) handle(conn net.Conn, connectionID uint32, acceptTime time.Ti
 		// The negotiation happens in clear text. Let's check we can.
 		if !l.AllowClearTextWithoutTLS.Get() && c.Capabilities&CapabilityClientSSL == 0 {
 			c.writeErrorPacket(CRServerHandshakeErr, SSUnknownSQLState, "Cannot use clear text authentication over non-SSL connections.")
-			return
+			return nil, false
 		}
 
 		// Switch our auth method to what the server wants.
@@ -437,54 +741,17 @@ func (l *Listener) handle(conn net.Conn, connectionID uint32, acceptTime time.Ti
 		}
 		if err := c.writeAuthSwitchRequest(authServerMethod, data); err != nil {
 			log.Errorf("Error writing auth switch packet for %s: %v", c, err)
-			return
+			return nil, false
 		}
 
 		// Then hand over the rest of the negotiation to the
 		// auth server.
-		userData, err := l.authServer.Negotiate(c, user, conn.RemoteAddr())
+		userData, err := l.cfg.AuthServer.Negotiate(c, user, c.RemoteAddr())
 		if err != nil {
 			c.writeErrorPacketFromError(err)
-			return
-		}
-		c.User = user
-		c.UserData = userData
-	}
-
-	if c.User != "" {
-		connCountPerUser.Add(c.User, 1)
-		defer connCountPerUser.Add(c.User, -1)
-	}
-
-	// Set db name.
-	if err = l.handler.ComInitDB(c, c.schemaName); err != nil {
-		log.Errorf("failed to set the database %s: %v", c, err)
-
-		c.writeErrorPacketFromError(err)
-		return
-	}
-
-	// Negotiation worked, send OK packet.
-	if err := c.writeOKPacket(0, 0, c.StatusFlags, 0); err != nil {
-		log.Errorf("Cannot write OK packet to %s: %v", c, err)
-		return
-	}
-
-	// Record how long we took to establish the connection
-	timings.Record(connectTimingKey, acceptTime)
-
-	// Log a warning if it took too long to connect
-	connectTime := time.Since(acceptTime)
-	if threshold := l.SlowConnectWarnThreshold.Get(); threshold != 0 && connectTime > threshold {
-		connSlow.Add(1)
-		log.Warningf("Slow connection from %s: %v", c, connectTime)
-	}
-
-	for {
-		err := c.handleNextCommand(l.handler)
-		if err != nil {
-			return
+			return nil, false
 		}
+		return userData, true
 	}
 }
 
@@ -505,6 +772,38 @@ func (l *Listener) isShutdown() bool {
 	return l.shutdown.Get()
 }
 
+// CloseConnections closes all the open connections of the listener,
+// like a kill of each of them would. Their handlers get
+// ConnectionClosed once their current command is interrupted. It can
+// be used after Shutdown, once clients had a chance to move away.
+func (l *Listener) CloseConnections() {
+	l.connsMu.Lock()
+	conns := make([]*Conn, 0, len(l.conns))
+	for c := range l.conns {
+		conns = append(conns, c)
+	}
+	l.connsMu.Unlock()
+
+	for _, c := range conns {
+		c.Close()
+	}
+}
+
+func (l *Listener) addConn(c *Conn) {
+	l.connsMu.Lock()
+	defer l.connsMu.Unlock()
+	if l.conns == nil {
+		l.conns = make(map[*Conn]struct{})
+	}
+	l.conns[c] = struct{}{}
+}
+
+func (l *Listener) removeConn(c *Conn) {
+	l.connsMu.Lock()
+	defer l.connsMu.Unlock()
+	delete(l.conns, c)
+}
+
 // writeHandshakeV10 writes the Initial Handshake Packet, server side.
 // It returns the salt data.
 func (c *Conn) writeHandshakeV10(serverVersion string, authServer AuthServer, enableTLS bool) ([]byte, error) {
@@ -521,10 +820,27 @@ func (c *Conn) writeHandshakeV10(serverVersion string, authServer AuthServer, en
 		CapabilityClientDeprecateEOF |
 		CapabilityClientConnAttr |
 		CapabilityClientFoundRows |
-		CapabilityClientLocalFiles
+		CapabilityClientLocalFiles |
+		CapabilityClientQueryAttributes
 	if enableTLS {
 		capabilities |= CapabilityClientSSL
 	}
+	if c.listener != nil && c.listener.cfg.AllowSessionTracking {
+		capabilities |= CapabilityClientSessionTrack
+	}
+	if c.listener != nil && c.listener.cfg.AllowCompression {
+		capabilities |= CapabilityClientCompress
+	}
+	if c.listener != nil && c.listener.cfg.AllowZstdCompression {
+		capabilities |= CapabilityClientZstdCompressionAlgorithm
+	}
+
+	// The auth method the client starts with, see
+	// ListenerConfig.DefaultAuthMethod.
+	authMethod := MysqlNativePassword
+	if c.listener != nil && c.listener.cfg.DefaultAuthMethod != "" {
+		authMethod = c.listener.cfg.DefaultAuthMethod
+	}
 
 	length :=
 		1 + // protocol version
@@ -539,7 +855,7 @@ func (c *Conn) writeHandshakeV10(serverVersion string, authServer AuthServer, en
 			1 + // length of auth plugin data
 			10 + // reserved (0)
 			13 + // auth-plugin-data
-			lenNullString(MysqlNativePassword) // auth-plugin-name
+			lenNullString(authMethod) // auth-plugin-name
 
 	data := c.startEphemeralPacket(length)
 	pos := 0
@@ -588,8 +904,8 @@ func (c *Conn) writeHandshakeV10(serverVersion string, authServer AuthServer, en
 	data[pos] = 0
 	pos++
 
-	// Copy authPluginName. We always start with mysql_native_password.
-	pos = writeNullString(data, pos, MysqlNativePassword)
+	// Copy authPluginName.
+	pos = writeNullString(data, pos, authMethod)
 
 	// Sanity check.
 	if pos != len(data) {
@@ -628,7 +944,16 @@ func (l *Listener) parseClientHandshakePacket(c *Conn, firstTime bool, data []by
 	// later in the protocol. If we re-received the handshake packet
 	// after SSL negotiation, do not overwrite capabilities.
 	if firstTime {
-		c.Capabilities = clientFlags & (CapabilityClientDeprecateEOF | CapabilityClientFoundRows)
+		c.Capabilities = clientFlags & (CapabilityClientDeprecateEOF | CapabilityClientFoundRows | CapabilityClientMultiResults | CapabilityClientPluginAuth | CapabilityClientQueryAttributes)
+		if l.cfg.AllowSessionTracking {
+			c.Capabilities |= clientFlags & CapabilityClientSessionTrack
+		}
+		if l.cfg.AllowCompression {
+			c.Capabilities |= clientFlags & CapabilityClientCompress
+		}
+		if l.cfg.AllowZstdCompression {
+			c.Capabilities |= clientFlags & CapabilityClientZstdCompressionAlgorithm
+		}
 	}
 
 	// set connection capability for executing multi statements
@@ -649,16 +974,17 @@ func (l *Listener) parseClientHandshakePacket(c *Conn, firstTime bool, data []by
 		return "", "", nil, vterrors.Errorf(vtrpc.Code_INTERNAL, "parseClientHandshakePacket: can't read characterSet")
 	}
 	c.CharacterSet = characterSet
+	c.handshakeCharacterSet = characterSet
 
 	// 23x reserved zero bytes.
 	pos += 23
 
 	// Check for SSL.
-	if firstTime && l.TLSConfig != nil && clientFlags&CapabilityClientSSL > 0 {
+	if firstTime && l.cfg.TLSConfig != nil && clientFlags&CapabilityClientSSL > 0 {
 		// Need to switch to TLS, and then re-read the packet.
-		conn := tls.Server(c.Conn, l.TLSConfig)
+		conn := tls.Server(c.Conn, l.cfg.TLSConfig)
 		c.Conn = conn
-		c.bufferedReader.Reset(conn)
+		c.bufferedReader.Reset(connReader{c})
 		c.Capabilities |= CapabilityClientSSL
 		return "", "", nil, nil
 	}
@@ -728,9 +1054,30 @@ func (l *Listener) parseClientHandshakePacket(c *Conn, firstTime bool, data []by
 
 	// Decode connection attributes send by the client
 	if clientFlags&CapabilityClientConnAttr != 0 {
-		if _, _, err := parseConnAttrs(data, pos); err != nil {
+		attrs, attrsEnd, err := parseConnAttrs(data, pos)
+		if err != nil {
+			if c.Capabilities&CapabilityClientZstdCompressionAlgorithm != 0 {
+				// The zstd compression level, which follows, can't be
+				// found, and the client already expects zstd.
+				return "", "", nil, vterrors.Errorf(vtrpc.Code_INTERNAL, "parseClientHandshakePacket: can't read connection attributes before the zstd compression level: %v", err)
+			}
 			log.Warningf("Decode connection attributes send by the client: %v", err)
+		} else {
+			c.Attributes = attrs
+			pos = attrsEnd
+		}
+	}
+
+	// zstd compression level.
+	if c.Capabilities&CapabilityClientZstdCompressionAlgorithm != 0 {
+		level, _, ok := readByte(data, pos)
+		if !ok {
+			return "", "", nil, vterrors.Errorf(vtrpc.Code_INTERNAL, "parseClientHandshakePacket: can't read zstd compression level")
+		}
+		if level < 1 || level > maxZstdCompressionLevel {
+			return "", "", nil, vterrors.Errorf(vtrpc.Code_INVALID_ARGUMENT, "parseClientHandshakePacket: invalid zstd compression level %v", level)
 		}
+		c.zstdCompressionLevel = int(level)
 	}
 
 	return username, authMethod, authResponse, nil
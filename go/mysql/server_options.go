@@ -0,0 +1,314 @@
+/*
+Copyright 2019 The Vitess Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mysql
+
+import (
+	"crypto/rsa"
+	"crypto/tls"
+	"net"
+	"time"
+
+	"github.com/dolthub/vitess/go/vt/proto/vtrpc"
+	"github.com/dolthub/vitess/go/vt/vterrors"
+)
+
+// This file contains the API to configure a Listener when embedding
+// the server.
+//
+// A Listener is configured by a ListenerConfig, given to
+// NewListenerWithConfig, or built from ListenerOptions by
+// NewListenerWithOptions. The configuration is checked when the
+// Listener is created, and is frozen once Accept is called: the
+// connections all see the same configuration. Config returns it, for
+// diagnostics.
+//
+// AllowClearTextWithoutTLS and SlowConnectWarnThreshold are the
+// exceptions, they can still be changed through the Listener fields of
+// the same names.
+
+// ListenerOption sets a parameter of a ListenerConfig, see
+// NewListenerWithOptions.
+type ListenerOption func(*ListenerConfig)
+
+// NewListenerWithOptions creates a new Listener configured by opts. The
+// connections read in buffers of DefaultConnBufferSize bytes, unless
+// WithBufferSizes says otherwise.
+func NewListenerWithOptions(opts ...ListenerOption) (*Listener, error) {
+	cfg := ListenerConfig{
+		ConnReadBufferSize: DefaultConnBufferSize,
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	return NewListenerWithConfig(cfg)
+}
+
+// WithAddress makes the Listener listen on address, see net.Listen.
+func WithAddress(protocol, address string) ListenerOption {
+	return func(cfg *ListenerConfig) {
+		cfg.Protocol = protocol
+		cfg.Address = address
+	}
+}
+
+// WithListener makes the Listener accept connections from an existing
+// net.Listener.
+func WithListener(l net.Listener) ListenerOption {
+	return func(cfg *ListenerConfig) {
+		cfg.Listener = l
+	}
+}
+
+// WithAuthServer sets the AuthServer authenticating the clients.
+func WithAuthServer(authServer AuthServer) ListenerOption {
+	return func(cfg *ListenerConfig) {
+		cfg.AuthServer = authServer
+	}
+}
+
+// WithHandler sets the Handler running the commands.
+func WithHandler(handler Handler) ListenerOption {
+	return func(cfg *ListenerConfig) {
+		cfg.Handler = handler
+	}
+}
+
+// WithTimeouts sets the read and write timeouts of the connections. 0
+// means no timeout.
+func WithTimeouts(read, write time.Duration) ListenerOption {
+	return func(cfg *ListenerConfig) {
+		cfg.ConnReadTimeout = read
+		cfg.ConnWriteTimeout = write
+	}
+}
+
+// WithBufferSizes sets the sizes of the read and write buffers of the
+// connections, see ListenerConfig.
+func WithBufferSizes(read, write int) ListenerOption {
+	return func(cfg *ListenerConfig) {
+		cfg.ConnReadBufferSize = read
+		cfg.ConnWriteBufferSize = write
+	}
+}
+
+// WithConnBufferPool sets the pool of the packet buffers of the
+// connections.
+func WithConnBufferPool(pool *ConnBufferPool) ListenerOption {
+	return func(cfg *ListenerConfig) {
+		cfg.ConnBufferPool = pool
+	}
+}
+
+// WithMaxConns limits the number of open connections. Accept waits
+// for connections to close once the limit is reached.
+func WithMaxConns(maxConns uint64) ListenerOption {
+	return func(cfg *ListenerConfig) {
+		cfg.MaxConns = maxConns
+	}
+}
+
+// WithTLS lets the clients use TLS. If requireSecureTransport is set,
+// the clients not using it are rejected.
+func WithTLS(tlsConfig *tls.Config, requireSecureTransport bool) ListenerOption {
+	return func(cfg *ListenerConfig) {
+		cfg.TLSConfig = tlsConfig
+		cfg.RequireSecureTransport = requireSecureTransport
+	}
+}
+
+// WithAllowClearTextWithoutTLS accepts the mysql_clear_password
+// authentication method when TLS is not in use.
+func WithAllowClearTextWithoutTLS(allow bool) ListenerOption {
+	return func(cfg *ListenerConfig) {
+		cfg.AllowClearTextWithoutTLS = allow
+	}
+}
+
+// WithServerVersion sets the version advertised to the clients.
+func WithServerVersion(version string) ListenerOption {
+	return func(cfg *ListenerConfig) {
+		cfg.ServerVersion = version
+	}
+}
+
+// WithClientQuirks adds rules giving protocol quirks to known clients.
+func WithClientQuirks(rules ...ClientQuirkRule) ListenerOption {
+	return func(cfg *ListenerConfig) {
+		cfg.ClientQuirks = append(cfg.ClientQuirks, rules...)
+	}
+}
+
+// WithCompression lets the clients use the compressed protocol, with
+// zlib.
+func WithCompression(allow bool) ListenerOption {
+	return func(cfg *ListenerConfig) {
+		cfg.AllowCompression = allow
+	}
+}
+
+// WithZstdCompression lets the clients use the compressed protocol,
+// with zstd.
+func WithZstdCompression(allow bool) ListenerOption {
+	return func(cfg *ListenerConfig) {
+		cfg.AllowZstdCompression = allow
+	}
+}
+
+// WithZstdCompressionLevel sets the level the server compresses with
+// when zstd is negotiated, instead of the one the client asked for.
+func WithZstdCompressionLevel(level int) ListenerOption {
+	return func(cfg *ListenerConfig) {
+		cfg.ZstdCompressionLevel = level
+	}
+}
+
+// WithSessionTracking lets the clients negotiate session state
+// tracking. See ListenerConfig.AllowSessionTracking.
+func WithSessionTracking(allow bool) ListenerOption {
+	return func(cfg *ListenerConfig) {
+		cfg.AllowSessionTracking = allow
+	}
+}
+
+// WithDefaultAuthMethod sets the auth method advertised in the initial
+// handshake, MysqlNativePassword or CachingSha2Password. The clients
+// start with it, it saves a round trip if the AuthServer uses it.
+func WithDefaultAuthMethod(method string) ListenerOption {
+	return func(cfg *ListenerConfig) {
+		cfg.DefaultAuthMethod = method
+	}
+}
+
+// WithRSAKey sets the key the clients encrypt their password with, in
+// the full caching_sha2_password authentication without TLS.
+func WithRSAKey(key *rsa.PrivateKey) ListenerOption {
+	return func(cfg *ListenerConfig) {
+		cfg.RSAKey = key
+	}
+}
+
+// WithNoDelay sets TCP_NODELAY on the connections, which is the
+// default. See ListenerConfig.DisableNoDelay.
+func WithNoDelay(noDelay bool) ListenerOption {
+	return func(cfg *ListenerConfig) {
+		cfg.DisableNoDelay = !noDelay
+	}
+}
+
+// WithSlowQueryThreshold counts the queries taking longer than
+// threshold in the Slow_queries status variable. 0 disables it.
+func WithSlowQueryThreshold(threshold time.Duration) ListenerOption {
+	return func(cfg *ListenerConfig) {
+		cfg.SlowQueryThreshold = threshold
+	}
+}
+
+// WithSlowConnectWarnThreshold logs a warning for the connections
+// taking longer than threshold to be established. 0 disables it.
+func WithSlowConnectWarnThreshold(threshold time.Duration) ListenerOption {
+	return func(cfg *ListenerConfig) {
+		cfg.SlowConnectWarnThreshold = threshold
+	}
+}
+
+// Validate checks the configuration is complete and consistent. It
+// returns all the problems found, aggregated in one error.
+func (cfg *ListenerConfig) Validate() error {
+	var errs []error
+	invalid := func(format string, args ...interface{}) {
+		errs = append(errs, vterrors.Errorf(vtrpc.Code_INVALID_ARGUMENT, format, args...))
+	}
+
+	if cfg.Listener != nil && (cfg.Protocol != "" || cfg.Address != "") {
+		invalid("invalid listener config: Listener and Protocol/Address are mutually exclusive")
+	}
+	if cfg.Listener == nil && cfg.Protocol == "" {
+		invalid("invalid listener config: one of Listener or Protocol/Address is required")
+	}
+	if cfg.AuthServer == nil {
+		invalid("invalid listener config: AuthServer is required")
+	}
+	if cfg.Handler == nil {
+		invalid("invalid listener config: Handler is required")
+	}
+	if cfg.ConnReadTimeout < 0 || cfg.ConnWriteTimeout < 0 {
+		invalid("invalid listener config: negative timeouts %v/%v", cfg.ConnReadTimeout, cfg.ConnWriteTimeout)
+	}
+	if cfg.SlowConnectWarnThreshold < 0 {
+		invalid("invalid listener config: negative SlowConnectWarnThreshold %v", cfg.SlowConnectWarnThreshold)
+	}
+	if cfg.RequireSecureTransport && cfg.TLSConfig == nil {
+		invalid("invalid listener config: RequireSecureTransport needs a TLSConfig")
+	}
+	if cfg.RequireSecureTransport && cfg.AllowClearTextWithoutTLS {
+		invalid("invalid listener config: AllowClearTextWithoutTLS conflicts with RequireSecureTransport")
+	}
+	if cfg.ZstdCompressionLevel < 0 || cfg.ZstdCompressionLevel > maxZstdCompressionLevel {
+		invalid("invalid listener config: ZstdCompressionLevel %v is not between 1 and %v", cfg.ZstdCompressionLevel, maxZstdCompressionLevel)
+	}
+	switch cfg.DefaultAuthMethod {
+	case "", MysqlNativePassword:
+	case CachingSha2Password:
+		if _, ok := cfg.AuthServer.(CachingSha2AuthServer); cfg.AuthServer != nil && !ok {
+			invalid("invalid listener config: DefaultAuthMethod %v needs a CachingSha2AuthServer", cfg.DefaultAuthMethod)
+		}
+	default:
+		invalid("invalid listener config: unsupported DefaultAuthMethod %q", cfg.DefaultAuthMethod)
+	}
+	for i, rule := range cfg.ClientQuirks {
+		if rule.Quirks == 0 {
+			invalid("invalid listener config: client quirk rule %d (%q) has no quirks", i, rule.Name)
+		}
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+	return vterrors.Aggregate(errs)
+}
+
+// freeze copies the deprecated exported parameters to the
+// configuration, and prevents later changes. It is called by Accept.
+func (l *Listener) freeze() {
+	if l.started.Get() {
+		return
+	}
+	l.cfg.ServerVersion = l.ServerVersion
+	l.cfg.TLSConfig = l.TLSConfig
+	l.cfg.ClientQuirks = l.ClientQuirks
+	l.cfg.AllowCompression = l.AllowCompression
+	l.cfg.RequireSecureTransport = l.RequireSecureTransport
+	l.started.Set(true)
+}
+
+// Config returns a snapshot of the configuration of the listener, for
+// diagnostics. Before Accept is called, it includes the changes made
+// to the deprecated exported parameters.
+func (l *Listener) Config() ListenerConfig {
+	cfg := l.cfg
+	if !l.started.Get() {
+		cfg.ServerVersion = l.ServerVersion
+		cfg.TLSConfig = l.TLSConfig
+		cfg.ClientQuirks = l.ClientQuirks
+		cfg.AllowCompression = l.AllowCompression
+		cfg.RequireSecureTransport = l.RequireSecureTransport
+	}
+	cfg.AllowClearTextWithoutTLS = l.AllowClearTextWithoutTLS.Get()
+	cfg.SlowConnectWarnThreshold = l.SlowConnectWarnThreshold.Get()
+	cfg.ClientQuirks = append([]ClientQuirkRule(nil), cfg.ClientQuirks...)
+	return cfg
+}
@@ -0,0 +1,176 @@
+/*
+Copyright 2019 The Vitess Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mysql
+
+import (
+	"crypto/tls"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestListenerConfigValidate(t *testing.T) {
+	authServer := NewAuthServerStatic("", "", 0)
+	th := &testHandler{}
+	valid := func() ListenerConfig {
+		return ListenerConfig{
+			Protocol:   "tcp",
+			Address:    ":0",
+			AuthServer: authServer,
+			Handler:    th,
+		}
+	}
+
+	for _, tcase := range []struct {
+		name   string
+		change func(*ListenerConfig)
+		want   []string
+	}{{
+		name:   "valid",
+		change: func(cfg *ListenerConfig) {},
+	}, {
+		name: "TLS required",
+		change: func(cfg *ListenerConfig) {
+			cfg.TLSConfig = &tls.Config{}
+			cfg.RequireSecureTransport = true
+		},
+	}, {
+		name: "no address",
+		change: func(cfg *ListenerConfig) {
+			cfg.Protocol = ""
+			cfg.Address = ""
+		},
+		want: []string{"one of Listener or Protocol/Address is required"},
+	}, {
+		name: "missing parameters",
+		change: func(cfg *ListenerConfig) {
+			cfg.AuthServer = nil
+			cfg.Handler = nil
+		},
+		want: []string{"AuthServer is required", "Handler is required"},
+	}, {
+		name: "negative timeouts",
+		change: func(cfg *ListenerConfig) {
+			cfg.ConnReadTimeout = -time.Second
+			cfg.SlowConnectWarnThreshold = -time.Second
+		},
+		want: []string{"negative timeouts -1s/0s", "negative SlowConnectWarnThreshold -1s"},
+	}, {
+		name: "secure transport without TLS",
+		change: func(cfg *ListenerConfig) {
+			cfg.RequireSecureTransport = true
+			cfg.AllowClearTextWithoutTLS = true
+		},
+		want: []string{"RequireSecureTransport needs a TLSConfig", "AllowClearTextWithoutTLS conflicts with RequireSecureTransport"},
+	}, {
+		name: "empty quirk rule",
+		change: func(cfg *ListenerConfig) {
+			cfg.ClientQuirks = []ClientQuirkRule{{Name: "connector", Quirks: ClientQuirkClassicEOF}, {Name: "nothing"}}
+		},
+		want: []string{`client quirk rule 1 ("nothing") has no quirks`},
+	}, {
+		name: "zstd compression level",
+		change: func(cfg *ListenerConfig) {
+			cfg.ZstdCompressionLevel = 23
+		},
+		want: []string{"ZstdCompressionLevel 23 is not between 1 and 22"},
+	}, {
+		name: "unsupported auth method",
+		change: func(cfg *ListenerConfig) {
+			cfg.DefaultAuthMethod = "foo"
+		},
+		want: []string{`unsupported DefaultAuthMethod "foo"`},
+	}, {
+		name: "caching_sha2_password without support",
+		change: func(cfg *ListenerConfig) {
+			cfg.AuthServer = &AuthServerNone{}
+			cfg.DefaultAuthMethod = CachingSha2Password
+		},
+		want: []string{"DefaultAuthMethod caching_sha2_password needs a CachingSha2AuthServer"},
+	}} {
+		t.Run(tcase.name, func(t *testing.T) {
+			cfg := valid()
+			tcase.change(&cfg)
+			err := cfg.Validate()
+			if len(tcase.want) == 0 {
+				if err != nil {
+					t.Errorf("Validate() failed: %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("Validate() succeeded, want errors %v", tcase.want)
+			}
+			for _, want := range tcase.want {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("Validate() returned %v, want it to contain %q", err, want)
+				}
+			}
+			if _, err := NewListenerWithConfig(cfg); err == nil {
+				t.Errorf("NewListenerWithConfig() succeeded with an invalid config")
+			}
+		})
+	}
+}
+
+func TestNewListenerWithOptions(t *testing.T) {
+	authServer := NewAuthServerStatic("", "", 0)
+	th := &testHandler{}
+	tlsConfig := &tls.Config{}
+	l, err := NewListenerWithOptions(
+		WithAddress("tcp", ":0"),
+		WithAuthServer(authServer),
+		WithHandler(th),
+		WithTimeouts(time.Second, 2*time.Second),
+		WithMaxConns(10),
+		WithTLS(tlsConfig, true),
+		WithCompression(true),
+		WithNoDelay(false),
+		WithClientQuirks(ClientQuirkRule{Name: "connector", Quirks: ClientQuirkClassicEOF}),
+	)
+	if err != nil {
+		t.Fatalf("NewListenerWithOptions failed: %v", err)
+	}
+	defer l.Close()
+
+	cfg := l.Config()
+	if cfg.AuthServer != authServer || cfg.Handler != th || cfg.ConnReadTimeout != time.Second || cfg.ConnWriteTimeout != 2*time.Second ||
+		cfg.ConnReadBufferSize != DefaultConnBufferSize || cfg.MaxConns != 10 || cfg.TLSConfig != tlsConfig || !cfg.RequireSecureTransport ||
+		!cfg.AllowCompression || !cfg.DisableNoDelay || len(cfg.ClientQuirks) != 1 || cfg.ServerVersion != DefaultServerVersion {
+		t.Errorf("got config %+v", cfg)
+	}
+
+	// The deprecated fields are used until Accept, then the config is
+	// frozen.
+	l.ServerVersion = "8.0.0-embedded"
+	if got := l.Config().ServerVersion; got != "8.0.0-embedded" {
+		t.Errorf("got server version %q before Accept, want 8.0.0-embedded", got)
+	}
+	l.freeze()
+	l.ServerVersion = "5.7.0"
+	l.AllowCompression = false
+	cfg = l.Config()
+	if cfg.ServerVersion != "8.0.0-embedded" || !cfg.AllowCompression {
+		t.Errorf("config changed after Accept: %+v", cfg)
+	}
+
+	// The parameters that can change at any time are reported.
+	l.AllowClearTextWithoutTLS.Set(true)
+	if !l.Config().AllowClearTextWithoutTLS {
+		t.Errorf("AllowClearTextWithoutTLS is not reported")
+	}
+}
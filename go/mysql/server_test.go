@@ -25,6 +25,7 @@ import (
 	"os"
 	"os/exec"
 	"path"
+	"reflect"
 	"strings"
 	"sync"
 	"testing"
@@ -32,6 +33,7 @@ import (
 
 	"github.com/dolthub/vitess/go/sqltypes"
 	vtenv "github.com/dolthub/vitess/go/vt/env"
+	"github.com/dolthub/vitess/go/vt/sqlparser"
 	"github.com/dolthub/vitess/go/vt/tlstest"
 	"github.com/dolthub/vitess/go/vt/vterrors"
 	"github.com/dolthub/vitess/go/vt/vttls"
@@ -65,11 +67,12 @@ var selectRowsResult = &sqltypes.Result{
 }
 
 type testHandler struct {
-	mu       sync.Mutex
-	lastConn *Conn
-	result   *sqltypes.Result
-	err      error
-	warnings uint16
+	mu                  sync.Mutex
+	lastConn            *Conn
+	result              *sqltypes.Result
+	err                 error
+	warnings            uint16
+	sessionStateChanges *sqltypes.SessionStateChanges
 }
 
 func (th *testHandler) LastConn() *Conn {
@@ -115,12 +118,16 @@ func (th *testHandler) ComInitDB(c *Conn, schemaName string) error {
 	return nil
 }
 
-func (th *testHandler) ComMultiQuery(c *Conn, query string, callback func(res *sqltypes.Result, more bool) error) (string, error) {
-	err := th.ComQuery(c, query, callback)
-	return "", err
+func (th *testHandler) ComMultiQuery(ctx context.Context, c *Conn, query string, callback func(res *sqltypes.Result, more bool) error) (string, error) {
+	query, remainder, err := sqlparser.SplitStatement(query)
+	if err != nil {
+		return "", err
+	}
+	err = th.ComQuery(ctx, c, strings.TrimSpace(query), callback)
+	return strings.TrimSpace(remainder), err
 }
 
-func (th *testHandler) ComQuery(c *Conn, query string, callback func(res *sqltypes.Result, more bool) error) error {
+func (th *testHandler) ComQuery(ctx context.Context, c *Conn, query string, callback func(res *sqltypes.Result, more bool) error) error {
 	if result := th.Result(); result != nil {
 		callback(th.result, false)
 		return nil
@@ -173,6 +180,17 @@ func (th *testHandler) ComQuery(c *Conn, query string, callback func(res *sqltyp
 				},
 			},
 		}, false)
+	case "slow progress":
+		for i := 1; i <= 3; i++ {
+			time.Sleep(10 * time.Millisecond)
+			if err := c.Progress(float64(25*i), fmt.Sprintf("step %v", i)); err != nil {
+				return err
+			}
+		}
+		callback(selectRowsResult, false)
+		if err := c.Progress(100, "done"); err == nil {
+			return fmt.Errorf("Progress after the result should have failed")
+		}
 	case "userData echo":
 		callback(&sqltypes.Result{
 			Fields: []*querypb.Field{
@@ -214,11 +232,11 @@ func (th *testHandler) ComQuery(c *Conn, query string, callback func(res *sqltyp
 	return nil
 }
 
-func (th *testHandler) ComPrepare(c *Conn, query string) ([]*querypb.Field, error) {
+func (th *testHandler) ComPrepare(ctx context.Context, c *Conn, query string) ([]*querypb.Field, error) {
 	return nil, nil
 }
 
-func (th *testHandler) ComStmtExecute(c *Conn, prepare *PrepareData, callback func(*sqltypes.Result) error) error {
+func (th *testHandler) ComStmtExecute(ctx context.Context, c *Conn, prepare *PrepareData, callback func(*sqltypes.Result) error) error {
 	switch prepare.PrepareStmt {
 	case "empty result":
 		res := &sqltypes.Result{
@@ -304,6 +322,12 @@ func (th *testHandler) WarningCount(c *Conn) uint16 {
 	return th.warnings
 }
 
+func (th *testHandler) SessionStateChanges(c *Conn) *sqltypes.SessionStateChanges {
+	th.mu.Lock()
+	defer th.mu.Unlock()
+	return th.sessionStateChanges
+}
+
 func getHostPort(t *testing.T, a net.Addr) (string, int) {
 	// For the host name, we resolve 'localhost' into an address.
 	// This works around a few travis issues where IPv6 is not 100% enabled.
@@ -356,6 +380,66 @@ func TestConnectionFromListener(t *testing.T) {
 	c.Close()
 }
 
+func TestListenerCommandCounts(t *testing.T) {
+	th := &testHandler{}
+
+	authServer := NewAuthServerStatic("", "", 0)
+	authServer.entries["user1"] = []*AuthServerStaticEntry{{
+		Password: "password1",
+		UserData: "userData1",
+	}}
+	l, err := NewListener("tcp", ":0", authServer, th, 0, 0)
+	if err != nil {
+		t.Fatalf("NewListener failed: %v", err)
+	}
+	defer l.Close()
+	go l.Accept()
+
+	host, port := getHostPort(t, l.Addr())
+	params := &ConnParams{
+		Host:  host,
+		Port:  port,
+		Uname: "user1",
+		Pass:  "password1",
+	}
+	c, err := Connect(context.Background(), params)
+	if err != nil {
+		t.Fatalf("Connect failed: %v", err)
+	}
+	defer c.Close()
+
+	for i := 0; i < 3; i++ {
+		if _, err := c.ExecuteFetch("select rows", 10, false); err != nil {
+			t.Fatalf("ExecuteFetch failed: %v", err)
+		}
+	}
+	for i := 0; i < 2; i++ {
+		if err := c.Ping(); err != nil {
+			t.Fatalf("Ping failed: %v", err)
+		}
+	}
+	c.sequence = 0
+	if err := c.writeComInitDB("db1"); err != nil {
+		t.Fatalf("writeComInitDB failed: %v", err)
+	}
+	if _, err := c.readEphemeralPacket(); err != nil {
+		t.Fatalf("reading ComInitDB response failed: %v", err)
+	}
+	c.recycleReadPacket()
+
+	want := map[string]int64{
+		"COM_QUERY":   3,
+		"COM_PING":    2,
+		"COM_INIT_DB": 1,
+	}
+	if got := l.CommandCounts(); !reflect.DeepEqual(got, want) {
+		t.Errorf("CommandCounts() = %v, want %v", got, want)
+	}
+	if got := commandCount.Counts()["COM_QUERY"]; got < 3 {
+		t.Errorf("MysqlServerCommandCount[COM_QUERY] = %v, want at least 3", got)
+	}
+}
+
 func TestConnectionWithoutSourceHost(t *testing.T) {
 	th := &testHandler{}
 
@@ -1460,3 +1544,42 @@ func TestParseConnAttrs(t *testing.T) {
 		}
 	}
 }
+
+func TestParseClientHandshakePacketZstdBadConnAttrs(t *testing.T) {
+	l, err := NewListenerWithOptions(
+		WithAddress("tcp", "127.0.0.1:"),
+		WithAuthServer(NewAuthServerStatic("", "", 0)),
+		WithHandler(&testHandler{}),
+		WithZstdCompression(true),
+	)
+	if err != nil {
+		t.Fatalf("NewListener failed: %v", err)
+	}
+	defer l.Close()
+
+	handshake := func(flags uint32) []byte {
+		data := make([]byte, 0, 64)
+		data = append(data, byte(flags), byte(flags>>8), byte(flags>>16), byte(flags>>24))
+		data = append(data, 0, 0, 0, 1)          // max packet size
+		data = append(data, CharacterSetUtf8)    // character set
+		data = append(data, make([]byte, 23)...) // reserved
+		data = append(data, "user1\x00"...)      // username
+		data = append(data, 0)                   // empty auth response
+		// Connection attributes announcing 16 bytes, with only 2.
+		data = append(data, 16, 1, 'a')
+		return data
+	}
+	flags := uint32(CapabilityClientProtocol41 | CapabilityClientSecureConnection | CapabilityClientConnAttr)
+
+	// Without zstd, the connection attributes are ignored.
+	if _, _, _, err := l.parseClientHandshakePacket(&Conn{}, true, handshake(flags)); err != nil {
+		t.Errorf("parseClientHandshakePacket without zstd failed: %v", err)
+	}
+
+	// With zstd, the compression level that follows can't be read.
+	c := &Conn{}
+	_, _, _, err = l.parseClientHandshakePacket(c, true, handshake(flags|CapabilityClientZstdCompressionAlgorithm))
+	if err == nil || !strings.Contains(err.Error(), "zstd compression level") {
+		t.Errorf("parseClientHandshakePacket with zstd: %v, want a zstd compression level error", err)
+	}
+}
@@ -0,0 +1,203 @@
+/*
+Copyright 2019 The Vitess Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mysql
+
+import (
+	"sort"
+
+	"github.com/dolthub/vitess/go/sqltypes"
+)
+
+// This file contains the session state changes of OK packets, sent to
+// the clients that negotiated CapabilityClientSessionTrack when
+// ServerSessionStateChanged is set. They follow the info string, as a
+// length encoded string of entries: each has a type byte, and its data
+// as a length encoded string.
+
+// sessionStateEntry is an entry of the session state changes of an OK
+// packet.
+type sessionStateEntry struct {
+	typ  uint8
+	data []byte
+}
+
+// sessionStateEntries returns the entries of changes, the system
+// variables sorted by name.
+func sessionStateEntries(changes *sqltypes.SessionStateChanges) []sessionStateEntry {
+	var entries []sessionStateEntry
+	names := make([]string, 0, len(changes.SystemVariables))
+	for name := range changes.SystemVariables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		entries = append(entries, sessionStateEntry{
+			typ:  SessionTrackSystemVariables,
+			data: lenEncStrings(name, changes.SystemVariables[name]),
+		})
+	}
+	if changes.SchemaChanged {
+		entries = append(entries, sessionStateEntry{
+			typ:  SessionTrackSchema,
+			data: lenEncStrings(changes.Schema),
+		})
+	}
+	if changes.StateChanged {
+		entries = append(entries, sessionStateEntry{
+			typ:  SessionTrackStateChange,
+			data: lenEncStrings("1"),
+		})
+	}
+	if changes.GTIDs != "" {
+		// The GTIDs are preceded by their encoding specification,
+		// always 0.
+		entries = append(entries, sessionStateEntry{
+			typ:  SessionTrackGtids,
+			data: append([]byte{0}, lenEncStrings(changes.GTIDs)...),
+		})
+	}
+	return entries
+}
+
+// lenEncStrings returns values as consecutive length encoded strings.
+func lenEncStrings(values ...string) []byte {
+	length := 0
+	for _, value := range values {
+		length += lenEncStringSize(value)
+	}
+	data := make([]byte, length)
+	pos := 0
+	for _, value := range values {
+		pos = writeLenEncString(data, pos, value)
+	}
+	return data
+}
+
+// writeOKPacketWithSessionState writes an OK packet with the info
+// string and the session state changes. It must only be used if
+// CapabilityClientSessionTrack was negotiated.
+// Server -> Client.
+// This method returns a generic error, not a SQLError.
+func (c *Conn) writeOKPacketWithSessionState(affectedRows, lastInsertID uint64, flags uint16, warnings uint16, info string, changes *sqltypes.SessionStateChanges) error {
+	entries := sessionStateEntries(changes)
+	stateLength := 0
+	for _, entry := range entries {
+		stateLength += 1 + // entry type
+			lenEncIntSize(uint64(len(entry.data))) +
+			len(entry.data)
+	}
+	length := 1 + // OKPacket
+		lenEncIntSize(affectedRows) +
+		lenEncIntSize(lastInsertID) +
+		2 + // flags
+		2 + // warnings
+		lenEncStringSize(info) +
+		lenEncIntSize(uint64(stateLength)) +
+		stateLength
+	data := c.startEphemeralPacket(length)
+	pos := 0
+	pos = writeByte(data, pos, OKPacket)
+	pos = writeLenEncInt(data, pos, affectedRows)
+	pos = writeLenEncInt(data, pos, lastInsertID)
+	pos = writeUint16(data, pos, flags|ServerSessionStateChanged)
+	pos = writeUint16(data, pos, warnings)
+	pos = writeLenEncString(data, pos, info)
+	pos = writeLenEncInt(data, pos, uint64(stateLength))
+	for _, entry := range entries {
+		pos = writeByte(data, pos, entry.typ)
+		pos = writeLenEncInt(data, pos, uint64(len(entry.data)))
+		pos += copy(data[pos:], entry.data)
+	}
+
+	return c.writeEphemeralPacket()
+}
+
+// parseOKSessionState returns the session state changes of an OK
+// packet, or nil if ServerSessionStateChanged is not set. It must only
+// be used if CapabilityClientSessionTrack was negotiated. The entries
+// of unknown types are skipped.
+func parseOKSessionState(data []byte) (*sqltypes.SessionStateChanges, error) {
+	// Skip the type, affected rows and last insert id.
+	pos := 1
+	var ok bool
+	for i := 0; i < 2; i++ {
+		if _, pos, ok = readLenEncInt(data, pos); !ok {
+			return nil, NewSQLError(CRMalformedPacket, SSUnknownSQLState, "invalid OK packet: %v", data)
+		}
+	}
+	flags, pos, ok := readUint16(data, pos)
+	if !ok {
+		return nil, NewSQLError(CRMalformedPacket, SSUnknownSQLState, "invalid OK packet statusFlags: %v", data)
+	}
+	if flags&ServerSessionStateChanged == 0 {
+		return nil, nil
+	}
+	// Skip warnings and info.
+	pos += 2
+	if pos, ok = skipLenEncString(data, pos); !ok {
+		return nil, NewSQLError(CRMalformedPacket, SSUnknownSQLState, "invalid OK packet info: %v", data)
+	}
+	state, _, ok := readLenEncStringAsBytes(data, pos)
+	if !ok {
+		return nil, NewSQLError(CRMalformedPacket, SSUnknownSQLState, "invalid OK packet session state: %v", data)
+	}
+
+	changes := &sqltypes.SessionStateChanges{}
+	for pos = 0; pos < len(state); {
+		var typ byte
+		var entry []byte
+		if typ, pos, ok = readByte(state, pos); !ok {
+			return nil, NewSQLError(CRMalformedPacket, SSUnknownSQLState, "invalid session state entry type: %v", state)
+		}
+		if entry, pos, ok = readLenEncStringAsBytes(state, pos); !ok {
+			return nil, NewSQLError(CRMalformedPacket, SSUnknownSQLState, "invalid session state entry of type %v: %v", typ, state)
+		}
+
+		switch typ {
+		case SessionTrackSystemVariables:
+			name, entryPos, ok := readLenEncString(entry, 0)
+			if !ok {
+				return nil, NewSQLError(CRMalformedPacket, SSUnknownSQLState, "invalid system variable name in session state: %v", entry)
+			}
+			value, _, ok := readLenEncString(entry, entryPos)
+			if !ok {
+				return nil, NewSQLError(CRMalformedPacket, SSUnknownSQLState, "invalid value of system variable %v in session state: %v", name, entry)
+			}
+			if changes.SystemVariables == nil {
+				changes.SystemVariables = make(map[string]string)
+			}
+			changes.SystemVariables[name] = value
+		case SessionTrackSchema:
+			schema, _, ok := readLenEncString(entry, 0)
+			if !ok {
+				return nil, NewSQLError(CRMalformedPacket, SSUnknownSQLState, "invalid schema in session state: %v", entry)
+			}
+			changes.SchemaChanged = true
+			changes.Schema = schema
+		case SessionTrackStateChange:
+			changes.StateChanged = true
+		case SessionTrackGtids:
+			// Skip the encoding specification.
+			gtids, _, ok := readLenEncString(entry, 1)
+			if !ok {
+				return nil, NewSQLError(CRMalformedPacket, SSUnknownSQLState, "invalid GTIDs in session state: %v", entry)
+			}
+			changes.GTIDs = gtids
+		}
+	}
+	return changes, nil
+}
@@ -0,0 +1,120 @@
+/*
+Copyright 2019 The Vitess Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mysql
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dolthub/vitess/go/sqltypes"
+)
+
+func TestSessionStateChangesPacket(t *testing.T) {
+	listener, sConn, cConn := createSocketPair(t)
+	defer func() {
+		listener.Close()
+		sConn.Close()
+		cConn.Close()
+	}()
+	sConn.Capabilities |= CapabilityClientSessionTrack
+	cConn.Capabilities |= CapabilityClientSessionTrack
+
+	for _, changes := range []*sqltypes.SessionStateChanges{{
+		SystemVariables: map[string]string{"autocommit": "OFF", "character_set_client": "latin1"},
+		SchemaChanged:   true,
+		Schema:          "db1",
+		StateChanged:    true,
+		GTIDs:           "3e11fa47-71ca-11e1-9e33-c80aa9429562:1-5",
+	}, {
+		SystemVariables: map[string]string{"sql_mode": ""},
+	}, {
+		// No schema anymore, after the default one was dropped.
+		SchemaChanged: true,
+	}} {
+		require.NoError(t, sConn.writeOKPacketWithSessionState(3, 4, sConn.StatusFlags, 0, "Rows matched: 3", changes))
+		result, _, _, err := cConn.ReadQueryResult(10, false)
+		require.NoError(t, err)
+		assert.EqualValues(t, 3, result.RowsAffected)
+		assert.EqualValues(t, 4, result.InsertID)
+		assert.Equal(t, changes, result.SessionStateChanges)
+		sConn.sequence = 0
+		cConn.sequence = 0
+	}
+
+	// Without ServerSessionStateChanged, there are no changes.
+	require.NoError(t, sConn.writeOKPacketWithInfo(1, 0, sConn.StatusFlags, 0, "info"))
+	result, _, _, err := cConn.ReadQueryResult(10, false)
+	require.NoError(t, err)
+	assert.Nil(t, result.SessionStateChanges)
+}
+
+func TestParseOKSessionStateErrors(t *testing.T) {
+	header := []byte{
+		OKPacket,
+		0,          // affected rows
+		0,          // last insert id
+		0x00, 0x40, // flags: ServerSessionStateChanged
+		0, 0, // warnings
+		0, // info
+	}
+	for _, tcase := range []struct {
+		state []byte
+		err   string
+	}{{
+		state: []byte{9, SessionTrackSchema, 3, 2, 'd', 'b'},
+		err:   "invalid OK packet session state",
+	}, {
+		state: []byte{3, SessionTrackSchema, 3, 2},
+		err:   "invalid session state entry of type 1",
+	}, {
+		state: []byte{4, SessionTrackSystemVariables, 2, 1, 'a'},
+		err:   "invalid value of system variable a",
+	}, {
+		state: []byte{3, SessionTrackGtids, 1, 0},
+		err:   "invalid GTIDs in session state",
+	}} {
+		_, err := parseOKSessionState(append(header, tcase.state...))
+		assertSQLError(t, err, CRMalformedPacket, SSUnknownSQLState, tcase.err, "")
+	}
+
+	// Unknown entries are skipped.
+	changes, err := parseOKSessionState(append(header, 8, 0x05, 2, 1, 'T', SessionTrackStateChange, 2, 1, '1'))
+	require.NoError(t, err)
+	assert.Equal(t, &sqltypes.SessionStateChanges{StateChanged: true}, changes)
+}
+
+func TestSessionStateChangesHandler(t *testing.T) {
+	changes := &sqltypes.SessionStateChanges{
+		SystemVariables: map[string]string{"time_zone": "+00:00"},
+		SchemaChanged:   true,
+		Schema:          "db2",
+	}
+	handler := &testHandler{sessionStateChanges: changes}
+	conn := connectResultPending(t, handler, ConnParams{}, WithSessionTracking(true))
+	require.NotZero(t, conn.Capabilities&CapabilityClientSessionTrack)
+
+	// The statements without a result set report the changes.
+	result, err := conn.ExecuteFetch("insert", 10, false)
+	require.NoError(t, err)
+	assert.EqualValues(t, 123, result.RowsAffected)
+	assert.Equal(t, changes, result.SessionStateChanges)
+	result, err = conn.ExecuteFetch("select rows", 10, false)
+	require.NoError(t, err)
+	assert.Nil(t, result.SessionStateChanges)
+}
@@ -0,0 +1,125 @@
+/*
+Copyright 2019 The Vitess Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mysql
+
+import "fmt"
+
+// sqlErrorDef is the SQLSTATE and the message template MySQL uses for
+// an error number.
+type sqlErrorDef struct {
+	state  string
+	format string
+}
+
+// sqlErrorDefs are the definitions of the common MySQL errors, as found
+// in share/messages_to_clients.txt, and of the Vitess specific ones.
+// The templates take the same arguments as MySQL's, in the same order.
+var sqlErrorDefs = map[int]sqlErrorDef{
+	ERDbCreateExists:                {SSUnknownSQLState, "Can't create database '%s'; database exists"},
+	ERDbDropExists:                  {SSUnknownSQLState, "Can't drop database '%s'; database doesn't exist"},
+	ERKeyNotFound:                   {SSUnknownSQLState, "Can't find record in '%s'"},
+	ERConCount:                      {"08004", "Too many connections"},
+	ERDBAccessDenied:                {SSClientError, "Access denied for user '%s'@'%s' to database '%s'"},
+	ERAccessDeniedError:             {SSAccessDeniedError, "Access denied for user '%s'@'%s' (using password: %s)"},
+	ERNoDb:                          {SSNoDB, "No database selected"},
+	ERUnknownComError:               {SSUnknownComError, "Unknown command"},
+	ERBadNullError:                  {SSConstraintViolation, "Column '%s' cannot be null"},
+	ERBadDb:                         {SSClientError, "Unknown database '%s'"},
+	ERTableExists:                   {"42S01", "Table '%s' already exists"},
+	ERBadTable:                      {SSUnknownTable, "Unknown table '%s'"},
+	ERNonUniq:                       {SSConstraintViolation, "Column '%s' in %s is ambiguous"},
+	ERServerShutdown:                {SSServerShutdown, "Server shutdown in progress"},
+	ERBadFieldError:                 {SSBadFieldError, "Unknown column '%s' in '%s'"},
+	ERDupFieldName:                  {SSDupFieldName, "Duplicate column name '%s'"},
+	ERDupKeyName:                    {SSClientError, "Duplicate key name '%s'"},
+	ERDupEntry:                      {SSDupKey, "Duplicate entry '%s' for key '%s'"},
+	ERParseError:                    {SSClientError, "%s near '%s' at line %d"},
+	EREmptyQuery:                    {SSClientError, "Query was empty"},
+	ERNonUniqTable:                  {SSClientError, "Not unique table/alias: '%s'"},
+	ERUnknownTable:                  {SSUnknownTable, "Unknown table '%s' in %s"},
+	ERUnknownCharacterSet:           {SSClientError, "Unknown character set: '%s'"},
+	ERWrongValueCountOnRow:          {SSWrongValueCountOnRow, "Column count doesn't match value count at row %d"},
+	ERNoSuchTable:                   {SSUnknownTable, "Table '%s.%s' doesn't exist"},
+	ERSyntaxError:                   {SSClientError, "You have an error in your SQL syntax; check the manual that corresponds to your MySQL server version for the right syntax to use"},
+	ERNetPacketTooLarge:             {SSNetError, "Got a packet bigger than 'max_allowed_packet' bytes"},
+	ERCantDoThisDuringAnTransaction: {SSCantDoThisDuringAnTransaction, "You are not allowed to execute this command in a transaction"},
+	ERUnknownSystemVariable:         {SSUnknownSQLState, "Unknown system variable '%s'"},
+	ERLockWaitTimeout:               {SSUnknownSQLState, "Lock wait timeout exceeded; try restarting transaction"},
+	ERLockDeadlock:                  {SSLockDeadlock, "Deadlock found when trying to get lock; try restarting transaction"},
+	ERWrongValueForVar:              {SSClientError, "Variable '%s' can't be set to the value of '%s'"},
+	ERNotSupportedYet:               {SSClientError, "This version of MySQL doesn't yet support '%s'"},
+	EROperandColumns:                {SSWrongNumberOfColumns, "Operand should contain %d column(s)"},
+	ERSubqueryNo1Row:                {SSWrongNumberOfColumns, "Subquery returns more than 1 row"},
+	ERUnknownStmtHandler:            {SSUnknownSQLState, "Unknown prepared statement handler (%s) given to %s"},
+	ERWarnDataOutOfRange:            {SSDataOutOfRange, "Out of range value for column '%s' at row %d"},
+	ERUnknownCollation:              {SSUnknownSQLState, "Unknown collation: '%s'"},
+	EROptionPreventsStatement:       {SSUnknownSQLState, "The MySQL server is running with the %s option so it cannot execute this statement"},
+	ERSPDoesNotExist:                {SSClientError, "%s %s does not exist"},
+	ERQueryInterrupted:              {SSQueryInterrupted, "Query execution was interrupted"},
+	ERTruncatedWrongValueForField:   {SSUnknownSQLState, "Incorrect %s value: '%s' for column '%s' at row %d"},
+	ERDataTooLong:                   {SSDataTooLong, "Data too long for column '%s' at row %d"},
+	ERRowIsReferenced2:              {SSConstraintViolation, "Cannot delete or update a parent row: a foreign key constraint fails (%s)"},
+	ErNoReferencedRow2:              {SSConstraintViolation, "Cannot add or update a child row: a foreign key constraint fails (%s)"},
+	ERQueryTimeout:                  {SSUnknownSQLState, "Query execution was interrupted, maximum statement execution time exceeded"},
+	ERMalformedPacket:               {SSUnknownSQLState, "Malformed communication packet"},
+
+	ERVitessMaxRowsExceeded:      {SSUnknownSQLState, "Row count exceeded %d"},
+	ERVitessResultTooManyColumns: {SSUnknownSQLState, "result has %v columns, more than the limit of %v"},
+	ERVitessResultTooManyRows:    {SSUnknownSQLState, "result has more than %v rows"},
+	ERVitessResultTooLarge:       {SSUnknownSQLState, "result is larger than %v bytes"},
+	ERVitessResultTooManyPackets: {SSUnknownSQLState, "result has more than %v packets"},
+}
+
+// NewSQLErrorFromCode creates a new SQLError for a MySQL error number,
+// with the SQLSTATE and the message MySQL uses for it. The message
+// template is filled in with args.
+// Unknown error numbers get "HY000" (general error), and a message
+// made of the args, or naming the error number if there are none.
+func NewSQLErrorFromCode(code int, args ...interface{}) *SQLError {
+	def, ok := sqlErrorDefs[code]
+	if !ok {
+		if len(args) == 0 {
+			return NewSQLError(code, SSUnknownSQLState, "Unknown error %d", code)
+		}
+		return NewSQLError(code, SSUnknownSQLState, "%s", fmt.Sprint(args...))
+	}
+	return NewSQLError(code, def.state, def.format, args...)
+}
+
+// NewDupEntryError returns the ERDupEntry error for a duplicate entry
+// of the unique key key.
+func NewDupEntryError(entry, key string) *SQLError {
+	return NewSQLErrorFromCode(ERDupEntry, entry, key)
+}
+
+// NewLockWaitTimeoutError returns the ERLockWaitTimeout error, for a
+// lock that could not be acquired in time.
+func NewLockWaitTimeoutError() *SQLError {
+	return NewSQLErrorFromCode(ERLockWaitTimeout)
+}
+
+// NewDeadlockError returns the ERLockDeadlock error, for a transaction
+// rolled back to break a deadlock.
+func NewDeadlockError() *SQLError {
+	return NewSQLErrorFromCode(ERLockDeadlock)
+}
+
+// NewUnknownTableError returns the ERBadTable error, as DROP TABLE
+// returns it, for a table that doesn't exist.
+func NewUnknownTableError(table string) *SQLError {
+	return NewSQLErrorFromCode(ERBadTable, table)
+}
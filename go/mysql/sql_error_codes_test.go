@@ -0,0 +1,123 @@
+/*
+Copyright 2019 The Vitess Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mysql
+
+import (
+	"testing"
+)
+
+func TestNewSQLErrorFromCode(t *testing.T) {
+	testcases := []struct {
+		code    int
+		args    []interface{}
+		state   string
+		message string
+	}{{
+		code:    ERDupEntry,
+		args:    []interface{}{"1", "PRIMARY"},
+		state:   "23000",
+		message: "Duplicate entry '1' for key 'PRIMARY'",
+	}, {
+		code:    ERNoSuchTable,
+		args:    []interface{}{"db", "t"},
+		state:   "42S02",
+		message: "Table 'db.t' doesn't exist",
+	}, {
+		code:    ERBadFieldError,
+		args:    []interface{}{"a", "field list"},
+		state:   "42S22",
+		message: "Unknown column 'a' in 'field list'",
+	}, {
+		code:    ERAccessDeniedError,
+		args:    []interface{}{"user", "localhost", "YES"},
+		state:   "28000",
+		message: "Access denied for user 'user'@'localhost' (using password: YES)",
+	}, {
+		code:    ERNoDb,
+		state:   "3D000",
+		message: "No database selected",
+	}, {
+		code:    ERDataTooLong,
+		args:    []interface{}{"a", 3},
+		state:   "22001",
+		message: "Data too long for column 'a' at row 3",
+	}, {
+		code:    ERLockDeadlock,
+		state:   "40001",
+		message: "Deadlock found when trying to get lock; try restarting transaction",
+	}, {
+		code:    99999,
+		state:   "HY000",
+		message: "Unknown error 99999",
+	}, {
+		code:    99999,
+		args:    []interface{}{"something bad"},
+		state:   "HY000",
+		message: "something bad",
+	}}
+	for _, tcase := range testcases {
+		err := NewSQLErrorFromCode(tcase.code, tcase.args...)
+		if err.Num != tcase.code || err.State != tcase.state || err.Message != tcase.message {
+			t.Errorf("NewSQLErrorFromCode(%v, %v) = %v, %v, %q, want %v, %v, %q", tcase.code, tcase.args, err.Num, err.State, err.Message, tcase.code, tcase.state, tcase.message)
+		}
+	}
+}
+
+func TestSQLErrorDefs(t *testing.T) {
+	for code, def := range sqlErrorDefs {
+		if len(def.state) != 5 {
+			t.Errorf("error %v has an invalid SQLSTATE %q", code, def.state)
+		}
+		if err := NewSQLErrorFromCode(code); err.State != def.state {
+			t.Errorf("NewSQLErrorFromCode(%v).State = %q, want %q", code, err.State, def.state)
+		}
+	}
+}
+
+func TestNamedSQLErrors(t *testing.T) {
+	testcases := []struct {
+		err     *SQLError
+		code    int
+		state   string
+		message string
+	}{{
+		err:     NewDupEntryError("a", "idx"),
+		code:    ERDupEntry,
+		state:   "23000",
+		message: "Duplicate entry 'a' for key 'idx'",
+	}, {
+		err:     NewLockWaitTimeoutError(),
+		code:    ERLockWaitTimeout,
+		state:   "HY000",
+		message: "Lock wait timeout exceeded; try restarting transaction",
+	}, {
+		err:     NewDeadlockError(),
+		code:    ERLockDeadlock,
+		state:   "40001",
+		message: "Deadlock found when trying to get lock; try restarting transaction",
+	}, {
+		err:     NewUnknownTableError("db.t"),
+		code:    ERBadTable,
+		state:   "42S02",
+		message: "Unknown table 'db.t'",
+	}}
+	for _, tcase := range testcases {
+		if tcase.err.Num != tcase.code || tcase.err.State != tcase.state || tcase.err.Message != tcase.message {
+			t.Errorf("got %v, %v, %q, want %v, %v, %q", tcase.err.Num, tcase.err.State, tcase.err.Message, tcase.code, tcase.state, tcase.message)
+		}
+	}
+}
@@ -0,0 +1,169 @@
+/*
+Copyright 2019 The Vitess Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mysql
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dolthub/vitess/go/sqltypes"
+	"github.com/dolthub/vitess/go/sync2"
+	querypb "github.com/dolthub/vitess/go/vt/proto/query"
+)
+
+// This file contains the status variables of the server, which a
+// Handler can answer SHOW [GLOBAL | SESSION] STATUS with, see
+// Listener.Status, Conn.Status and StatusResult.
+
+// The status variables, with their MySQL names.
+const (
+	// StatusAbortedConnects is the number of connections that
+	// failed before the end of the handshake. It is global.
+	StatusAbortedConnects = "Aborted_connects"
+
+	// StatusBytesReceived is the number of bytes received from the
+	// clients.
+	StatusBytesReceived = "Bytes_received"
+
+	// StatusBytesSent is the number of bytes sent to the clients.
+	StatusBytesSent = "Bytes_sent"
+
+	// StatusConnections is the number of connections accepted. It
+	// is global.
+	StatusConnections = "Connections"
+
+	// StatusQuestions is the number of queries and prepared
+	// statement executions the clients sent.
+	StatusQuestions = "Questions"
+
+	// StatusSlowQueries is the number of queries and prepared
+	// statement executions that took longer than
+	// ListenerConfig.SlowQueryThreshold.
+	StatusSlowQueries = "Slow_queries"
+
+	// StatusThreadsConnected is the number of open connections. It
+	// is global.
+	StatusThreadsConnected = "Threads_connected"
+
+	// StatusCommandPrefix is the prefix of the global status
+	// variables counting the commands received, by type: the command
+	// name in lower case follows, as in Vt_com_query.
+	StatusCommandPrefix = "Vt_"
+)
+
+// statusCounters are the counters of the status variables that also
+// have a session value.
+type statusCounters struct {
+	bytesReceived sync2.AtomicInt64
+	bytesSent     sync2.AtomicInt64
+	questions     sync2.AtomicInt64
+	slowQueries   sync2.AtomicInt64
+}
+
+// addTo adds the values of the counters to status.
+func (sc *statusCounters) addTo(status map[string]int64) {
+	status[StatusBytesReceived] = sc.bytesReceived.Get()
+	status[StatusBytesSent] = sc.bytesSent.Get()
+	status[StatusQuestions] = sc.questions.Get()
+	status[StatusSlowQueries] = sc.slowQueries.Get()
+}
+
+// Status returns a snapshot of the global status variables of the
+// listener, indexed by name, for SHOW GLOBAL STATUS.
+func (l *Listener) Status() map[string]int64 {
+	status := make(map[string]int64)
+	l.status.addTo(status)
+	status[StatusAbortedConnects] = l.abortedConnects.Get()
+	status[StatusConnections] = l.connections.Get()
+	l.connsMu.Lock()
+	status[StatusThreadsConnected] = int64(len(l.conns))
+	l.connsMu.Unlock()
+	for name, count := range l.commandCount.Counts() {
+		status[StatusCommandPrefix+strings.ToLower(name)] = count
+	}
+	return status
+}
+
+// Status returns a snapshot of the session status variables of the
+// connection, indexed by name, for SHOW [SESSION] STATUS. As in MySQL,
+// the variables that only have a global value are included, with it.
+func (c *Conn) Status() map[string]int64 {
+	status := make(map[string]int64)
+	if c.listener != nil {
+		status = c.listener.Status()
+	}
+	c.status.addTo(status)
+	return status
+}
+
+// StatusResult returns the status variables as SHOW STATUS returns
+// them: a Variable_name and a Value column, sorted by name.
+func StatusResult(status map[string]int64) *sqltypes.Result {
+	names := make([]string, 0, len(status))
+	for name := range status {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	result := &sqltypes.Result{
+		Fields: []*querypb.Field{
+			{Name: "Variable_name", Type: querypb.Type_VARCHAR},
+			{Name: "Value", Type: querypb.Type_VARCHAR},
+		},
+		Rows: make([][]sqltypes.Value, 0, len(names)),
+	}
+	for _, name := range names {
+		result.Rows = append(result.Rows, []sqltypes.Value{
+			sqltypes.NewVarChar(name),
+			sqltypes.NewVarChar(strconv.FormatInt(status[name], 10)),
+		})
+	}
+	return result
+}
+
+// countQuestion updates the status variables for a query or a
+// prepared statement execution that started at start.
+func (c *Conn) countQuestion(start time.Time) {
+	c.status.questions.Add(1)
+	if c.listener == nil {
+		return
+	}
+	c.listener.status.questions.Add(1)
+	if threshold := c.listener.cfg.SlowQueryThreshold; threshold != 0 && time.Since(start) > threshold {
+		c.status.slowQueries.Add(1)
+		c.listener.status.slowQueries.Add(1)
+	}
+}
+
+// countBytesReceived counts n bytes read from the socket, see
+// connReader.
+func (c *Conn) countBytesReceived(n int) {
+	c.status.bytesReceived.Add(int64(n))
+	if c.listener != nil {
+		c.listener.status.bytesReceived.Add(int64(n))
+	}
+}
+
+// countBytesSent counts n bytes written to the socket, see fullWriter.
+func (c *Conn) countBytesSent(n int) {
+	c.status.bytesSent.Add(int64(n))
+	if c.listener != nil {
+		c.listener.status.bytesSent.Add(int64(n))
+	}
+}
@@ -0,0 +1,167 @@
+/*
+Copyright 2019 The Vitess Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mysql
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dolthub/vitess/go/sqltypes"
+)
+
+// statusHandler is a testHandler answering SHOW [GLOBAL] STATUS with
+// the status variables, and "slow" after 30ms.
+type statusHandler struct {
+	testHandler
+}
+
+func (th *statusHandler) ComMultiQuery(ctx context.Context, c *Conn, query string, callback func(*sqltypes.Result, bool) error) (string, error) {
+	return "", th.ComQuery(ctx, c, strings.TrimSpace(query), callback)
+}
+
+func (th *statusHandler) ComQuery(ctx context.Context, c *Conn, query string, callback func(*sqltypes.Result, bool) error) error {
+	switch query {
+	case "show global status":
+		return callback(StatusResult(c.listener.Status()), false)
+	case "show status":
+		return callback(StatusResult(c.Status()), false)
+	case "slow":
+		time.Sleep(30 * time.Millisecond)
+		return callback(selectRowsResult, false)
+	}
+	return th.testHandler.ComQuery(ctx, c, query, callback)
+}
+
+// showStatus runs query, a SHOW STATUS, and returns the variables.
+func showStatus(t *testing.T, conn *Conn, query string) map[string]int64 {
+	t.Helper()
+	result, err := conn.ExecuteFetch(query, 1000, true)
+	require.NoError(t, err)
+	require.Equal(t, "Variable_name", result.Fields[0].Name)
+	require.Equal(t, "Value", result.Fields[1].Name)
+	status := make(map[string]int64)
+	for _, row := range result.Rows {
+		value, err := strconv.ParseInt(row[1].ToString(), 10, 64)
+		require.NoError(t, err)
+		status[row[0].ToString()] = value
+	}
+	return status
+}
+
+func TestStatus(t *testing.T) {
+	th := &statusHandler{}
+	authServer := NewAuthServerStatic("", "", 0)
+	authServer.entries["user1"] = []*AuthServerStaticEntry{{
+		Password: "password1",
+	}}
+	l, err := NewListenerWithOptions(
+		WithAddress("tcp", ":0"),
+		WithAuthServer(authServer),
+		WithHandler(th),
+		WithSlowQueryThreshold(20*time.Millisecond),
+	)
+	require.NoError(t, err)
+	defer l.Close()
+	go l.Accept()
+
+	host, port := getHostPort(t, l.Addr())
+	connect := func(pass string) (*Conn, error) {
+		return Connect(context.Background(), &ConnParams{
+			Host:  host,
+			Port:  port,
+			Uname: "user1",
+			Pass:  pass,
+		})
+	}
+
+	conn1, err := connect("password1")
+	require.NoError(t, err)
+	defer conn1.Close()
+
+	// Counting the bytes doesn't hide the socket.
+	_, ok := th.LastConn().Conn.(*net.TCPConn)
+	assert.True(t, ok, "got %T, want *net.TCPConn", th.LastConn().Conn)
+	conn2, err := connect("password1")
+	require.NoError(t, err)
+	defer conn2.Close()
+	_, err = connect("bad password")
+	require.Error(t, err)
+
+	// The workload: 3 queries on conn1, one of them slow, and 1 on
+	// conn2.
+	for _, query := range []string{"select rows", "slow", "select rows"} {
+		_, err := conn1.ExecuteFetch(query, 10, false)
+		require.NoError(t, err, query)
+	}
+	_, err = conn2.ExecuteFetch("insert", 10, false)
+	require.NoError(t, err)
+
+	// The failed connection is closed asynchronously.
+	require.Eventually(t, func() bool {
+		status := l.Status()
+		return status[StatusThreadsConnected] == 2 && status[StatusAbortedConnects] == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	global := showStatus(t, conn1, "show global status")
+	assert.EqualValues(t, 3, global[StatusConnections])
+	assert.EqualValues(t, 1, global[StatusAbortedConnects])
+	assert.EqualValues(t, 2, global[StatusThreadsConnected])
+	assert.EqualValues(t, 4, global[StatusQuestions])
+	assert.EqualValues(t, 1, global[StatusSlowQueries])
+	assert.EqualValues(t, 5, global[StatusCommandPrefix+"com_query"])
+	assert.Greater(t, global[StatusBytesReceived], int64(0))
+	assert.Greater(t, global[StatusBytesSent], int64(0))
+
+	// The session values are the ones of the connection, the global
+	// only variables keep their global value.
+	session1 := showStatus(t, conn1, "show status")
+	assert.EqualValues(t, 4, session1[StatusQuestions])
+	assert.EqualValues(t, 1, session1[StatusSlowQueries])
+	assert.EqualValues(t, 2, session1[StatusThreadsConnected])
+
+	session2 := showStatus(t, conn2, "show status")
+	assert.EqualValues(t, 1, session2[StatusQuestions])
+	assert.EqualValues(t, 0, session2[StatusSlowQueries])
+	assert.EqualValues(t, 3, session2[StatusConnections])
+	assert.Less(t, session2[StatusBytesReceived], session1[StatusBytesReceived])
+
+	// The counters keep moving.
+	global = showStatus(t, conn2, "show global status")
+	assert.EqualValues(t, 7, global[StatusQuestions])
+	assert.EqualValues(t, 8, global[StatusCommandPrefix+"com_query"])
+	assert.Less(t, session1[StatusBytesSent], global[StatusBytesSent])
+}
+
+func TestStatusResult(t *testing.T) {
+	result := StatusResult(map[string]int64{
+		StatusQuestions:   12,
+		StatusBytesSent:   3,
+		StatusConnections: 0,
+	})
+	var rows []string
+	for _, row := range result.Rows {
+		rows = append(rows, row[0].ToString()+"="+row[1].ToString())
+	}
+	assert.Equal(t, []string{"Bytes_sent=3", "Connections=0", "Questions=12"}, rows)
+}
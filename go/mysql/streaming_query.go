@@ -25,8 +25,10 @@ import (
 // This file contains the methods needed to execute streaming queries.
 
 // ExecuteStreamFetch starts a streaming query.  Fields(), FetchNext() and
-// CloseResult() can be called once this is successful.
-// Returns a SQLError.
+// CloseResult() can be called once this is successful. The other
+// client methods fail with ErrResultPending until the last row is read
+// or CloseResult is called.
+// Returns a SQLError, or ErrResultPending.
 func (c *Conn) ExecuteStreamFetch(query string) (err error) {
 	defer func() {
 		if err != nil {
@@ -36,13 +38,13 @@ func (c *Conn) ExecuteStreamFetch(query string) (err error) {
 		}
 	}()
 
-	// Sanity check.
-	if c.fields != nil {
-		return NewSQLError(CRCommandsOutOfSync, SSUnknownSQLState, "streaming query already in progress")
+	if err := c.startCommand(); err != nil {
+		return err
 	}
+	defer c.endCommand()
 
 	// Send the query as a COM_QUERY packet.
-	if err := c.WriteComQuery(query); err != nil {
+	if err := c.writeComQuery(query); err != nil {
 		return err
 	}
 
@@ -57,6 +59,12 @@ func (c *Conn) ExecuteStreamFetch(query string) (err error) {
 		return nil
 	}
 
+	// Column definitions and the EOF that may follow them.
+	c.streamBudget = c.newResultBudget()
+	if err := c.streamBudget.addPackets(colNumber + 1); err != nil {
+		return c.abortResult(err)
+	}
+
 	// Read the fields, save them.
 	fields := make([]querypb.Field, colNumber)
 	fieldsPointers := make([]*querypb.Field, colNumber)
@@ -93,6 +101,26 @@ func (c *Conn) ExecuteStreamFetch(query string) (err error) {
 	return nil
 }
 
+// ReadQueryResultStreaming gets the result from the last written query,
+// like ReadQueryResult, but passes each row to onRow as it is read
+// instead of keeping them. It returns the fields and the status of the
+// result once its last row was read. If onRow returns an error, the
+// rest of the result is not read and the connection is closed, as it
+// is out of sync.
+// Returns a SQLError, ErrResultPending, or the error of onRow.
+func (c *Conn) ReadQueryResultStreaming(maxRows int, wantfields bool, onRow func([]sqltypes.Value) error) (fields []*querypb.Field, status serverStatus, err error) {
+	if err := c.startCommand(); err != nil {
+		return nil, 0, err
+	}
+	defer c.endCommand()
+
+	result, status, _, err := c.readResult(maxRows, wantfields, onRow)
+	if err != nil {
+		return nil, 0, err
+	}
+	return result.Fields, status, nil
+}
+
 // Fields returns the fields for an ongoing streaming query.
 func (c *Conn) Fields() ([]*querypb.Field, error) {
 	if c.fields == nil {
@@ -108,6 +136,16 @@ func (c *Conn) Fields() ([]*querypb.Field, error) {
 // FetchNext returns the next result for an ongoing streaming query.
 // It returns (nil, nil) if there is nothing more to read.
 func (c *Conn) FetchNext() ([]sqltypes.Value, error) {
+	if err := c.startResultRead(); err != nil {
+		return nil, err
+	}
+	defer c.endCommand()
+	return c.fetchNext()
+}
+
+// fetchNext is FetchNext, for the client methods that already called
+// startResultRead.
+func (c *Conn) fetchNext() ([]sqltypes.Value, error) {
 	if c.fields == nil {
 		// We are already done, and the result was closed.
 		return nil, NewSQLError(CRCommandsOutOfSync, SSUnknownSQLState, "no streaming query in progress")
@@ -128,19 +166,35 @@ func (c *Conn) FetchNext() ([]sqltypes.Value, error) {
 		c.fields = nil
 		return nil, nil
 	} else if isErrorPacket(data) {
-		// Error packet.
+		// Error packet, it ends the result.
+		c.fields = nil
 		return nil, ParseErrorPacket(data)
 	}
 
 	// Regular row.
-	return c.parseRow(data, c.fields)
+	if err := c.streamBudget.addRow(len(data)); err != nil {
+		c.fields = nil
+		return nil, c.abortResult(err)
+	}
+	row, err := c.parseRow(data, c.fields)
+	if err != nil {
+		c.fields = nil
+		return nil, c.abortResult(err)
+	}
+	return row, nil
 }
 
 // CloseResult can be used to terminate a streaming query
-// early. It just drains the remaining values.
+// early. It just drains the remaining values. It does nothing if no
+// streaming query is in progress, or if another goroutine is using the
+// connection.
 func (c *Conn) CloseResult() {
+	if c.startResultRead() != nil {
+		return
+	}
+	defer c.endCommand()
 	for c.fields != nil {
-		rows, err := c.FetchNext()
+		rows, err := c.fetchNext()
 		if err != nil || rows == nil {
 			// We either got an error, or got the last result.
 			c.fields = nil
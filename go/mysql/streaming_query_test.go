@@ -0,0 +1,78 @@
+/*
+Copyright 2019 The Vitess Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mysql
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dolthub/vitess/go/sqltypes"
+)
+
+func TestReadQueryResultStreaming(t *testing.T) {
+	conn := connectResultPending(t, &testHandler{}, ConnParams{})
+
+	// readStreaming sends query, and reads its result with
+	// ReadQueryResultStreaming, collecting the rows.
+	readStreaming := func(query string, maxRows int, onRow func([]sqltypes.Value) error) ([]string, [][]sqltypes.Value, error) {
+		t.Helper()
+		require.NoError(t, conn.writeComQuery(query))
+		var rows [][]sqltypes.Value
+		fields, _, err := conn.ReadQueryResultStreaming(maxRows, true, func(row []sqltypes.Value) error {
+			rows = append(rows, row)
+			if onRow != nil {
+				return onRow(row)
+			}
+			return nil
+		})
+		var names []string
+		for _, field := range fields {
+			names = append(names, field.Name)
+		}
+		return names, rows, err
+	}
+
+	names, rows, err := readStreaming("select rows", -1, nil)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"id", "name"}, names)
+	assert.Equal(t, selectRowsResult.Rows, rows)
+
+	// A result without rows.
+	names, rows, err = readStreaming("insert", -1, nil)
+	require.NoError(t, err)
+	assert.Empty(t, names)
+	assert.Empty(t, rows)
+
+	// Going over maxRows drains the result, the connection can be used
+	// again.
+	_, rows, err = readStreaming("select rows", 1, nil)
+	assertSQLError(t, err, ERVitessMaxRowsExceeded, SSUnknownSQLState, "Row count exceeded 1", "")
+	assert.Len(t, rows, 1)
+	result, err := conn.ExecuteFetch("select rows", 10, false)
+	require.NoError(t, err)
+	assert.Equal(t, selectRowsResult.Rows, result.Rows)
+
+	// A failing callback stops the read, and closes the connection.
+	errStop := errors.New("stop")
+	_, rows, err = readStreaming("select rows", -1, func([]sqltypes.Value) error { return errStop })
+	assert.Equal(t, errStop, err)
+	assert.Len(t, rows, 1)
+	assert.True(t, conn.IsClosed())
+}
@@ -0,0 +1,142 @@
+/*
+Copyright 2019 The Vitess Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sqltypes
+
+import (
+	"strings"
+	"unicode/utf8"
+
+	vtrpcpb "github.com/dolthub/vitess/go/vt/proto/vtrpc"
+	"github.com/dolthub/vitess/go/vt/vterrors"
+)
+
+// The character sets StringInCharset decodes.
+const (
+	CharsetBinary  = "binary"
+	CharsetASCII   = "ascii"
+	CharsetLatin1  = "latin1"
+	CharsetUtf8mb3 = "utf8mb3"
+	CharsetUtf8mb4 = "utf8mb4"
+)
+
+// collationCharsets maps the IDs of the collations of the character
+// sets StringInCharset decodes to their character set. The IDs are the
+// ones of the Charset of the fields.
+var collationCharsets = map[uint16]string{
+	63: CharsetBinary,
+
+	11: CharsetASCII, // ascii_general_ci
+	65: CharsetASCII, // ascii_bin
+
+	5:  CharsetLatin1, // latin1_german1_ci
+	8:  CharsetLatin1, // latin1_swedish_ci
+	15: CharsetLatin1, // latin1_danish_ci
+	31: CharsetLatin1, // latin1_german2_ci
+	47: CharsetLatin1, // latin1_bin
+	48: CharsetLatin1, // latin1_general_ci
+	49: CharsetLatin1, // latin1_general_cs
+	94: CharsetLatin1, // latin1_spanish_ci
+
+	33:  CharsetUtf8mb3, // utf8mb3_general_ci
+	76:  CharsetUtf8mb3, // utf8mb3_tolower_ci
+	83:  CharsetUtf8mb3, // utf8mb3_bin
+	223: CharsetUtf8mb3, // utf8mb3_general_mysql500_ci
+
+	45: CharsetUtf8mb4, // utf8mb4_general_ci
+	46: CharsetUtf8mb4, // utf8mb4_bin
+}
+
+func init() {
+	// The language specific collations.
+	for id := uint16(192); id <= 215; id++ {
+		collationCharsets[id] = CharsetUtf8mb3
+	}
+	for id := uint16(224); id <= 247; id++ {
+		collationCharsets[id] = CharsetUtf8mb4
+	}
+	for id := uint16(255); id <= 323; id++ {
+		collationCharsets[id] = CharsetUtf8mb4
+	}
+}
+
+// CollationCharset returns the character set of a collation, if
+// StringInCharset can decode it.
+func CollationCharset(collation uint16) (string, bool) {
+	charset, ok := collationCharsets[collation]
+	return charset, ok
+}
+
+// latin1High are the characters of the bytes 0x80 to 0x9f in latin1.
+// MySQL's latin1 is cp1252, except for the 5 bytes cp1252 leaves
+// undefined, which are the C1 control characters of ISO-8859-1.
+var latin1High = [32]rune{
+	'€', '\u0081', '‚', 'ƒ', '„', '…', '†', '‡',
+	'ˆ', '‰', 'Š', '‹', 'Œ', '\u008d', 'Ž', '\u008f',
+	'\u0090', '‘', '’', '“', '”', '•', '–', '—',
+	'˜', '™', 'š', '›', 'œ', '\u009d', 'ž', 'Ÿ',
+}
+
+// StringInCharset decodes the value, as MySQL returns it, from the
+// character set of the collation charset (see CollationCharset) into a
+// UTF-8 string. binary values are returned as is. It returns an error
+// if the collation is unknown, or if the value is not valid in its
+// character set. NULL is the empty string.
+func (v Value) StringInCharset(charset uint16) (string, error) {
+	if v.typ == Expression {
+		return "", vterrors.Errorf(vtrpcpb.Code_INVALID_ARGUMENT, "%v cannot be converted to a go type", v)
+	}
+	name, ok := collationCharsets[charset]
+	if !ok {
+		return "", vterrors.Errorf(vtrpcpb.Code_INVALID_ARGUMENT, "unsupported character set of collation %d", charset)
+	}
+	b := v.ToBytes()
+	switch name {
+	case CharsetASCII:
+		for _, c := range b {
+			if c >= utf8.RuneSelf {
+				return "", vterrors.Errorf(vtrpcpb.Code_INVALID_ARGUMENT, "invalid %s string: %q", name, b)
+			}
+		}
+	case CharsetLatin1:
+		var sb strings.Builder
+		sb.Grow(len(b))
+		for _, c := range b {
+			switch {
+			case c < 0x80:
+				sb.WriteByte(c)
+			case c < 0xa0:
+				sb.WriteRune(latin1High[c-0x80])
+			default:
+				sb.WriteRune(rune(c))
+			}
+		}
+		return sb.String(), nil
+	case CharsetUtf8mb3, CharsetUtf8mb4:
+		if !utf8.Valid(b) {
+			return "", vterrors.Errorf(vtrpcpb.Code_INVALID_ARGUMENT, "invalid %s string: %q", name, b)
+		}
+		if name == CharsetUtf8mb3 {
+			// Only the characters of 3 bytes at most.
+			for _, r := range string(b) {
+				if r > 0xffff {
+					return "", vterrors.Errorf(vtrpcpb.Code_INVALID_ARGUMENT, "invalid %s string: %q", name, b)
+				}
+			}
+		}
+	}
+	return string(b), nil
+}
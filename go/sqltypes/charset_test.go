@@ -0,0 +1,134 @@
+/*
+Copyright 2019 The Vitess Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sqltypes
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestStringInCharset(t *testing.T) {
+	testcases := []struct {
+		in      Value
+		charset uint16
+		out     string
+		err     string
+	}{{
+		in:      NewVarBinary("\x00\xff\x80"),
+		charset: 63,
+		out:     "\x00\xff\x80",
+	}, {
+		in:      NewInt64(-12),
+		charset: 63,
+		out:     "-12",
+	}, {
+		in:      NULL,
+		charset: 33,
+		out:     "",
+	}, {
+		in:      NewVarChar("abc"),
+		charset: 11,
+		out:     "abc",
+	}, {
+		in:      NewVarChar("caf\xe9"),
+		charset: 65,
+		err:     `invalid ascii string: "caf\xe9"`,
+	}, {
+		in:      NewVarChar("caf\xe9 \x80 \x81 \x9f \xff"),
+		charset: 8,
+		out:     "café € \u0081 Ÿ ÿ",
+	}, {
+		in:      NewVarChar("na\xefve"),
+		charset: 47,
+		out:     "naïve",
+	}, {
+		in:      NewVarChar("café"),
+		charset: 33,
+		out:     "café",
+	}, {
+		in:      NewVarChar("café"),
+		charset: 192,
+		out:     "café",
+	}, {
+		in:      NewVarChar("caf\xe9"),
+		charset: 83,
+		err:     `invalid utf8mb3 string: "caf\xe9"`,
+	}, {
+		in:      NewVarChar("🐬"),
+		charset: 33,
+		err:     `invalid utf8mb3 string: "🐬"`,
+	}, {
+		in:      NewVarChar("🐬"),
+		charset: 45,
+		out:     "🐬",
+	}, {
+		in:      NewVarChar("🐬"),
+		charset: 255,
+		out:     "🐬",
+	}, {
+		in:      NewVarChar("\xf0\x9f"),
+		charset: 46,
+		err:     `invalid utf8mb4 string: "\xf0\x9f"`,
+	}, {
+		in:      NewVarChar("abc"),
+		charset: 28,
+		err:     "unsupported character set of collation 28",
+	}, {
+		in:      NewVarChar("abc"),
+		charset: 0,
+		err:     "unsupported character set of collation 0",
+	}, {
+		in:      MakeTrusted(Expression, []byte("a + 1")),
+		charset: 33,
+		err:     "cannot be converted to a go type",
+	}}
+	for _, tcase := range testcases {
+		got, err := tcase.in.StringInCharset(tcase.charset)
+		if tcase.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tcase.err) {
+				t.Errorf("%v.StringInCharset(%d): %v, want error %q", tcase.in, tcase.charset, err, tcase.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%v.StringInCharset(%d) failed: %v", tcase.in, tcase.charset, err)
+			continue
+		}
+		if got != tcase.out {
+			t.Errorf("%v.StringInCharset(%d): %q, want %q", tcase.in, tcase.charset, got, tcase.out)
+		}
+	}
+}
+
+func TestCollationCharset(t *testing.T) {
+	for collation, want := range map[uint16]string{
+		8:   CharsetLatin1,
+		33:  CharsetUtf8mb3,
+		45:  CharsetUtf8mb4,
+		63:  CharsetBinary,
+		215: CharsetUtf8mb3,
+		247: CharsetUtf8mb4,
+		309: CharsetUtf8mb4,
+	} {
+		if got, ok := CollationCharset(collation); !ok || got != want {
+			t.Errorf("CollationCharset(%d): %v, %v, want %v", collation, got, ok, want)
+		}
+	}
+	if got, ok := CollationCharset(248); ok {
+		t.Errorf("CollationCharset(248): %v, want none", got)
+	}
+}
@@ -0,0 +1,89 @@
+/*
+Copyright 2019 The Vitess Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sqltypes
+
+import (
+	querypb "github.com/dolthub/vitess/go/vt/proto/query"
+	vtrpcpb "github.com/dolthub/vitess/go/vt/proto/vtrpc"
+	"github.com/dolthub/vitess/go/vt/vterrors"
+)
+
+// Column is a column of a Result, with the values of all its rows.
+type Column struct {
+	Field *querypb.Field
+
+	// Values is a slice with one element per row, of the Go type of
+	// Field.Type, as ToNative returns it: []int64 for the signed
+	// types, []uint64 for the unsigned ones, []float64 for the floats,
+	// and [][]byte for the others. The NULL elements are zero values.
+	Values interface{}
+
+	// Nulls has one element per row, true if the value is NULL.
+	Nulls []bool
+}
+
+// ResultToColumns transposes the rows of r into one Column per field.
+// It returns an error if a row doesn't have a value per field, or if a
+// value can't be converted to the Go type of its field.
+func ResultToColumns(r *Result) ([]Column, error) {
+	columns := make([]Column, len(r.Fields))
+	for i, field := range r.Fields {
+		columns[i] = Column{
+			Field: field,
+			Nulls: make([]bool, len(r.Rows)),
+		}
+		switch typ := field.Type; {
+		case IsSigned(typ):
+			columns[i].Values = make([]int64, len(r.Rows))
+		case IsUnsigned(typ):
+			columns[i].Values = make([]uint64, len(r.Rows))
+		case IsFloat(typ):
+			columns[i].Values = make([]float64, len(r.Rows))
+		case typ == Null || IsQuoted(typ) || typ == Bit || typ == Decimal:
+			columns[i].Values = make([][]byte, len(r.Rows))
+		default:
+			return nil, vterrors.Errorf(vtrpcpb.Code_INVALID_ARGUMENT, "column %v of type %v cannot be converted to a go type", field.Name, typ)
+		}
+	}
+
+	for j, row := range r.Rows {
+		if len(row) != len(columns) {
+			return nil, vterrors.Errorf(vtrpcpb.Code_INVALID_ARGUMENT, "row %v has %v values, want %v", j, len(row), len(columns))
+		}
+		for i, v := range row {
+			if v.IsNull() {
+				columns[i].Nulls[j] = true
+				continue
+			}
+			var err error
+			switch values := columns[i].Values.(type) {
+			case []int64:
+				values[j], err = ToInt64(v)
+			case []uint64:
+				values[j], err = ToUint64(v)
+			case []float64:
+				values[j], err = ToFloat64(v)
+			case [][]byte:
+				values[j] = v.Raw()
+			}
+			if err != nil {
+				return nil, vterrors.Wrapf(err, "row %v, column %v", j, columns[i].Field.Name)
+			}
+		}
+	}
+	return columns, nil
+}
@@ -0,0 +1,117 @@
+/*
+Copyright 2019 The Vitess Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sqltypes
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	querypb "github.com/dolthub/vitess/go/vt/proto/query"
+)
+
+func TestResultToColumns(t *testing.T) {
+	fields := MakeTestFields("i|u|f|d|s|b|dt|n", "int32|uint64|float64|decimal|varchar|blob|datetime|null_type")
+	result := &Result{
+		Fields: fields,
+		Rows: [][]Value{
+			{NewInt32(-1), NewUint64(1), NewFloat64(1.5), TestValue(Decimal, "1.25"), NewVarChar("a"), NewVarBinary("\x00\x01"), TestValue(Datetime, "2019-01-01 00:00:00"), NULL},
+			{NULL, NULL, NULL, NULL, NULL, NULL, NULL, NULL},
+			{NewInt32(3), NewUint64(18446744073709551615), NewFloat64(-2), TestValue(Decimal, "-0.5"), NewVarChar(""), NewVarBinary(""), NULL, NULL},
+		},
+	}
+	want := []Column{{
+		Field:  fields[0],
+		Values: []int64{-1, 0, 3},
+		Nulls:  []bool{false, true, false},
+	}, {
+		Field:  fields[1],
+		Values: []uint64{1, 0, 18446744073709551615},
+		Nulls:  []bool{false, true, false},
+	}, {
+		Field:  fields[2],
+		Values: []float64{1.5, 0, -2},
+		Nulls:  []bool{false, true, false},
+	}, {
+		Field:  fields[3],
+		Values: [][]byte{[]byte("1.25"), nil, []byte("-0.5")},
+		Nulls:  []bool{false, true, false},
+	}, {
+		Field:  fields[4],
+		Values: [][]byte{[]byte("a"), nil, {}},
+		Nulls:  []bool{false, true, false},
+	}, {
+		Field:  fields[5],
+		Values: [][]byte{{0, 1}, nil, {}},
+		Nulls:  []bool{false, true, false},
+	}, {
+		Field:  fields[6],
+		Values: [][]byte{[]byte("2019-01-01 00:00:00"), nil, nil},
+		Nulls:  []bool{false, true, true},
+	}, {
+		Field:  fields[7],
+		Values: [][]byte{nil, nil, nil},
+		Nulls:  []bool{true, true, true},
+	}}
+	got, err := ResultToColumns(result)
+	if err != nil {
+		t.Fatalf("ResultToColumns failed: %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ResultToColumns:\n%v, want\n%v", got, want)
+	}
+
+	// A result without rows has empty columns.
+	got, err = ResultToColumns(&Result{Fields: fields[:1]})
+	if err != nil {
+		t.Fatalf("ResultToColumns failed: %v", err)
+	}
+	want = []Column{{Field: fields[0], Values: []int64{}, Nulls: []bool{}}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ResultToColumns:\n%v, want\n%v", got, want)
+	}
+}
+
+func TestResultToColumnsError(t *testing.T) {
+	testcases := []struct {
+		in  *Result
+		err string
+	}{{
+		in: &Result{
+			Fields: []*querypb.Field{{Name: "e", Type: Expression}},
+		},
+		err: "column e of type EXPRESSION cannot be converted to a go type",
+	}, {
+		in: &Result{
+			Fields: MakeTestFields("a|b", "int64|varchar"),
+			Rows:   [][]Value{{NewInt64(1)}},
+		},
+		err: "row 0 has 1 values, want 2",
+	}, {
+		in: &Result{
+			Fields: MakeTestFields("a", "int64"),
+			Rows:   [][]Value{{NewInt64(1)}, {NewVarChar("a")}},
+		},
+		err: "row 1, column a: could not parse value: 'a'",
+	}}
+	for _, tcase := range testcases {
+		_, err := ResultToColumns(tcase.in)
+		if err == nil || !strings.Contains(err.Error(), tcase.err) {
+			t.Errorf("ResultToColumns(%v): %v, want %q", tcase.in, err, tcase.err)
+		}
+	}
+}
@@ -32,6 +32,39 @@ type Result struct {
 	Info         string                `json:"info"`
 	Rows         [][]Value             `json:"rows"`
 	Extras       *querypb.ResultExtras `json:"extras"`
+
+	// SessionStateChanges are the changes of the session state the
+	// MySQL server reported with the result, if any. They are only set
+	// by the client, servers report them with a Handler hook.
+	SessionStateChanges *SessionStateChanges `json:"session_state_changes,omitempty"`
+}
+
+// SessionStateChanges are the changes of the session state a MySQL
+// server reports in OK packets, to clients that negotiated
+// CLIENT_SESSION_TRACK. What is reported depends on the session_track_*
+// system variables.
+type SessionStateChanges struct {
+	// SystemVariables are the system variables that changed, with
+	// their new value (SESSION_TRACK_SYSTEM_VARIABLES).
+	SystemVariables map[string]string `json:"system_variables,omitempty"`
+
+	// SchemaChanged is set if the default schema changed, to Schema
+	// (SESSION_TRACK_SCHEMA).
+	SchemaChanged bool   `json:"schema_changed,omitempty"`
+	Schema        string `json:"schema,omitempty"`
+
+	// StateChanged is set if the session state changed, whether or
+	// not the change is reported otherwise (SESSION_TRACK_STATE_CHANGE).
+	StateChanged bool `json:"state_changed,omitempty"`
+
+	// GTIDs are the GTIDs of the transactions the statement committed,
+	// in the text format of GTID sets (SESSION_TRACK_GTIDS).
+	GTIDs string `json:"gtids,omitempty"`
+}
+
+// IsEmpty returns true if no change is reported.
+func (ssc *SessionStateChanges) IsEmpty() bool {
+	return ssc == nil || len(ssc.SystemVariables) == 0 && !ssc.SchemaChanged && !ssc.StateChanged && ssc.GTIDs == ""
 }
 
 // ResultStream is an interface for receiving Result. It is used for
@@ -21,6 +21,7 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"io"
 	"strconv"
 
 	"github.com/dolthub/vitess/go/bytes2"
@@ -53,6 +54,16 @@ type BinWriter interface {
 type Value struct {
 	typ querypb.Type
 	val []byte
+
+	// stream, if set, provides the contents of the value instead
+	// of val. See NewStreamValue.
+	stream *valueStream
+}
+
+// valueStream is the reader and the declared length of a stream value.
+type valueStream struct {
+	r      io.Reader
+	length int64
 }
 
 // NewValue builds a Value using typ and val. If the value and typ
@@ -97,6 +108,16 @@ func MakeTrusted(typ querypb.Type, val []byte) Value {
 	return Value{typ: typ, val: val}
 }
 
+// NewStreamValue builds a Value whose contents are read from r
+// instead of being held in memory. r must return exactly length
+// bytes. This is meant for very large values, like LONGBLOBs, that
+// are passed through the mysql protocol without being buffered.
+// Stream values can only be consumed once, and the accessors of the
+// contents, like Raw or ToString, return nothing for them.
+func NewStreamValue(typ querypb.Type, r io.Reader, length int64) Value {
+	return Value{typ: typ, stream: &valueStream{r: r, length: length}}
+}
+
 // NewInt64 builds an Int64 Value.
 func NewInt64(v int64) Value {
 	return MakeTrusted(Int64, strconv.AppendInt(nil, v, 10))
@@ -217,6 +238,9 @@ func (v Value) String() string {
 	if v.typ == Null {
 		return "NULL"
 	}
+	if v.stream != nil {
+		return fmt.Sprintf("%v(<stream of %v bytes>)", v.typ, v.stream.length)
+	}
 	if v.IsQuoted() || v.typ == Bit {
 		return fmt.Sprintf("%v(%q)", v.typ, v.val)
 	}
@@ -254,6 +278,21 @@ func (v Value) IsNull() bool {
 	return v.typ == Null
 }
 
+// IsStream returns true if the contents of Value are provided by a
+// reader. See NewStreamValue.
+func (v Value) IsStream() bool {
+	return v.stream != nil
+}
+
+// Stream returns the reader and the declared length of a stream
+// value, or nil and 0 for other values.
+func (v Value) Stream() (io.Reader, int64) {
+	if v.stream == nil {
+		return nil, 0
+	}
+	return v.stream.r, v.stream.length
+}
+
 // IsIntegral returns true if Value is an integral.
 func (v Value) IsIntegral() bool {
 	return IsIntegral(v.typ)
@@ -315,9 +354,10 @@ func (v *Value) UnmarshalJSON(b []byte) error {
 		err = json.Unmarshal(b, &ival)
 		val = ival
 	case '"':
-		var bval []byte
-		err = json.Unmarshal(b, &bval)
-		val = bval
+		// MarshalJSON writes the value as a string, not as base64.
+		var sval string
+		err = json.Unmarshal(b, &sval)
+		val = []byte(sval)
 	case 'n': // null
 		err = json.Unmarshal(b, &val)
 	default:
@@ -338,6 +338,31 @@ func TestAccessors(t *testing.T) {
 	}
 }
 
+func TestStreamValue(t *testing.T) {
+	r := strings.NewReader("abcd")
+	v := NewStreamValue(Blob, r, 4)
+	if !v.IsStream() {
+		t.Error("v.IsStream: false, want true")
+	}
+	if gotR, gotLength := v.Stream(); gotR != r || gotLength != 4 {
+		t.Errorf("v.Stream=%v, %v, want %v, 4", gotR, gotLength, r)
+	}
+	if v.Type() != Blob || v.IsNull() || v.Raw() != nil {
+		t.Errorf("v=%v, want a non-null Blob without raw contents", v)
+	}
+	if got, want := v.String(), "BLOB(<stream of 4 bytes>)"; got != want {
+		t.Errorf("v.String=%v, want %v", got, want)
+	}
+
+	v = TestValue(Blob, "abcd")
+	if v.IsStream() {
+		t.Error("v.IsStream: true, want false")
+	}
+	if gotR, gotLength := v.Stream(); gotR != nil || gotLength != 0 {
+		t.Errorf("v.Stream=%v, %v, want nil, 0", gotR, gotLength)
+	}
+}
+
 func TestToBytesAndString(t *testing.T) {
 	for _, v := range []Value{
 		NULL,
@@ -401,6 +426,50 @@ func TestEncode(t *testing.T) {
 	}
 }
 
+func TestValueJSON(t *testing.T) {
+	testcases := []struct {
+		in   Value
+		json string
+		out  Value
+	}{{
+		in:   NULL,
+		json: "null",
+		out:  NULL,
+	}, {
+		in:   NewVarChar(""),
+		json: `""`,
+		out:  NewVarBinary(""),
+	}, {
+		in:   NewVarChar("abc"),
+		json: `"abc"`,
+		out:  NewVarBinary("abc"),
+	}, {
+		in:   NewInt64(-1),
+		json: "-1",
+		out:  NewInt64(-1),
+	}, {
+		in:   NewUint64(1),
+		json: "1",
+		out:  NewUint64(1),
+	}}
+	for _, tcase := range testcases {
+		b, err := tcase.in.MarshalJSON()
+		if err != nil {
+			t.Fatalf("%v.MarshalJSON failed: %v", tcase.in, err)
+		}
+		if string(b) != tcase.json {
+			t.Errorf("%v.MarshalJSON = %s, want %s", tcase.in, b, tcase.json)
+		}
+		var out Value
+		if err := out.UnmarshalJSON(b); err != nil {
+			t.Fatalf("UnmarshalJSON(%s) failed: %v", b, err)
+		}
+		if !reflect.DeepEqual(out, tcase.out) {
+			t.Errorf("UnmarshalJSON(%s) = %v, want %v", b, out, tcase.out)
+		}
+	}
+}
+
 // TestEncodeMap ensures DontEscape is not escaped
 func TestEncodeMap(t *testing.T) {
 	if SQLEncodeMap[DontEscape] != DontEscape {
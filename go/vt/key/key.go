@@ -0,0 +1,261 @@
+/*
+Copyright 2019 The Vitess Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package key contains the KeyRange primitives used to route keyspace
+// ids to shards.
+//
+// A KeyRange covers the keyspace ids id with Start <= id < End, in
+// bytes.Compare order. An empty Start is the beginning of the keyspace,
+// and an empty End its end. A nil KeyRange covers the whole keyspace,
+// like the "-" shard.
+package key
+
+import (
+	"bytes"
+	"encoding/hex"
+	"math/big"
+	"sort"
+	"strings"
+
+	"github.com/dolthub/vitess/go/vt/proto/vtrpc"
+	"github.com/dolthub/vitess/go/vt/vterrors"
+
+	topodatapb "github.com/dolthub/vitess/go/vt/proto/topodata"
+)
+
+// ParseShardName parses a shard name like "40-60", "-80", "80-" or "-"
+// into the KeyRange it covers. The bounds are hex encoded, and the
+// start must be strictly before the end.
+func ParseShardName(shard string) (*topodatapb.KeyRange, error) {
+	startHex, endHex, ok := strings.Cut(shard, "-")
+	if !ok {
+		return nil, vterrors.Errorf(vtrpc.Code_INVALID_ARGUMENT, "malformed shard name %q: missing '-'", shard)
+	}
+	start, err := hex.DecodeString(startHex)
+	if err != nil {
+		return nil, vterrors.Errorf(vtrpc.Code_INVALID_ARGUMENT, "malformed start of shard name %q: %v", shard, err)
+	}
+	end, err := hex.DecodeString(endHex)
+	if err != nil {
+		return nil, vterrors.Errorf(vtrpc.Code_INVALID_ARGUMENT, "malformed end of shard name %q: %v", shard, err)
+	}
+	kr := &topodatapb.KeyRange{Start: start, End: end}
+	if isEmpty(kr) {
+		return nil, vterrors.Errorf(vtrpc.Code_INVALID_ARGUMENT, "shard name %q covers no keyspace id", shard)
+	}
+	return kr, nil
+}
+
+// KeyRangeString returns the shard name of a KeyRange, the reverse of
+// ParseShardName.
+func KeyRangeString(kr *topodatapb.KeyRange) string {
+	if kr == nil {
+		return "-"
+	}
+	return hex.EncodeToString(kr.Start) + "-" + hex.EncodeToString(kr.End)
+}
+
+// KeyRangeIsPartial returns true if the KeyRange does not cover the
+// whole keyspace.
+func KeyRangeIsPartial(kr *topodatapb.KeyRange) bool {
+	return kr != nil && (len(kr.Start) != 0 || len(kr.End) != 0)
+}
+
+// KeyRangeEqual returns true if both KeyRanges have the same bounds.
+func KeyRangeEqual(left, right *topodatapb.KeyRange) bool {
+	return bytes.Equal(left.GetStart(), right.GetStart()) && bytes.Equal(left.GetEnd(), right.GetEnd())
+}
+
+// KeyRangeContains returns true if the keyspace id belongs to the
+// KeyRange. A keyspace id equal to the end of a range belongs to the
+// next range.
+func KeyRangeContains(kr *topodatapb.KeyRange, id []byte) bool {
+	return bytes.Compare(kr.GetStart(), id) <= 0 && endAfter(kr.GetEnd(), id)
+}
+
+// KeyRangeIncludes returns true if big covers all the keyspace ids of
+// small. Every KeyRange includes an empty one.
+func KeyRangeIncludes(big, small *topodatapb.KeyRange) bool {
+	if isEmpty(small) {
+		return true
+	}
+	return bytes.Compare(big.GetStart(), small.GetStart()) <= 0 && compareEnds(small.GetEnd(), big.GetEnd()) <= 0
+}
+
+// KeyRangesIntersect returns true if some keyspace id belongs to both
+// KeyRanges.
+func KeyRangesIntersect(first, second *topodatapb.KeyRange) bool {
+	_, ok := KeyRangeIntersection(first, second)
+	return ok
+}
+
+// KeyRangeIntersection returns the KeyRange of the keyspace ids that
+// belong to both KeyRanges. It returns false if there are none.
+func KeyRangeIntersection(first, second *topodatapb.KeyRange) (*topodatapb.KeyRange, bool) {
+	result := &topodatapb.KeyRange{
+		Start: first.GetStart(),
+		End:   first.GetEnd(),
+	}
+	if bytes.Compare(second.GetStart(), result.Start) > 0 {
+		result.Start = second.GetStart()
+	}
+	if compareEnds(second.GetEnd(), result.End) < 0 {
+		result.End = second.GetEnd()
+	}
+	if isEmpty(result) {
+		return nil, false
+	}
+	return result, true
+}
+
+// KeyRangeUnion returns the KeyRange covering the keyspace ids of both
+// KeyRanges. They must be adjacent or overlap, so the result has no
+// gap.
+func KeyRangeUnion(first, second *topodatapb.KeyRange) (*topodatapb.KeyRange, error) {
+	if isEmpty(first) {
+		return second, nil
+	}
+	if isEmpty(second) {
+		return first, nil
+	}
+	if bytes.Compare(second.GetStart(), first.GetStart()) < 0 {
+		first, second = second, first
+	}
+	// second starts within first, or right at its end.
+	if !endAfter(first.GetEnd(), second.GetStart()) && !bytes.Equal(first.GetEnd(), second.GetStart()) {
+		return nil, vterrors.Errorf(vtrpc.Code_INVALID_ARGUMENT, "key ranges %v and %v are not adjacent", KeyRangeString(first), KeyRangeString(second))
+	}
+	result := &topodatapb.KeyRange{
+		Start: first.GetStart(),
+		End:   first.GetEnd(),
+	}
+	if compareEnds(second.GetEnd(), result.End) > 0 {
+		result.End = second.GetEnd()
+	}
+	return result, nil
+}
+
+// KeyRangeSplit splits a KeyRange into n adjacent KeyRanges of the same
+// size, give or take one keyspace id of the finest granularity used.
+// The bounds between the parts are as short as possible, so splitting
+// "-" in 4 returns "-40", "40-80", "80-c0" and "c0-".
+func KeyRangeSplit(kr *topodatapb.KeyRange, n int) ([]*topodatapb.KeyRange, error) {
+	if n < 1 {
+		return nil, vterrors.Errorf(vtrpc.Code_INVALID_ARGUMENT, "cannot split key range %v in %v parts", KeyRangeString(kr), n)
+	}
+	if isEmpty(kr) {
+		return nil, vterrors.Errorf(vtrpc.Code_INVALID_ARGUMENT, "cannot split empty key range %v", KeyRangeString(kr))
+	}
+
+	if n == 1 {
+		return []*topodatapb.KeyRange{kr}, nil
+	}
+
+	// Find the shortest width in bytes where the range holds at least
+	// n values, and the bounds are exact.
+	width := len(kr.GetStart())
+	if len(kr.GetEnd()) > width {
+		width = len(kr.GetEnd())
+	}
+	count := big.NewInt(int64(n))
+	var start, size *big.Int
+	for {
+		start = toInt(kr.GetStart(), width)
+		end := toInt(kr.GetEnd(), width)
+		if len(kr.GetEnd()) == 0 {
+			end = new(big.Int).Lsh(big.NewInt(1), uint(8*width))
+		}
+		size = end.Sub(end, start)
+		if size.Sign() == 0 {
+			// The end is the start followed by zeroes, like
+			// "40-4000": the range only holds prefixes of the end.
+			return nil, vterrors.Errorf(vtrpc.Code_INVALID_ARGUMENT, "cannot split key range %v in %v parts", KeyRangeString(kr), n)
+		}
+		if size.Cmp(count) >= 0 {
+			break
+		}
+		width++
+	}
+
+	result := make([]*topodatapb.KeyRange, n)
+	bound := kr.GetStart()
+	for i := 0; i < n; i++ {
+		part := &topodatapb.KeyRange{Start: bound}
+		if i == n-1 {
+			part.End = kr.GetEnd()
+		} else {
+			offset := new(big.Int).Mul(size, big.NewInt(int64(i+1)))
+			offset.Quo(offset, count)
+			part.End = toBytes(offset.Add(offset, start), width)
+		}
+		result[i] = part
+		bound = part.End
+	}
+	return result, nil
+}
+
+// ShardForKeyspaceID returns the index of the KeyRange that contains
+// the keyspace id in shards. shards must be sorted by start, and not
+// overlap. It returns an error if no shard covers the keyspace id.
+func ShardForKeyspaceID(shards []*topodatapb.KeyRange, id []byte) (int, error) {
+	// The first shard that ends after id is the only one that may
+	// contain it.
+	i := sort.Search(len(shards), func(i int) bool {
+		return endAfter(shards[i].GetEnd(), id)
+	})
+	if i == len(shards) || !KeyRangeContains(shards[i], id) {
+		return 0, vterrors.Errorf(vtrpc.Code_FAILED_PRECONDITION, "no shard covers keyspace id %v", hex.EncodeToString(id))
+	}
+	return i, nil
+}
+
+// endAfter returns true if the KeyRange end is after the keyspace id.
+func endAfter(end, id []byte) bool {
+	return len(end) == 0 || bytes.Compare(id, end) < 0
+}
+
+// compareEnds compares two KeyRange ends, an empty end being the end
+// of the keyspace.
+func compareEnds(left, right []byte) int {
+	switch {
+	case len(left) == 0 && len(right) == 0:
+		return 0
+	case len(left) == 0:
+		return 1
+	case len(right) == 0:
+		return -1
+	}
+	return bytes.Compare(left, right)
+}
+
+// isEmpty returns true if the KeyRange covers no keyspace id.
+func isEmpty(kr *topodatapb.KeyRange) bool {
+	return len(kr.GetEnd()) != 0 && bytes.Compare(kr.GetStart(), kr.GetEnd()) >= 0
+}
+
+// toInt returns the value of a KeyRange bound, padded with zeroes to
+// width bytes.
+func toInt(bound []byte, width int) *big.Int {
+	padded := make([]byte, width)
+	copy(padded, bound)
+	return new(big.Int).SetBytes(padded)
+}
+
+// toBytes is the reverse of toInt, without the trailing zeroes.
+func toBytes(value *big.Int, width int) []byte {
+	result := value.FillBytes(make([]byte, width))
+	return bytes.TrimRight(result, "\x00")
+}
@@ -0,0 +1,323 @@
+/*
+Copyright 2019 The Vitess Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package key
+
+import (
+	"bytes"
+	"encoding/hex"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	topodatapb "github.com/dolthub/vitess/go/vt/proto/topodata"
+)
+
+func mustParse(t testing.TB, shard string) *topodatapb.KeyRange {
+	t.Helper()
+	kr, err := ParseShardName(shard)
+	require.NoError(t, err, shard)
+	return kr
+}
+
+func mustDecode(t testing.TB, id string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(id)
+	require.NoError(t, err, id)
+	return b
+}
+
+func TestParseShardName(t *testing.T) {
+	testcases := []struct {
+		in    string
+		start string
+		end   string
+		err   string
+	}{
+		{in: "-"},
+		{in: "-80", end: "80"},
+		{in: "80-", start: "80"},
+		{in: "40-60", start: "40", end: "60"},
+		{in: "4000-40a0", start: "4000", end: "40a0"},
+		{in: "40", err: "missing '-'"},
+		{in: "4g-60", err: "malformed start"},
+		{in: "40-6", err: "malformed end"},
+		{in: "40-40", err: "covers no keyspace id"},
+		{in: "60-40", err: "covers no keyspace id"},
+		{in: "40-3fff", err: "covers no keyspace id"},
+	}
+	for _, tcase := range testcases {
+		t.Run(tcase.in, func(t *testing.T) {
+			kr, err := ParseShardName(tcase.in)
+			if tcase.err != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tcase.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tcase.start, hex.EncodeToString(kr.Start))
+			assert.Equal(t, tcase.end, hex.EncodeToString(kr.End))
+			assert.Equal(t, tcase.in, KeyRangeString(kr))
+		})
+	}
+}
+
+func TestKeyRangeContains(t *testing.T) {
+	testcases := []struct {
+		shard string
+		id    string
+		want  bool
+	}{
+		{shard: "-", id: "", want: true},
+		{shard: "-", id: "ffffff", want: true},
+		{shard: "-80", id: "", want: true},
+		{shard: "-80", id: "7fffff", want: true},
+		// Boundaries belong to the right-hand shard.
+		{shard: "-80", id: "80", want: false},
+		{shard: "80-", id: "80", want: true},
+		{shard: "40-60", id: "40", want: true},
+		{shard: "40-60", id: "4000", want: true},
+		{shard: "40-60", id: "3fffff", want: false},
+		{shard: "40-60", id: "5fffff", want: true},
+		{shard: "40-60", id: "60", want: false},
+		{shard: "40-60", id: "6000", want: false},
+	}
+	for _, tcase := range testcases {
+		got := KeyRangeContains(mustParse(t, tcase.shard), mustDecode(t, tcase.id))
+		assert.Equal(t, tcase.want, got, "KeyRangeContains(%v, %v)", tcase.shard, tcase.id)
+	}
+	assert.True(t, KeyRangeContains(nil, []byte{0x12}), "nil KeyRange")
+}
+
+func TestKeyRangeIsPartial(t *testing.T) {
+	assert.False(t, KeyRangeIsPartial(nil))
+	assert.False(t, KeyRangeIsPartial(mustParse(t, "-")))
+	assert.True(t, KeyRangeIsPartial(mustParse(t, "-80")))
+	assert.True(t, KeyRangeIsPartial(mustParse(t, "80-")))
+}
+
+func TestKeyRangeIntersectionAndUnion(t *testing.T) {
+	testcases := []struct {
+		first, second string
+		intersection  string
+		union         string
+	}{
+		{first: "-", second: "40-60", intersection: "40-60", union: "-"},
+		{first: "-80", second: "80-", union: "-"},
+		{first: "40-60", second: "60-80", union: "40-80"},
+		{first: "40-60", second: "50-80", intersection: "50-60", union: "40-80"},
+		{first: "40-60", second: "4080-50", intersection: "4080-50", union: "40-60"},
+		{first: "40-60", second: "70-80"},
+		{first: "-40", second: "c0-"},
+		{first: "80-", second: "a0-", intersection: "a0-", union: "80-"},
+	}
+	for _, tcase := range testcases {
+		t.Run(tcase.first+" "+tcase.second, func(t *testing.T) {
+			first, second := mustParse(t, tcase.first), mustParse(t, tcase.second)
+			for _, order := range [][2]*topodatapb.KeyRange{{first, second}, {second, first}} {
+				intersection, ok := KeyRangeIntersection(order[0], order[1])
+				assert.Equal(t, ok, KeyRangesIntersect(order[0], order[1]))
+				if tcase.intersection == "" {
+					assert.False(t, ok, "got intersection %v", KeyRangeString(intersection))
+				} else if assert.True(t, ok) {
+					assert.Equal(t, tcase.intersection, KeyRangeString(intersection))
+				}
+
+				union, err := KeyRangeUnion(order[0], order[1])
+				if tcase.union == "" {
+					assert.Error(t, err)
+				} else if assert.NoError(t, err) {
+					assert.Equal(t, tcase.union, KeyRangeString(union))
+				}
+			}
+		})
+	}
+}
+
+func TestKeyRangeSplit(t *testing.T) {
+	testcases := []struct {
+		shard string
+		n     int
+		want  string
+		err   string
+	}{
+		{shard: "-", n: 1, want: "-"},
+		{shard: "-", n: 2, want: "-80 80-"},
+		{shard: "-", n: 4, want: "-40 40-80 80-c0 c0-"},
+		{shard: "-", n: 3, want: "-55 55-aa aa-"},
+		{shard: "40-80", n: 2, want: "40-60 60-80"},
+		{shard: "80-", n: 4, want: "80-a0 a0-c0 c0-e0 e0-"},
+		{shard: "40-41", n: 2, want: "40-4080 4080-41"},
+		{shard: "40-4001", n: 2, want: "40-400080 400080-4001"},
+		{shard: "-01", n: 256, want: ""},
+		{shard: "-", n: 0, err: "cannot split"},
+		{shard: "40-4000", n: 2, err: "cannot split"},
+	}
+	for _, tcase := range testcases {
+		t.Run(tcase.shard, func(t *testing.T) {
+			parts, err := KeyRangeSplit(mustParse(t, tcase.shard), tcase.n)
+			if tcase.err != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tcase.err)
+				return
+			}
+			require.NoError(t, err)
+			require.Len(t, parts, tcase.n)
+			if tcase.want != "" {
+				names := make([]string, len(parts))
+				for i, part := range parts {
+					names[i] = KeyRangeString(part)
+				}
+				assert.Equal(t, tcase.want, strings.Join(names, " "))
+			}
+		})
+	}
+
+	_, err := KeyRangeSplit(&topodatapb.KeyRange{Start: []byte{0x40}, End: []byte{0x40}}, 2)
+	assert.Error(t, err, "zero-length range")
+}
+
+func TestShardForKeyspaceID(t *testing.T) {
+	var shards []*topodatapb.KeyRange
+	for _, shard := range []string{"-40", "40-80", "80-c0", "c0-"} {
+		shards = append(shards, mustParse(t, shard))
+	}
+	testcases := []struct {
+		id   string
+		want int
+	}{
+		{id: "", want: 0},
+		{id: "3fffffff", want: 0},
+		{id: "40", want: 1},
+		{id: "4000", want: 1},
+		{id: "7f", want: 1},
+		{id: "80", want: 2},
+		{id: "c0", want: 3},
+		{id: "ffffffff", want: 3},
+	}
+	for _, tcase := range testcases {
+		got, err := ShardForKeyspaceID(shards, mustDecode(t, tcase.id))
+		require.NoError(t, err, tcase.id)
+		assert.Equal(t, tcase.want, got, "ShardForKeyspaceID(%v)", tcase.id)
+	}
+
+	// A gap in the shards.
+	_, err := ShardForKeyspaceID([]*topodatapb.KeyRange{shards[0], shards[2]}, []byte{0x50})
+	assert.Error(t, err)
+	_, err = ShardForKeyspaceID(nil, []byte{0x50})
+	assert.Error(t, err)
+	got, err := ShardForKeyspaceID([]*topodatapb.KeyRange{mustParse(t, "-")}, []byte{0x50})
+	require.NoError(t, err)
+	assert.Equal(t, 0, got)
+}
+
+// randomKeyRange returns a random KeyRange with short bounds, so they
+// often share prefixes or are equal.
+func randomKeyRange(r *rand.Rand) *topodatapb.KeyRange {
+	for {
+		kr := &topodatapb.KeyRange{Start: randomID(r), End: randomID(r)}
+		if !isEmpty(kr) {
+			return kr
+		}
+	}
+}
+
+func randomID(r *rand.Rand) []byte {
+	id := make([]byte, r.Intn(3))
+	for i := range id {
+		id[i] = []byte{0x00, 0x40, 0x80, 0xc0, 0xff}[r.Intn(5)]
+	}
+	return id
+}
+
+// TestKeyRangeProperties checks the algebraic identities between the
+// KeyRange operations on random KeyRanges and keyspace ids.
+func TestKeyRangeProperties(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 10000; i++ {
+		a, b := randomKeyRange(r), randomKeyRange(r)
+		id := randomID(r)
+		name := KeyRangeString(a) + " " + KeyRangeString(b) + " " + hex.EncodeToString(id)
+
+		// The intersection is commutative, and contains exactly the
+		// ids of both.
+		ab, okAB := KeyRangeIntersection(a, b)
+		ba, okBA := KeyRangeIntersection(b, a)
+		require.Equal(t, okAB, okBA, name)
+		if okAB {
+			require.True(t, KeyRangeEqual(ab, ba), name)
+			require.True(t, KeyRangeIncludes(a, ab) && KeyRangeIncludes(b, ab), name)
+		}
+		require.Equal(t, KeyRangeContains(a, id) && KeyRangeContains(b, id), okAB && KeyRangeContains(ab, id), name)
+
+		// The union, when there is one, contains exactly the ids of
+		// either.
+		if union, err := KeyRangeUnion(a, b); err == nil {
+			require.True(t, KeyRangeIncludes(union, a) && KeyRangeIncludes(union, b), name)
+			require.Equal(t, KeyRangeContains(a, id) || KeyRangeContains(b, id), KeyRangeContains(union, id), name)
+		} else {
+			require.False(t, okAB, name)
+		}
+
+		// Parsing the name of a KeyRange returns it.
+		parsed, err := ParseShardName(KeyRangeString(a))
+		require.NoError(t, err, name)
+		require.True(t, KeyRangeEqual(a, parsed), name)
+
+		// The parts of a split are adjacent, cover the range, and
+		// the id belongs to the one ShardForKeyspaceID returns.
+		parts, err := KeyRangeSplit(a, 1+r.Intn(10))
+		if err != nil {
+			// a only holds prefixes of its end.
+			require.True(t, bytes.Equal(bytes.TrimRight(a.End, "\x00"), bytes.TrimRight(a.Start, "\x00")), name)
+			parts = []*topodatapb.KeyRange{a}
+		}
+		require.True(t, bytes.Equal(a.Start, parts[0].Start), name)
+		require.True(t, bytes.Equal(a.End, parts[len(parts)-1].End), name)
+		for j := 1; j < len(parts); j++ {
+			require.True(t, bytes.Equal(parts[j-1].End, parts[j].Start), name)
+			require.False(t, isEmpty(parts[j]), name)
+		}
+		shard, err := ShardForKeyspaceID(parts, id)
+		if KeyRangeContains(a, id) {
+			require.NoError(t, err, name)
+			require.True(t, KeyRangeContains(parts[shard], id), name)
+		} else {
+			require.Error(t, err, name)
+		}
+	}
+}
+
+func FuzzParseShardName(f *testing.F) {
+	for _, seed := range []string{"-", "-80", "80-", "40-60", "40-40", "4g-", "--", ""} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, shard string) {
+		kr, err := ParseShardName(shard)
+		if err != nil {
+			return
+		}
+		if isEmpty(kr) {
+			t.Fatalf("ParseShardName(%q) returned an empty KeyRange", shard)
+		}
+		if got := KeyRangeString(kr); got != strings.ToLower(shard) {
+			t.Fatalf("KeyRangeString(ParseShardName(%q)) = %q", shard, got)
+		}
+	})
+}
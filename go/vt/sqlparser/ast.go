@@ -24,6 +24,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"strconv"
 	"strings"
 	"sync"
 	"unicode"
@@ -92,6 +93,35 @@ func Parse(sql string) (Statement, error) {
 	return parseTokenizer(sql, tokenizer)
 }
 
+// ParserOptions are the session settings that change how a query is
+// parsed.
+type ParserOptions struct {
+	// AnsiQuotes makes double quotes delimit identifiers instead of
+	// strings, as with the ANSI_QUOTES SQL mode.
+	AnsiQuotes bool
+}
+
+// ParserOptionsForSQLMode returns the ParserOptions matching the value
+// of the sql_mode system variable, a comma separated list of modes.
+func ParserOptionsForSQLMode(sqlMode string) ParserOptions {
+	var options ParserOptions
+	for _, mode := range strings.Split(sqlMode, ",") {
+		switch strings.ToUpper(strings.TrimSpace(mode)) {
+		case "ANSI_QUOTES", "ANSI":
+			options.AnsiQuotes = true
+		}
+	}
+	return options
+}
+
+// ParseWithOptions parses the SQL in full like Parse, using the given
+// options.
+func ParseWithOptions(sql string, options ParserOptions) (Statement, error) {
+	tokenizer := NewStringTokenizer(sql)
+	tokenizer.AnsiQuotes = options.AnsiQuotes
+	return parseTokenizer(sql, tokenizer)
+}
+
 // ParseOne parses the first SQL statement in the given string and returns the
 // index of the start of the next statement in |sql|. If there was only one
 // statement in |sql|, the value of the returned index will be |len(sql)|.
@@ -1974,6 +2004,9 @@ type DDL struct {
 	// DefaultSpec is set for SET / DROP DEFAULT operations
 	DefaultSpec *DefaultSpec
 
+	// AlterConstraintSpec is set for ALTER CHECK / ALTER CONSTRAINT operations
+	AlterConstraintSpec *AlterConstraintSpec
+
 	// TriggerSpec is set for CREATE / ALTER / DROP trigger operations
 	TriggerSpec *TriggerSpec
 
@@ -2294,6 +2327,8 @@ func (node *DDL) alterFormat(buf *TrackedBuffer) {
 		}
 	} else if node.DefaultSpec != nil {
 		buf.Myprintf(" %v", node.DefaultSpec)
+	} else if node.AlterConstraintSpec != nil {
+		buf.Myprintf(" %v", node.AlterConstraintSpec)
 	} else if node.AlterCollationSpec != nil {
 		if len(node.AlterCollationSpec.CharacterSet) > 0 {
 			buf.Myprintf(" character set %s", node.AlterCollationSpec.CharacterSet)
@@ -3213,6 +3248,35 @@ func (node *DefaultSpec) walkSubtree(visit Visit) error {
 	return Walk(visit, node.Column, node.Value)
 }
 
+// AlterConstraintSpec defines an ALTER CHECK / ALTER CONSTRAINT on a constraint, to enforce it or not.
+type AlterConstraintSpec struct {
+	Name string
+	// Check is set for ALTER CHECK, which only applies to check constraints.
+	Check    bool
+	Enforced bool
+}
+
+var _ SQLNode = (*AlterConstraintSpec)(nil)
+
+// Format formats the node.
+func (node *AlterConstraintSpec) Format(buf *TrackedBuffer) {
+	if node.Check {
+		buf.Myprintf("alter check %s", node.Name)
+	} else {
+		buf.Myprintf("alter constraint %s", node.Name)
+	}
+	if node.Enforced {
+		buf.Myprintf(" enforced")
+	} else {
+		buf.Myprintf(" not enforced")
+	}
+}
+
+// walkSubtree implements SQLNode.
+func (node *AlterConstraintSpec) walkSubtree(visit Visit) error {
+	return nil
+}
+
 // ConstraintDefinition describes a constraint in a CREATE TABLE statement
 type ConstraintDefinition struct {
 	Name    string
@@ -3879,6 +3943,26 @@ func (node *Over) isSimpleRef() bool {
 	return !node.NameRef.IsEmpty() && len(node.PartitionBy) == 0 && len(node.OrderBy) == 0 && node.Frame == nil
 }
 
+// Filter defines a FILTER (WHERE ...) clause on an aggregate function.
+type Filter struct {
+	Expr Expr
+}
+
+// Format formats the node.
+func (node *Filter) Format(buf *TrackedBuffer) {
+	if node == nil {
+		return
+	}
+	buf.Myprintf("filter (where %v)", node.Expr)
+}
+
+func (node *Filter) walkSubtree(visit Visit) error {
+	if node == nil {
+		return nil
+	}
+	return Walk(visit, node.Expr)
+}
+
 // Nextval defines the NEXT VALUE expression.
 type Nextval struct {
 	Expr Expr
@@ -4578,39 +4662,40 @@ type Expr interface {
 	SQLNode
 }
 
-func (*AndExpr) iExpr()           {}
-func (*OrExpr) iExpr()            {}
-func (*XorExpr) iExpr()           {}
-func (*NotExpr) iExpr()           {}
-func (*ParenExpr) iExpr()         {}
-func (*ComparisonExpr) iExpr()    {}
-func (*RangeCond) iExpr()         {}
-func (*IsExpr) iExpr()            {}
-func (*ExistsExpr) iExpr()        {}
-func (*SQLVal) iExpr()            {}
-func (*NullVal) iExpr()           {}
-func (BoolVal) iExpr()            {}
-func (*ColName) iExpr()           {}
-func (ValTuple) iExpr()           {}
-func (*Subquery) iExpr()          {}
-func (ListArg) iExpr()            {}
-func (*BinaryExpr) iExpr()        {}
-func (*UnaryExpr) iExpr()         {}
-func (*IntervalExpr) iExpr()      {}
-func (*CollateExpr) iExpr()       {}
-func (*FuncExpr) iExpr()          {}
-func (*TimestampFuncExpr) iExpr() {}
-func (*ExtractFuncExpr) iExpr()   {}
-func (*CurTimeFuncExpr) iExpr()   {}
-func (*CaseExpr) iExpr()          {}
-func (*ValuesFuncExpr) iExpr()    {}
-func (*ConvertExpr) iExpr()       {}
-func (*SubstrExpr) iExpr()        {}
-func (*TrimExpr) iExpr()          {}
-func (*ConvertUsingExpr) iExpr()  {}
-func (*MatchExpr) iExpr()         {}
-func (*GroupConcatExpr) iExpr()   {}
-func (*Default) iExpr()           {}
+func (*AndExpr) iExpr()              {}
+func (*OrExpr) iExpr()               {}
+func (*XorExpr) iExpr()              {}
+func (*NotExpr) iExpr()              {}
+func (*ParenExpr) iExpr()            {}
+func (*ComparisonExpr) iExpr()       {}
+func (*RangeCond) iExpr()            {}
+func (*IsExpr) iExpr()               {}
+func (*ExistsExpr) iExpr()           {}
+func (*SQLVal) iExpr()               {}
+func (*NullVal) iExpr()              {}
+func (BoolVal) iExpr()               {}
+func (*ColName) iExpr()              {}
+func (ValTuple) iExpr()              {}
+func (*Subquery) iExpr()             {}
+func (ListArg) iExpr()               {}
+func (*BinaryExpr) iExpr()           {}
+func (*UnaryExpr) iExpr()            {}
+func (*IntervalExpr) iExpr()         {}
+func (*CollateExpr) iExpr()          {}
+func (*FuncExpr) iExpr()             {}
+func (*TimestampFuncExpr) iExpr()    {}
+func (*ExtractFuncExpr) iExpr()      {}
+func (*WeightStringFuncExpr) iExpr() {}
+func (*CurTimeFuncExpr) iExpr()      {}
+func (*CaseExpr) iExpr()             {}
+func (*ValuesFuncExpr) iExpr()       {}
+func (*ConvertExpr) iExpr()          {}
+func (*SubstrExpr) iExpr()           {}
+func (*TrimExpr) iExpr()             {}
+func (*ConvertUsingExpr) iExpr()     {}
+func (*MatchExpr) iExpr()            {}
+func (*GroupConcatExpr) iExpr()      {}
+func (*Default) iExpr()              {}
 
 // ReplaceExpr finds the from expression from root
 // and replaces it with to. If from matches root,
@@ -4808,6 +4893,7 @@ const (
 	NotLikeStr           = "not like"
 	RegexpStr            = "regexp"
 	NotRegexpStr         = "not regexp"
+	SoundsLikeStr        = "sounds like"
 	JSONExtractOp        = "->"
 	JSONUnquoteExtractOp = "->>"
 )
@@ -4910,6 +4996,10 @@ const (
 	IsNotTrueStr  = "is not true"
 	IsFalseStr    = "is false"
 	IsNotFalseStr = "is not false"
+	// IS UNKNOWN is a synonym of IS NULL, but keeps its own
+	// spelling.
+	IsUnknownStr    = "is unknown"
+	IsNotUnknownStr = "is not unknown"
 )
 
 // Format formats the node.
@@ -5326,6 +5416,10 @@ const (
 	Utf32Str    = "_utf32 "
 	Utf8mb3Str  = "_utf8mb3 "
 	Utf8mb4Str  = "_utf8mb4 "
+
+	// NStringStr is the prefix of national character set string
+	// literals, as in N'string'. It is not followed by a space.
+	NStringStr = "N"
 )
 
 // Format formats the node.
@@ -5405,6 +5499,75 @@ func (node *ExtractFuncExpr) replace(from, to Expr) bool {
 	return false
 }
 
+// WeightStringFuncExpr represents a call to WEIGHT_STRING with an AS or
+// a LEVEL clause, like WEIGHT_STRING(<expr> AS CHAR(10) LEVEL 1-3).
+// Calls with only an argument are a FuncExpr.
+type WeightStringFuncExpr struct {
+	Name string
+	Expr Expr
+	// As is the type the argument is cast to, CHAR or BINARY, if any.
+	As *ConvertType
+	// Levels are the collation levels of the LEVEL clause, if any:
+	// a single range, or a list of levels.
+	Levels []*WeightStringLevel
+}
+
+// Format formats the node.
+func (node *WeightStringFuncExpr) Format(buf *TrackedBuffer) {
+	buf.Myprintf("%s(%v", node.Name, node.Expr)
+	if node.As != nil {
+		buf.Myprintf(" as %v", node.As)
+	}
+	for i, level := range node.Levels {
+		if i == 0 {
+			buf.Myprintf(" level ")
+		} else {
+			buf.Myprintf(", ")
+		}
+		level.Format(buf)
+	}
+	buf.Myprintf(")")
+}
+
+func (node *WeightStringFuncExpr) walkSubtree(visit Visit) error {
+	if node == nil {
+		return nil
+	}
+	return Walk(
+		visit,
+		node.Expr,
+		node.As,
+	)
+}
+
+func (node *WeightStringFuncExpr) replace(from, to Expr) bool {
+	return replaceExprs(from, to, &node.Expr)
+}
+
+// WeightStringLevel is a level, or a range of levels, in the LEVEL
+// clause of WEIGHT_STRING, like "2 desc" or "1-3 reverse".
+type WeightStringLevel struct {
+	From int
+	// To is the last level of a range, or 0 for a single level.
+	To      int
+	Desc    bool
+	Reverse bool
+}
+
+// Format formats the node.
+func (node *WeightStringLevel) Format(buf *TrackedBuffer) {
+	buf.WriteString(fmt.Sprintf("%d", node.From))
+	if node.To != 0 {
+		buf.WriteString(fmt.Sprintf("-%d", node.To))
+	}
+	if node.Desc {
+		buf.Myprintf(" %s", DescScr)
+	}
+	if node.Reverse {
+		buf.Myprintf(" reverse")
+	}
+}
+
 // TimestampFuncExpr represents the function and arguments for TIMESTAMP{ADD,DIFF} functions.
 type TimestampFuncExpr struct {
 	Name  string
@@ -5496,6 +5659,7 @@ type FuncExpr struct {
 	Name      ColIdent
 	Distinct  bool
 	Exprs     SelectExprs
+	Filter    *Filter
 	Over      *Over
 }
 
@@ -5513,6 +5677,9 @@ func (node *FuncExpr) Format(buf *TrackedBuffer) {
 	// name as is.
 	buf.Myprintf("%s(%s%v)", node.Name.String(), distinct, node.Exprs)
 
+	if node.Filter != nil {
+		buf.Myprintf(" %v", node.Filter)
+	}
 	if node.Over != nil {
 		buf.Myprintf(" %v", node.Over)
 	}
@@ -5527,6 +5694,7 @@ func (node *FuncExpr) walkSubtree(visit Visit) error {
 		node.Qualifier,
 		node.Name,
 		node.Exprs,
+		node.Filter,
 		node.Over,
 	)
 }
@@ -5541,6 +5709,9 @@ func (node *FuncExpr) replace(from, to Expr) bool {
 			return true
 		}
 	}
+	if node.Filter != nil && replaceExprs(from, to, &node.Filter.Expr) {
+		return true
+	}
 	return false
 }
 
@@ -5571,6 +5742,44 @@ func (node *FuncExpr) IsAggregate() bool {
 	return Aggregates[node.Name.Lowered()]
 }
 
+// FuncKind classifies the functions whose semantics query rewriters
+// need to recognize.
+type FuncKind int
+
+// FuncKind values
+const (
+	// GenericFunc is any function without a dedicated kind.
+	GenericFunc FuncKind = iota
+	// CoalesceFunc is COALESCE(expr, ...).
+	CoalesceFunc
+	// IfNullFunc is IFNULL(expr1, expr2).
+	IfNullFunc
+	// NullIfFunc is NULLIF(expr1, expr2).
+	NullIfFunc
+)
+
+// funcKinds maps lowered function names to their kind.
+var funcKinds = map[string]FuncKind{
+	"coalesce": CoalesceFunc,
+	"ifnull":   IfNullFunc,
+	"nullif":   NullIfFunc,
+}
+
+// Kind returns the kind of the function. Qualified names are user
+// defined functions, which are always generic.
+func (node *FuncExpr) Kind() FuncKind {
+	if !node.Qualifier.IsEmpty() {
+		return GenericFunc
+	}
+	return funcKinds[node.Name.Lowered()]
+}
+
+// IsNullHandling returns true if the function is one of COALESCE,
+// IFNULL or NULLIF.
+func (node *FuncExpr) IsNullHandling() bool {
+	return node.Kind() != GenericFunc
+}
+
 // GroupConcatExpr represents a call to GROUP_CONCAT
 type GroupConcatExpr struct {
 	Distinct  string
@@ -5968,6 +6177,24 @@ func (node GroupBy) walkSubtree(visit Visit) error {
 	return nil
 }
 
+// ColumnPosition returns the position expr refers to, if it is a
+// positional reference in GROUP BY or ORDER BY, like the 1 of
+// `order by 1`. Such references are integer literals, the position
+// is counted from 1 in the select expressions. The position is 0 if it
+// does not fit in an int. It returns false if expr is not an integer
+// literal.
+func ColumnPosition(expr Expr) (int, bool) {
+	val, ok := expr.(*SQLVal)
+	if !ok || val.Type != IntVal {
+		return 0, false
+	}
+	position, err := strconv.Atoi(string(val.Val))
+	if err != nil {
+		return 0, true
+	}
+	return position, true
+}
+
 // OrderBy represents an ORDER By clause.
 type OrderBy []*Order
 
@@ -6017,6 +6244,12 @@ func (node *Order) Format(buf *TrackedBuffer) {
 	buf.Myprintf("%v %s", node.Expr, node.Direction)
 }
 
+// ColumnPosition returns the position the order refers to, if it is
+// positional, see ColumnPosition.
+func (node *Order) ColumnPosition() (int, bool) {
+	return ColumnPosition(node.Expr)
+}
+
 func (node *Order) walkSubtree(visit Visit) error {
 	if node == nil {
 		return nil
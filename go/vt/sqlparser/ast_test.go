@@ -443,6 +443,215 @@ func TestIsAggregate(t *testing.T) {
 	}
 }
 
+func TestFuncKind(t *testing.T) {
+	testcases := []struct {
+		in   string
+		kind FuncKind
+	}{
+		{in: "select coalesce(a, b, 1) from t", kind: CoalesceFunc},
+		{in: "select COALESCE(a, b) from t", kind: CoalesceFunc},
+		{in: "select ifnull(a, 0) from t", kind: IfNullFunc},
+		{in: "select IfNull(a, 0) from t", kind: IfNullFunc},
+		{in: "select nullif(a, b) from t", kind: NullIfFunc},
+		{in: "select concat(a, b) from t", kind: GenericFunc},
+		{in: "select db.coalesce(a, b) from t", kind: GenericFunc},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.in, func(t *testing.T) {
+			stmt, err := Parse(tc.in)
+			require.NoError(t, err)
+			f := stmt.(*Select).SelectExprs[0].(*AliasedExpr).Expr.(*FuncExpr)
+			require.Equal(t, tc.kind, f.Kind())
+			require.Equal(t, tc.kind != GenericFunc, f.IsNullHandling())
+			require.Equal(t, tc.in, String(stmt))
+		})
+	}
+}
+
+func TestAggregateFilterWalk(t *testing.T) {
+	stmt, err := Parse("select count(*) filter (where a > 1) from t")
+	require.NoError(t, err)
+
+	var cols []string
+	err = Walk(func(node SQLNode) (bool, error) {
+		if col, ok := node.(*ColName); ok {
+			cols = append(cols, col.Name.String())
+		}
+		return true, nil
+	}, stmt)
+	require.NoError(t, err)
+	require.Equal(t, []string{"a"}, cols)
+}
+
+func TestExistsExpr(t *testing.T) {
+	stmt, err := Parse("select a from t1 where not exists (select b from t2 where t2.c = t1.a)")
+	require.NoError(t, err)
+
+	// NOT EXISTS is a NOT around the EXISTS predicate.
+	not, ok := stmt.(*Select).Where.Expr.(*NotExpr)
+	require.True(t, ok, "%T", stmt.(*Select).Where.Expr)
+	_, ok = not.Expr.(*ExistsExpr)
+	require.True(t, ok, "%T", not.Expr)
+
+	var cols []string
+	err = Walk(func(node SQLNode) (bool, error) {
+		if col, ok := node.(*ColName); ok {
+			cols = append(cols, String(col))
+		}
+		return true, nil
+	}, stmt.(*Select).Where)
+	require.NoError(t, err)
+	require.Equal(t, []string{"b", "t2.c", "t1.a"}, cols)
+}
+
+func TestUpdateJoin(t *testing.T) {
+	stmt, err := Parse("update t1 join t2 on t1.id = t2.id set t1.a = t2.b where t2.c = 1")
+	require.NoError(t, err)
+	upd := stmt.(*Update)
+
+	require.Len(t, upd.TableExprs, 1)
+	join, ok := upd.TableExprs[0].(*JoinTableExpr)
+	require.True(t, ok, "%T", upd.TableExprs[0])
+	require.Equal(t, JoinStr, join.Join)
+	require.Len(t, upd.Exprs, 1)
+	require.Equal(t, "t1", upd.Exprs[0].Name.Qualifier.Name.String())
+	require.Equal(t, "a", upd.Exprs[0].Name.Name.String())
+
+	var joins int
+	var cols []string
+	err = Walk(func(node SQLNode) (bool, error) {
+		switch node := node.(type) {
+		case *JoinTableExpr:
+			joins++
+		case *ColName:
+			cols = append(cols, String(node))
+		}
+		return true, nil
+	}, stmt)
+	require.NoError(t, err)
+	require.Equal(t, 1, joins)
+	require.Equal(t, []string{"t1.id", "t2.id", "t1.a", "t2.b", "t2.c"}, cols)
+}
+
+func TestDMLOrderByLimit(t *testing.T) {
+	for _, tc := range []struct {
+		in      string
+		orderBy string
+		limit   string
+		cols    []string
+	}{{
+		in:      "update t set a = 1 where b = 2 order by c asc, d desc limit 100",
+		orderBy: " order by c asc, d desc",
+		limit:   " limit 100",
+		cols:    []string{"a", "b", "c", "d"},
+	}, {
+		in:    "update t set a = 1 limit 100",
+		limit: " limit 100",
+		cols:  []string{"a"},
+	}, {
+		in:      "delete from t where b = 2 order by c asc, d desc limit 50",
+		orderBy: " order by c asc, d desc",
+		limit:   " limit 50",
+		cols:    []string{"b", "c", "d"},
+	}, {
+		in:    "delete from t where b limit 50",
+		limit: " limit 50",
+		cols:  []string{"b"},
+	}} {
+		t.Run(tc.in, func(t *testing.T) {
+			stmt, err := Parse(tc.in)
+			require.NoError(t, err)
+			require.Equal(t, tc.in, String(stmt))
+
+			var orderBy OrderBy
+			var limit *Limit
+			switch stmt := stmt.(type) {
+			case *Update:
+				orderBy, limit = stmt.OrderBy, stmt.Limit
+			case *Delete:
+				orderBy, limit = stmt.OrderBy, stmt.Limit
+			default:
+				t.Fatalf("unexpected statement %T", stmt)
+			}
+			require.Equal(t, tc.orderBy, String(orderBy))
+			require.Equal(t, tc.limit, String(limit))
+
+			// The ORDER BY expressions are walked.
+			var cols []string
+			err = Walk(func(node SQLNode) (bool, error) {
+				if col, ok := node.(*ColName); ok {
+					cols = append(cols, String(col))
+				}
+				return true, nil
+			}, stmt)
+			require.NoError(t, err)
+			require.Equal(t, tc.cols, cols)
+		})
+	}
+}
+
+func TestJoinUsing(t *testing.T) {
+	in := "select 1 from t1 join t2 using (a, b) left join t3 using (c, d)"
+	stmt, err := Parse(in)
+	require.NoError(t, err)
+	require.Equal(t, in, String(stmt))
+
+	join, ok := stmt.(*Select).From[0].(*JoinTableExpr)
+	require.True(t, ok, "%T", stmt.(*Select).From[0])
+	require.Nil(t, join.Condition.On)
+	require.Equal(t, Columns{NewColIdent("c"), NewColIdent("d")}, join.Condition.Using)
+
+	// The USING columns are the names of columns of both tables, they
+	// are walked as identifiers, not as column references.
+	var idents []string
+	err = Walk(func(node SQLNode) (bool, error) {
+		switch node := node.(type) {
+		case *ColName:
+			t.Errorf("unexpected column reference %v", String(node))
+		case ColIdent:
+			if !node.IsEmpty() {
+				idents = append(idents, node.String())
+			}
+		}
+		return true, nil
+	}, stmt.(*Select).From)
+	require.NoError(t, err)
+	require.Equal(t, []string{"a", "b", "c", "d"}, idents)
+}
+
+func TestColumnPosition(t *testing.T) {
+	in := "select a, b, c from t group by 1, b, 2 order by 3 desc, c asc, 1 asc, 'x' asc, 1.5 asc"
+	stmt, err := Parse(in)
+	require.NoError(t, err)
+	require.Equal(t, in, String(stmt))
+
+	sel := stmt.(*Select)
+	var groupBy []int
+	for _, expr := range sel.GroupBy {
+		position, ok := ColumnPosition(expr)
+		if !ok {
+			position = -1
+		}
+		groupBy = append(groupBy, position)
+	}
+	require.Equal(t, []int{1, -1, 2}, groupBy)
+
+	var orderBy []int
+	for _, order := range sel.OrderBy {
+		position, ok := order.ColumnPosition()
+		if !ok {
+			position = -1
+		}
+		orderBy = append(orderBy, position)
+	}
+	require.Equal(t, []int{3, -1, 1, -1, -1}, orderBy)
+
+	// Positions too large for an int are still positional.
+	position, ok := ColumnPosition(NewIntVal([]byte("99999999999999999999999")))
+	require.True(t, ok)
+	require.Equal(t, 0, position)
+}
+
 func TestIsImpossible(t *testing.T) {
 	f := ComparisonExpr{
 		Operator: NotEqualStr,
@@ -17,6 +17,8 @@ limitations under the License.
 package sqlparser
 
 import (
+	"encoding/hex"
+	"fmt"
 	"strconv"
 	"strings"
 	"unicode"
@@ -32,6 +34,9 @@ const (
 	DirectiveQueryTimeout = "QUERY_TIMEOUT_MS"
 	// DirectiveScatterErrorsAsWarnings enables partial success scatter select queries
 	DirectiveScatterErrorsAsWarnings = "SCATTER_ERRORS_AS_WARNINGS"
+	// DirectiveShardingKey routes a statement to the destination of a
+	// vindex value or of a raw keyspace id.
+	DirectiveShardingKey = "SHARDING_KEY"
 )
 
 func isNonSpace(r rune) bool {
@@ -315,3 +320,66 @@ func SkipQueryPlanCacheDirective(stmt Statement) bool {
 	}
 	return false
 }
+
+// shardingKeyKeyspaceID is the name used in a SHARDING_KEY directive
+// for a raw, hex encoded, keyspace id.
+const shardingKeyKeyspaceID = "keyspace_id"
+
+// ShardingKeyHint is the parsed value of a SHARDING_KEY directive. It
+// is either a vindex name and a value:
+//
+//     /*vt+ SHARDING_KEY=user_index:12345 */
+//
+// or a raw keyspace id, in hex:
+//
+//     /*vt+ SHARDING_KEY=keyspace_id:80a1 */
+type ShardingKeyHint struct {
+	// Vindex and Value are set for the vindex form.
+	Vindex string
+	Value  string
+
+	// KeyspaceID is set for the raw keyspace id form.
+	KeyspaceID []byte
+}
+
+// ExtractShardingKeyHint returns the SHARDING_KEY directive of a
+// SELECT, INSERT, UPDATE or DELETE statement, or nil if there is none.
+// It returns an error if the directive is malformed. Checking that the
+// vindex exists and that the value is valid for it is up to the caller.
+func ExtractShardingKeyHint(stmt Statement) (*ShardingKeyHint, error) {
+	var comments Comments
+	switch stmt := stmt.(type) {
+	case *Select:
+		comments = stmt.Comments
+	case *Insert:
+		comments = stmt.Comments
+	case *Update:
+		comments = stmt.Comments
+	case *Delete:
+		comments = stmt.Comments
+	default:
+		return nil, nil
+	}
+
+	val, ok := ExtractCommentDirectives(comments)[DirectiveShardingKey]
+	if !ok {
+		return nil, nil
+	}
+	strVal, ok := val.(string)
+	if !ok {
+		return nil, fmt.Errorf("invalid %s directive %v: expected <vindex>:<value>", DirectiveShardingKey, val)
+	}
+	name, value, ok := strings.Cut(strVal, ":")
+	if !ok || name == "" || value == "" {
+		return nil, fmt.Errorf("invalid %s directive %s: expected <vindex>:<value>", DirectiveShardingKey, strVal)
+	}
+
+	if name == shardingKeyKeyspaceID {
+		ksid, err := hex.DecodeString(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid keyspace id in %s directive %s: %v", DirectiveShardingKey, strVal, err)
+		}
+		return &ShardingKeyHint{KeyspaceID: ksid}, nil
+	}
+	return &ShardingKeyHint{Vindex: name, Value: value}, nil
+}
@@ -458,3 +458,46 @@ func TestSkipQueryPlanCacheDirective(t *testing.T) {
 		t.Errorf("d.SkipQueryPlanCacheDirective(stmt) should be true")
 	}
 }
+
+func TestExtractShardingKeyHint(t *testing.T) {
+	testCases := []struct {
+		sql  string
+		want *ShardingKeyHint
+		err  string
+	}{{
+		sql:  "select /*vt+ SHARDING_KEY=user_index:12345 */ * from users where denormalized = 1",
+		want: &ShardingKeyHint{Vindex: "user_index", Value: "12345"},
+	}, {
+		sql:  "update /*vt+ SHARDING_KEY=user_index:12345 */ users set name = 'a' where computed = 2",
+		want: &ShardingKeyHint{Vindex: "user_index", Value: "12345"},
+	}, {
+		sql:  "delete /*vt+ SHARDING_KEY=keyspace_id:80a1 */ from users",
+		want: &ShardingKeyHint{KeyspaceID: []byte{0x80, 0xa1}},
+	}, {
+		sql: "select * from users",
+	}, {
+		sql: "select /*vt+ SKIP_QUERY_PLAN_CACHE=1 */ * from users",
+	}, {
+		sql: "select /*vt+ SHARDING_KEY=12345 */ * from users",
+		err: "invalid SHARDING_KEY directive 12345: expected <vindex>:<value>",
+	}, {
+		sql: "select /*vt+ SHARDING_KEY=user_index: */ * from users",
+		err: "invalid SHARDING_KEY directive user_index:: expected <vindex>:<value>",
+	}, {
+		sql: "select /*vt+ SHARDING_KEY=keyspace_id:xyz */ * from users",
+		err: "invalid keyspace id in SHARDING_KEY directive keyspace_id:xyz: encoding/hex: invalid byte: U+0078 'x'",
+	}}
+	for _, tc := range testCases {
+		t.Run(tc.sql, func(t *testing.T) {
+			stmt, err := Parse(tc.sql)
+			require.NoError(t, err)
+			got, err := ExtractShardingKeyHint(stmt)
+			if tc.err != "" {
+				require.EqualError(t, err, tc.err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.want, got)
+		})
+	}
+}
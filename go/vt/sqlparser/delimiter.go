@@ -0,0 +1,156 @@
+/*
+Copyright 2019 The Vitess Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sqlparser
+
+import (
+	"fmt"
+	"strings"
+)
+
+// SplitStatements splits a script, like the mysql client runs it, into
+// its statements. They are separated by the delimiter, ';' at first,
+// which the DELIMITER directive changes, to write stored programs whose
+// bodies contain ';':
+//
+//	DELIMITER //
+//	CREATE PROCEDURE p() BEGIN SELECT 1; SELECT 2; END//
+//	DELIMITER ;
+//
+// As in the mysql client, a DELIMITER directive must start a statement,
+// and takes the rest of its line: the new delimiter is its first word.
+// The delimiter doesn't end a statement inside a quoted string, a
+// quoted identifier or a comment. The statements are returned without
+// the delimiter, and trimmed. The ones that are only comments are
+// dropped, and so are the directives.
+//
+// It returns an error if a quoted string, a quoted identifier or a
+// comment is not terminated, or if a directive has no delimiter.
+func SplitStatements(script string) ([]string, error) {
+	var statements []string
+	delimiter := ";"
+	// begin is the offset of the current statement, and hasContent is
+	// set once it has something else than spaces and comments.
+	begin := 0
+	hasContent := false
+	line := 1
+
+	for i := 0; i < len(script); {
+		if !hasContent && (i == 0 || script[i-1] == '\n') {
+			if newDelimiter, end, ok := parseDelimiterDirective(script[i:]); ok {
+				if newDelimiter == "" {
+					return nil, fmt.Errorf("DELIMITER without a delimiter at line %d", line)
+				}
+				delimiter = newDelimiter
+				line += strings.Count(script[i:i+end], "\n")
+				i += end
+				begin = i
+				continue
+			}
+		}
+
+		switch c := script[i]; {
+		case strings.HasPrefix(script[i:], delimiter):
+			if hasContent {
+				statements = append(statements, strings.TrimSpace(script[begin:i]))
+			}
+			i += len(delimiter)
+			begin = i
+			hasContent = false
+		case c == '\'' || c == '"' || c == '`':
+			end := quotedEnd(script[i:], c)
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated %c at line %d", c, line)
+			}
+			line += strings.Count(script[i:i+end], "\n")
+			i += end
+			hasContent = true
+		case c == '#' || strings.HasPrefix(script[i:], "--") && (i+2 == len(script) || isSpaceOrControl(script[i+2])):
+			end := strings.IndexByte(script[i:], '\n')
+			if end < 0 {
+				end = len(script) - i
+			}
+			i += end
+		case strings.HasPrefix(script[i:], "/*"):
+			end := strings.Index(script[i+2:], "*/")
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated comment at line %d", line)
+			}
+			line += strings.Count(script[i:i+2+end], "\n")
+			i += end + 4
+		default:
+			if c == '\n' {
+				line++
+			} else if !isSpaceOrControl(c) {
+				hasContent = true
+			}
+			i++
+		}
+	}
+	if hasContent {
+		statements = append(statements, strings.TrimSpace(script[begin:]))
+	}
+	return statements, nil
+}
+
+// parseDelimiterDirective parses the DELIMITER directive s starts with,
+// if any. It returns the new delimiter, and the length of the
+// directive, including its line end.
+func parseDelimiterDirective(s string) (delimiter string, end int, ok bool) {
+	directive := s
+	if i := strings.IndexByte(s, '\n'); i >= 0 {
+		directive = s[:i]
+		end = i + 1
+	} else {
+		end = len(s)
+	}
+	fields := strings.Fields(directive)
+	if len(fields) == 0 || !strings.EqualFold(fields[0], "delimiter") {
+		return "", 0, false
+	}
+	if len(fields) == 1 {
+		return "", end, true
+	}
+	return fields[1], end, true
+}
+
+// quotedEnd returns the length of the string quoted with quote s starts
+// with, including its quotes, or -1 if it is not terminated. A doubled
+// quote, or a quote escaped with a backslash outside of identifiers,
+// doesn't end it.
+func quotedEnd(s string, quote byte) int {
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			if quote != '`' {
+				i++
+			}
+		case quote:
+			if i+1 < len(s) && s[i+1] == quote {
+				i++
+				continue
+			}
+			return i + 1
+		}
+	}
+	return -1
+}
+
+// isSpaceOrControl returns whether c is a space or a control
+// character, which end the "--" of a comment.
+func isSpaceOrControl(c byte) bool {
+	return c <= ' '
+}
@@ -0,0 +1,133 @@
+/*
+Copyright 2019 The Vitess Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sqlparser
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSplitStatements(t *testing.T) {
+	testcases := []struct {
+		input  string
+		output []string
+	}{{
+		input:  "select 1",
+		output: []string{"select 1"},
+	}, {
+		input:  "select 1; select 2;\n",
+		output: []string{"select 1", "select 2"},
+	}, {
+		input:  "select ';', \";\", `;`, 'it''s;', 'a\\';' from t; select 2",
+		output: []string{"select ';', \";\", `;`, 'it''s;', 'a\\';' from t", "select 2"},
+	}, {
+		input:  "select 1 /* ; */ from t -- ;\n;# ;\nselect 2 --;",
+		output: []string{"select 1 /* ; */ from t -- ;", "# ;\nselect 2 --"},
+	}, {
+		input:  "-- only a comment;\n/* and another */;",
+		output: nil,
+	}, {
+		input: "create table t (id int);\n" +
+			"-- p inserts two rows\n" +
+			"DELIMITER //\n" +
+			"CREATE PROCEDURE p(x INT)\n" +
+			"BEGIN\n" +
+			"  INSERT INTO t VALUES (x);\n" +
+			"  INSERT INTO t VALUES (x + 1); -- end//\n" +
+			"END//\n" +
+			"delimiter ;\n" +
+			"call p(1);\n" +
+			"select '//' from t",
+		output: []string{
+			"create table t (id int)",
+			"CREATE PROCEDURE p(x INT)\nBEGIN\n  INSERT INTO t VALUES (x);\n  INSERT INTO t VALUES (x + 1); -- end//\nEND",
+			"call p(1)",
+			"select '//' from t",
+		},
+	}, {
+		input:  "DELIMITER $$\nselect 1; select 2$$\n  Delimiter   ;;  ignored\nselect 3;;select 4",
+		output: []string{"select 1; select 2", "select 3", "select 4"},
+	}, {
+		// A DELIMITER in the middle of a statement is part of it.
+		input:  "select 1\ndelimiter //\n;select 2",
+		output: []string{"select 1\ndelimiter //", "select 2"},
+	}}
+	for _, tcase := range testcases {
+		got, err := SplitStatements(tcase.input)
+		if err != nil {
+			t.Errorf("SplitStatements(%q) failed: %v", tcase.input, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tcase.output) {
+			t.Errorf("SplitStatements(%q):\n%q, want\n%q", tcase.input, got, tcase.output)
+		}
+	}
+}
+
+func TestSplitStatementsProcedure(t *testing.T) {
+	script := `
+DELIMITER //
+CREATE PROCEDURE p(x INT)
+BEGIN
+  SELECT x;
+  SELECT x + 1;
+END//
+DELIMITER ;
+CALL p(1);
+`
+	statements, err := SplitStatements(script)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(statements) != 2 {
+		t.Fatalf("SplitStatements: %q, want 2 statements", statements)
+	}
+	for _, statement := range statements {
+		if _, err := Parse(statement); err != nil {
+			t.Errorf("Parse(%q) failed: %v", statement, err)
+		}
+	}
+}
+
+func TestSplitStatementsErrors(t *testing.T) {
+	testcases := []struct {
+		input string
+		err   string
+	}{{
+		input: "select 'a;",
+		err:   "unterminated ' at line 1",
+	}, {
+		input: "select 1;\nselect \"a\n;",
+		err:   "unterminated \" at line 2",
+	}, {
+		input: "select `a``;",
+		err:   "unterminated ` at line 1",
+	}, {
+		input: "select 1;\n\n/* ;",
+		err:   "unterminated comment at line 3",
+	}, {
+		input: "select '\n';\nDELIMITER\nselect 1",
+		err:   "DELIMITER without a delimiter at line 3",
+	}}
+	for _, tcase := range testcases {
+		_, err := SplitStatements(tcase.input)
+		if err == nil || !strings.Contains(err.Error(), tcase.err) {
+			t.Errorf("SplitStatements(%q): %v, want %s", tcase.input, err, tcase.err)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+/*
+Copyright 2019 The Vitess Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sqlparser
+
+// CausesImplicitCommit returns true if MySQL commits the current
+// transaction before running the statement, see
+// https://dev.mysql.com/doc/refman/8.0/en/implicit-commit.html.
+// These are:
+// - DDL, except CREATE TEMPORARY TABLE.
+// - account management, like CREATE USER or GRANT.
+// - BEGIN, START TRANSACTION and LOCK TABLES.
+// - administration statements, like ANALYZE TABLE or FLUSH.
+// - replication control, like START REPLICA.
+//
+// UNLOCK TABLES and SET autocommit = 1 only commit depending on the
+// state of the session, they are reported as not committing: it is
+// safer to think a transaction is still open than the other way
+// around. COMMIT and ROLLBACK end transactions explicitly, and are not
+// reported either.
+func CausesImplicitCommit(stmt Statement) bool {
+	switch stmt := stmt.(type) {
+	case *DDL:
+		return !stmt.Temporary
+	case *DBDDL, *MultiAlterDDL:
+		return true
+	case *CreateUser, *RenameUser, *DropUser, *CreateRole, *DropRole,
+		*GrantPrivilege, *GrantRole, *GrantProxy,
+		*RevokePrivilege, *RevokeAllPrivileges, *RevokeRole, *RevokeProxy:
+		return true
+	case *Begin, *LockTables:
+		return true
+	case *Flush, *Analyze, *OtherAdmin:
+		return true
+	case *ChangeReplicationSource, *ChangeReplicationFilter, *StartReplica, *StopReplica, *ResetReplica:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,69 @@
+/*
+Copyright 2019 The Vitess Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sqlparser
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCausesImplicitCommit(t *testing.T) {
+	for _, tcase := range []struct {
+		sql  string
+		want bool
+	}{
+		{"create table t (a int)", true},
+		{"create temporary table t (a int)", false},
+		{"alter table t add column b int", true},
+		{"drop table t", true},
+		{"rename table t to u", true},
+		{"truncate table t", true},
+		{"create index i on t (a)", true},
+		{"create view v as select 1", true},
+		{"create database d", true},
+		{"drop database d", true},
+		{"create user 'u'@'%'", true},
+		{"grant select on t to 'u'@'%'", true},
+		{"revoke select on t from 'u'@'%'", true},
+		{"begin", true},
+		{"start transaction", true},
+		{"lock tables t read", true},
+		{"flush privileges", true},
+		{"analyze table t", true},
+		{"start replica", true},
+		{"select 1 from t", false},
+		{"insert into t values (1)", false},
+		{"update t set a = 1", false},
+		{"delete from t", false},
+		{"set @a = 1", false},
+		{"set autocommit = 1", false},
+		{"unlock tables", false},
+		{"commit", false},
+		{"rollback", false},
+		{"savepoint s", false},
+		{"show tables", false},
+		{"explain select 1", false},
+	} {
+		t.Run(tcase.sql, func(t *testing.T) {
+			stmt, err := Parse(tcase.sql)
+			require.NoError(t, err)
+			assert.Equal(t, tcase.want, CausesImplicitCommit(stmt))
+		})
+	}
+}
@@ -32,17 +32,36 @@ import (
 // us to identify vindex equality. Otherwise, every value is
 // treated as distinct.
 func Normalize(stmt Statement, bindVars map[string]*querypb.BindVariable, prefix string) {
+	NormalizeWithOptions(stmt, bindVars, prefix, NormalizerOptions{})
+}
+
+// NormalizerOptions change how Normalize rewrites a statement.
+type NormalizerOptions struct {
+	// PreserveCollate keeps the COLLATE clause of a string literal
+	// compared to an expression, by moving it to the other side of the
+	// comparison: "name = 'x' collate utf8mb4_bin" becomes
+	// "name collate utf8mb4_bin = :bv1". The collation changes what the
+	// comparison matches, it is kept in the query rather than hidden
+	// around a bind var.
+	PreserveCollate bool
+}
+
+// NormalizeWithOptions normalizes the statement like Normalize, using
+// the given options.
+func NormalizeWithOptions(stmt Statement, bindVars map[string]*querypb.BindVariable, prefix string, options NormalizerOptions) {
 	nz := newNormalizer(stmt, bindVars, prefix)
+	nz.preserveCollate = options.PreserveCollate
 	_ = Walk(nz.WalkStatement, stmt)
 }
 
 type normalizer struct {
-	stmt     Statement
-	bindVars map[string]*querypb.BindVariable
-	prefix   string
-	reserved map[string]struct{}
-	counter  int
-	vals     map[string]string
+	stmt            Statement
+	bindVars        map[string]*querypb.BindVariable
+	prefix          string
+	reserved        map[string]struct{}
+	counter         int
+	vals            map[string]string
+	preserveCollate bool
 }
 
 func newNormalizer(stmt Statement, bindVars map[string]*querypb.BindVariable, prefix string) *normalizer {
@@ -155,6 +174,9 @@ func (nz *normalizer) convertSQLVal(node *SQLVal) {
 // bind vars.
 func (nz *normalizer) convertComparison(node *ComparisonExpr) {
 	if node.Operator != InStr && node.Operator != NotInStr {
+		if nz.preserveCollate {
+			nz.moveCollate(node)
+		}
 		return
 	}
 	tupleVals, ok := node.Right.(ValTuple)
@@ -182,6 +204,39 @@ func (nz *normalizer) convertComparison(node *ComparisonExpr) {
 	node.Right = ListArg(append([]byte("::"), bvname...))
 }
 
+// moveCollate moves the COLLATE clause of a string literal compared to
+// an expression to that expression, so that the literal can be
+// converted to a bind var without losing the collation.
+func (nz *normalizer) moveCollate(node *ComparisonExpr) {
+	if collate, ok := collatedLiteral(node.Right); ok {
+		if _, ok := node.Left.(*CollateExpr); !ok {
+			node.Left = &CollateExpr{Expr: node.Left, Charset: collate.Charset}
+			node.Right = collate.Expr
+		}
+		return
+	}
+	if collate, ok := collatedLiteral(node.Left); ok {
+		if _, ok := node.Right.(*CollateExpr); !ok {
+			node.Right = &CollateExpr{Expr: node.Right, Charset: collate.Charset}
+			node.Left = collate.Expr
+		}
+	}
+}
+
+// collatedLiteral returns expr if it is a COLLATE clause on a string
+// literal.
+func collatedLiteral(expr Expr) (*CollateExpr, bool) {
+	collate, ok := expr.(*CollateExpr)
+	if !ok {
+		return nil, false
+	}
+	val, ok := collate.Expr.(*SQLVal)
+	if !ok || val.Type != StrVal {
+		return nil, false
+	}
+	return collate, true
+}
+
 func (nz *normalizer) sqlToBindvar(node SQLNode) *querypb.BindVariable {
 	if node, ok := node.(*SQLVal); ok {
 		var v sqltypes.Value
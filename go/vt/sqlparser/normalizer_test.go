@@ -188,6 +188,13 @@ func TestNormalize(t *testing.T) {
 		outbv: map[string]*querypb.BindVariable{
 			"bv1": sqltypes.TestBindVariable([]interface{}{1, []byte("2")}),
 		},
+	}, {
+		// COLLATE clause
+		in:      "select * from t where name = 'x' collate utf8mb4_bin",
+		outstmt: "select * from t where name = :bv1 collate utf8mb4_bin",
+		outbv: map[string]*querypb.BindVariable{
+			"bv1": sqltypes.BytesBindVariable([]byte("x")),
+		},
 	}}
 	for _, tc := range testcases {
 		stmt, err := Parse(tc.in)
@@ -207,6 +214,71 @@ func TestNormalize(t *testing.T) {
 	}
 }
 
+func TestNormalizePreserveCollate(t *testing.T) {
+	prefix := "bv"
+	testcases := []struct {
+		in      string
+		outstmt string
+		outbv   map[string]*querypb.BindVariable
+	}{{
+		in:      "select * from t where name = 'x' COLLATE utf8mb4_bin",
+		outstmt: "select * from t where name collate utf8mb4_bin = :bv1",
+		outbv: map[string]*querypb.BindVariable{
+			"bv1": sqltypes.BytesBindVariable([]byte("x")),
+		},
+	}, {
+		// literal on the left
+		in:      "select * from t where 'x' collate utf8mb4_bin < name",
+		outstmt: "select * from t where :bv1 < name collate utf8mb4_bin",
+		outbv: map[string]*querypb.BindVariable{
+			"bv1": sqltypes.BytesBindVariable([]byte("x")),
+		},
+	}, {
+		// values are still deduped
+		in:      "select * from t where name = 'x' collate utf8mb4_bin or nick like 'x'",
+		outstmt: "select * from t where name collate utf8mb4_bin = :bv1 or nick like :bv1",
+		outbv: map[string]*querypb.BindVariable{
+			"bv1": sqltypes.BytesBindVariable([]byte("x")),
+		},
+	}, {
+		// outside of a select
+		in:      "update t set a = 1 where name = 'x' collate utf8mb4_bin",
+		outstmt: "update t set a = :bv1 where name collate utf8mb4_bin = :bv2",
+		outbv: map[string]*querypb.BindVariable{
+			"bv1": sqltypes.Int64BindVariable(1),
+			"bv2": sqltypes.BytesBindVariable([]byte("x")),
+		},
+	}, {
+		// the other side has its own collation
+		in:      "select * from t where name collate utf8mb4_general_ci = 'x' collate utf8mb4_bin",
+		outstmt: "select * from t where name collate utf8mb4_general_ci = :bv1 collate utf8mb4_bin",
+		outbv: map[string]*querypb.BindVariable{
+			"bv1": sqltypes.BytesBindVariable([]byte("x")),
+		},
+	}, {
+		// only literals are moved
+		in:      "select * from t where name = nick collate utf8mb4_bin",
+		outstmt: "select * from t where name = nick collate utf8mb4_bin",
+		outbv:   map[string]*querypb.BindVariable{},
+	}}
+	for _, tc := range testcases {
+		stmt, err := Parse(tc.in)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		bv := make(map[string]*querypb.BindVariable)
+		NormalizeWithOptions(stmt, bv, prefix, NormalizerOptions{PreserveCollate: true})
+		outstmt := String(stmt)
+		if outstmt != tc.outstmt {
+			t.Errorf("Query:\n%s:\n%s, want\n%s", tc.in, outstmt, tc.outstmt)
+		}
+		if !reflect.DeepEqual(tc.outbv, bv) {
+			t.Errorf("Query:\n%s:\n%v, want\n%v", tc.in, bv, tc.outbv)
+		}
+	}
+}
+
 func TestGetBindVars(t *testing.T) {
 	stmt, err := Parse("select * from t where :v1 = :v2 and :v2 = :v3 and :v4 in ::v5")
 	if err != nil {
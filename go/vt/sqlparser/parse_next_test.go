@@ -54,6 +54,34 @@ func TestParseNextValid(t *testing.T) {
 	}
 }
 
+// TestParseNextFilterClause checks that FILTER (WHERE ...) is
+// recognized when it straddles the refills of the tokenizer buffer.
+func TestParseNextFilterClause(t *testing.T) {
+	queries := []string{
+		"select count(a) FILTER  ( WHERE b > 1) from t",
+		"select count(a) filter from t",
+	}
+	var want []string
+	for _, query := range queries {
+		tree, err := Parse(query)
+		require.NoError(t, err)
+		want = append(want, String(tree))
+	}
+
+	for offset := defaultBufSize - len(queries[0]); offset <= defaultBufSize; offset++ {
+		var sql bytes.Buffer
+		sql.WriteString(strings.Repeat(" ", offset))
+		sql.WriteString(strings.Join(queries, ";"))
+
+		tokens := NewTokenizer(&sql)
+		for i := range queries {
+			tree, err := ParseNext(tokens)
+			require.NoError(t, err, "offset %d", offset)
+			assert.Equal(t, want[i], String(tree), "offset %d", offset)
+		}
+	}
+}
+
 func TestIgnoreSpecialComments(t *testing.T) {
 	var sql bytes.Buffer
 	sql.WriteString(`SELECT 1;/*! ALTER TABLE foo DISABLE KEYS */;SELECT 2;`)
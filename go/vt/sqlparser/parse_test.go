@@ -294,6 +294,17 @@ var (
 			output: "select * from t1 where col in (select 1 union select 2)",
 		}, {
 			input: "select * from t1 where exists (select a from t2 union select b from t3)",
+		}, {
+			input: "select exists (select 1 from t2 where t2.a = t1.a) from t1",
+		}, {
+			input: "select not exists (select 1 from t2 where t2.a = t1.a) as missing from t1",
+		}, {
+			input: "select a from t1 group by a having exists (select 1 from t2 where t2.a = t1.a)",
+		}, {
+			input: "select a from t1 group by a having not exists (select 1 from t2 where t2.a = t1.a)",
+		}, {
+			input:  "select * from t1 where NOT EXISTS (select 1 from t2) and b = 1",
+			output: "select * from t1 where not exists (select 1 from t2) and b = 1",
 		}, {
 			input: "select /* distinct */ distinct 1 from t",
 		}, {
@@ -480,6 +491,11 @@ var (
 			input: "select /* join using */ 1 from t1 join t2 using (a)",
 		}, {
 			input: "select /* join using (a, b, c) */ 1 from t1 join t2 using (a, b, c)",
+		}, {
+			input:  "select /* inner join using */ 1 from t1 inner join t2 using (a, `b`) right outer join t3 using (c, d)",
+			output: "select /* inner join using */ 1 from t1 join t2 using (a, b) right join t3 using (c, d)",
+		}, {
+			input: "select /* nested join using */ 1 from (t1 join t2 using (a, b)) join t3 using (c)",
 		}, {
 			input: "with cte1 as (select a from b) select * from cte1",
 		}, {
@@ -575,6 +591,39 @@ var (
 			input: "select /* regexp */ 1 from t where a regexp b",
 		}, {
 			input: "select /* not regexp */ 1 from t where a not regexp b",
+		}, {
+			input: "select /* sounds like */ 1 from t where a sounds like b",
+		}, {
+			input:  "select /* sounds like */ 1 from t where a SOUNDS   LIKE 'foo' and b = 1",
+			output: "select /* sounds like */ 1 from t where a sounds like 'foo' and b = 1",
+		}, {
+			input:  "select /* sounds like */ a sounds like concat(b, c) as sounds from t",
+			output: "select /* sounds like */ a sounds like concat(b, c) as `sounds` from t",
+		}, {
+			input: "update /* sounds like */ t set sounds = 1 where not a sounds like b",
+		}, {
+			input:  "select /* sounds as identifier */ sounds, t.sounds from sounds as t where sounds = 1",
+			output: "select /* sounds as identifier */ `sounds`, t.`sounds` from `sounds` as t where `sounds` = 1",
+		}, {
+			input:  "create table sounds (sounds int)",
+			output: "create table `sounds` (\n\t`sounds` int\n)",
+		}, {
+			input:  "select /* sounds like on sounds */ sounds from t where sounds sounds like b",
+			output: "select /* sounds like on sounds */ `sounds` from t where `sounds` sounds like b",
+		}, {
+			input: "select /* regexp_like */ 1 from t where regexp_like(a, 'b')",
+		}, {
+			input: "select /* regexp_like match type */ 1 from t where REGEXP_LIKE(a, 'b', 'i')",
+		}, {
+			input: "select /* regexp_replace */ 1 from t where regexp_replace(a, 'b', 'c') = 'd'",
+		}, {
+			input: "select /* regexp_replace position */ 1 from t where regexp_replace(a, 'b', 'c', 2) = 'd'",
+		}, {
+			input: "select /* regexp_replace all args */ 1 from t where regexp_replace(a, 'b', 'c', 1, 0, 'c') = 'd'",
+		}, {
+			input: "select /* regexp_instr all args */ 1 from t where regexp_instr(a, 'b', 1, 2, 1, 'i') > 0",
+		}, {
+			input: "select /* regexp_substr all args */ 1 from t where regexp_substr(a, 'b', 1, 2, 'i') is not null",
 		}, {
 			input:  "select /* rlike */ 1 from t where a rlike b",
 			output: "select /* rlike */ 1 from t where a regexp b",
@@ -597,6 +646,20 @@ var (
 			input: "select /* is false */ 1 from t where a is false",
 		}, {
 			input: "select /* is not false */ 1 from t where a is not false",
+		}, {
+			input: "select /* is unknown */ 1 from t where a is unknown",
+		}, {
+			input: "select /* is not unknown */ 1 from t where a is not unknown",
+		}, {
+			input:  "select /* is true upper */ 1 from t where a IS NOT TRUE and b IS UNKNOWN",
+			output: "select /* is true upper */ 1 from t where a is not true and b is unknown",
+		}, {
+			input: "select /* bool is unknown */ 1 from t where a = b is not unknown",
+		}, {
+			input: "select a is true, b is not false, c is unknown from t",
+		}, {
+			input:  "select unknown from t where unknown is not unknown",
+			output: "select `unknown` from t where `unknown` is not unknown",
 		}, {
 			input: "select /* < */ 1 from t where a < b",
 		}, {
@@ -836,6 +899,8 @@ var (
 			input: "select /* float */ 0.1 from t",
 		}, {
 			input: "select /* group by */ 1 from t group by a",
+		}, {
+			input: "select /* positional group by */ a, b from t group by 2, 1",
 		}, {
 			input: "select /* having */ 1 from t having a = b",
 		}, {
@@ -847,6 +912,9 @@ var (
 			input: "select /* order by desc */ 1 from t order by a desc",
 		}, {
 			input: "select /* order by null */ 1 from t order by null",
+		}, {
+			input:  "select /* positional order by */ a, b from t order by 2, 1 desc",
+			output: "select /* positional order by */ a, b from t order by 2 asc, 1 desc",
 		}, {
 			input: "select /* limit a */ 1 from t limit 3",
 		}, {
@@ -1013,6 +1081,13 @@ var (
 			input: "update /* order */ a set b = 3 order by c desc",
 		}, {
 			input: "update /* limit */ a set b = 3 limit 100",
+		}, {
+			input:  "UPDATE t SET a=1 ORDER BY id LIMIT 100",
+			output: "update t set a = 1 order by id asc limit 100",
+		}, {
+			input: "update /* where order limit */ a set b = 3 where c = 1 order by d asc, e desc limit :v1",
+		}, {
+			input: "update /* ignore order limit */ ignore a set b = 3 order by c desc limit 1",
 		}, {
 			input: "update /* bool in update */ a set b = true",
 		}, {
@@ -1035,6 +1110,16 @@ var (
 		}, {
 			input:  "update foo f join bar b on f.name = b.name set f.id = b.id where b.name = 'test'",
 			output: "update foo as f join bar as b on f.name = b.name set f.id = b.id where b.name = 'test'",
+		}, {
+			input:  "UPDATE t1 JOIN t2 ON t1.id = t2.id SET t1.a = t2.b WHERE t2.c = 1",
+			output: "update t1 join t2 on t1.id = t2.id set t1.a = t2.b where t2.c = 1",
+		}, {
+			input: "update t1 left join t2 on t1.id = t2.id set t1.a = t2.b, t2.b = null where t2.id is null",
+		}, {
+			input: "update db1.t1 join db2.t2 using (id) set db1.t1.a = db2.t2.b",
+		}, {
+			input:  "update t1 inner join t2 on t1.id = t2.id join t3 on t2.id = t3.id set t1.a = t3.c",
+			output: "update t1 join t2 on t1.id = t2.id join t3 on t2.id = t3.id set t1.a = t3.c",
 		}, {
 			input: "update /* ignore */ ignore a set b = 3",
 		}, {
@@ -1047,6 +1132,13 @@ var (
 			input: "delete /* order */ from a order by b desc",
 		}, {
 			input: "delete /* limit */ from a limit 100",
+		}, {
+			input:  "DELETE FROM t WHERE x LIMIT 50",
+			output: "delete from t where x limit 50",
+		}, {
+			input: "delete /* where order limit */ from a where c = 1 order by d asc, e desc limit :v1",
+		}, {
+			input: "delete /* partition order limit */ from a partition (p1) where c = 1 order by d desc limit 10",
 		}, {
 			input: "delete a from a join b on a.id = b.id where b.name = 'test'",
 		}, {
@@ -1375,6 +1467,16 @@ var (
 			input: "alter table a drop foreign key fk_something",
 		}, {
 			input: "alter table a drop constraint b",
+		}, {
+			input: "alter table a alter check ch_1 enforced",
+		}, {
+			input: "alter table a alter check ch_1 not enforced",
+		}, {
+			input: "alter table a alter check status not enforced",
+		}, {
+			input: "alter table a alter constraint ch_1 enforced",
+		}, {
+			input: "alter table a alter constraint status not enforced",
 		}, {
 			input:  "alter table a drop id",
 			output: "alter table a drop column id",
@@ -1955,6 +2057,20 @@ var (
 		}, {
 			input:  "select 1 from t where foo = _utf8mb4'bar'",
 			output: "select 1 from t where foo = _utf8mb4 'bar'",
+		}, {
+			input:  "select 1 from t where BINARY col = 'bar'",
+			output: "select 1 from t where binary col = 'bar'",
+		}, {
+			input:  "select 1 from t where foo = _latin1'x'",
+			output: "select 1 from t where foo = _latin1 'x'",
+		}, {
+			input: "select 1 from t where foo = N'x'",
+		}, {
+			input:  "select 1 from t where foo = n'it''s'",
+			output: "select 1 from t where foo = N'it\\'s'",
+		}, {
+			input:  "insert into t values (N'a', _utf8mb4'b')",
+			output: "insert into t values (N'a', _utf8mb4 'b')",
 		}, {
 			input: "select match(a) against ('foo') from t",
 		}, {
@@ -2030,6 +2146,28 @@ var (
 			input: "select name, var_pop(a) over (partition by b) from t",
 		}, {
 			input: "select name, variance(a) over (partition by b) from t",
+		}, {
+			input:  "select count(*) FILTER (WHERE x > 0) from t",
+			output: "select count(*) filter (where x > 0) from t",
+		}, {
+			input: "select name, sum(distinct a) filter (where b = 1) from t group by name",
+		}, {
+			input: "select name, avg(a) filter (where b is not null) over (partition by b) from t",
+		}, {
+			input:  "select count(a) as filter from t",
+			output: "select count(a) as `filter` from t",
+		}, {
+			input:  "select count(a) filter from t",
+			output: "select count(a) as `filter` from t",
+		}, {
+			input:  "select count(a)filter(where b) from t",
+			output: "select count(a) filter (where b) from t",
+		}, {
+			input:  "insert into filter (filter) values (1)",
+			output: "insert into `filter`(`filter`) values (1)",
+		}, {
+			input:  "create table filter (filter int)",
+			output: "create table `filter` (\n\t`filter` int\n)",
 		}, {
 			input: "select name, cume_dist() over (partition by b) from t",
 		}, {
@@ -3376,6 +3514,43 @@ func TestParsingIdentifiersStartingWithNumbers(t *testing.T) {
 	}
 }
 
+func TestParseWithAnsiQuotes(t *testing.T) {
+	testCases := []struct {
+		input   string
+		sqlMode string
+		output  string
+	}{{
+		input:  `select * from t where "b" = 'c'`,
+		output: "select * from t where 'b' = 'c'",
+	}, {
+		input:   `select * from t where "b" = 'c'`,
+		sqlMode: "STRICT_TRANS_TABLES,ANSI_QUOTES",
+		output:  "select * from t where b = 'c'",
+	}, {
+		input:   `select * from "t" where "x""y" = 1`,
+		sqlMode: "ansi",
+		output:  "select * from t where `x\"y` = 1",
+	}, {
+		input:   `select * from t where a = 1 /*! and "b" = 2 */`,
+		sqlMode: "ANSI_QUOTES",
+		output:  "select * from t where a = 1 and b = 2",
+	}, {
+		input:   "select * from `t` where 'b' = 1",
+		sqlMode: "ANSI_QUOTES",
+		output:  "select * from t where 'b' = 1",
+	}}
+	for _, tc := range testCases {
+		t.Run(tc.sqlMode+" "+tc.input, func(t *testing.T) {
+			tree, err := ParseWithOptions(tc.input, ParserOptionsForSQLMode(tc.sqlMode))
+			require.NoError(t, err)
+			assert.Equal(t, tc.output, String(tree))
+		})
+	}
+
+	_, err := ParseWithOptions(`select * from t where a = "unterminated`, ParserOptions{AnsiQuotes: true})
+	require.Error(t, err)
+}
+
 func TestParseOne(t *testing.T) {
 	type tc struct {
 		input     string
@@ -4841,6 +5016,75 @@ func TestConvert(t *testing.T) {
 	}
 }
 
+func TestWeightString(t *testing.T) {
+	validSQL := []parseTest{{
+		input: "select weight_string(a) from t",
+	}, {
+		input: "select weight_string(a as char(10)) from t",
+	}, {
+		input:  "select WEIGHT_STRING(a AS CHAR(10)) from t",
+		output: "select WEIGHT_STRING(a as CHAR(10)) from t",
+	}, {
+		input:                      "select WEIGHT_STRING(a AS CHAR(10)) from t",
+		useSelectExpressionLiteral: true,
+	}, {
+		input: "select weight_string('abc' as binary(4)) from t",
+	}, {
+		input: "select weight_string(a as char(10) level 1-3) from t",
+	}, {
+		input:  "select weight_string(a level 1 desc, 2, 3 asc) from t",
+		output: "select weight_string(a level 1 desc, 2, 3) from t",
+	}, {
+		input:  "select weight_string('a' level 1 asc, 2 desc, 3 reverse)",
+		output: "select weight_string('a' level 1, 2 desc, 3 reverse)",
+	}, {
+		input: "select weight_string(a as binary(8) level 1 desc reverse, 2) from t",
+	}, {
+		input:  "select weight_string(a level 1 asc reverse) from t",
+		output: "select weight_string(a level 1 reverse) from t",
+	}, {
+		input: "select weight_string(a level 1-2 reverse) from t",
+	}, {
+		input: "select weight_string(a as char(3) level 2-4 desc) from t",
+	}, {
+		input:  "select reverse, reverse(a) from t",
+		output: "select `reverse`, reverse(a) from t",
+	}, {
+		input: "select weight_string(a, 'x') from t",
+	}, {
+		input:  "select weight_string from t",
+		output: "select `weight_string` from t",
+	}}
+
+	for _, tcase := range validSQL {
+		runParseTestCase(t, tcase)
+	}
+
+	invalidSQL := []struct {
+		input  string
+		output string
+	}{{
+		input:  "select weight_string(a as date) from t",
+		output: "syntax error at position 31 near 'date'",
+	}, {
+		input:  "select weight_string(a level) from t",
+		output: "syntax error at position 30 near 'level'",
+	}, {
+		input:  "select weight_string(a level 1-2, 3) from t",
+		output: "syntax error at position 34 near '2'",
+	}, {
+		input:  "select weight_string(a level 1 reverse desc) from t",
+		output: "syntax error at position 44 near 'desc'",
+	}}
+
+	for _, tcase := range invalidSQL {
+		_, err := Parse(tcase.input)
+		if err == nil || err.Error() != tcase.output {
+			t.Errorf("%s: %v, want %s", tcase.input, err, tcase.output)
+		}
+	}
+}
+
 func TestSubStr(t *testing.T) {
 
 	// various substring forms get parsed correctly
@@ -7531,3 +7775,53 @@ func BenchmarkParse3(b *testing.B) {
 		}
 	}
 }
+
+// largeInQuery returns a query with an IN list of n values.
+func largeInQuery(n int) string {
+	var buf strings.Builder
+	buf.WriteString("select a from t where id in (")
+	for i := 0; i < n; i++ {
+		if i > 0 {
+			buf.WriteString(", ")
+		}
+		switch i % 4 {
+		case 0, 1:
+			fmt.Fprintf(&buf, "%d", i)
+		case 2:
+			fmt.Fprintf(&buf, "%d.5", i)
+		case 3:
+			fmt.Fprintf(&buf, "'v%d'", i)
+		}
+	}
+	buf.WriteString(")")
+	return buf.String()
+}
+
+func TestParseLargeIn(t *testing.T) {
+	sql := largeInQuery(10000)
+	tree, err := Parse(sql)
+	require.NoError(t, err)
+	assert.Equal(t, sql, String(tree))
+	values := tree.(*Select).Where.Expr.(*ComparisonExpr).Right.(ValTuple)
+	require.Len(t, values, 10000)
+	assert.Equal(t, NewIntVal([]byte("9996")), values[9996])
+	assert.Equal(t, NewFloatVal([]byte("9998.5")), values[9998])
+	assert.Equal(t, NewStrVal([]byte("v9999")), values[9999])
+
+	// Parsing another query leaves the values of the first one alone.
+	tree2, err := Parse("select a from t where id in (1, 2)")
+	require.NoError(t, err)
+	assert.Equal(t, sql, String(tree))
+	assert.Equal(t, "select a from t where id in (1, 2)", String(tree2))
+}
+
+func BenchmarkParseLargeIn(b *testing.B) {
+	sql := largeInQuery(100000)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := Parse(sql); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
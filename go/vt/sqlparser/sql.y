@@ -135,6 +135,8 @@ func yySpecialCommentMode(yylex interface{}) bool {
   flushOption   *FlushOption
   replicationOption *ReplicationOption
   replicationOptions []*ReplicationOption
+  weightStringLevel *WeightStringLevel
+  weightStringLevels []*WeightStringLevel
   indexColumn   *IndexColumn
   indexColumns  []*IndexColumn
   constraintDefinition *ConstraintDefinition
@@ -190,6 +192,7 @@ func yySpecialCommentMode(yylex interface{}) bool {
   with *With
   window Window
   over *Over
+  filter *Filter
   windowDef *WindowDef
   eventName EventName
   eventScheduleSpec *EventScheduleSpec
@@ -213,8 +216,8 @@ func yySpecialCommentMode(yylex interface{}) bool {
 %left <bytes> JOIN STRAIGHT_JOIN LEFT RIGHT INNER OUTER CROSS NATURAL USE FORCE
 %left <bytes> ON USING
 %token <empty> '(' ',' ')' '@' ':'
-%token <bytes> ID HEX STRING INTEGRAL FLOAT HEXNUM VALUE_ARG LIST_ARG COMMENT COMMENT_KEYWORD BIT_LITERAL
-%token <bytes> NULL TRUE FALSE OFF
+%token <bytes> ID HEX STRING NCHAR_STRING INTEGRAL FLOAT HEXNUM VALUE_ARG LIST_ARG COMMENT COMMENT_KEYWORD BIT_LITERAL
+%token <bytes> NULL TRUE FALSE UNKNOWN OFF
 %right <bytes> INTO
 
 // Precedence dictated by mysql. But the vitess grammar is simplified.
@@ -226,7 +229,8 @@ func yySpecialCommentMode(yylex interface{}) bool {
 %left <bytes> AND
 %right <bytes> NOT '!'
 %left <bytes> BETWEEN CASE WHEN THEN ELSE ELSEIF END
-%left <bytes> '=' '<' '>' LE GE NE NULL_SAFE_EQUAL IS LIKE REGEXP IN
+%nonassoc LOWER_THAN_SOUNDS
+%left <bytes> '=' '<' '>' LE GE NE NULL_SAFE_EQUAL IS LIKE REGEXP IN SOUNDS
 %nonassoc  UNBOUNDED // ideally should have same precedence as IDENT
 %nonassoc ID NULL PARTITION RANGE ROWS GROUPS PRECEDING FOLLOWING
 %left <bytes> '|'
@@ -330,10 +334,12 @@ func yySpecialCommentMode(yylex interface{}) bool {
 %token <bytes> SUBSTR SUBSTRING
 %token <bytes> TRIM LEADING TRAILING BOTH
 %token <bytes> GROUP_CONCAT SEPARATOR
-%token <bytes> TIMESTAMPADD TIMESTAMPDIFF EXTRACT
+%token <bytes> TIMESTAMPADD TIMESTAMPDIFF EXTRACT WEIGHT_STRING REVERSE
 
 // Window functions
 %token <bytes> OVER WINDOW GROUPING GROUPS
+// FILTER_CLAUSE is FILTER followed by "(WHERE", see Tokenizer.filterClauseFollows.
+%token <bytes> FILTER_CLAUSE
 %token <bytes> CURRENT ROWS RANGE
 %token <bytes> AVG BIT_AND BIT_OR BIT_XOR COUNT JSON_ARRAYAGG JSON_OBJECTAGG MAX MIN STDDEV_POP STDDEV STD STDDEV_SAMP
 %token <bytes> SUM VAR_POP VARIANCE VAR_SAMP CUME_DIST DENSE_RANK FIRST_VALUE LAG LAST_VALUE LEAD NTH_VALUE NTILE
@@ -448,7 +454,7 @@ func yySpecialCommentMode(yylex interface{}) bool {
 %type <expr> where_expression_opt
 %type <expr> condition
 %type <boolVal> boolean_value
-%type <boolean> all_opt enforced_opt
+%type <boolean> all_opt enforced_opt enforced
 %type <str> compare
 %type <ins> insert_data
 %type <expr> value value_expression num_val as_of_opt integral_or_value_arg integral_or_interval_expr timestamp_value
@@ -472,6 +478,7 @@ func yySpecialCommentMode(yylex interface{}) bool {
 %type <triggerOrder> trigger_order_opt
 %type <order> order
 %type <over> over over_opt
+%type <filter> filter_opt
 %type <window> window window_opt
 %type <windowDef> window_definition window_spec
 %type <frame> frame_opt
@@ -514,7 +521,9 @@ func yySpecialCommentMode(yylex interface{}) bool {
 %type <tableIdent> table_id reserved_table_id table_alias
 %type <str> charset underscore_charsets
 %type <str> show_session_or_global
-%type <convertType> convert_type
+%type <convertType> convert_type weight_string_type
+%type <weightStringLevels> weight_string_levels_opt weight_string_levels weight_string_level_list
+%type <weightStringLevel> weight_string_level weight_string_level_modifier_opt
 %type <columnType> column_type  column_type_options
 %type <columnType> int_type decimal_type numeric_type time_type char_type spatial_type
 %type <sqlVal> char_length_opt length_opt column_comment ignore_number_opt comment_keyword_opt
@@ -3891,7 +3900,13 @@ enforced_opt:
   {
     $$ = true
   }
-| ENFORCED
+| enforced
+  {
+    $$ = $1
+  }
+
+enforced:
+  ENFORCED
   {
     $$ = true
   }
@@ -4408,6 +4423,22 @@ alter_table_statement_part:
   {
     $$ = &DDL{Action: AlterStr, DefaultSpec: &DefaultSpec{Action: DropStr, Column: $3}}
   }
+| ALTER CHECK ID enforced
+  {
+    $$ = &DDL{Action: AlterStr, ConstraintAction: AlterStr, AlterConstraintSpec: &AlterConstraintSpec{Name: string($3), Check: true, Enforced: $4}}
+  }
+| ALTER CHECK all_non_reserved enforced
+  {
+    $$ = &DDL{Action: AlterStr, ConstraintAction: AlterStr, AlterConstraintSpec: &AlterConstraintSpec{Name: string($3), Check: true, Enforced: $4}}
+  }
+| ALTER CONSTRAINT ID enforced
+  {
+    $$ = &DDL{Action: AlterStr, ConstraintAction: AlterStr, AlterConstraintSpec: &AlterConstraintSpec{Name: string($3), Enforced: $4}}
+  }
+| ALTER CONSTRAINT all_non_reserved enforced
+  {
+    $$ = &DDL{Action: AlterStr, ConstraintAction: AlterStr, AlterConstraintSpec: &AlterConstraintSpec{Name: string($3), Enforced: $4}}
+  }
 | DROP PRIMARY KEY
   {
     $$ = &DDL{Action: AlterStr, IndexSpec: &IndexSpec{Action: DropStr, Type: PrimaryStr}}
@@ -5306,6 +5337,18 @@ over_opt:
     $$ = $1
   }
 
+// FILTER is a non-reserved keyword, so the tokenizer only returns
+// FILTER_CLAUSE when it is followed by "(WHERE", and an aggregate can
+// still be aliased as filter without AS.
+filter_opt:
+  {
+    $$ = nil
+  }
+| FILTER_CLAUSE openb WHERE expression closeb
+  {
+    $$ = &Filter{Expr: $4}
+  }
+
 frame_opt:
   {
     $$ = nil
@@ -5963,7 +6006,11 @@ expression:
   {
     $$ = &IsExpr{Operator: $3, Expr: $1}
   }
-| value_expression
+// SOUNDS is a non-reserved keyword, so an expression followed by SOUNDS
+// could also be followed by an alias. The %prec override below makes the
+// parser choose SOUNDS LIKE, as MySQL does, so an expression can only be
+// aliased as sounds with an explicit AS.
+| value_expression %prec LOWER_THAN_SOUNDS
   {
     $$ = $1
   }
@@ -6017,6 +6064,10 @@ condition:
   {
     $$ = &ComparisonExpr{Left: $1, Operator: RegexpStr, Right: $3}
   }
+| value_expression SOUNDS LIKE value_expression
+  {
+    $$ = &ComparisonExpr{Left: $1, Operator: SoundsLikeStr, Right: $4}
+  }
 | value_expression NOT REGEXP value_expression
   {
     $$ = &ComparisonExpr{Left: $1, Operator: NotRegexpStr, Right: $4}
@@ -6059,6 +6110,14 @@ is_suffix:
   {
     $$ = IsNotFalseStr
   }
+| UNKNOWN
+  {
+    $$ = IsUnknownStr
+  }
+| NOT UNKNOWN
+  {
+    $$ = IsNotUnknownStr
+  }
 
 compare:
   '='
@@ -6321,73 +6380,73 @@ function_call_generic:
    OVER clause (not legal on any other non-window, non-aggregate function)
  */
 function_call_aggregate_with_window:
- MAX openb distinct_opt argument_expression_list closeb over_opt
+ MAX openb distinct_opt argument_expression_list closeb filter_opt over_opt
   {
-    $$ = &FuncExpr{Name: NewColIdent(string($1)), Exprs: $4, Distinct: $3 == DistinctStr, Over: $6}
+    $$ = &FuncExpr{Name: NewColIdent(string($1)), Exprs: $4, Distinct: $3 == DistinctStr, Filter: $6, Over: $7}
   }
-| AVG openb distinct_opt argument_expression_list closeb over_opt
+| AVG openb distinct_opt argument_expression_list closeb filter_opt over_opt
   {
-    $$ = &FuncExpr{Name: NewColIdent(string($1)), Exprs: $4, Distinct: $3 == DistinctStr, Over: $6}
+    $$ = &FuncExpr{Name: NewColIdent(string($1)), Exprs: $4, Distinct: $3 == DistinctStr, Filter: $6, Over: $7}
   }
-| BIT_AND openb argument_expression_list closeb over_opt
+| BIT_AND openb argument_expression_list closeb filter_opt over_opt
   {
-    $$ = &FuncExpr{Name: NewColIdent(string($1)), Exprs: $3, Over: $5}
+    $$ = &FuncExpr{Name: NewColIdent(string($1)), Exprs: $3, Filter: $5, Over: $6}
   }
-| BIT_OR openb argument_expression_list closeb over_opt
+| BIT_OR openb argument_expression_list closeb filter_opt over_opt
   {
-    $$ = &FuncExpr{Name: NewColIdent(string($1)), Exprs: $3, Over: $5}
+    $$ = &FuncExpr{Name: NewColIdent(string($1)), Exprs: $3, Filter: $5, Over: $6}
   }
-| BIT_XOR openb argument_expression_list closeb over_opt
+| BIT_XOR openb argument_expression_list closeb filter_opt over_opt
   {
-    $$ = &FuncExpr{Name: NewColIdent(string($1)), Exprs: $3, Over: $5}
+    $$ = &FuncExpr{Name: NewColIdent(string($1)), Exprs: $3, Filter: $5, Over: $6}
   }
-| COUNT openb distinct_opt argument_expression_list closeb over_opt
+| COUNT openb distinct_opt argument_expression_list closeb filter_opt over_opt
   {
-    $$ = &FuncExpr{Name: NewColIdent(string($1)), Exprs: $4, Distinct: $3 == DistinctStr, Over: $6}
+    $$ = &FuncExpr{Name: NewColIdent(string($1)), Exprs: $4, Distinct: $3 == DistinctStr, Filter: $6, Over: $7}
   }
-| JSON_ARRAYAGG openb argument_expression_list closeb over_opt
+| JSON_ARRAYAGG openb argument_expression_list closeb filter_opt over_opt
   {
-    $$ = &FuncExpr{Name: NewColIdent(string($1)), Exprs: $3, Over: $5}
+    $$ = &FuncExpr{Name: NewColIdent(string($1)), Exprs: $3, Filter: $5, Over: $6}
   }
-| JSON_OBJECTAGG openb argument_expression_list closeb over_opt
+| JSON_OBJECTAGG openb argument_expression_list closeb filter_opt over_opt
   {
-    $$ = &FuncExpr{Name: NewColIdent(string($1)), Exprs: $3, Over: $5}
+    $$ = &FuncExpr{Name: NewColIdent(string($1)), Exprs: $3, Filter: $5, Over: $6}
   }
-| MIN openb distinct_opt argument_expression_list closeb over_opt
+| MIN openb distinct_opt argument_expression_list closeb filter_opt over_opt
   {
-    $$ = &FuncExpr{Name: NewColIdent(string($1)), Exprs: $4, Distinct: $3 == DistinctStr, Over: $6}
+    $$ = &FuncExpr{Name: NewColIdent(string($1)), Exprs: $4, Distinct: $3 == DistinctStr, Filter: $6, Over: $7}
   }
-| STDDEV_POP openb argument_expression_list closeb over_opt
+| STDDEV_POP openb argument_expression_list closeb filter_opt over_opt
   {
-    $$ = &FuncExpr{Name: NewColIdent(string($1)), Exprs: $3, Over: $5}
+    $$ = &FuncExpr{Name: NewColIdent(string($1)), Exprs: $3, Filter: $5, Over: $6}
   }
-| STDDEV openb argument_expression_list closeb over_opt
+| STDDEV openb argument_expression_list closeb filter_opt over_opt
   {
-    $$ = &FuncExpr{Name: NewColIdent(string($1)), Exprs: $3, Over: $5}
+    $$ = &FuncExpr{Name: NewColIdent(string($1)), Exprs: $3, Filter: $5, Over: $6}
   }
-| STD openb argument_expression_list closeb over_opt
+| STD openb argument_expression_list closeb filter_opt over_opt
   {
-    $$ = &FuncExpr{Name: NewColIdent(string($1)), Exprs: $3, Over: $5}
+    $$ = &FuncExpr{Name: NewColIdent(string($1)), Exprs: $3, Filter: $5, Over: $6}
   }
-| STDDEV_SAMP openb argument_expression_list closeb over_opt
+| STDDEV_SAMP openb argument_expression_list closeb filter_opt over_opt
   {
-    $$ = &FuncExpr{Name: NewColIdent(string($1)), Exprs: $3, Over: $5}
+    $$ = &FuncExpr{Name: NewColIdent(string($1)), Exprs: $3, Filter: $5, Over: $6}
   }
-| SUM openb distinct_opt argument_expression_list closeb over_opt
+| SUM openb distinct_opt argument_expression_list closeb filter_opt over_opt
   {
-    $$ = &FuncExpr{Name: NewColIdent(string($1)), Exprs: $4, Distinct: $3 == DistinctStr, Over: $6}
+    $$ = &FuncExpr{Name: NewColIdent(string($1)), Exprs: $4, Distinct: $3 == DistinctStr, Filter: $6, Over: $7}
   }
-| VAR_POP openb argument_expression_list closeb over_opt
+| VAR_POP openb argument_expression_list closeb filter_opt over_opt
   {
-    $$ = &FuncExpr{Name: NewColIdent(string($1)), Exprs: $3, Over: $5}
+    $$ = &FuncExpr{Name: NewColIdent(string($1)), Exprs: $3, Filter: $5, Over: $6}
   }
-| VARIANCE openb argument_expression_list closeb over_opt
+| VARIANCE openb argument_expression_list closeb filter_opt over_opt
   {
-    $$ = &FuncExpr{Name: NewColIdent(string($1)), Exprs: $3, Over: $5}
+    $$ = &FuncExpr{Name: NewColIdent(string($1)), Exprs: $3, Filter: $5, Over: $6}
   }
-| VAR_SAMP openb argument_expression_list closeb over_opt
+| VAR_SAMP openb argument_expression_list closeb filter_opt over_opt
   {
-    $$ = &FuncExpr{Name: NewColIdent(string($1)), Exprs: $3, Over: $5}
+    $$ = &FuncExpr{Name: NewColIdent(string($1)), Exprs: $3, Filter: $5, Over: $6}
   }
 
 /*
@@ -6637,6 +6696,90 @@ function_call_nonkeyword:
   {
     $$ = &ExtractFuncExpr{Name: string($1), Unit: string($3), Expr: $5}
   }
+| WEIGHT_STRING openb argument_expression_list_opt closeb
+  {
+    $$ = &FuncExpr{Name: NewColIdent(string($1)), Exprs: $3}
+  }
+| WEIGHT_STRING openb expression AS weight_string_type weight_string_levels_opt closeb
+  {
+    $$ = &WeightStringFuncExpr{Name: string($1), Expr: $3, As: $5, Levels: $6}
+  }
+| WEIGHT_STRING openb expression LEVEL weight_string_levels closeb
+  {
+    $$ = &WeightStringFuncExpr{Name: string($1), Expr: $3, Levels: $5}
+  }
+
+weight_string_type:
+  CHAR length_opt
+  {
+    $$ = &ConvertType{Type: string($1), Length: $2}
+  }
+| BINARY length_opt
+  {
+    $$ = &ConvertType{Type: string($1), Length: $2}
+  }
+
+weight_string_levels_opt:
+  {
+    $$ = nil
+  }
+| LEVEL weight_string_levels
+  {
+    $$ = $2
+  }
+
+// The levels of WEIGHT_STRING are a range, or a list of levels, each
+// with an optional order and REVERSE.
+weight_string_levels:
+  INTEGRAL '-' INTEGRAL weight_string_level_modifier_opt
+  {
+    $4.From = mustAtoi(yylex, string($1))
+    $4.To = mustAtoi(yylex, string($3))
+    $$ = []*WeightStringLevel{$4}
+  }
+| weight_string_level_list
+
+weight_string_level_list:
+  weight_string_level
+  {
+    $$ = []*WeightStringLevel{$1}
+  }
+| weight_string_level_list ',' weight_string_level
+  {
+    $$ = append($1, $3)
+  }
+
+weight_string_level:
+  INTEGRAL weight_string_level_modifier_opt
+  {
+    $2.From = mustAtoi(yylex, string($1))
+    $$ = $2
+  }
+
+weight_string_level_modifier_opt:
+  {
+    $$ = &WeightStringLevel{}
+  }
+| ASC
+  {
+    $$ = &WeightStringLevel{}
+  }
+| DESC
+  {
+    $$ = &WeightStringLevel{Desc: true}
+  }
+| REVERSE
+  {
+    $$ = &WeightStringLevel{Reverse: true}
+  }
+| ASC REVERSE
+  {
+    $$ = &WeightStringLevel{Reverse: true}
+  }
+| DESC REVERSE
+  {
+    $$ = &WeightStringLevel{Desc: true, Reverse: true}
+  }
 
 // Optional parens for certain keyword functions that don't require them.
 func_parens_opt:
@@ -7041,7 +7184,11 @@ column_name:
 value:
   STRING
   {
-    $$ = NewStrVal($1)
+    $$ = yylex.(*Tokenizer).newSQLVal(StrVal, $1)
+  }
+| NCHAR_STRING
+  {
+    $$ = &UnaryExpr{Operator: NStringStr, Expr: NewStrVal($1)}
   }
 | HEX
   {
@@ -7053,11 +7200,11 @@ value:
   }
 | INTEGRAL
   {
-    $$ = NewIntVal($1)
+    $$ = yylex.(*Tokenizer).newSQLVal(IntVal, $1)
   }
 | FLOAT
   {
-    $$ = NewFloatVal($1)
+    $$ = yylex.(*Tokenizer).newSQLVal(FloatVal, $1)
   }
 | HEXNUM
   {
@@ -8501,6 +8648,7 @@ non_reserved_keyword:
 | RESTART
 | RETAIN
 | REUSE
+| REVERSE
 | ROLE
 | ROLLBACK
 | ROUTINE
@@ -8523,6 +8671,7 @@ non_reserved_keyword:
 | SKIP
 | SLAVE
 | SLOW
+| SOUNDS
 | SOURCE
 | SOURCE_CONNECT_RETRY
 | SOURCE_HOST
@@ -8560,6 +8709,7 @@ non_reserved_keyword:
 | TRUNCATE
 | UNBOUNDED
 | UNCOMMITTED
+| UNKNOWN
 | UNTIL
 | UNUSED
 | USER
@@ -8596,6 +8746,7 @@ non_reserved_keyword2:
 | TIMESTAMPADD
 | TIMESTAMPDIFF
 | VIEW
+| WEIGHT_STRING
 
 // non_reserved_keyword that can't go in non_reserved_keyword or non_reserved_keyword2 for some reason
 non_reserved_keyword3:
@@ -714,6 +714,49 @@ func TestAlterDropConstraint(t *testing.T) {
 	}
 }
 
+func TestAlterConstraintEnforced(t *testing.T) {
+	tests := []struct {
+		statement string
+		res       *AlterConstraintSpec
+	}{
+		{
+			`ALTER TABLE t ALTER CHECK chk ENFORCED`,
+			&AlterConstraintSpec{Name: "chk", Check: true, Enforced: true},
+		},
+		{
+			`ALTER TABLE t ALTER CHECK chk NOT ENFORCED`,
+			&AlterConstraintSpec{Name: "chk", Check: true, Enforced: false},
+		},
+		{
+			`ALTER TABLE t ALTER CONSTRAINT chk ENFORCED`,
+			&AlterConstraintSpec{Name: "chk", Enforced: true},
+		},
+		{
+			`ALTER TABLE t ALTER CONSTRAINT chk NOT ENFORCED`,
+			&AlterConstraintSpec{Name: "chk", Enforced: false},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.statement, func(t *testing.T) {
+			res, err := Parse(test.statement)
+			require.NoError(t, err)
+			ddlRes, ok := res.(*DDL)
+			if !ok {
+				mAlterDDL, ok := res.(*MultiAlterDDL)
+				require.True(t, ok)
+				require.Len(t, mAlterDDL.Statements, 1)
+				ddlRes = mAlterDDL.Statements[0]
+			}
+			assert.Equal(t, AlterStr, ddlRes.ConstraintAction)
+			assert.Equal(t, test.res, ddlRes.AlterConstraintSpec)
+		})
+	}
+
+	_, err := Parse("ALTER TABLE t ALTER CHECK chk")
+	require.Error(t, err)
+}
+
 type testIndexStruct struct {
 	statement string
 	resTable  TableName
@@ -54,6 +54,10 @@ type Tokenizer struct {
 	specialCommentEndPos int
 	potentialAccountName bool
 
+	// AnsiQuotes makes double quotes delimit identifiers, like
+	// backticks, instead of strings. See the ANSI_QUOTES SQL mode.
+	AnsiQuotes bool
+
 	// If true, the parser should collaborate to set `stopped` on this
 	// tokenizer after a statement is parsed. From that point forward, the
 	// tokenizer will return EOF, instead of new tokens. `ParseOne` uses
@@ -67,6 +71,14 @@ type Tokenizer struct {
 	bufSize int
 
 	queryBuf []byte
+
+	// numBuf is the scratch buffer of scanNumber.
+	numBuf []byte
+
+	// sqlVals is the rest of the block newSQLVal allocates from, of
+	// sqlValsBlockSize values.
+	sqlVals          []SQLVal
+	sqlValsBlockSize int
 }
 
 // NewStringTokenizer creates a new Tokenizer for the
@@ -558,6 +570,7 @@ var keywords = map[string]int{
 	"restrict":                      RESTRICT,
 	"return":                        RETURN,
 	"reuse":                         REUSE,
+	"reverse":                       REVERSE,
 	"revoke":                        REVOKE,
 	"right":                         RIGHT,
 	"rlike":                         REGEXP,
@@ -599,6 +612,7 @@ var keywords = map[string]int{
 	"slave":                         SLAVE,
 	"slow":                          SLOW,
 	"smallint":                      SMALLINT,
+	"sounds":                        SOUNDS,
 	"source":                        SOURCE,
 	"source_connect_retry":          SOURCE_CONNECT_RETRY,
 	"source_host":                   SOURCE_HOST,
@@ -678,6 +692,7 @@ var keywords = map[string]int{
 	"undo":                          UNDO,
 	"union":                         UNION,
 	"unique":                        UNIQUE,
+	"unknown":                       UNKNOWN,
 	"unlock":                        UNLOCK,
 	"unsigned":                      UNSIGNED,
 	"until":                         UNTIL,
@@ -704,6 +719,7 @@ var keywords = map[string]int{
 	"virtual":                       VIRTUAL,
 	"warnings":                      WARNINGS,
 	"week":                          WEEK,
+	"weight_string":                 WEIGHT_STRING,
 	"when":                          WHEN,
 	"where":                         WHERE,
 	"while":                         WHILE,
@@ -826,6 +842,12 @@ func (tkn *Tokenizer) Scan() (int, []byte) {
 				return tkn.scanBitLiteral()
 			}
 		}
+		if ch == 'N' || ch == 'n' {
+			if tkn.lastChar == '\'' {
+				tkn.next()
+				return tkn.scanString('\'', NCHAR_STRING)
+			}
+		}
 		return tkn.scanIdentifier(byte(ch), false)
 	case ch == '@':
 		tkn.next()
@@ -958,10 +980,15 @@ func (tkn *Tokenizer) Scan() (int, []byte) {
 				return NE, nil
 			}
 			return int(ch), nil
-		case '\'', '"':
+		case '"':
+			if tkn.AnsiQuotes {
+				return tkn.scanLiteralIdentifier('"')
+			}
+			return tkn.scanString(ch, STRING)
+		case '\'':
 			return tkn.scanString(ch, STRING)
 		case '`':
-			return tkn.scanLiteralIdentifier()
+			return tkn.scanLiteralIdentifier('`')
 		default:
 			return LEX_ERROR, []byte{byte(ch)}
 		}
@@ -1003,6 +1030,9 @@ func (tkn *Tokenizer) scanIdentifier(firstByte byte, isDbSystemVariable bool) (i
 	lowered := bytes.ToLower(buffer.Bytes())
 	loweredStr := string(lowered)
 	if keywordID, found := keywords[loweredStr]; found {
+		if keywordID == FILTER && tkn.filterClauseFollows() {
+			return FILTER_CLAUSE, buffer.Bytes()
+		}
 		return keywordID, buffer.Bytes()
 	}
 	// dual must always be case-insensitive
@@ -1012,6 +1042,67 @@ func (tkn *Tokenizer) scanIdentifier(firstByte byte, isDbSystemVariable bool) (i
 	return ID, buffer.Bytes()
 }
 
+// filterClauseFollows returns true if the input that follows is
+// "(WHERE", which makes a preceding FILTER the start of the FILTER clause
+// of an aggregate instead of an identifier. It does not consume any
+// input.
+func (tkn *Tokenizer) filterClauseFollows() bool {
+	if tkn.lastChar == eofChar {
+		return false
+	}
+	// lastChar is at bufPos-1.
+	pos := tkn.bufPos - 1
+	skipBlank := func() {
+		for {
+			ch, ok := tkn.peekByte(pos)
+			if !ok || (ch != ' ' && ch != '\n' && ch != '\r' && ch != '\t') {
+				return
+			}
+			pos++
+		}
+	}
+	skipBlank()
+	if ch, ok := tkn.peekByte(pos); !ok || ch != '(' {
+		return false
+	}
+	pos++
+	skipBlank()
+	for _, want := range []byte("where") {
+		ch, ok := tkn.peekByte(pos)
+		if !ok || ch|0x20 != want {
+			return false
+		}
+		pos++
+	}
+	ch, ok := tkn.peekByte(pos)
+	return !ok || !(isLetter(uint16(ch)) || isDigit(uint16(ch)))
+}
+
+// peekByte returns the byte at pos in buf, for lookahead. If pos is past
+// the buffered input, more of InStream is read after it first.
+func (tkn *Tokenizer) peekByte(pos int) (byte, bool) {
+	for pos >= tkn.bufSize {
+		if tkn.InStream == nil {
+			return 0, false
+		}
+		more := make([]byte, defaultBufSize)
+		n, err := tkn.InStream.Read(more)
+		if err != io.EOF && err != nil {
+			tkn.LastError = err
+		}
+		if n == 0 {
+			return 0, false
+		}
+		// In multi mode, queryBuf ends with all of buf, see next.
+		if stale := len(tkn.buf) - tkn.bufSize; tkn.multi && len(tkn.queryBuf) >= stale {
+			tkn.queryBuf = append(tkn.queryBuf[:len(tkn.queryBuf)-stale], more[:n]...)
+		}
+		tkn.buf = append(tkn.buf[:tkn.bufSize], more[:n]...)
+		tkn.bufSize += n
+	}
+	return tkn.buf[pos], true
+}
+
 func (tkn *Tokenizer) scanHex() (int, []byte) {
 	buffer := &bytes2.Buffer{}
 	tkn.scanMantissa(16, buffer)
@@ -1035,22 +1126,22 @@ func (tkn *Tokenizer) scanBitLiteral() (int, []byte) {
 	return BIT_LITERAL, buffer.Bytes()
 }
 
-func (tkn *Tokenizer) scanLiteralIdentifier() (int, []byte) {
+func (tkn *Tokenizer) scanLiteralIdentifier(delim uint16) (int, []byte) {
 	buffer := &bytes2.Buffer{}
 	backTickSeen := false
 	for {
 		if backTickSeen {
-			if tkn.lastChar != '`' {
+			if tkn.lastChar != delim {
 				break
 			}
 			backTickSeen = false
-			buffer.WriteByte('`')
+			buffer.WriteByte(byte(delim))
 			tkn.next()
 			continue
 		}
 		// The previous char was not a backtick.
 		switch tkn.lastChar {
-		case '`':
+		case delim:
 			backTickSeen = true
 		case eofChar:
 			// Premature EOF.
@@ -1112,7 +1203,8 @@ func (tkn *Tokenizer) scanMantissa(base int, buffer *bytes2.Buffer) {
 
 func (tkn *Tokenizer) scanNumber(seenDecimalPoint bool) (int, []byte) {
 	token := INTEGRAL
-	buffer := &bytes2.Buffer{}
+	start := tkn.bufPos - 1
+	buffer := bytes2.NewBuffer(tkn.numBuf[:0])
 	if seenDecimalPoint {
 		token = FLOAT
 		buffer.WriteByte('.')
@@ -1150,12 +1242,48 @@ exponent:
 	}
 
 exit:
+	tkn.numBuf = buffer.Bytes()
+	number := tkn.numberBytes(start, seenDecimalPoint)
 	// A letter cannot immediately follow a number.
 	if isLetter(tkn.lastChar) {
-		return LEX_ERROR, buffer.Bytes()
+		return LEX_ERROR, number
 	}
 
-	return token, buffer.Bytes()
+	return token, number
+}
+
+// numberBytes returns the number scanNumber just scanned into numBuf,
+// from start in buf. Without an InStream, buf holds the whole query, so
+// the number is returned as a capped slice of it instead of a copy: a
+// large IN list would otherwise allocate once per value.
+func (tkn *Tokenizer) numberBytes(start int, seenDecimalPoint bool) []byte {
+	if tkn.InStream != nil || seenDecimalPoint {
+		// buf is refilled from InStream, and the decimal point
+		// preceding a number that starts with it was read before start.
+		return append([]byte(nil), tkn.numBuf...)
+	}
+	end := start + len(tkn.numBuf)
+	return tkn.buf[start:end:end]
+}
+
+// newSQLVal returns a new SQLVal for a literal of the query. They are
+// allocated in blocks, which grow with the number of literals, so that
+// the values of a large IN list are not allocated one by one.
+func (tkn *Tokenizer) newSQLVal(typ ValType, val []byte) *SQLVal {
+	if len(tkn.sqlVals) == 0 {
+		tkn.sqlValsBlockSize *= 2
+		if tkn.sqlValsBlockSize < 8 {
+			tkn.sqlValsBlockSize = 8
+		} else if tkn.sqlValsBlockSize > 1024 {
+			tkn.sqlValsBlockSize = 1024
+		}
+		tkn.sqlVals = make([]SQLVal, tkn.sqlValsBlockSize)
+	}
+	sqlVal := &tkn.sqlVals[0]
+	tkn.sqlVals = tkn.sqlVals[1:]
+	sqlVal.Type = typ
+	sqlVal.Val = val
+	return sqlVal
 }
 
 func (tkn *Tokenizer) scanString(delim uint16, typ int) (int, []byte) {
@@ -1306,6 +1434,7 @@ func (tkn *Tokenizer) scanMySQLSpecificComment() (int, []byte) {
 	_, sql := ExtractMysqlComment(buffer.String())
 
 	tkn.specialComment = NewStringTokenizer(sql)
+	tkn.specialComment.AnsiQuotes = tkn.AnsiQuotes
 	tkn.specialComment.Position = startOffset
 
 	return tkn.Scan()